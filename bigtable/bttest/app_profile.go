@@ -0,0 +1,59 @@
+package bttest
+
+import (
+	"context"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// defaultAppProfileId names the app profile every instance has; data requests with no app profile id use it.
+const defaultAppProfileId = "default"
+
+// appProfiles is the set of app profile ids data requests may name, as Options.AppProfileIds.
+type appProfiles map[string]bool
+
+func newAppProfiles(ids []string) appProfiles {
+	p := appProfiles{defaultAppProfileId: true}
+	for _, id := range ids {
+		p[id] = true
+	}
+	return p
+}
+
+// check returns InvalidArgument if req is a data request naming an unknown app profile.
+func (p appProfiles) check(req interface{}) error {
+	r, ok := req.(interface{ GetAppProfileId() string })
+	if !ok {
+		return nil
+	}
+	if id := r.GetAppProfileId(); id != "" && !p[id] {
+		return status.Errorf(codes.InvalidArgument, "app profile %q not found", id)
+	}
+	return nil
+}
+
+func (p appProfiles) unaryInterceptor(ctx context.Context, req interface{}, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	if err := p.check(req); err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+func (p appProfiles) streamInterceptor(srv interface{}, ss grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	return handler(srv, &appProfileStream{ServerStream: ss, profiles: p})
+}
+
+// appProfileStream checks the app profile of each request received on a stream.
+type appProfileStream struct {
+	grpc.ServerStream
+	profiles appProfiles
+}
+
+func (s *appProfileStream) RecvMsg(m interface{}) error {
+	if err := s.ServerStream.RecvMsg(m); err != nil {
+		return err
+	}
+	return s.profiles.check(m)
+}
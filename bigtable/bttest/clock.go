@@ -0,0 +1,40 @@
+package bttest
+
+import (
+	"sync"
+	"time"
+
+	"cloud.google.com/go/bigtable"
+)
+
+// FakeClock is a clock that only moves when told to. Pass its Now method as Options.Clock to make server-assigned
+// timestamps and MaxAge garbage collection deterministic:
+//
+//	clock := bttest.NewFakeClock(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+//	srv, err := bttest.NewServerWithOptions("localhost:0", bttest.Options{Clock: clock.Now})
+//	...
+//	clock.Advance(48 * time.Hour)
+//	err = srv.GarbageCollect(tableName)
+type FakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+// NewFakeClock returns a FakeClock set to t.
+func NewFakeClock(t time.Time) *FakeClock {
+	return &FakeClock{now: t}
+}
+
+// Now returns the clock's current time, truncated to the millisecond granularity Bigtable stores.
+func (c *FakeClock) Now() bigtable.Timestamp {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return bigtable.Time(c.now).TruncateToMilliseconds()
+}
+
+// Advance moves the clock forward by d.
+func (c *FakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
@@ -41,6 +41,7 @@ import (
 	"net"
 	"regexp"
 	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -49,15 +50,30 @@ import (
 	"cloud.google.com/go/bigtable"
 	btapb "cloud.google.com/go/bigtable/admin/apiv2/adminpb"
 	btpb "cloud.google.com/go/bigtable/apiv2/bigtablepb"
+	longrunningpb "cloud.google.com/go/longrunning/autogen/longrunningpb"
 	emptypb "github.com/golang/protobuf/ptypes/empty"
 	"github.com/golang/protobuf/ptypes/wrappers"
 	statpb "google.golang.org/genproto/googleapis/rpc/status"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/reflection"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/anypb"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
 	"rsc.io/binaryregexp"
 )
 
+// granularityMicros maps each supported table timestamp granularity to the unit, in microseconds, that cell
+// timestamps must be a multiple of. Unspecified granularity means MILLIS.
+var granularityMicros = map[btapb.Table_TimestampGranularity]int64{
+	btapb.Table_TIMESTAMP_GRANULARITY_UNSPECIFIED: 1000,
+	btapb.Table_MILLIS: 1000,
+}
+
 const (
 	// MilliSeconds field of the minimum valid Timestamp.
 	minValidMilliSeconds = 0
@@ -75,21 +91,33 @@ var validLabelTransformer = regexp.MustCompile(`[a-z0-9\-]{1,15}`)
 type Server struct {
 	Addr string
 
-	l   net.Listener
-	srv *grpc.Server
-	s   *server
+	l      net.Listener
+	srv    *grpc.Server
+	s      *server
+	health *health.Server
 }
 
 // server is the real implementation of the fake.
 // It is a separate and unexported type so the API won't be cluttered with
 // methods that are only relevant to the fake's implementation.
 type server struct {
-	storage Storage
-	clock   func() bigtable.Timestamp
-
-	mu     sync.Mutex
-	tables map[string]*table // keyed by fully qualified name
-	done   chan struct{}     // closed when server shuts down
+	storage       Storage
+	clock         func() bigtable.Timestamp
+	splitPoints   [][]byte      // if set, SampleRowKeys reports exactly these keys
+	maxKeyLen     int           // longest row key accepted by mutations; defaults to defaultMaxRowKeyLength if zero
+	maxRowSize    int           // largest row mutations may produce; defaults to defaultMaxRowSize if zero
+	errorAfter    int           // if >0, ReadRows fails with Unavailable after streaming this many rows
+	flushChunks   int           // most chunks per ReadRows response; defaults to defaultReadChunkFlushThreshold if zero
+	defaultGcRule *btapb.GcRule // if set, applied to column families created without a GC rule
+	changeStream  bool          // if set, the change stream RPCs return minimal stub responses
+	gcOnRead      bool          // if set, ReadRows applies GC rules to the cells it returns
+	log           logFunc       // if nil, logs to the standard logger
+	gcInterval    time.Duration // if >0, the wait between background GC passes; otherwise random 15-60s
+	gcQuiesce     time.Duration // if >0, how long a table must be idle before background GC; otherwise 5m
+	onMutation    mutationFunc  // if set, called with applied mutations
+
+	*tableSet               // shared with other servers using the same SharedMemStorage
+	done      chan struct{} // closed when server shuts down
 
 	// Any unimplemented methods will return unimplemented.
 	*btapb.UnimplementedBigtableTableAdminServer
@@ -97,6 +125,17 @@ type server struct {
 	*btpb.UnimplementedBigtableServer
 }
 
+// tableSet holds a server's tables. Servers built on the same SharedMemStorage share one tableSet, and with it each
+// table's lock and definition.
+type tableSet struct {
+	mu     sync.Mutex
+	tables map[string]*table // keyed by fully qualified name
+}
+
+func newTableSet() *tableSet {
+	return &tableSet{tables: make(map[string]*table)}
+}
+
 // NewServer creates a new Server.
 // The Server will be listening for gRPC connections, without TLS,
 // on the provided address. The resolved address is named by the Addr field.
@@ -110,13 +149,100 @@ func NewServer(laddr string, opt ...grpc.ServerOption) (*Server, error) {
 type Options struct {
 	// A storage layer to use; if nil, defaults to LeveldbMemStorage.
 	Storage Storage
-	// The clock to use use; if nil, defaults to bigtable.Now().
+	// The clock to use use; if nil, defaults to bigtable.Now(). A FakeClock's Now makes time controllable in tests.
 	Clock func() bigtable.Timestamp
 
+	// If set, SampleRowKeys reports exactly these split points (dropping any beyond the last row in the table)
+	// instead of randomly chosen row keys.
+	SplitPoints [][]byte
+
+	// The longest row key, in bytes, that mutations will accept; if zero, defaults to 4KB like real Bigtable.
+	MaxRowKeyLength int
+
+	// The largest total size, in bytes, of the cell values in a row; mutations that would exceed it fail with
+	// InvalidArgument and leave the row unchanged. If zero, defaults to 256MB like real Bigtable.
+	MaxRowSize int
+
+	// If >0, every ReadRows stream fails with Unavailable after sending this many rows, to exercise client
+	// retry and resumption logic.
+	ReadRowsErrorAfter int
+
+	// The most cell chunks ReadRows buffers before sending a response; larger rows are split across several
+	// responses. Lower it to exercise client chunk reassembly and stream backpressure. If zero, defaults to 1024.
+	ReadChunkFlushThreshold int
+
+	// If set, column families created without a GC rule (via CreateTable or ModifyColumnFamilies) get this rule,
+	// e.g. a default max versions, to better mimic a configured instance.
+	DefaultGcRule *btapb.GcRule
+
+	// If true, GenerateInitialChangeStreamPartitions reports a single partition covering the whole table and
+	// ReadChangeStream sends one heartbeat before closing, so change-stream-aware clients can probe for support.
+	// No data changes are ever streamed. If false, both RPCs return Unimplemented.
+	EnableChangeStream bool
+
+	// If true, ReadRows omits cells that their column family's GC rule would collect, as of the current clock,
+	// instead of returning them until background GC catches up. Real Bigtable collects garbage lazily, so stale
+	// cells may still be read there.
+	GcOnRead bool
+
+	// If true, no background GC runs at all, so extra versions and expired cells stay until Server.GarbageCollect
+	// is called.
+	DisableBackgroundGc bool
+
+	// If >0, the wait between background GC passes; if zero, each wait is a random 15-60 seconds.
+	GcInterval time.Duration
+
+	// If >0, how long a table must go without reads or writes before background GC visits it; if zero, defaults
+	// to 5 minutes.
+	GcQuiesce time.Duration
+
+	// Receives internal warnings, such as unsupported filters or GC rules, and GC progress; if nil, they go to
+	// the standard logger.
+	Log func(err error, format string, args ...interface{})
+
+	// If set, called with the fully qualified table name, row key and mutations of every successfully applied
+	// MutateRow, MutateRows entry and CheckAndMutateRow, so tests can assert on what clients sent. It runs after
+	// the table lock is released, so it may call back into the server.
+	OnMutation func(table string, rowKey []byte, muts []*btpb.Mutation)
+
+	// If true, requests carrying google-cloud-resource-prefix metadata fail with InvalidArgument unless the table,
+	// instance or other resource they target falls under that prefix, as real Bigtable routes on it.
+	ValidateResourcePrefix bool
+
+	// If true, the gRPC server reflection service is registered, so tools like grpcurl can list and describe the
+	// emulated services.
+	EnableReflection bool
+
+	// If non-nil, the app profiles that exist, besides "default"; data requests naming any other app profile fail
+	// with InvalidArgument. If nil, app profile ids are ignored.
+	AppProfileIds []string
+
 	// Grpc server options.
 	GrpcOpts []grpc.ServerOption
+
+	// If set, called with the gRPC server after the emulated services are registered but before it starts
+	// serving, so callers can register additional services on the same address.
+	RegisterServices func(*grpc.Server)
 }
 
+// logFunc reports internal events, as Options.Log; a nil logFunc writes to the standard logger.
+type logFunc func(err error, format string, args ...interface{})
+
+func (l logFunc) printf(err error, format string, args ...interface{}) {
+	if l != nil {
+		l(err, format, args...)
+		return
+	}
+	if err != nil {
+		format += ": %v"
+		args = append(args, err)
+	}
+	log.Printf(format, args...)
+}
+
+// mutationFunc observes applied mutations, as Options.OnMutation.
+type mutationFunc func(table string, rowKey []byte, muts []*btpb.Mutation)
+
 // NewServerWithOptions creates a new Server with the given options.
 // The Server will be listening for gRPC connections, without TLS,
 // on the provided address. The resolved address is named by the Addr field.
@@ -127,43 +253,155 @@ func NewServerWithOptions(laddr string, opt Options) (*Server, error) {
 	if opt.Clock == nil {
 		opt.Clock = bigtable.Now
 	}
+	grpcOpts := opt.GrpcOpts
+	if opt.ValidateResourcePrefix {
+		grpcOpts = append(grpcOpts[:len(grpcOpts):len(grpcOpts)],
+			grpc.ChainUnaryInterceptor(resourcePrefixUnaryInterceptor),
+			grpc.ChainStreamInterceptor(resourcePrefixStreamInterceptor))
+	}
+	if opt.AppProfileIds != nil {
+		profiles := newAppProfiles(opt.AppProfileIds)
+		grpcOpts = append(grpcOpts[:len(grpcOpts):len(grpcOpts)],
+			grpc.ChainUnaryInterceptor(profiles.unaryInterceptor),
+			grpc.ChainStreamInterceptor(profiles.streamInterceptor))
+	}
 	l, err := net.Listen("tcp", laddr)
 	if err != nil {
 		return nil, err
 	}
 
+	tables := newTableSet()
+	if shared, ok := opt.Storage.(*SharedMemStorage); ok {
+		tables = shared.sharedTables()
+	}
+
 	s := &Server{
 		Addr: l.Addr().String(),
 		l:    l,
-		srv:  grpc.NewServer(opt.GrpcOpts...),
+		srv:  grpc.NewServer(grpcOpts...),
 		s: &server{
-			storage: opt.Storage,
-			tables:  make(map[string]*table),
-			clock:   opt.Clock,
-			done:    make(chan struct{}),
+			storage:       opt.Storage,
+			tableSet:      tables,
+			clock:         opt.Clock,
+			splitPoints:   opt.SplitPoints,
+			maxKeyLen:     opt.MaxRowKeyLength,
+			maxRowSize:    opt.MaxRowSize,
+			errorAfter:    opt.ReadRowsErrorAfter,
+			flushChunks:   opt.ReadChunkFlushThreshold,
+			defaultGcRule: opt.DefaultGcRule,
+			changeStream:  opt.EnableChangeStream,
+			gcOnRead:      opt.GcOnRead,
+			log:           opt.Log,
+			gcInterval:    opt.GcInterval,
+			gcQuiesce:     opt.GcQuiesce,
+			onMutation:    opt.OnMutation,
+			done:          make(chan struct{}),
 		},
 	}
 
 	// Init from storage.
 	for _, tbl := range s.s.storage.GetTables() {
 		rows := s.s.storage.Open(tbl)
-		s.s.tables[tbl.Name] = newTable(tbl, rows)
+		s.s.tables[tbl.Name] = newTable(tbl, rows, s.s.log)
 	}
 
 	btapb.RegisterBigtableInstanceAdminServer(s.srv, s.s)
 	btapb.RegisterBigtableTableAdminServer(s.srv, s.s)
 	btpb.RegisterBigtableServer(s.srv, s.s)
+	// The standard health service reports SERVING as soon as the server is listening.
+	s.health = health.NewServer()
+	healthpb.RegisterHealthServer(s.srv, s.health)
+	if opt.EnableReflection {
+		reflection.Register(s.srv)
+	}
+	if opt.RegisterServices != nil {
+		opt.RegisterServices(s.srv)
+	}
 
 	go func() {
 		_ = s.srv.Serve(s.l)
 	}()
-	go s.s.gcloop()
+	if !opt.DisableBackgroundGc {
+		go s.s.gcloop()
+	}
 
 	return s, nil
 }
 
+// GRPC returns the underlying gRPC server. It is already serving, so it can't take new services; register those
+// with Options.RegisterServices instead.
+func (s *Server) GRPC() *grpc.Server {
+	return s.srv
+}
+
+// SetTableReadOnly marks the named table as read-only, or writable again. While read-only, mutations and
+// DropRowRange fail with FailedPrecondition but reads still succeed. The name is the fully qualified table name,
+// e.g. "projects/p/instances/i/tables/t".
+func (s *Server) SetTableReadOnly(name string, ro bool) error {
+	s.s.mu.Lock()
+	tbl, ok := s.s.tables[name]
+	s.s.mu.Unlock()
+	if !ok {
+		return status.Errorf(codes.NotFound, "table %q not found", name)
+	}
+	var v int32
+	if ro {
+		v = 1
+	}
+	atomic.StoreInt32(&tbl.readOnly, v)
+	return nil
+}
+
+// TableStats reports how many rows and cells the named table holds, and their approximate size in bytes: the sum of
+// the row keys, plus the qualifiers and values of every cell. Rows without cells aren't counted. The name is the
+// fully qualified table name, e.g. "projects/p/instances/i/tables/t".
+func (s *Server) TableStats(name string) (rows, cells, bytes int64, err error) {
+	s.s.mu.Lock()
+	tbl, ok := s.s.tables[name]
+	s.s.mu.Unlock()
+	if !ok {
+		return 0, 0, 0, status.Errorf(codes.NotFound, "table %q not found", name)
+	}
+
+	tbl.mu.RLock()
+	defer tbl.mu.RUnlock()
+	tbl.rows.Ascend(func(r *btpb.Row) bool {
+		n := countCells(r)
+		if n == 0 {
+			return true
+		}
+		rows++
+		cells += int64(n)
+		bytes += int64(len(r.Key))
+		for _, fam := range r.Families {
+			for _, col := range fam.Columns {
+				for _, cell := range col.Cells {
+					bytes += int64(len(col.Qualifier) + len(cell.Value))
+				}
+			}
+		}
+		return true
+	})
+	return rows, cells, bytes, nil
+}
+
+// GarbageCollect immediately applies the named table's GC rules as of the server's clock, rather than waiting for
+// background GC to visit the table once it's idle. The name is the fully qualified table name, e.g.
+// "projects/p/instances/i/tables/t".
+func (s *Server) GarbageCollect(name string) error {
+	s.s.mu.Lock()
+	tbl, ok := s.s.tables[name]
+	s.s.mu.Unlock()
+	if !ok {
+		return status.Errorf(codes.NotFound, "table %q not found", name)
+	}
+	tbl.gc(s.s.clock(), s.s.gcQuiesce, s.s.done, true)
+	return nil
+}
+
 // Close shuts down the server.
 func (s *Server) Close() {
+	s.health.Shutdown()
 	close(s.s.done)
 	s.srv.Stop()
 	_ = s.l.Close()
@@ -186,6 +424,9 @@ func (s *Server) Close() {
 
 func (s *server) CreateTable(ctx context.Context, req *btapb.CreateTableRequest) (*btapb.Table, error) {
 	tbl := req.Parent + "/tables/" + req.TableId
+	if g := req.GetTable().GetGranularity(); granularityMicros[g] == 0 {
+		return nil, status.Errorf(codes.InvalidArgument, "unsupported timestamp granularity %v", g)
+	}
 
 	s.mu.Lock()
 	if _, ok := s.tables[tbl]; ok {
@@ -196,8 +437,17 @@ func (s *server) CreateTable(ctx context.Context, req *btapb.CreateTableRequest)
 		req.Table = &btapb.Table{}
 	}
 	req.Table.Name = tbl
+	for fam, cf := range req.Table.ColumnFamilies {
+		if cf == nil {
+			cf = &btapb.ColumnFamily{}
+			req.Table.ColumnFamilies[fam] = cf
+		}
+		if cf.GcRule == nil {
+			cf.GcRule = s.newDefaultGcRule()
+		}
+	}
 	rows := s.storage.Create(req.Table)
-	s.tables[tbl] = newTable(req.Table, rows)
+	s.tables[tbl] = newTable(req.Table, rows, s.log)
 
 	s.mu.Unlock()
 
@@ -243,13 +493,69 @@ func (s *server) GetTable(ctx context.Context, req *btapb.GetTableRequest) (*bta
 func (s *server) DeleteTable(ctx context.Context, req *btapb.DeleteTableRequest) (*emptypb.Empty, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	if _, ok := s.tables[req.Name]; !ok {
+	tbl, ok := s.tables[req.Name]
+	if !ok {
 		return nil, status.Errorf(codes.NotFound, "table %q not found", req.Name)
 	}
+	tbl.mu.RLock()
+	protected := tbl.def.DeletionProtection
+	tbl.mu.RUnlock()
+	if protected {
+		return nil, status.Errorf(codes.FailedPrecondition, "table %q is protected against deletion", req.Name)
+	}
 	delete(s.tables, req.Name)
 	return &emptypb.Empty{}, nil
 }
 
+// UpdateTable applies the table-level settings named by the update mask. The returned operation is already done.
+func (s *server) UpdateTable(ctx context.Context, req *btapb.UpdateTableRequest) (*longrunningpb.Operation, error) {
+	upd := req.GetTable()
+	s.mu.Lock()
+	tbl, ok := s.tables[upd.GetName()]
+	s.mu.Unlock()
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "table %q not found", upd.GetName())
+	}
+	paths := req.GetUpdateMask().GetPaths()
+	if len(paths) == 0 {
+		return nil, status.Errorf(codes.InvalidArgument, "update_mask is required")
+	}
+
+	tbl.mu.Lock()
+	defer tbl.mu.Unlock()
+
+	def := proto.Clone(tbl.def).(*btapb.Table)
+	for _, path := range paths {
+		switch path {
+		case "deletion_protection":
+			def.DeletionProtection = upd.DeletionProtection
+		case "change_stream_config":
+			def.ChangeStreamConfig = upd.ChangeStreamConfig
+		case "change_stream_config.retention_period":
+			if def.ChangeStreamConfig == nil {
+				def.ChangeStreamConfig = &btapb.ChangeStreamConfig{}
+			}
+			def.ChangeStreamConfig.RetentionPeriod = upd.GetChangeStreamConfig().GetRetentionPeriod()
+		case "column_families":
+			return nil, status.Errorf(codes.Unimplemented, "use ModifyColumnFamilies to update column families")
+		default:
+			return nil, status.Errorf(codes.InvalidArgument, "unsupported update_mask path %q", path)
+		}
+	}
+	tbl.def = def
+	s.storage.SetTableMeta(tbl.def)
+
+	res, err := anypb.New(def)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "marshaling table: %v", err)
+	}
+	return &longrunningpb.Operation{
+		Name:   def.Name + "/operations/update",
+		Done:   true,
+		Result: &longrunningpb.Operation_Response{Response: res},
+	}, nil
+}
+
 func (s *server) ModifyColumnFamilies(ctx context.Context, req *btapb.ModifyColumnFamiliesRequest) (*btapb.Table, error) {
 	s.mu.Lock()
 	tbl, ok := s.tables[req.Name]
@@ -260,43 +566,80 @@ func (s *server) ModifyColumnFamilies(ctx context.Context, req *btapb.ModifyColu
 
 	tbl.mu.Lock()
 	defer tbl.mu.Unlock()
-	cfs := tbl.def.ColumnFamilies
+
+	// Apply the modifications to a copy, so that a failure leaves the table unchanged.
+	cfs := make(map[string]*btapb.ColumnFamily, len(tbl.def.ColumnFamilies))
+	for id, cf := range tbl.def.ColumnFamilies {
+		cfs[id] = proto.Clone(cf).(*btapb.ColumnFamily)
+	}
+	dropped := map[string]bool{}
 
 	for _, mod := range req.Modifications {
 		if create := mod.GetCreate(); create != nil {
 			if _, ok := cfs[mod.Id]; ok {
 				return nil, status.Errorf(codes.AlreadyExists, "family %q already exists", mod.Id)
 			}
+			gcRule := create.GcRule
+			if gcRule == nil {
+				gcRule = s.newDefaultGcRule()
+			}
 			cfs[mod.Id] = &btapb.ColumnFamily{
-				GcRule: create.GcRule,
+				GcRule:    gcRule,
+				ValueType: create.ValueType,
 			}
 		} else if mod.GetDrop() {
 			if _, ok := cfs[mod.Id]; !ok {
-				return nil, fmt.Errorf("can't delete unknown family %q", mod.Id)
+				return nil, status.Errorf(codes.NotFound, "can't delete unknown family %q", mod.Id)
 			}
 			delete(cfs, mod.Id)
-
-			// Purge all data for this column family
-			tbl.rows.Ascend(func(r *btpb.Row) bool {
-				r, changed := scrubRow(r, tbl.cols())
-				if changed {
-					tbl.rows.ReplaceOrInsert(r)
-				}
-				return true
-			})
+			dropped[mod.Id] = true
 		} else if modify := mod.GetUpdate(); modify != nil {
 			cf, ok := cfs[mod.Id]
 			if !ok {
-				return nil, fmt.Errorf("no such family %q", mod.Id)
+				return nil, status.Errorf(codes.NotFound, "no such family %q", mod.Id)
 			}
 			// assume that we ALWAYS want to replace by the new setting
 			// we may need partial update through
 			cf.GcRule = modify.GcRule
+		} else {
+			return nil, status.Errorf(codes.InvalidArgument, "modification of family %q must create, update or drop it", mod.Id)
+		}
+	}
+	tbl.def.ColumnFamilies = cfs
+
+	if len(dropped) > 0 {
+		// Purge all data for the dropped column families, including any recreated in the same request. Rows are
+		// rewritten after the scan, since emptied rows are deleted and storage can't be modified while iterating.
+		var rowsToUpdate []*btpb.Row
+		tbl.rows.Ascend(func(r *btpb.Row) bool {
+			fams := r.Families[:0]
+			for _, fam := range r.Families {
+				if !dropped[fam.Name] {
+					fams = append(fams, fam)
+				}
+			}
+			if len(fams) != len(r.Families) {
+				r.Families = fams
+				rowsToUpdate = append(rowsToUpdate, r)
+			}
+			return true
+		})
+		for _, r := range rowsToUpdate {
+			tbl.updateRow(r)
 		}
 	}
 
 	s.storage.SetTableMeta(tbl.def)
-	return tbl.def, nil
+	// Return a snapshot of the new schema, which later modifications won't change underneath the caller.
+	return proto.Clone(tbl.def).(*btapb.Table), nil
+}
+
+// newDefaultGcRule returns a copy of the configured default GC rule, or nil if there is none.
+func (s *server) newDefaultGcRule() *btapb.GcRule {
+	if s.defaultGcRule == nil {
+		return nil
+	}
+	return proto.Clone(s.defaultGcRule).(*btapb.GcRule)
 }
 
 func (s *server) DropRowRange(ctx context.Context, req *btapb.DropRowRangeRequest) (*emptypb.Empty, error) {
@@ -306,10 +649,16 @@ func (s *server) DropRowRange(ctx context.Context, req *btapb.DropRowRangeReques
 	if !ok {
 		return nil, status.Errorf(codes.NotFound, "table %q not found", req.Name)
 	}
+	if err := tbl.checkWritable(); err != nil {
+		return nil, err
+	}
 
 	tbl.mu.Lock()
 	defer tbl.mu.Unlock()
 	if req.GetDeleteAllDataFromTable() {
+		if tbl.def.DeletionProtection {
+			return nil, status.Errorf(codes.FailedPrecondition, "table %q is protected against deletion; can't delete all data", req.Name)
+		}
 		tbl.rows.Clear()
 	} else {
 		// Delete rows by prefix.
@@ -370,13 +719,33 @@ type simpleRange struct {
 	start, end keyType
 }
 
+// lastRows returns, in scan order, the last n rows visited by scan, or every row if n is 0. It holds at most n rows
+// at once, so a reversed read with a small limit doesn't materialise a whole range.
+func lastRows(n int, scan func(RowIterator)) []*btpb.Row {
+	var rows []*btpb.Row
+	next := 0 // once the buffer is full, the oldest row, which the next row overwrites
+	scan(func(r *btpb.Row) bool {
+		if n == 0 || len(rows) < n {
+			rows = append(rows, r)
+		} else {
+			rows[next] = r
+			next = (next + 1) % n
+		}
+		return true
+	})
+	if next == 0 {
+		return rows
+	}
+	return append(append([]*btpb.Row(nil), rows[next:]...), rows[:next]...)
+}
+
 // Returns a sorted, normalized list of ranges to traverse.
 func mergeRowRanges(explicit []keyType, rrs []*btpb.RowRange) []simpleRange {
 	var srs []simpleRange
 	for _, k := range explicit {
 		srs = append(srs, simpleRange{
 			start: k,
-			end:   append(k, 0),
+			end:   keySuccessor(k),
 		})
 	}
 	for _, rr := range rrs {
@@ -385,11 +754,11 @@ func mergeRowRanges(explicit []keyType, rrs []*btpb.RowRange) []simpleRange {
 		case *btpb.RowRange_StartKeyClosed:
 			sr.start = sk.StartKeyClosed
 		case *btpb.RowRange_StartKeyOpen:
-			sr.start = append(sk.StartKeyOpen, 0)
+			sr.start = keySuccessor(sk.StartKeyOpen)
 		}
 		switch ek := rr.EndKey.(type) {
 		case *btpb.RowRange_EndKeyClosed:
-			sr.end = append(ek.EndKeyClosed, 0)
+			sr.end = keySuccessor(ek.EndKeyClosed)
 		case *btpb.RowRange_EndKeyOpen:
 			sr.end = ek.EndKeyOpen
 		}
@@ -398,6 +767,14 @@ func mergeRowRanges(explicit []keyType, rrs []*btpb.RowRange) []simpleRange {
 	return mergeSimpleRanges(srs)
 }
 
+// keySuccessor returns a new key immediately after k. It never appends in place, since request keys may share a
+// backing array.
+func keySuccessor(k keyType) keyType {
+	ret := make(keyType, len(k)+1)
+	copy(ret, k)
+	return ret
+}
+
 func mergeSimpleRanges(srs []simpleRange) []simpleRange {
 	if len(srs) == 0 {
 		return srs
@@ -467,6 +844,9 @@ func mergeSimpleRanges(srs []simpleRange) []simpleRange {
 	return srs[:last+1]
 }
 
+// readRowsCancelCheckInterval is how many rows ReadRows scans between checks for a cancelled stream.
+const readRowsCancelCheckInterval = 100
+
 func (s *server) ReadRows(req *btpb.ReadRowsRequest, stream btpb.Bigtable_ReadRowsServer) error {
 	s.mu.Lock()
 	tbl, ok := s.tables[req.TableName]
@@ -479,80 +859,207 @@ func (s *server) ReadRows(req *btpb.ReadRowsRequest, stream btpb.Bigtable_ReadRo
 		return err
 	}
 
-	srs := []simpleRange{{}} // infinite range unless specified
-	if len(req.GetRows().GetRowKeys())+len(req.GetRows().GetRowRanges()) > 0 {
-		srs = mergeRowRanges(req.GetRows().GetRowKeys(), req.GetRows().GetRowRanges())
-	}
-
 	defer tbl.read()
 	tbl.mu.RLock()
 	defer tbl.mu.RUnlock()
 
 	limit := int(req.RowsLimit)
 	count := 0
+	scanned := 0
+
+	start := time.Now()
+	var stats *btpb.ReadIterationStats
+	if req.RequestStatsView == btpb.ReadRowsRequest_REQUEST_STATS_FULL {
+		stats = &btpb.ReadIterationStats{}
+	}
+
+	var gcRules map[string]*btapb.GcRule
+	var now bigtable.Timestamp
+	if s.gcOnRead {
+		gcRules, now = tbl.gcRules(), s.clock()
+	}
 
 	var err error
 	var cb chunkBuilder
+	flushChunks := s.readChunkFlushThreshold()
 	sendResponse := func() error {
 		// Reverse the lock while streaming the row out.
 		tbl.mu.RUnlock()
 		defer tbl.mu.RLock()
-		return stream.Send(&btpb.ReadRowsResponse{Chunks: cb.chunks})
+		for chunks := cb.chunks; len(chunks) > 0; {
+			n := len(chunks)
+			if n > flushChunks {
+				n = flushChunks
+			}
+			if err := stream.Send(&btpb.ReadRowsResponse{Chunks: chunks[:n]}); err != nil {
+				return err
+			}
+			chunks = chunks[n:]
+		}
+		return nil
 	}
 
-	for _, sr := range srs {
-		addRow := func(r *btpb.Row) bool {
-			if limit > 0 && count >= limit {
+	addRow := func(r *btpb.Row) bool {
+		if limit > 0 && count >= limit {
+			return false
+		}
+		scanned++
+		if scanned%readRowsCancelCheckInterval == 0 {
+			// Stop scanning promptly if the client has gone away.
+			if cerr := stream.Context().Err(); cerr != nil {
+				err = status.FromContextError(cerr).Err()
 				return false
 			}
+		}
 
-			if len(r.Families) == 0 {
-				return true
-			}
+		if len(gcRules) > 0 && gcRow(r, gcRules, now, s.log) > 0 {
+			r, _ = scrubRow(r, tbl.cols())
+		}
+		if len(r.Families) == 0 {
+			return true
+		}
+		if stats != nil {
+			stats.RowsSeenCount++
+			stats.CellsSeenCount += int64(countCells(r))
+		}
 
-			var match bool
-			match, err = filterRow(req.Filter, r)
-			if err != nil {
-				return false
-			} else if !match {
-				return true
-			}
+		var match bool
+		match, err = filterRow(req.Filter, r, s.log)
+		if err != nil {
+			return false
+		} else if !match {
+			return true
+		}
 
-			if added := cb.add(tbl.cols(), r); added {
-				count++
+		if added := cb.add(tbl.cols(), r); added {
+			count++
+			if stats != nil {
+				stats.RowsReturnedCount++
+				stats.CellsReturnedCount += int64(countCells(r))
 			}
+		}
 
-			if len(cb.chunks) > 1024 {
-				err = sendResponse()
-				if err != nil {
-					return false
-				}
-				cb.reset()
+		if s.errorAfter > 0 && count >= s.errorAfter {
+			// Flush the rows sent so far, then fail the stream as if the server went away.
+			if err = sendResponse(); err == nil {
+				err = status.Errorf(codes.Unavailable, "injected error after %d rows", count)
 			}
-			return true
+			return false
 		}
 
-		switch {
-		case len(sr.start) == 0 && len(sr.end) == 0:
-			tbl.rows.Ascend(addRow) // all rows
-		case len(sr.start) == 0:
-			tbl.rows.AscendLessThan(sr.end, addRow)
-		case len(sr.end) == 0:
-			tbl.rows.AscendGreaterOrEqual(sr.start, addRow)
-		default:
-			tbl.rows.AscendRange(sr.start, sr.end, addRow)
+		if len(cb.chunks) >= flushChunks {
+			err = sendResponse()
+			if err != nil {
+				return false
+			}
+			cb.reset()
 		}
+		return true
+	}
 
+	if blocksAll(req.Filter) {
+		// No row can match, so skip the scan entirely, but still reject an invalid filter.
+		if err := validateFilter(req.Filter, s.log); err != nil {
+			return err
+		}
+	} else if key, ok := singleRowKey(req.GetRows()); ok {
+		// Fast path: look up a single row directly rather than scanning a range.
+		if r := tbl.rows.Get(key); r != nil {
+			addRow(r)
+		}
 		if err != nil {
 			return err
 		}
+	} else {
+		srs := []simpleRange{{}} // infinite range unless specified
+		if len(req.GetRows().GetRowKeys())+len(req.GetRows().GetRowRanges()) > 0 {
+			srs = mergeRowRanges(req.GetRows().GetRowKeys(), req.GetRows().GetRowRanges())
+		}
+		scan := func(sr simpleRange, iterator RowIterator) {
+			switch {
+			case len(sr.start) == 0 && len(sr.end) == 0:
+				tbl.rows.Ascend(iterator) // all rows
+			case len(sr.start) == 0:
+				tbl.rows.AscendLessThan(sr.end, iterator)
+			case len(sr.end) == 0:
+				tbl.rows.AscendGreaterOrEqual(sr.start, iterator)
+			default:
+				tbl.rows.AscendRange(sr.start, sr.end, iterator)
+			}
+		}
+		if req.Reversed {
+			// Storage only scans forward, so collect each range and walk it backwards, highest range first. With a
+			// limit, only the last rows still needed are kept; if the filter drops some of them, the rest of the range
+			// is rescanned below the lowest row kept.
+			for i := len(srs) - 1; i >= 0 && (limit == 0 || count < limit); i-- {
+				sr := srs[i]
+				for {
+					want := 0
+					if limit > 0 {
+						want = limit - count
+					}
+					rows := lastRows(want, func(iterator RowIterator) { scan(sr, iterator) })
+					for j := len(rows) - 1; j >= 0; j-- {
+						if !addRow(rows[j]) {
+							break
+						}
+					}
+					if err != nil {
+						return err
+					}
+					if want == 0 || len(rows) < want || count >= limit {
+						break
+					}
+					sr.end = rows[0].Key
+				}
+			}
+		} else {
+			for _, sr := range srs {
+				scan(sr, addRow)
+				if err != nil {
+					return err
+				}
+			}
+		}
 	}
 	if err == nil && len(cb.chunks) > 0 {
 		err = sendResponse()
 	}
+	if err == nil && stats != nil {
+		// Stats are sent once, after all the data.
+		tbl.mu.RUnlock()
+		defer tbl.mu.RLock()
+		err = stream.Send(&btpb.ReadRowsResponse{RequestStats: &btpb.RequestStats{
+			StatsView: &btpb.RequestStats_FullReadStatsView{FullReadStatsView: &btpb.FullReadStatsView{
+				ReadIterationStats: stats,
+				RequestLatencyStats: &btpb.RequestLatencyStats{
+					FrontendServerLatency: durationpb.New(time.Since(start)),
+				},
+			}},
+		}})
+	}
 	return err
 }
 
+// singleRowKey returns the key if the row set names exactly one row and no ranges.
+func singleRowKey(rs *btpb.RowSet) (keyType, bool) {
+	if len(rs.GetRowKeys()) != 1 || len(rs.GetRowRanges()) != 0 {
+		return nil, false
+	}
+	return rs.GetRowKeys()[0], true
+}
+
+// countCells returns the number of cells in the row.
+func countCells(r *btpb.Row) int {
+	n := 0
+	for _, fam := range r.Families {
+		for _, col := range fam.Columns {
+			n += len(col.Cells)
+		}
+	}
+	return n
+}
+
 type chunkBuilder struct {
 	chunks []*btpb.ReadRowsResponse_CellChunk
 }
@@ -604,9 +1111,79 @@ func (cb *chunkBuilder) add(cols map[string]*btapb.ColumnFamily, r *btpb.Row) bo
 	return true
 }
 
+// blocksAll reports whether a filter provably matches no row, so that ReadRows can skip scanning. It errs on the side
+// of false, leaving filterRow to decide row by row.
+func blocksAll(f *btpb.RowFilter) bool {
+	switch f := f.GetFilter().(type) {
+	case *btpb.RowFilter_BlockAllFilter:
+		return f.BlockAllFilter
+	case *btpb.RowFilter_Chain_:
+		if len(f.Chain.Filters) < 2 {
+			return false // invalid; let filterRow report it
+		}
+		for _, sub := range f.Chain.Filters {
+			if blocksAll(sub) {
+				return true
+			}
+		}
+		return false
+	case *btpb.RowFilter_Interleave_:
+		if len(f.Interleave.Filters) < 2 {
+			return false // invalid; let filterRow report it
+		}
+		for _, sub := range f.Interleave.Filters {
+			if !blocksAll(sub) {
+				return false
+			}
+		}
+		return true
+	case *btpb.RowFilter_Condition_:
+		// A missing arm matches nothing.
+		t, fl := f.Condition.TrueFilter, f.Condition.FalseFilter
+		return (t == nil || blocksAll(t)) && (fl == nil || blocksAll(fl))
+	default:
+		return false
+	}
+}
+
+// validateFilter returns the error filterRow would report for an invalid filter anywhere in f, even in a branch no
+// row would reach. Leaf filters are checked by running them against a single-cell row.
+func validateFilter(f *btpb.RowFilter, logf logFunc) error {
+	var subs []*btpb.RowFilter
+	switch ft := f.GetFilter().(type) {
+	case *btpb.RowFilter_Chain_:
+		subs = ft.Chain.Filters
+	case *btpb.RowFilter_Interleave_:
+		subs = ft.Interleave.Filters
+	case *btpb.RowFilter_Condition_:
+		subs = []*btpb.RowFilter{ft.Condition.PredicateFilter, ft.Condition.TrueFilter, ft.Condition.FalseFilter}
+	default:
+		probe := &btpb.Row{
+			Key: []byte("row"),
+			Families: []*btpb.Family{{
+				Name:    "cf",
+				Columns: []*btpb.Column{{Qualifier: []byte("col"), Cells: []*btpb.Cell{{Value: []byte("value")}}}},
+			}},
+		}
+		_, err := filterRow(f, probe, logf)
+		return err
+	}
+	if len(subs) < 2 {
+		// Reported by filterRow regardless of the row.
+		_, err := filterRow(f, &btpb.Row{}, logf)
+		return err
+	}
+	for _, sub := range subs {
+		if err := validateFilter(sub, logf); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // filterRow modifies a row with the given filter. Returns true if at least one cell from the row matches,
 // false otherwise. If a filter is invalid, filterRow returns false and an error.
-func filterRow(f *btpb.RowFilter, r *btpb.Row) (bool, error) {
+func filterRow(f *btpb.RowFilter, r *btpb.Row, logf logFunc) (bool, error) {
 	if f == nil {
 		return true, nil
 	}
@@ -627,7 +1204,7 @@ func filterRow(f *btpb.RowFilter, r *btpb.Row) (bool, error) {
 			return false, status.Errorf(codes.InvalidArgument, "Chain must contain at least two RowFilters")
 		}
 		for _, sub := range f.Chain.Filters {
-			match, err := filterRow(sub, r)
+			match, err := filterRow(sub, r, logf)
 			if err != nil {
 				return false, err
 			}
@@ -643,7 +1220,7 @@ func filterRow(f *btpb.RowFilter, r *btpb.Row) (bool, error) {
 		srs := make([]*btpb.Row, 0, len(f.Interleave.Filters))
 		for _, sub := range f.Interleave.Filters {
 			sr := copyRow(r)
-			match, err := filterRow(sub, sr)
+			match, err := filterRow(sub, sr, logf)
 			if err != nil {
 				return false, err
 			}
@@ -682,7 +1259,7 @@ func filterRow(f *btpb.RowFilter, r *btpb.Row) (bool, error) {
 		}
 		return true, nil
 	case *btpb.RowFilter_Condition_:
-		match, err := filterRow(f.Condition.PredicateFilter, copyRow(r))
+		match, err := filterRow(f.Condition.PredicateFilter, copyRow(r), logf)
 		if err != nil {
 			return false, err
 		}
@@ -690,14 +1267,14 @@ func filterRow(f *btpb.RowFilter, r *btpb.Row) (bool, error) {
 			if f.Condition.TrueFilter == nil {
 				return false, nil
 			}
-			return filterRow(f.Condition.TrueFilter, r)
+			return filterRow(f.Condition.TrueFilter, r, logf)
 		}
 		if f.Condition.FalseFilter == nil {
 			return false, nil
 		}
-		return filterRow(f.Condition.FalseFilter, r)
+		return filterRow(f.Condition.FalseFilter, r, logf)
 	case *btpb.RowFilter_RowKeyRegexFilter:
-		rx, err := newRegexp(f.RowKeyRegexFilter)
+		rx, err := newRegexp(f.RowKeyRegexFilter, logf)
 		if err != nil {
 			return false, status.Errorf(codes.InvalidArgument, "Error in field 'rowkey_regex_filter' : %v", err)
 		}
@@ -705,7 +1282,8 @@ func filterRow(f *btpb.RowFilter, r *btpb.Row) (bool, error) {
 			return false, nil
 		}
 	case *btpb.RowFilter_CellsPerRowLimitFilter:
-		// Grab the first n cells in the row.
+		// Grab the first n cells in the row. Rows written before families were kept sorted may still be out of order.
+		sortFamilies(r)
 		lim := int(f.CellsPerRowLimitFilter)
 		for _, fam := range r.Families {
 			for _, col := range fam.Columns {
@@ -719,7 +1297,8 @@ func filterRow(f *btpb.RowFilter, r *btpb.Row) (bool, error) {
 		}
 		return true, nil
 	case *btpb.RowFilter_CellsPerRowOffsetFilter:
-		// Skip the first n cells in the row.
+		// Skip the first n cells in the row, in the same order as the limit filter.
+		sortFamilies(r)
 		offset := int(f.CellsPerRowOffsetFilter)
 		for _, fam := range r.Families {
 			for _, col := range fam.Columns {
@@ -727,8 +1306,8 @@ func filterRow(f *btpb.RowFilter, r *btpb.Row) (bool, error) {
 					col.Cells = col.Cells[offset:]
 					return true, nil
 				}
-				col.Cells = col.Cells[:0]
 				offset -= len(col.Cells)
+				col.Cells = col.Cells[:0]
 			}
 		}
 		return true, nil
@@ -746,7 +1325,7 @@ func filterRow(f *btpb.RowFilter, r *btpb.Row) (bool, error) {
 	cellCount := 0
 	for _, fam := range r.Families {
 		for _, col := range fam.Columns {
-			filtered, err := filterCells(f, fam.Name, col.Qualifier, col.Cells)
+			filtered, err := filterCells(f, fam.Name, col.Qualifier, col.Cells, logf)
 			if err != nil {
 				return false, err
 			}
@@ -759,10 +1338,10 @@ func filterRow(f *btpb.RowFilter, r *btpb.Row) (bool, error) {
 
 var randFloat = rand.Float64
 
-func filterCells(f *btpb.RowFilter, fam string, col []byte, cs []*btpb.Cell) ([]*btpb.Cell, error) {
+func filterCells(f *btpb.RowFilter, fam string, col []byte, cs []*btpb.Cell, logf logFunc) ([]*btpb.Cell, error) {
 	var ret []*btpb.Cell
 	for _, cell := range cs {
-		include, err := includeCell(f, fam, col, cell)
+		include, err := includeCell(f, fam, col, cell, logf)
 		if err != nil {
 			return nil, err
 		}
@@ -803,7 +1382,7 @@ func modifyCell(f *btpb.RowFilter, c *btpb.Cell) (*btpb.Cell, error) {
 	}
 }
 
-func includeCell(f *btpb.RowFilter, fam string, col []byte, cell *btpb.Cell) (bool, error) {
+func includeCell(f *btpb.RowFilter, fam string, col []byte, cell *btpb.Cell, logf logFunc) (bool, error) {
 	if f == nil {
 		return true, nil
 	}
@@ -822,27 +1401,31 @@ func includeCell(f *btpb.RowFilter, fam string, col []byte, cell *btpb.Cell) (bo
 		// Don't log, cell-modifying filter
 		return true, nil
 	default:
-		log.Printf("WARNING: don't know how to handle filter of type %T (ignoring it)", f)
+		logf.printf(nil, "WARNING: don't know how to handle filter of type %T (ignoring it)", f)
 		return true, nil
 	case *btpb.RowFilter_FamilyNameRegexFilter:
-		rx, err := newRegexp([]byte(f.FamilyNameRegexFilter))
+		rx, err := newRegexp([]byte(f.FamilyNameRegexFilter), logf)
 		if err != nil {
 			return false, status.Errorf(codes.InvalidArgument, "Error in field 'family_name_regex_filter' : %v", err)
 		}
 		return rx.MatchString(fam), nil
 	case *btpb.RowFilter_ColumnQualifierRegexFilter:
-		rx, err := newRegexp(f.ColumnQualifierRegexFilter)
+		rx, err := newRegexp(f.ColumnQualifierRegexFilter, logf)
 		if err != nil {
 			return false, status.Errorf(codes.InvalidArgument, "Error in field 'column_qualifier_regex_filter' : %v", err)
 		}
 		return rx.Match(col), nil
 	case *btpb.RowFilter_ValueRegexFilter:
-		rx, err := newRegexp(f.ValueRegexFilter)
+		rx, err := newRegexp(f.ValueRegexFilter, logf)
 		if err != nil {
 			return false, status.Errorf(codes.InvalidArgument, "Error in field 'value_regex_filter' : %v", err)
 		}
 		return rx.Match(cell.Value), nil
 	case *btpb.RowFilter_ColumnRangeFilter:
+		// Inverted ranges are allowed and match nothing, as in real Bigtable, but the family is required.
+		if f.ColumnRangeFilter.FamilyName == "" {
+			return false, status.Errorf(codes.InvalidArgument, "Error in field 'column_range_filter' : family_name must be set")
+		}
 		if fam != f.ColumnRangeFilter.FamilyName {
 			return false, nil
 		}
@@ -868,6 +1451,9 @@ func includeCell(f *btpb.RowFilter, fam string, col []byte, cell *btpb.Cell) (bo
 		if f.TimestampRangeFilter.StartTimestampMicros%int64(time.Millisecond/time.Microsecond) != 0 || f.TimestampRangeFilter.EndTimestampMicros%int64(time.Millisecond/time.Microsecond) != 0 {
 			return false, status.Errorf(codes.InvalidArgument, "Error in field 'timestamp_range_filter'. Maximum precision allowed in filter is millisecond.\nGot:\nStart: %v\nEnd: %v", f.TimestampRangeFilter.StartTimestampMicros, f.TimestampRangeFilter.EndTimestampMicros)
 		}
+		if end := f.TimestampRangeFilter.EndTimestampMicros; end != 0 && f.TimestampRangeFilter.StartTimestampMicros > end {
+			return false, status.Errorf(codes.InvalidArgument, "Error in field 'timestamp_range_filter'. Start timestamp must not be after end timestamp.\nGot:\nStart: %v\nEnd: %v", f.TimestampRangeFilter.StartTimestampMicros, end)
+		}
 		// Lower bound is inclusive and defaults to 0, upper bound is exclusive and defaults to infinity.
 		return cell.TimestampMicros >= f.TimestampRangeFilter.StartTimestampMicros &&
 			(f.TimestampRangeFilter.EndTimestampMicros == 0 || cell.TimestampMicros < f.TimestampRangeFilter.EndTimestampMicros), nil
@@ -895,7 +1481,10 @@ func includeCell(f *btpb.RowFilter, fam string, col []byte, cell *btpb.Cell) (bo
 
 // escapeUTF is used to escape non-ASCII characters in pattern strings passed
 // to binaryregexp. This makes regexp column and row key matching work more
-// closely to what's seen with the real BigTable.
+// closely to what's seen with the real BigTable. ASCII bytes, including regexp
+// metacharacters, are left alone: the pattern is RE2 syntax, so a client matching
+// a literal value must quote it, e.g. with regexp.QuoteMeta, just as with the
+// real Bigtable.
 func escapeUTF(in []byte) []byte {
 	var toEsc int
 	for _, c := range in {
@@ -920,32 +1509,77 @@ func escapeUTF(in []byte) []byte {
 	return out
 }
 
-func newRegexp(pat []byte) (*binaryregexp.Regexp, error) {
+func newRegexp(pat []byte, logf logFunc) (*binaryregexp.Regexp, error) {
 	re, err := binaryregexp.Compile("^(?:" + string(escapeUTF(pat)) + ")$") // match entire target
 	if err != nil {
-		log.Printf("Bad pattern %q: %v", pat, err)
+		logf.printf(err, "Bad pattern %q", pat)
 	}
 	return re, err
 }
 
+// defaultMaxRowKeyLength is the row key size limit enforced by real Bigtable.
+const defaultMaxRowKeyLength = 4 << 10
+
+func (s *server) validateRowKey(key []byte) error {
+	maxLen := s.maxKeyLen
+	if maxLen <= 0 {
+		maxLen = defaultMaxRowKeyLength
+	}
+	if len(key) > maxLen {
+		return status.Errorf(codes.InvalidArgument, "row key length %d exceeds maximum of %d bytes", len(key), maxLen)
+	}
+	return nil
+}
+
+// defaultMaxRowSize is the row size limit enforced by real Bigtable.
+const defaultMaxRowSize = 256 << 20
+
+// defaultReadChunkFlushThreshold is the most cell chunks ReadRows buffers before sending a response.
+const defaultReadChunkFlushThreshold = 1024
+
+// readChunkFlushThreshold returns the most cell chunks ReadRows sends in one response.
+func (s *server) readChunkFlushThreshold() int {
+	if s.flushChunks <= 0 {
+		return defaultReadChunkFlushThreshold
+	}
+	return s.flushChunks
+}
+
+// rowSizeLimit returns the largest row mutations may produce.
+func (s *server) rowSizeLimit() int {
+	if s.maxRowSize <= 0 {
+		return defaultMaxRowSize
+	}
+	return s.maxRowSize
+}
+
 func (s *server) MutateRow(ctx context.Context, req *btpb.MutateRowRequest) (*btpb.MutateRowResponse, error) {
+	if err := s.validateRowKey(req.RowKey); err != nil {
+		return nil, err
+	}
 	s.mu.Lock()
 	tbl, ok := s.tables[req.TableName]
 	s.mu.Unlock()
 	if !ok {
 		return nil, status.Errorf(codes.NotFound, "table %q not found", req.TableName)
 	}
+	if err := tbl.checkWritable(); err != nil {
+		return nil, err
+	}
 
+	var applied []appliedMutations
+	defer func() { s.notifyMutations(req.TableName, applied) }()
 	defer tbl.write()
 	tbl.mu.Lock()
 	defer tbl.mu.Unlock()
 	now := s.clock()
 	r := tbl.getOrCreateRow(req.RowKey)
 
-	if err := applyMutations(tbl, r, req.Mutations, now); err != nil {
+	if err := applyMutations(tbl, r, req.Mutations, now, s.rowSizeLimit()); err != nil {
 		return nil, err
 	}
 	tbl.updateRow(r)
+	applied = append(applied, appliedMutations{req.RowKey, req.Mutations})
 	return &btpb.MutateRowResponse{}, nil
 }
 
@@ -956,22 +1590,37 @@ func (s *server) MutateRows(req *btpb.MutateRowsRequest, stream btpb.Bigtable_Mu
 	if !ok {
 		return status.Errorf(codes.NotFound, "table %q not found", req.TableName)
 	}
+	if err := tbl.checkWritable(); err != nil {
+		return err
+	}
 	res := &btpb.MutateRowsResponse{Entries: make([]*btpb.MutateRowsResponse_Entry, len(req.Entries))}
 
+	var applied []appliedMutations
+	defer func() { s.notifyMutations(req.TableName, applied) }()
 	defer tbl.write()
 	tbl.mu.Lock()
 	defer tbl.mu.Unlock()
 	now := s.clock()
 
 	for i, entry := range req.Entries {
-		r := tbl.getOrCreateRow(entry.RowKey)
-
 		code, msg := int32(codes.OK), ""
-		if err := applyMutations(tbl, r, entry.Mutations, now); err != nil {
-			code = int32(codes.Internal)
+		if err := s.validateRowKey(entry.RowKey); err != nil {
+			code = int32(codes.InvalidArgument)
 			msg = err.Error()
+		} else {
+			// Each entry's row is written back before the next entry reads it, so entries for the same row see
+			// the effects of earlier ones, as if sent in separate requests.
+			r := tbl.getOrCreateRow(entry.RowKey)
+			if err := applyMutations(tbl, r, entry.Mutations, now, s.rowSizeLimit()); err != nil {
+				code, msg = int32(codes.Internal), err.Error()
+				if st, ok := status.FromError(err); ok {
+					code, msg = int32(st.Code()), st.Message()
+				}
+			} else {
+				applied = append(applied, appliedMutations{entry.RowKey, entry.Mutations})
+			}
+			tbl.updateRow(r)
 		}
-		tbl.updateRow(r)
 		res.Entries[i] = &btpb.MutateRowsResponse_Entry{
 			Index:  int64(i),
 			Status: &statpb.Status{Code: code, Message: msg},
@@ -981,14 +1630,22 @@ func (s *server) MutateRows(req *btpb.MutateRowsRequest, stream btpb.Bigtable_Mu
 }
 
 func (s *server) CheckAndMutateRow(ctx context.Context, req *btpb.CheckAndMutateRowRequest) (*btpb.CheckAndMutateRowResponse, error) {
+	if err := s.validateRowKey(req.RowKey); err != nil {
+		return nil, err
+	}
 	s.mu.Lock()
 	tbl, ok := s.tables[req.TableName]
 	s.mu.Unlock()
 	if !ok {
 		return nil, status.Errorf(codes.NotFound, "table %q not found", req.TableName)
 	}
+	if err := tbl.checkWritable(); err != nil {
+		return nil, err
+	}
 	res := &btpb.CheckAndMutateRowResponse{}
 
+	var applied []appliedMutations
+	defer func() { s.notifyMutations(req.TableName, applied) }()
 	defer tbl.write()
 	tbl.mu.Lock()
 	defer tbl.mu.Unlock()
@@ -1005,7 +1662,7 @@ func (s *server) CheckAndMutateRow(ctx context.Context, req *btpb.CheckAndMutate
 		// TODO(dsymonds): This could be cheaper.
 		nr := copyRow(r)
 
-		match, err := filterRow(req.PredicateFilter, nr)
+		match, err := filterRow(req.PredicateFilter, nr, s.log)
 		if err != nil {
 			return nil, err
 		}
@@ -1017,32 +1674,74 @@ func (s *server) CheckAndMutateRow(ctx context.Context, req *btpb.CheckAndMutate
 		muts = req.TrueMutations
 	}
 
-	if err := applyMutations(tbl, r, muts, now); err != nil {
+	if err := applyMutations(tbl, r, muts, now, s.rowSizeLimit()); err != nil {
 		return nil, err
 	}
 	tbl.updateRow(r)
+	if len(muts) > 0 {
+		applied = append(applied, appliedMutations{req.RowKey, muts})
+	}
 	return res, nil
 }
 
-// applyMutations applies a sequence of mutations to a row.
+// appliedMutations records the mutations applied to one row, for Options.OnMutation.
+type appliedMutations struct {
+	rowKey []byte
+	muts   []*btpb.Mutation
+}
+
+// notifyMutations reports applied mutations to Options.OnMutation. Callers defer it before locking the table, so
+// that it runs after the lock is released.
+func (s *server) notifyMutations(table string, applied []appliedMutations) {
+	if s.onMutation == nil {
+		return
+	}
+	for _, a := range applied {
+		s.onMutation(table, a.rowKey, a.muts)
+	}
+}
+
+// applyMutations applies a sequence of mutations to a row. If the row would then hold more than maxRowSize bytes of
+// cell values, it is restored and an InvalidArgument error is returned.
 // It assumes r.mu is locked.
-func applyMutations(tbl *table, r *btpb.Row, muts []*btpb.Mutation, now bigtable.Timestamp) error {
+func applyMutations(tbl *table, r *btpb.Row, muts []*btpb.Mutation, now bigtable.Timestamp, maxRowSize int) error {
+	// Snapshot the row for rollback only if the mutations could push it over the limit.
+	var before []*btpb.Family
+	sizeBefore := rowsize(r)
+	snapshot := sizeBefore+maxGrowth(muts) > maxRowSize
+	if snapshot {
+		before = copyRow(r).Families
+	}
+
 	fs := tbl.def.ColumnFamilies
+	// Server-assigned timestamps are the current millisecond, advanced by a millisecond for each further server-time
+	// SetCell to the same column in one request, so those writes produce distinct versions even when the clock
+	// doesn't move.
+	nowTs := int64(now.TruncateToMilliseconds())
+	var serverTs map[[2]string]int64 // last server-assigned timestamp, by family and qualifier
 	for _, mut := range muts {
 		switch mut := mut.Mutation.(type) {
 		default:
-			return fmt.Errorf("can't handle mutation type %T", mut)
+			return status.Errorf(codes.InvalidArgument, "can't handle mutation type %T", mut)
 		case *btpb.Mutation_SetCell_:
 			set := mut.SetCell
 			if _, ok := fs[set.FamilyName]; !ok {
-				return fmt.Errorf("unknown family %q", set.FamilyName)
+				return status.Errorf(codes.NotFound, "unknown family %q", set.FamilyName)
 			}
 			ts := set.TimestampMicros
 			if ts == -1 { // bigtable.ServerTime
-				ts = int64(now.TruncateToMilliseconds())
+				col := [2]string{set.FamilyName, string(set.ColumnQualifier)}
+				ts = nowTs
+				if last, ok := serverTs[col]; ok {
+					ts = last + 1000
+				}
+				if serverTs == nil {
+					serverTs = make(map[[2]string]int64)
+				}
+				serverTs[col] = ts
 			}
 			if !tbl.validTimestamp(ts) {
-				return fmt.Errorf("invalid timestamp %d", ts)
+				return status.Errorf(codes.InvalidArgument, "invalid timestamp %d", ts)
 			}
 			fam := set.FamilyName
 			col := set.ColumnQualifier
@@ -1051,10 +1750,58 @@ func applyMutations(tbl *table, r *btpb.Row, muts []*btpb.Mutation, now bigtable
 			f := getOrCreateFamily(r, fam)
 			c := getOrCreateColumn(f, col)
 			c.Cells = appendOrReplaceCell(c.Cells, newCell)
+		case *btpb.Mutation_AddToCell_:
+			add := mut.AddToCell
+			cf, ok := fs[add.FamilyName]
+			if !ok {
+				return status.Errorf(codes.NotFound, "unknown family %q", add.FamilyName)
+			}
+			if cf.GetValueType().GetAggregateType().GetSum() == nil {
+				return status.Errorf(codes.InvalidArgument, "AddToCell requires a sum aggregate family, %q is not one", add.FamilyName)
+			}
+			ts := add.GetTimestamp().GetRawTimestampMicros()
+			if !tbl.validTimestamp(ts) {
+				return status.Errorf(codes.InvalidArgument, "invalid timestamp %d", ts)
+			}
+			in, ok := add.GetInput().GetKind().(*btpb.Value_IntValue)
+			if !ok {
+				return status.Errorf(codes.InvalidArgument, "AddToCell input must be an int64 value")
+			}
+
+			f := getOrCreateFamily(r, add.FamilyName)
+			c := getOrCreateColumn(f, add.GetColumnQualifier().GetRawValue())
+			// Increments are accumulated into the cell with the same timestamp, so reads see the running sum.
+			sum := in.IntValue
+			for _, cell := range c.Cells {
+				if cell.TimestampMicros == ts {
+					if len(cell.Value) != 8 {
+						return status.Errorf(codes.InvalidArgument, "sum on non-64-bit value")
+					}
+					sum += int64(binary.BigEndian.Uint64(cell.Value))
+					break
+				}
+			}
+			var val [8]byte
+			binary.BigEndian.PutUint64(val[:], uint64(sum))
+			c.Cells = appendOrReplaceCell(c.Cells, &btpb.Cell{TimestampMicros: ts, Value: val[:]})
 		case *btpb.Mutation_DeleteFromColumn_:
 			del := mut.DeleteFromColumn
 			if _, ok := fs[del.FamilyName]; !ok {
-				return fmt.Errorf("unknown family %q", del.FamilyName)
+				return status.Errorf(codes.NotFound, "unknown family %q", del.FamilyName)
+			}
+			if tsr := del.TimeRange; tsr != nil {
+				if tsr.StartTimestampMicros%1000 != 0 || tsr.EndTimestampMicros%1000 != 0 {
+					return status.Errorf(codes.InvalidArgument, "Error in field 'delete_from_column'. Millisecond precision required for timestamp range.\nGot:\nStart: %v\nEnd: %v", tsr.StartTimestampMicros, tsr.EndTimestampMicros)
+				}
+				if !tbl.validTimestamp(tsr.StartTimestampMicros) {
+					return status.Errorf(codes.InvalidArgument, "invalid timestamp %d", tsr.StartTimestampMicros)
+				}
+				if !tbl.validTimestamp(tsr.EndTimestampMicros) && tsr.EndTimestampMicros != 0 {
+					return status.Errorf(codes.InvalidArgument, "invalid timestamp %d", tsr.EndTimestampMicros)
+				}
+				if tsr.StartTimestampMicros >= tsr.EndTimestampMicros && tsr.EndTimestampMicros != 0 {
+					return status.Errorf(codes.InvalidArgument, "inverted or invalid timestamp range [%d, %d]", tsr.StartTimestampMicros, tsr.EndTimestampMicros)
+				}
 			}
 			fam := getFamily(r, del.FamilyName)
 			if fam == nil {
@@ -1067,16 +1814,6 @@ func applyMutations(tbl *table, r *btpb.Row, muts []*btpb.Mutation, now bigtable
 			cs := col.Cells
 			if del.TimeRange != nil {
 				tsr := del.TimeRange
-				if !tbl.validTimestamp(tsr.StartTimestampMicros) {
-					return fmt.Errorf("invalid timestamp %d", tsr.StartTimestampMicros)
-				}
-				if !tbl.validTimestamp(tsr.EndTimestampMicros) && tsr.EndTimestampMicros != 0 {
-					return fmt.Errorf("invalid timestamp %d", tsr.EndTimestampMicros)
-				}
-				if tsr.StartTimestampMicros >= tsr.EndTimestampMicros && tsr.EndTimestampMicros != 0 {
-					return fmt.Errorf("inverted or invalid timestamp range [%d, %d]", tsr.StartTimestampMicros, tsr.EndTimestampMicros)
-				}
-
 				// Find half-open interval to remove.
 				// Cells are in descending timestamp order,
 				// so the predicates to sort.Search are inverted.
@@ -1103,9 +1840,30 @@ func applyMutations(tbl *table, r *btpb.Row, muts []*btpb.Mutation, now bigtable
 			}
 		}
 	}
+	if snapshot {
+		// Rows already over the limit may still shrink.
+		if size := rowsize(r); size > maxRowSize && size > sizeBefore {
+			r.Families = before
+			return status.Errorf(codes.InvalidArgument, "row %q size %d exceeds maximum of %d bytes", r.Key, size, maxRowSize)
+		}
+	}
 	return nil
 }
 
+// maxGrowth returns an upper bound on how many bytes of cell values the mutations can add to a row.
+func maxGrowth(muts []*btpb.Mutation) int {
+	n := 0
+	for _, mut := range muts {
+		switch mut := mut.Mutation.(type) {
+		case *btpb.Mutation_SetCell_:
+			n += len(mut.SetCell.Value)
+		case *btpb.Mutation_AddToCell_:
+			n += 8
+		}
+	}
+	return n
+}
+
 // Remove empty families / columns
 func scrubRow(r *btpb.Row, cols map[string]*btapb.ColumnFamily) (*btpb.Row, bool) {
 	n := len(r.Families)
@@ -1125,9 +1883,22 @@ func scrubRow(r *btpb.Row, cols map[string]*btapb.ColumnFamily) (*btpb.Row, bool
 		}
 	}
 	r.Families = r.Families[:wIdx]
+	didChange = sortFamilies(r) || didChange
 	return r, n != wIdx || didChange
 }
 
+// sortFamilies puts a row's families in name order, so per-row cell limits and offsets count cells in (family,
+// qualifier) order as real Bigtable does, rather than in the order families were first written. It reports whether
+// the order changed.
+func sortFamilies(r *btpb.Row) bool {
+	less := func(i, j int) bool { return r.Families[i].Name < r.Families[j].Name }
+	if sort.SliceIsSorted(r.Families, less) {
+		return false
+	}
+	sort.Slice(r.Families, less)
+	return true
+}
+
 // Remove empty columns
 func scrubFam(f *btpb.Family) (*btpb.Family, bool) {
 	n := len(f.Columns)
@@ -1169,12 +1940,18 @@ func appendOrReplaceCell(cs []*btpb.Cell, newCell *btpb.Cell) []*btpb.Cell {
 }
 
 func (s *server) ReadModifyWriteRow(ctx context.Context, req *btpb.ReadModifyWriteRowRequest) (*btpb.ReadModifyWriteRowResponse, error) {
+	if err := s.validateRowKey(req.RowKey); err != nil {
+		return nil, err
+	}
 	s.mu.Lock()
 	tbl, ok := s.tables[req.TableName]
 	s.mu.Unlock()
 	if !ok {
 		return nil, status.Errorf(codes.NotFound, "table %q not found", req.TableName)
 	}
+	if err := tbl.checkWritable(); err != nil {
+		return nil, err
+	}
 
 	defer tbl.write()
 	tbl.mu.Lock()
@@ -1183,13 +1960,21 @@ func (s *server) ReadModifyWriteRow(ctx context.Context, req *btpb.ReadModifyWri
 	r := tbl.getOrCreateRow(req.RowKey)
 	resultRow := &btpb.Row{Key: req.RowKey} // copy of updated cells
 	cols := tbl.cols()
+	// Appends can grow a cell by its whole previous value, so always snapshot the row for a size rollback.
+	sizeBefore := rowsize(r)
+	before := copyRow(r).Families
 
 	// Assume all mutations apply to the most recent version of the cell.
 	// TODO(dsymonds): Verify this assumption and document it in the proto.
 	for _, rule := range req.Rules {
-		if _, ok := cols[rule.FamilyName]; !ok {
+		cf, ok := cols[rule.FamilyName]
+		if !ok {
 			return nil, fmt.Errorf("unknown family %q", rule.FamilyName)
 		}
+		if cf.GetValueType().GetAggregateType() != nil {
+			// A read-modify-write would store a running total in a new cell, which the aggregate would then double count.
+			return nil, status.Errorf(codes.InvalidArgument, "ReadModifyWriteRow is not supported on aggregate family %q, use AddToCell", rule.FamilyName)
+		}
 
 		fam := getOrCreateFamily(r, rule.FamilyName)
 		col := getOrCreateColumn(fam, rule.ColumnQualifier)
@@ -1232,12 +2017,73 @@ func (s *server) ReadModifyWriteRow(ctx context.Context, req *btpb.ReadModifyWri
 		resultCol.Cells = []*btpb.Cell{newCell}
 	}
 
+	// As in applyMutations, rows already over the limit may still shrink.
+	if size, maxRowSize := rowsize(r), s.rowSizeLimit(); size > maxRowSize && size > sizeBefore {
+		r.Families = before
+		return nil, status.Errorf(codes.InvalidArgument, "row %q size %d exceeds maximum of %d bytes", r.Key, size, maxRowSize)
+	}
+
 	r, _ = scrubRow(r, cols)
 	tbl.rows.ReplaceOrInsert(r)
 	resultRow, _ = scrubRow(resultRow, cols)
 	return &btpb.ReadModifyWriteRowResponse{Row: resultRow}, nil
 }
 
+// PingAndWarm succeeds for any well-formed instance name, since the emulator has no record of which instances exist.
+func (s *server) PingAndWarm(ctx context.Context, req *btpb.PingAndWarmRequest) (*btpb.PingAndWarmResponse, error) {
+	parts := strings.Split(req.Name, "/")
+	if len(parts) != 4 || parts[0] != "projects" || parts[2] != "instances" || parts[1] == "" || parts[3] == "" {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid instance name %q", req.Name)
+	}
+	// Like CreateTable, any instance is assumed to exist.
+	return &btpb.PingAndWarmResponse{}, nil
+}
+
+func (s *server) GenerateInitialChangeStreamPartitions(req *btpb.GenerateInitialChangeStreamPartitionsRequest, stream btpb.Bigtable_GenerateInitialChangeStreamPartitionsServer) error {
+	if !s.changeStream {
+		return status.Errorf(codes.Unimplemented, "method GenerateInitialChangeStreamPartitions not implemented")
+	}
+	s.mu.Lock()
+	_, ok := s.tables[req.TableName]
+	s.mu.Unlock()
+	if !ok {
+		return status.Errorf(codes.NotFound, "table %q not found", req.TableName)
+	}
+
+	// A single partition covering the full key range.
+	return stream.Send(&btpb.GenerateInitialChangeStreamPartitionsResponse{
+		Partition: &btpb.StreamPartition{RowRange: &btpb.RowRange{}},
+	})
+}
+
+func (s *server) ReadChangeStream(req *btpb.ReadChangeStreamRequest, stream btpb.Bigtable_ReadChangeStreamServer) error {
+	if !s.changeStream {
+		return status.Errorf(codes.Unimplemented, "method ReadChangeStream not implemented")
+	}
+	s.mu.Lock()
+	_, ok := s.tables[req.TableName]
+	s.mu.Unlock()
+	if !ok {
+		return status.Errorf(codes.NotFound, "table %q not found", req.TableName)
+	}
+
+	// The emulator records no change history, so just report a heartbeat at the current time and close.
+	partition := req.Partition
+	if partition == nil {
+		partition = &btpb.StreamPartition{RowRange: &btpb.RowRange{}}
+	}
+	now := s.clock()
+	return stream.Send(&btpb.ReadChangeStreamResponse{
+		StreamRecord: &btpb.ReadChangeStreamResponse_Heartbeat_{Heartbeat: &btpb.ReadChangeStreamResponse_Heartbeat{
+			ContinuationToken: &btpb.StreamContinuationToken{
+				Partition: partition,
+				Token:     strconv.FormatInt(int64(now), 10),
+			},
+			EstimatedLowWatermark: timestamppb.New(now.Time()),
+		}},
+	})
+}
+
 func (s *server) SampleRowKeys(req *btpb.SampleRowKeysRequest, stream btpb.Bigtable_SampleRowKeysServer) error {
 	s.mu.Lock()
 	tbl, ok := s.tables[req.TableName]
@@ -1249,6 +2095,10 @@ func (s *server) SampleRowKeys(req *btpb.SampleRowKeysRequest, stream btpb.Bigta
 	tbl.mu.RLock()
 	defer tbl.mu.RUnlock()
 
+	if len(s.splitPoints) > 0 {
+		return sampleSplitPoints(tbl, s.splitPoints, stream)
+	}
+
 	// The return value of SampleRowKeys is very loosely defined. Return at least the
 	// final row key in the table and choose other row keys randomly.
 	var offset int64
@@ -1281,6 +2131,37 @@ func (s *server) SampleRowKeys(req *btpb.SampleRowKeysRequest, stream btpb.Bigta
 	return err
 }
 
+// sampleSplitPoints reports the given split points, in sorted order, with the offset of each being the total size of
+// all rows that precede it. Split points beyond the last row in the table are not reported.
+// Must hold table lock.
+func sampleSplitPoints(tbl *table, splitPoints [][]byte, stream btpb.Bigtable_SampleRowKeysServer) error {
+	splits := append([][]byte{}, splitPoints...)
+	sort.Slice(splits, func(i, j int) bool {
+		return bytes.Compare(splits[i], splits[j]) < 0
+	})
+
+	var offset int64
+	var err error
+	i := 0
+	tbl.rows.Ascend(func(r *btpb.Row) bool {
+		for ; i < len(splits) && bytes.Compare(splits[i], r.Key) <= 0; i++ {
+			err = stream.Send(&btpb.SampleRowKeysResponse{
+				RowKey:      splits[i],
+				OffsetBytes: offset,
+			})
+			if err != nil {
+				return false
+			}
+		}
+		offset += int64(rowsize(r))
+		return i < len(splits)
+	})
+	return err
+}
+
+// defaultGcQuiesce is how long a table must be idle before background GC visits it.
+const defaultGcQuiesce = 5 * time.Minute
+
 func (s *server) gcloop() {
 	const (
 		minWait = 15000 // ms
@@ -1288,8 +2169,11 @@ func (s *server) gcloop() {
 	)
 
 	for {
-		// Wait for a random time interval.
-		d := time.Duration(minWait+rand.Intn(maxWait-minWait)) * time.Millisecond
+		// Wait for the configured interval, or a random one.
+		d := s.gcInterval
+		if d <= 0 {
+			d = time.Duration(minWait+rand.Intn(maxWait-minWait)) * time.Millisecond
+		}
 		select {
 		case <-time.After(d):
 		case <-s.done:
@@ -1313,7 +2197,7 @@ func (s *server) gcloop() {
 		})
 
 		for _, todo := range todos {
-			todo.tbl.gc(s.clock(), s.done, false)
+			todo.tbl.gc(s.clock(), s.gcQuiesce, s.done, false)
 		}
 	}
 }
@@ -1325,9 +2209,11 @@ type table struct {
 
 	lastReadNanos  int64 // atomic, time in nanos on the real system clock
 	lastWriteNanos int64 // atomic, time in nanos on the real system clock
+	readOnly       int32 // atomic, nonzero while writes are rejected
+	log            logFunc
 }
 
-func newTable(tbl *btapb.Table, rows Rows) *table {
+func newTable(tbl *btapb.Table, rows Rows, log logFunc) *table {
 	if tbl.ColumnFamilies == nil {
 		tbl.ColumnFamilies = map[string]*btapb.ColumnFamily{}
 	}
@@ -1337,9 +2223,18 @@ func newTable(tbl *btapb.Table, rows Rows) *table {
 		lastReadNanos:  realNow,
 		lastWriteNanos: realNow,
 		rows:           rows,
+		log:            log,
 	}
 }
 
+// checkWritable returns a FailedPrecondition error if the table has been marked read-only.
+func (t *table) checkWritable() error {
+	if atomic.LoadInt32(&t.readOnly) != 0 {
+		return status.Errorf(codes.FailedPrecondition, "table %q is read-only", t.def.Name)
+	}
+	return nil
+}
+
 func (t *table) cols() map[string]*btapb.ColumnFamily {
 	return t.def.ColumnFamilies
 }
@@ -1349,8 +2244,8 @@ func (t *table) validTimestamp(ts int64) bool {
 		return false
 	}
 
-	// Assume millisecond granularity is required.
-	return ts%1000 == 0
+	unit, ok := granularityMicros[t.def.Granularity]
+	return ok && ts%unit == 0
 }
 
 // Must hold table lock.
@@ -1372,10 +2267,15 @@ func (t *table) updateRow(r *btpb.Row) {
 	}
 }
 
-func (t *table) gc(now bigtable.Timestamp, done <-chan struct{}, force bool) {
+// gc collects garbage cells from the table. Unless forced, it does nothing until the table has been idle for the
+// quiesce duration; if that is zero, it defaults to defaultGcQuiesce.
+func (t *table) gc(now bigtable.Timestamp, quiesce time.Duration, done <-chan struct{}, force bool) {
 	if !force {
 		// Recheck lastReadNanos/lastWriteNanos
-		const quiesceNanos = int64(5 * time.Minute)
+		if quiesce <= 0 {
+			quiesce = defaultGcQuiesce
+		}
+		quiesceNanos := int64(quiesce)
 		lr := atomic.LoadInt64(&t.lastReadNanos)
 		lw := atomic.LoadInt64(&t.lastWriteNanos)
 		realNow := time.Now().UnixNano()
@@ -1391,33 +2291,22 @@ func (t *table) gc(now bigtable.Timestamp, done <-chan struct{}, force bool) {
 	t.mu.Lock()
 	defer t.mu.Unlock()
 
-	// Gather GC rules we'll apply.
-	rules := make(map[string]*btapb.GcRule) // keyed by "fam"
-	for fam, cf := range t.cols() {
-		if cf.GcRule != nil {
-			rules[fam] = cf.GcRule
-		}
-	}
+	rules := t.gcRules()
 	if len(rules) == 0 {
 		return
 	}
 
 	// TODO(scottb): could collect batches of rows that need GC with only a read lock, update with write lock.
 
-	i := 0
-	t.rows.Ascend(func(r *btpb.Row) bool {
-		changed := false
-		for _, fam := range r.Families {
-			gcRule := rules[fam.Name]
-			if gcRule != nil {
-				for _, col := range fam.Columns {
-					n := len(col.Cells)
-					col.Cells = applyGC(col.Cells, gcRule, now)
-					changed = changed || n != len(col.Cells)
-				}
-			}
+	i, deleted := 0, 0
+	defer func() {
+		if deleted > 0 {
+			t.log.printf(nil, "bttest: GC deleted %d cells from %s.", deleted, t.def.Name)
 		}
-		if changed {
+	}()
+	t.rows.Ascend(func(r *btpb.Row) bool {
+		if n := gcRow(r, rules, now, t.log); n > 0 {
+			deleted += n
 			r, _ := scrubRow(r, t.cols())
 			t.rows.ReplaceOrInsert(r)
 		}
@@ -1438,6 +2327,35 @@ func (t *table) gc(now bigtable.Timestamp, done <-chan struct{}, force bool) {
 	})
 }
 
+// gcRules returns the GC rule of each column family that has one, keyed by family name.
+// Must hold table lock.
+func (t *table) gcRules() map[string]*btapb.GcRule {
+	rules := make(map[string]*btapb.GcRule)
+	for fam, cf := range t.cols() {
+		if cf.GcRule != nil {
+			rules[fam] = cf.GcRule
+		}
+	}
+	return rules
+}
+
+// gcRow applies the GC rules to every column in the row, returning the number of cells removed.
+func gcRow(r *btpb.Row, rules map[string]*btapb.GcRule, now bigtable.Timestamp, logf logFunc) int {
+	deleted := 0
+	for _, fam := range r.Families {
+		gcRule := rules[fam.Name]
+		if gcRule == nil {
+			continue
+		}
+		for _, col := range fam.Columns {
+			n := len(col.Cells)
+			col.Cells = applyGC(col.Cells, gcRule, now, logf)
+			deleted += n - len(col.Cells)
+		}
+	}
+	return deleted
+}
+
 func (t *table) read() {
 	now := time.Now().UnixNano()
 	for {
@@ -1530,18 +2448,32 @@ func rowsize(r *btpb.Row) int {
 var gcTypeWarn sync.Once
 
 // applyGC applies the given GC rule to the cells.
-func applyGC(cells []*btpb.Cell, rule *btapb.GcRule, now bigtable.Timestamp) []*btpb.Cell {
+// Cells are in descending timestamp order and every rule keeps a prefix of them, so a union keeps the shortest
+// prefix any sub-rule keeps and an intersection the longest.
+func applyGC(cells []*btpb.Cell, rule *btapb.GcRule, now bigtable.Timestamp, logf logFunc) []*btpb.Cell {
 	switch rule := rule.Rule.(type) {
 	default:
-		// TODO(dsymonds): Support GcRule_Intersection_
 		gcTypeWarn.Do(func() {
-			log.Printf("Unsupported GC rule type %T", rule)
+			logf.printf(nil, "Unsupported GC rule type %T", rule)
 		})
 	case *btapb.GcRule_Union_:
+		// A cell is collected if any sub-rule would collect it.
 		for _, sub := range rule.Union.Rules {
-			cells = applyGC(cells, sub, now)
+			cells = applyGC(cells, sub, now, logf)
 		}
 		return cells
+	case *btapb.GcRule_Intersection_:
+		// A cell is collected only if every sub-rule would collect it.
+		if len(rule.Intersection.Rules) == 0 {
+			return cells
+		}
+		keep := 0
+		for _, sub := range rule.Intersection.Rules {
+			if n := len(applyGC(cells, sub, now, logf)); n > keep {
+				keep = n
+			}
+		}
+		return cells[:keep]
 	case *btapb.GcRule_MaxAge:
 		// Timestamps are in microseconds.
 		cutoff := int64(now)
@@ -1550,9 +2482,6 @@ func applyGC(cells []*btpb.Cell, rule *btapb.GcRule, now bigtable.Timestamp) []*
 		// The slice of cells in in descending timestamp order.
 		// This sort.Search will return the index of the first cell whose timestamp is chronologically before the cutoff.
 		si := sort.Search(len(cells), func(i int) bool { return cells[i].TimestampMicros < cutoff })
-		if si < len(cells) {
-			log.Printf("bttest: GC MaxAge(%v) deleted %d cells.", rule.MaxAge, len(cells)-si)
-		}
 		return cells[:si]
 	case *btapb.GcRule_MaxNumVersions:
 		n := int(rule.MaxNumVersions)
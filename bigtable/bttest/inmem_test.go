@@ -17,24 +17,37 @@ package bttest
 import (
 	"bytes"
 	"context"
+	"encoding/binary"
 	"fmt"
 	"io"
 	"math"
 	"math/rand"
+	"regexp"
 	"sort"
 	"strconv"
+	"strings"
+	"sync"
 	"sync/atomic"
 	"testing"
 	"time"
 
+	"cloud.google.com/go/bigtable"
 	btapb "cloud.google.com/go/bigtable/admin/apiv2/adminpb"
 	btpb "cloud.google.com/go/bigtable/apiv2/bigtablepb"
 	"github.com/golang/protobuf/ptypes/wrappers"
 	"github.com/google/go-cmp/cmp"
 	"golang.org/x/sync/errgroup"
+	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/reflection"
+	reflectionpb "google.golang.org/grpc/reflection/grpc_reflection_v1"
 	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
 )
 
 type clientIntf struct {
@@ -196,6 +209,89 @@ func TestCreateTableResponse(t *testing.T) {
 	}
 }
 
+func TestPingAndWarm(t *testing.T) {
+	ctx := context.Background()
+	svr := &server{
+		tableSet: newTableSet(),
+		storage:  BtreeStorage{},
+	}
+	parent := "projects/project/instances/instance"
+
+	// A freshly started emulator accepts channel priming before any table exists.
+	if _, err := svr.PingAndWarm(ctx, &btpb.PingAndWarmRequest{Name: parent}); err != nil {
+		t.Fatalf("PingAndWarm before any table: %v", err)
+	}
+	if _, err := svr.CreateTable(ctx, &btapb.CreateTableRequest{Parent: parent, TableId: "t"}); err != nil {
+		t.Fatalf("Creating table: %v", err)
+	}
+	if _, err := svr.PingAndWarm(ctx, &btpb.PingAndWarmRequest{Name: parent}); err != nil {
+		t.Fatalf("PingAndWarm: %v", err)
+	}
+	_, err := svr.PingAndWarm(ctx, &btpb.PingAndWarmRequest{Name: "projects/project"})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("PingAndWarm malformed name: got %v, want InvalidArgument", err)
+	}
+}
+
+func TestChangeStream(t *testing.T) {
+	ctx := context.Background()
+	svr, s := newTestServer(t, func(svr *server) {
+		svr.clock = func() bigtable.Timestamp { return 5000 }
+	})
+	if _, err := s.CreateTable(ctx, &btapb.CreateTableRequest{Parent: s.parent, TableId: s.name}); err != nil {
+		t.Fatalf("Creating table: %v", err)
+	}
+
+	// Disabled by default.
+	_, err := s.GenerateInitialChangeStreamPartitions(ctx, &btpb.GenerateInitialChangeStreamPartitionsRequest{TableName: s.tblName})
+	if status.Code(err) != codes.Unimplemented {
+		t.Fatalf("GenerateInitialChangeStreamPartitions: got %v, want Unimplemented", err)
+	}
+	_, err = s.ReadChangeStream(ctx, &btpb.ReadChangeStreamRequest{TableName: s.tblName})
+	if status.Code(err) != codes.Unimplemented {
+		t.Fatalf("ReadChangeStream: got %v, want Unimplemented", err)
+	}
+
+	svr.changeStream = true
+	pstream, err := s.GenerateInitialChangeStreamPartitions(ctx, &btpb.GenerateInitialChangeStreamPartitionsRequest{TableName: s.tblName})
+	if err != nil {
+		t.Fatalf("GenerateInitialChangeStreamPartitions: %v", err)
+	}
+	pres, err := pstream.Recv()
+	if err != nil {
+		t.Fatalf("GenerateInitialChangeStreamPartitions response: %v", err)
+	}
+	partition := pres.Partition
+	if rr := partition.GetRowRange(); rr == nil || rr.StartKey != nil || rr.EndKey != nil {
+		t.Fatalf("Partition: got %v, want the full key range", partition)
+	}
+	if _, err := pstream.Recv(); err != io.EOF {
+		t.Fatalf("Partition count: want exactly one partition, got err %v", err)
+	}
+
+	cstream, err := s.ReadChangeStream(ctx, &btpb.ReadChangeStreamRequest{TableName: s.tblName, Partition: partition})
+	if err != nil {
+		t.Fatalf("ReadChangeStream: %v", err)
+	}
+	cres, err := cstream.Recv()
+	if err != nil {
+		t.Fatalf("ReadChangeStream response: %v", err)
+	}
+	hb := cres.GetHeartbeat()
+	if hb == nil {
+		t.Fatalf("ReadChangeStream: got %v, want a heartbeat", cres)
+	}
+	if !proto.Equal(partition, hb.GetContinuationToken().GetPartition()) {
+		t.Errorf("Heartbeat partition: got %v, want %v", hb.GetContinuationToken().GetPartition(), partition)
+	}
+	if got, want := hb.GetEstimatedLowWatermark().AsTime(), bigtable.Timestamp(5000).Time(); !got.Equal(want) {
+		t.Errorf("Heartbeat watermark: got %v, want %v", got, want)
+	}
+	if _, err := cstream.Recv(); err != io.EOF {
+		t.Fatalf("ReadChangeStream: want the stream to close after the heartbeat, got err %v", err)
+	}
+}
+
 func TestCreateTableWithFamily(t *testing.T) {
 	// The Go client currently doesn't support creating a table with column families
 	// in one operation but it is allowed by the API. This must still be supported by the
@@ -294,6 +390,63 @@ func TestSampleRowKeys(t *testing.T) {
 	}
 }
 
+func TestSampleRowKeysSplitPoints(t *testing.T) {
+	ctx := context.Background()
+	_, s := newTestServer(t, func(svr *server) {
+		svr.storage = LeveldbMemStorage{}
+		svr.splitPoints = [][]byte{[]byte("row-7"), []byte("zzz"), []byte("row-3")}
+	})
+
+	newTbl := btapb.Table{
+		ColumnFamilies: map[string]*btapb.ColumnFamily{
+			"cf": {},
+		},
+	}
+	if _, err := s.CreateTable(ctx, &btapb.CreateTableRequest{Parent: s.parent, TableId: s.name, Table: &newTbl}); err != nil {
+		t.Fatalf("Creating table: %v", err)
+	}
+
+	// An empty table reports no split points.
+	responses, err := sampleRowKeys(ctx, s, &btpb.SampleRowKeysRequest{TableName: s.tblName})
+	if err != nil {
+		t.Fatalf("SampleRowKeys error: %v", err)
+	}
+	if len(responses) != 0 {
+		t.Fatalf("Response count: got %d, want 0", len(responses))
+	}
+
+	val := []byte("value")
+	for i := 0; i < 10; i++ {
+		req := &btpb.MutateRowRequest{
+			TableName: s.tblName,
+			RowKey:    []byte("row-" + strconv.Itoa(i)),
+			Mutations: []*btpb.Mutation{{
+				Mutation: &btpb.Mutation_SetCell_{SetCell: &btpb.Mutation_SetCell{
+					FamilyName:      "cf",
+					ColumnQualifier: []byte("col"),
+					TimestampMicros: 1000,
+					Value:           val,
+				}},
+			}},
+		}
+		if _, err := s.MutateRow(ctx, req); err != nil {
+			t.Fatalf("Populating table: %v", err)
+		}
+	}
+
+	responses, err = sampleRowKeys(ctx, s, &btpb.SampleRowKeysRequest{TableName: s.tblName})
+	if err != nil {
+		t.Fatalf("SampleRowKeys error: %v", err)
+	}
+	want := []*btpb.SampleRowKeysResponse{
+		{RowKey: []byte("row-3"), OffsetBytes: int64(3 * len(val))},
+		{RowKey: []byte("row-7"), OffsetBytes: int64(7 * len(val))},
+	}
+	if diff := cmp.Diff(want, responses, cmp.Comparer(proto.Equal)); diff != "" {
+		t.Fatalf("Response mismatch: got: + want -\n%s", diff)
+	}
+}
+
 func TestTableRowsConcurrent(t *testing.T) {
 	ctx, s, ok := newClient(t)
 	if !ok {
@@ -426,6 +579,230 @@ func TestModifyColumnFamilies(t *testing.T) {
 	readRows(18, 6, 2)
 }
 
+func TestModifyColumnFamiliesErrors(t *testing.T) {
+	ctx, s, ok := newClient(t)
+	if err := populateTable(ctx, s, ok); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		desc string
+		mod  *btapb.ModifyColumnFamiliesRequest_Modification
+		want codes.Code
+	}{
+		{"create existing", &btapb.ModifyColumnFamiliesRequest_Modification{
+			Id:  "cf0",
+			Mod: &btapb.ModifyColumnFamiliesRequest_Modification_Create{Create: &btapb.ColumnFamily{}},
+		}, codes.AlreadyExists},
+		{"drop missing", &btapb.ModifyColumnFamiliesRequest_Modification{
+			Id:  "nope",
+			Mod: &btapb.ModifyColumnFamiliesRequest_Modification_Drop{Drop: true},
+		}, codes.NotFound},
+		{"update missing", &btapb.ModifyColumnFamiliesRequest_Modification{
+			Id:  "nope",
+			Mod: &btapb.ModifyColumnFamiliesRequest_Modification_Update{Update: &btapb.ColumnFamily{}},
+		}, codes.NotFound},
+		{"no change", &btapb.ModifyColumnFamiliesRequest_Modification{Id: "cf0"}, codes.InvalidArgument},
+	} {
+		_, err := s.ModifyColumnFamilies(ctx, &btapb.ModifyColumnFamiliesRequest{
+			Name:          s.tblName,
+			Modifications: []*btapb.ModifyColumnFamiliesRequest_Modification{tc.mod},
+		})
+		if got := status.Code(err); got != tc.want {
+			t.Errorf("%s: got %v, want %v", tc.desc, err, tc.want)
+		}
+	}
+}
+
+func TestModifyColumnFamiliesResponse(t *testing.T) {
+	ctx, s, _ := newClient(t)
+	oldRule := &btapb.GcRule{Rule: &btapb.GcRule_MaxNumVersions{MaxNumVersions: 1}}
+	newRule := &btapb.GcRule{Rule: &btapb.GcRule_MaxAge{MaxAge: durationpb.New(time.Hour)}}
+	if _, err := s.CreateTable(ctx, &btapb.CreateTableRequest{
+		Parent:  s.parent,
+		TableId: s.name,
+		Table: &btapb.Table{
+			Granularity:    btapb.Table_MILLIS,
+			ColumnFamilies: map[string]*btapb.ColumnFamily{"cf": {GcRule: oldRule}, "other": {}},
+		},
+	}); err != nil {
+		t.Fatalf("Creating table: %v", err)
+	}
+	drop := &btapb.ModifyColumnFamiliesRequest_Modification{
+		Id:  "cf",
+		Mod: &btapb.ModifyColumnFamiliesRequest_Modification_Drop{Drop: true},
+	}
+	create := func(rule *btapb.GcRule) *btapb.ModifyColumnFamiliesRequest_Modification {
+		return &btapb.ModifyColumnFamiliesRequest_Modification{
+			Id:  "cf",
+			Mod: &btapb.ModifyColumnFamiliesRequest_Modification_Create{Create: &btapb.ColumnFamily{GcRule: rule}},
+		}
+	}
+	modify := func(mods ...*btapb.ModifyColumnFamiliesRequest_Modification) *btapb.Table {
+		t.Helper()
+		tbl, err := s.ModifyColumnFamilies(ctx, &btapb.ModifyColumnFamiliesRequest{Name: s.tblName, Modifications: mods})
+		if err != nil {
+			t.Fatalf("ModifyColumnFamilies: %v", err)
+		}
+		return tbl
+	}
+	check := func(desc string, tbl *btapb.Table, rule *btapb.GcRule) {
+		t.Helper()
+		want := &btapb.Table{
+			Name:           s.tblName,
+			Granularity:    btapb.Table_MILLIS,
+			ColumnFamilies: map[string]*btapb.ColumnFamily{"cf": {GcRule: rule}, "other": {}},
+		}
+		if diff := cmp.Diff(want, tbl, cmp.Comparer(proto.Equal)); diff != "" {
+			t.Errorf("%s: table mismatch (-want +got):\n%s", desc, diff)
+		}
+	}
+
+	// Recreating a dropped family, in one request or two, reports only the new rule.
+	check("drop and create", modify(drop, create(newRule)), newRule)
+	dropped := modify(drop)
+	if _, ok := dropped.ColumnFamilies["cf"]; ok {
+		t.Errorf("dropped family still reported: %v", dropped)
+	}
+	recreated := modify(create(oldRule))
+	check("create after drop", recreated, oldRule)
+
+	// Each response is a snapshot of the schema at the time.
+	modify(drop, create(newRule))
+	check("earlier response", recreated, oldRule)
+	if _, ok := dropped.ColumnFamilies["cf"]; ok {
+		t.Errorf("earlier response changed: %v", dropped)
+	}
+}
+
+func TestModifyColumnFamiliesDropEmptiesRows(t *testing.T) {
+	forEachStorage(t, func(t *testing.T, storage Storage) {
+		ctx := context.Background()
+		_, s := newTestServer(t, func(svr *server) { svr.storage = storage })
+		newTbl := btapb.Table{
+			ColumnFamilies: map[string]*btapb.ColumnFamily{"cf": {}, "other": {}},
+		}
+		if _, err := s.CreateTable(ctx, &btapb.CreateTableRequest{Parent: s.parent, TableId: s.name, Table: &newTbl}); err != nil {
+			t.Fatalf("Creating table: %v", err)
+		}
+		// Most rows hold only the dropped family, so dropping it deletes them; every tenth also keeps a cell in "other".
+		const numRows = 2000
+		for i := 0; i < numRows; i++ {
+			muts := []*btpb.Mutation{{Mutation: &btpb.Mutation_SetCell_{SetCell: &btpb.Mutation_SetCell{
+				FamilyName: "cf", ColumnQualifier: []byte("col"), TimestampMicros: 1000, Value: []byte("value"),
+			}}}}
+			if i%10 == 0 {
+				muts = append(muts, &btpb.Mutation{Mutation: &btpb.Mutation_SetCell_{SetCell: &btpb.Mutation_SetCell{
+					FamilyName: "other", ColumnQualifier: []byte("col"), TimestampMicros: 1000, Value: []byte("value"),
+				}}})
+			}
+			req := &btpb.MutateRowRequest{TableName: s.tblName, RowKey: []byte(fmt.Sprintf("row-%04d", i)), Mutations: muts}
+			if _, err := s.MutateRow(ctx, req); err != nil {
+				t.Fatalf("Populating table: %v", err)
+			}
+		}
+
+		if _, err := s.ModifyColumnFamilies(ctx, &btapb.ModifyColumnFamiliesRequest{
+			Name: s.tblName,
+			Modifications: []*btapb.ModifyColumnFamiliesRequest_Modification{{
+				Id:  "cf",
+				Mod: &btapb.ModifyColumnFamiliesRequest_Modification_Drop{Drop: true},
+			}},
+		}); err != nil {
+			t.Fatalf("ModifyColumnFamilies: %v", err)
+		}
+
+		responses, err := readRows(ctx, s, &btpb.ReadRowsRequest{TableName: s.tblName})
+		if err != nil {
+			t.Fatalf("ReadRows: %v", err)
+		}
+		var keys int
+		for _, res := range responses {
+			for _, c := range res.Chunks {
+				if c.FamilyName.GetValue() == "cf" {
+					t.Fatalf("Cell from dropped family remains: %v", c)
+				}
+				if c.GetCommitRow() {
+					keys++
+				}
+			}
+		}
+		if want := numRows / 10; keys != want {
+			t.Errorf("Got %d rows after drop, want %d", keys, want)
+		}
+	})
+}
+
+func TestDefaultGcRule(t *testing.T) {
+	ctx := context.Background()
+	defaultRule := &btapb.GcRule{Rule: &btapb.GcRule_MaxNumVersions{MaxNumVersions: 1}}
+	svr, s := newTestServer(t, func(svr *server) { svr.defaultGcRule = defaultRule })
+
+	explicitRule := &btapb.GcRule{Rule: &btapb.GcRule_MaxNumVersions{MaxNumVersions: 3}}
+	newTbl := btapb.Table{
+		ColumnFamilies: map[string]*btapb.ColumnFamily{
+			"cf":       {},
+			"explicit": {GcRule: explicitRule},
+		},
+	}
+	if _, err := s.CreateTable(ctx, &btapb.CreateTableRequest{Parent: s.parent, TableId: s.name, Table: &newTbl}); err != nil {
+		t.Fatalf("Creating table: %v", err)
+	}
+	if _, err := s.ModifyColumnFamilies(ctx, &btapb.ModifyColumnFamiliesRequest{
+		Name: s.tblName,
+		Modifications: []*btapb.ModifyColumnFamiliesRequest_Modification{{
+			Id:  "added",
+			Mod: &btapb.ModifyColumnFamiliesRequest_Modification_Create{Create: &btapb.ColumnFamily{}},
+		}},
+	}); err != nil {
+		t.Fatalf("ModifyColumnFamilies error: %v", err)
+	}
+
+	tbl, err := s.GetTable(ctx, &btapb.GetTableRequest{Name: s.tblName})
+	if err != nil {
+		t.Fatalf("GetTable error: %v", err)
+	}
+	for fam, want := range map[string]*btapb.GcRule{"cf": defaultRule, "added": defaultRule, "explicit": explicitRule} {
+		if got := tbl.ColumnFamilies[fam].GetGcRule(); !proto.Equal(got, want) {
+			t.Errorf("GcRule for %q: got %v, want %v", fam, got, want)
+		}
+	}
+
+	// Write several versions of a cell; GC should keep only the newest.
+	for ts := int64(1000); ts <= 3000; ts += 1000 {
+		req := &btpb.MutateRowRequest{
+			TableName: s.tblName,
+			RowKey:    []byte("row"),
+			Mutations: []*btpb.Mutation{{
+				Mutation: &btpb.Mutation_SetCell_{SetCell: &btpb.Mutation_SetCell{
+					FamilyName:      "cf",
+					ColumnQualifier: []byte("col"),
+					TimestampMicros: ts,
+					Value:           []byte("value"),
+				}},
+			}},
+		}
+		if _, err := s.MutateRow(ctx, req); err != nil {
+			t.Fatalf("Populating table: %v", err)
+		}
+	}
+	svr.tables[s.tblName].gc(0, 0, nil, true)
+
+	responses, err := readRows(ctx, s, &btpb.ReadRowsRequest{TableName: s.tblName})
+	if err != nil {
+		t.Fatalf("ReadRows error: %v", err)
+	}
+	var timestamps []int64
+	for _, res := range responses {
+		for _, c := range res.Chunks {
+			timestamps = append(timestamps, c.TimestampMicros)
+		}
+	}
+	if diff := cmp.Diff([]int64{3000}, timestamps); diff != "" {
+		t.Fatalf("Cells after GC mismatch (-want +got):\n%s", diff)
+	}
+}
+
 func TestDropRowRange(t *testing.T) {
 	ctx, s, ok := newClient(t)
 	if !ok {
@@ -614,124 +991,226 @@ func TestCheckTimestampMaxValue(t *testing.T) {
 	}
 }
 
-func TestReadRows(t *testing.T) {
-	ctx, s, ok := newClient(t)
-	if !ok {
-		newTbl := btapb.Table{
-			ColumnFamilies: map[string]*btapb.ColumnFamily{
-				"cf0": {GcRule: &btapb.GcRule{Rule: &btapb.GcRule_MaxNumVersions{MaxNumVersions: 1}}},
-			},
-		}
-		_, err := s.CreateTable(ctx, &btapb.CreateTableRequest{Parent: s.parent, TableId: s.name, Table: &newTbl})
-		if err != nil {
-			t.Fatalf("Creating table: %v", err)
-		}
+func TestTableGranularity(t *testing.T) {
+	ctx, s, _ := newClient(t)
+
+	// Explicit MILLIS behaves like the default and rejects sub-millisecond timestamps.
+	newTbl := btapb.Table{
+		ColumnFamilies: map[string]*btapb.ColumnFamily{
+			"cf": {},
+		},
+		Granularity: btapb.Table_MILLIS,
 	}
-	mreq := &btpb.MutateRowRequest{
-		TableName: s.tblName,
-		RowKey:    []byte("row"),
-		Mutations: []*btpb.Mutation{{
-			Mutation: &btpb.Mutation_SetCell_{SetCell: &btpb.Mutation_SetCell{
-				FamilyName:      "cf0",
-				ColumnQualifier: []byte("col"),
-				TimestampMicros: 1000,
-				Value:           []byte{},
+	if _, err := s.CreateTable(ctx, &btapb.CreateTableRequest{Parent: s.parent, TableId: s.name, Table: &newTbl}); err != nil {
+		t.Fatalf("Creating table: %v", err)
+	}
+	setCell := func(ts int64) error {
+		_, err := s.MutateRow(ctx, &btpb.MutateRowRequest{
+			TableName: s.tblName,
+			RowKey:    []byte("row"),
+			Mutations: []*btpb.Mutation{{
+				Mutation: &btpb.Mutation_SetCell_{SetCell: &btpb.Mutation_SetCell{
+					FamilyName:      "cf",
+					ColumnQualifier: []byte("col"),
+					TimestampMicros: ts,
+					Value:           []byte("value"),
+				}},
 			}},
-		}},
+		})
+		return err
 	}
-	if _, err := s.MutateRow(ctx, mreq); err != nil {
-		t.Fatalf("Populating table: %v", err)
+	if err := setCell(2000); err != nil {
+		t.Fatalf("Millisecond timestamp: %v", err)
+	}
+	if err := setCell(2001); err == nil {
+		t.Fatalf("want sub-millisecond timestamp rejection, got acceptance")
 	}
 
-	for _, rowset := range []*btpb.RowSet{
-		{RowKeys: [][]byte{[]byte("row")}},
-		{RowRanges: []*btpb.RowRange{{StartKey: &btpb.RowRange_StartKeyClosed{StartKeyClosed: []byte("")}}}},
-		{RowRanges: []*btpb.RowRange{{StartKey: &btpb.RowRange_StartKeyClosed{StartKeyClosed: []byte("r")}}}},
-		{RowRanges: []*btpb.RowRange{{
-			StartKey: &btpb.RowRange_StartKeyClosed{StartKeyClosed: []byte("")},
-			EndKey:   &btpb.RowRange_EndKeyOpen{EndKeyOpen: []byte("s")},
-		}}},
-	} {
-		responses, err := readRows(ctx, s, &btpb.ReadRowsRequest{TableName: s.tblName})
-		if err != nil {
-			t.Fatalf("ReadRows error: %v", err)
-		}
-		if got, want := len(responses), 1; got != want {
-			t.Errorf("%+v: response count: got %d, want %d", rowset, got, want)
-		}
+	// Unknown granularities are rejected up front.
+	_, err := s.CreateTable(ctx, &btapb.CreateTableRequest{
+		Parent:  s.parent,
+		TableId: s.name + "-invalid",
+		Table:   &btapb.Table{Granularity: btapb.Table_TimestampGranularity(42)},
+	})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("Creating table with invalid granularity: got %v, want InvalidArgument", err)
+	}
+	if _, err := s.GetTable(ctx, &btapb.GetTableRequest{Name: s.parent + "/tables/" + s.name + "-invalid"}); status.Code(err) != codes.NotFound {
+		t.Fatalf("Table with invalid granularity should not exist: got %v", err)
 	}
 }
 
-func TestReadRowsError(t *testing.T) {
-	ctx, s, ok := newClient(t)
-	if !ok {
-		newTbl := btapb.Table{
-			ColumnFamilies: map[string]*btapb.ColumnFamily{
-				"cf0": {GcRule: &btapb.GcRule{Rule: &btapb.GcRule_MaxNumVersions{MaxNumVersions: 1}}},
-			},
-		}
-		_, err := s.CreateTable(ctx, &btapb.CreateTableRequest{Parent: s.parent, TableId: s.name, Table: &newTbl})
-		if err != nil {
-			t.Fatalf("Creating table: %v", err)
-		}
+func TestAggregateSumFamily(t *testing.T) {
+	ctx, s, _ := newClient(t)
+
+	newTbl := btapb.Table{
+		ColumnFamilies: map[string]*btapb.ColumnFamily{
+			"sum": {ValueType: &btapb.Type{Kind: &btapb.Type_AggregateType{AggregateType: &btapb.Type_Aggregate{
+				InputType:  &btapb.Type{Kind: &btapb.Type_Int64Type{Int64Type: &btapb.Type_Int64{}}},
+				Aggregator: &btapb.Type_Aggregate_Sum_{Sum: &btapb.Type_Aggregate_Sum{}},
+			}}}},
+			"cf": {},
+		},
 	}
-	mreq := &btpb.MutateRowRequest{
-		TableName: s.tblName,
-		RowKey:    []byte("row"),
-		Mutations: []*btpb.Mutation{{
-			Mutation: &btpb.Mutation_SetCell_{SetCell: &btpb.Mutation_SetCell{
-				FamilyName:      "cf0",
-				ColumnQualifier: []byte("col"),
-				TimestampMicros: 1000,
-				Value:           []byte{},
+	if _, err := s.CreateTable(ctx, &btapb.CreateTableRequest{Parent: s.parent, TableId: s.name, Table: &newTbl}); err != nil {
+		t.Fatalf("Creating table: %v", err)
+	}
+	addToCell := func(fam string, v int64) error {
+		_, err := s.MutateRow(ctx, &btpb.MutateRowRequest{
+			TableName: s.tblName,
+			RowKey:    []byte("row"),
+			Mutations: []*btpb.Mutation{{
+				Mutation: &btpb.Mutation_AddToCell_{AddToCell: &btpb.Mutation_AddToCell{
+					FamilyName:      fam,
+					ColumnQualifier: &btpb.Value{Kind: &btpb.Value_RawValue{RawValue: []byte("col")}},
+					Timestamp:       &btpb.Value{Kind: &btpb.Value_RawTimestampMicros{RawTimestampMicros: 1000}},
+					Input:           &btpb.Value{Kind: &btpb.Value_IntValue{IntValue: v}},
+				}},
 			}},
-		}},
+		})
+		return err
 	}
-	if _, err := s.MutateRow(ctx, mreq); err != nil {
-		t.Fatalf("Populating table: %v", err)
+	for _, v := range []int64{1, 2, 39} {
+		if err := addToCell("sum", v); err != nil {
+			t.Fatalf("AddToCell(%d): %v", v, err)
+		}
 	}
 
-	req := &btpb.ReadRowsRequest{TableName: s.tblName, Filter: &btpb.RowFilter{
-		Filter: &btpb.RowFilter_RowKeyRegexFilter{RowKeyRegexFilter: []byte("[")}}, // Invalid regex.
+	res, err := readRows(ctx, s, &btpb.ReadRowsRequest{TableName: s.tblName})
+	if err != nil {
+		t.Fatalf("ReadRows: %v", err)
 	}
-	if _, err := readRows(ctx, s, req); err == nil {
-		t.Fatal("ReadRows got no error, want error")
+	var cells []*btpb.ReadRowsResponse_CellChunk
+	for _, r := range res {
+		cells = append(cells, r.Chunks...)
+	}
+	if len(cells) != 1 {
+		t.Fatalf("got %d cells, want a single accumulated cell: %v", len(cells), cells)
+	}
+	if got := int64(binary.BigEndian.Uint64(cells[0].Value)); got != 42 {
+		t.Errorf("accumulated value: got %d, want 42", got)
 	}
-}
 
-func TestReadRowsAfterDeletion(t *testing.T) {
-	ctx, s, ok := newClient(t)
-	if err := populateTable(ctx, s, ok); err != nil {
-		t.Fatal(err)
+	// AddToCell is only valid on aggregate families, and read-modify-write is not valid on them.
+	if err := addToCell("cf", 1); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("AddToCell on non-aggregate family: got %v, want InvalidArgument", err)
 	}
-	dreq := &btpb.MutateRowRequest{
+	_, err = s.ReadModifyWriteRow(ctx, &btpb.ReadModifyWriteRowRequest{
 		TableName: s.tblName,
 		RowKey:    []byte("row"),
-		Mutations: []*btpb.Mutation{{
-			Mutation: &btpb.Mutation_DeleteFromRow_{
-				DeleteFromRow: &btpb.Mutation_DeleteFromRow{},
-			},
+		Rules: []*btpb.ReadModifyWriteRule{{
+			FamilyName:      "sum",
+			ColumnQualifier: []byte("col"),
+			Rule:            &btpb.ReadModifyWriteRule_IncrementAmount{IncrementAmount: 1},
 		}},
+	})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("ReadModifyWriteRow on aggregate family: got %v, want InvalidArgument", err)
 	}
-	if _, err := s.MutateRow(ctx, dreq); err != nil {
-		t.Fatalf("Deleting from table: %v", err)
+}
+
+func TestCheckAndMutateRowServerTime(t *testing.T) {
+	ctx, s, _ := newClient(t)
+
+	newTbl := btapb.Table{
+		ColumnFamilies: map[string]*btapb.ColumnFamily{
+			"cf": {GcRule: &btapb.GcRule{Rule: &btapb.GcRule_MaxNumVersions{MaxNumVersions: 2}}},
+		},
+	}
+	if _, err := s.CreateTable(ctx, &btapb.CreateTableRequest{Parent: s.parent, TableId: s.name, Table: &newTbl}); err != nil {
+		t.Fatalf("Creating table: %v", err)
+	}
+	setCell := func(value string) *btpb.Mutation {
+		return &btpb.Mutation{Mutation: &btpb.Mutation_SetCell_{SetCell: &btpb.Mutation_SetCell{
+			FamilyName:      "cf",
+			ColumnQualifier: []byte("col"),
+			TimestampMicros: -1,
+			Value:           []byte(value),
+		}}}
+	}
+	// The row doesn't exist yet, so the false mutations apply.
+	if _, err := s.CheckAndMutateRow(ctx, &btpb.CheckAndMutateRowRequest{
+		TableName:      s.tblName,
+		RowKey:         []byte("row"),
+		FalseMutations: []*btpb.Mutation{setCell("v1"), setCell("v2")},
+	}); err != nil {
+		t.Fatalf("CheckAndMutateRow: %v", err)
 	}
 
-	responses, err := readRows(ctx, s, &btpb.ReadRowsRequest{TableName: s.tblName})
+	res, err := readRows(ctx, s, &btpb.ReadRowsRequest{TableName: s.tblName})
 	if err != nil {
-		t.Fatalf("ReadRows error: %v", err)
+		t.Fatalf("ReadRows: %v", err)
 	}
-	if got, want := len(responses), 0; got != want {
-		t.Errorf("response count: got %d, want %d", got, want)
+	var got []string
+	for _, r := range res {
+		for _, cc := range r.Chunks {
+			got = append(got, fmt.Sprintf("%d:%s", cc.TimestampMicros, cc.Value))
+		}
+	}
+	if diff := cmp.Diff([]string{"1000:v2", "0:v1"}, got); diff != "" {
+		t.Errorf("cells mismatch (-want +got):\n%s", diff)
 	}
 }
 
-func TestReadRowsOrder(t *testing.T) {
+func TestServerTimeAcrossColumns(t *testing.T) {
+	ctx := context.Background()
+	t0 := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	_, s := newTestServer(t, func(svr *server) { svr.clock = NewFakeClock(t0).Now })
+	newTbl := btapb.Table{
+		ColumnFamilies: map[string]*btapb.ColumnFamily{
+			"cf": {},
+		},
+	}
+	if _, err := s.CreateTable(ctx, &btapb.CreateTableRequest{Parent: s.parent, TableId: s.name, Table: &newTbl}); err != nil {
+		t.Fatalf("Creating table: %v", err)
+	}
+	setCell := func(col, value string) *btpb.Mutation {
+		return &btpb.Mutation{Mutation: &btpb.Mutation_SetCell_{SetCell: &btpb.Mutation_SetCell{
+			FamilyName:      "cf",
+			ColumnQualifier: []byte(col),
+			TimestampMicros: -1,
+			Value:           []byte(value),
+		}}}
+	}
+	mutate := func(muts ...*btpb.Mutation) {
+		t.Helper()
+		if _, err := s.MutateRow(ctx, &btpb.MutateRowRequest{TableName: s.tblName, RowKey: []byte("row"), Mutations: muts}); err != nil {
+			t.Fatalf("MutateRow: %v", err)
+		}
+	}
+
+	// Writes to different columns all take the current time, so a later overwrite in the same tick replaces
+	// rather than hides behind them.
+	mutate(setCell("a", "old"), setCell("b", "old"), setCell("c", "old"))
+	mutate(setCell("c", "new"))
+
+	res, err := readRows(ctx, s, &btpb.ReadRowsRequest{TableName: s.tblName})
+	if err != nil {
+		t.Fatalf("ReadRows: %v", err)
+	}
+	var got []string
+	for _, r := range res {
+		for _, cc := range r.Chunks {
+			got = append(got, fmt.Sprintf("%s@%d:%s", cc.Qualifier.GetValue(), cc.TimestampMicros, cc.Value))
+		}
+	}
+	ts := t0.UnixMicro()
+	want := []string{
+		fmt.Sprintf("a@%d:old", ts),
+		fmt.Sprintf("b@%d:old", ts),
+		fmt.Sprintf("c@%d:new", ts),
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("cells mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestMaxRowKeyLength(t *testing.T) {
 	ctx, s, ok := newClient(t)
 	if !ok {
 		newTbl := btapb.Table{
 			ColumnFamilies: map[string]*btapb.ColumnFamily{
-				"cf0": {GcRule: &btapb.GcRule{Rule: &btapb.GcRule_MaxNumVersions{MaxNumVersions: 1}}},
+				"cf": {},
 			},
 		}
 		_, err := s.CreateTable(ctx, &btapb.CreateTableRequest{Parent: s.parent, TableId: s.name, Table: &newTbl})
@@ -739,153 +1218,80 @@ func TestReadRowsOrder(t *testing.T) {
 			t.Fatalf("Creating table: %v", err)
 		}
 	}
-	count := 3
-	mcf := func(i int) *btapb.ModifyColumnFamiliesRequest {
-		return &btapb.ModifyColumnFamiliesRequest{
-			Name: s.tblName,
-			Modifications: []*btapb.ModifyColumnFamiliesRequest_Modification{{
-				Id:  "cf" + strconv.Itoa(i),
-				Mod: &btapb.ModifyColumnFamiliesRequest_Modification_Create{Create: &btapb.ColumnFamily{}},
-			}},
-		}
+	atLimit := bytes.Repeat([]byte("k"), 4<<10)
+	pastLimit := bytes.Repeat([]byte("k"), 4<<10+1)
+	muts := []*btpb.Mutation{{
+		Mutation: &btpb.Mutation_SetCell_{SetCell: &btpb.Mutation_SetCell{
+			FamilyName:      "cf",
+			ColumnQualifier: []byte("col"),
+			Value:           []byte("value"),
+		}},
+	}}
+
+	if _, err := s.MutateRow(ctx, &btpb.MutateRowRequest{TableName: s.tblName, RowKey: atLimit, Mutations: muts}); err != nil {
+		t.Fatalf("MutateRow at limit: %v", err)
 	}
-	for i := 1; i <= count; i++ {
-		_, err := s.ModifyColumnFamilies(ctx, mcf(i))
-		if err != nil {
-			if s, ok := status.FromError(err); !ok || s.Code() != codes.AlreadyExists {
-				t.Fatal(err)
-			}
-		}
+	_, err := s.MutateRow(ctx, &btpb.MutateRowRequest{TableName: s.tblName, RowKey: pastLimit, Mutations: muts})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("MutateRow past limit: got %v, want InvalidArgument", err)
 	}
-	// Populate the table
-	for fc := 0; fc < count; fc++ {
-		for cc := count; cc > 0; cc-- {
-			for tc := 0; tc < count; tc++ {
-				req := &btpb.MutateRowRequest{
-					TableName: s.tblName,
-					RowKey:    []byte("row"),
-					Mutations: []*btpb.Mutation{{
-						Mutation: &btpb.Mutation_SetCell_{SetCell: &btpb.Mutation_SetCell{
-							FamilyName:      "cf" + strconv.Itoa(fc),
-							ColumnQualifier: []byte("col" + strconv.Itoa(cc)),
-							TimestampMicros: int64((tc + 1) * 1000),
-							Value:           []byte{},
-						}},
-					}},
-				}
-				if _, err := s.MutateRow(ctx, req); err != nil {
-					t.Fatalf("Populating table: %v", err)
-				}
-			}
-		}
+	_, err = s.CheckAndMutateRow(ctx, &btpb.CheckAndMutateRowRequest{TableName: s.tblName, RowKey: pastLimit, TrueMutations: muts})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("CheckAndMutateRow past limit: got %v, want InvalidArgument", err)
 	}
-	req := &btpb.ReadRowsRequest{
+	_, err = s.ReadModifyWriteRow(ctx, &btpb.ReadModifyWriteRowRequest{
 		TableName: s.tblName,
-		Rows:      &btpb.RowSet{RowKeys: [][]byte{[]byte("row")}},
-	}
-	responses, err := readRows(ctx, s, req)
-	if err != nil {
-		t.Fatalf("ReadRows error: %v", err)
-	}
-
-	if got, want := len(responses[0].Chunks), 27; got != want {
-		t.Errorf("Chunk count: got %d, want %d", got, want)
-	}
-	testOrder := func(responses []*btpb.ReadRowsResponse) {
-		var prevFam string
-		var prevCol []byte
-		var prevTime int64
-		for _, cc := range responses[0].Chunks {
-			if prevFam == "" {
-				prevFam = cc.FamilyName.Value
-				prevCol = cc.Qualifier.Value
-				prevTime = cc.TimestampMicros
-				continue
-			}
-			if cc.FamilyName.Value < prevFam {
-				t.Errorf("Family order is not correct: got %s < %s", cc.FamilyName.Value, prevFam)
-			} else if cc.FamilyName.Value == prevFam {
-				if comp := bytes.Compare(cc.Qualifier.Value, prevCol); comp < 0 {
-					t.Errorf("Column order is not correct: got %s < %s", cc.Qualifier.Value, prevCol)
-				} else if comp == 0 {
-					if cc.TimestampMicros > prevTime {
-						t.Errorf("cell order is not correct: got %d > %d", cc.TimestampMicros, prevTime)
-					}
-				}
-			}
-			prevFam = cc.FamilyName.Value
-			prevCol = cc.Qualifier.Value
-			prevTime = cc.TimestampMicros
-		}
+		RowKey:    pastLimit,
+		Rules: []*btpb.ReadModifyWriteRule{{
+			FamilyName:      "cf",
+			ColumnQualifier: []byte("counter"),
+			Rule:            &btpb.ReadModifyWriteRule_IncrementAmount{IncrementAmount: 1},
+		}},
+	})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("ReadModifyWriteRow past limit: got %v, want InvalidArgument", err)
 	}
-	testOrder(responses)
 
-	// Read with interleave filter
-	inter := &btpb.RowFilter_Interleave{}
-	fnr := &btpb.RowFilter{Filter: &btpb.RowFilter_FamilyNameRegexFilter{FamilyNameRegexFilter: "cf1"}}
-	cqr := &btpb.RowFilter{Filter: &btpb.RowFilter_ColumnQualifierRegexFilter{ColumnQualifierRegexFilter: []byte("col2")}}
-	inter.Filters = append(inter.Filters, fnr, cqr)
-	req = &btpb.ReadRowsRequest{
+	// MutateRows reports the oversized key per entry and still applies the others.
+	stream, err := s.MutateRows(ctx, &btpb.MutateRowsRequest{
 		TableName: s.tblName,
-		Rows:      &btpb.RowSet{RowKeys: [][]byte{[]byte("row")}},
-		Filter: &btpb.RowFilter{
-			Filter: &btpb.RowFilter_Interleave_{Interleave: inter},
+		Entries: []*btpb.MutateRowsRequest_Entry{
+			{RowKey: []byte("ok"), Mutations: muts},
+			{RowKey: pastLimit, Mutations: muts},
 		},
+	})
+	if err != nil {
+		t.Fatalf("MutateRows: %v", err)
 	}
-
-	responses, err = readRows(ctx, s, req)
+	res, err := stream.Recv()
 	if err != nil {
-		t.Fatalf("ReadRows error: %v", err)
+		t.Fatalf("MutateRows response: %v", err)
 	}
-	if len(responses) == 0 {
-		t.Fatal("Response count: got 0, want > 0")
+	if got := codes.Code(res.Entries[0].Status.Code); got != codes.OK {
+		t.Errorf("MutateRows entry 0: got %v, want OK", got)
 	}
-
-	// cf1 12 cells will be returned (3 are dups)
-	// cf0 and cf2 the 3 cells in col2 will be returned
-	if got, want := len(responses[0].Chunks), 3+12+3; got != want {
-		t.Errorf("Chunk count: got %d, want %d", got, want)
+	if got := codes.Code(res.Entries[1].Status.Code); got != codes.InvalidArgument {
+		t.Errorf("MutateRows entry 1: got %v, want InvalidArgument", got)
 	}
-	testOrder(responses)
 
-	// Check order after ReadModifyWriteRow
-	rmw := func(i int) *btpb.ReadModifyWriteRowRequest {
-		return &btpb.ReadModifyWriteRowRequest{
-			TableName: s.tblName,
-			RowKey:    []byte("row"),
-			Rules: []*btpb.ReadModifyWriteRule{{
-				FamilyName:      "cf3",
-				ColumnQualifier: []byte("col" + strconv.Itoa(i)),
-				Rule:            &btpb.ReadModifyWriteRule_IncrementAmount{IncrementAmount: 1},
-			}},
-		}
-	}
-	for i := count; i > 0; i-- {
-		if _, err := s.ReadModifyWriteRow(ctx, rmw(i)); err != nil {
-			t.Fatal(err)
-		}
-	}
-	req = &btpb.ReadRowsRequest{
-		TableName: s.tblName,
-		Rows:      &btpb.RowSet{RowKeys: [][]byte{[]byte("row")}},
-	}
-	responses, err = readRows(ctx, s, req)
+	responses, err := readRows(ctx, s, &btpb.ReadRowsRequest{TableName: s.tblName})
 	if err != nil {
 		t.Fatalf("ReadRows error: %v", err)
 	}
-	if len(responses) == 0 {
-		t.Fatal("Response count: got 0, want > 0")
+	var rows int
+	for _, res := range responses {
+		for _, c := range res.Chunks {
+			if c.GetCommitRow() {
+				rows++
+			}
+		}
 	}
-
-	// cf0, cf1, and cf2 all 9 cells will be returned
-	// cf3 all 3 cells will be returned
-	if got, want := len(responses[0].Chunks), 9+9+9+3; got != want {
-		t.Errorf("Chunk count: got %d, want %d", got, want)
+	if got, want := rows, 2; got != want {
+		t.Fatalf("Row count: got %d, want %d", got, want)
 	}
-	testOrder(responses)
 }
 
-func TestReadRowsWithlabelTransformer(t *testing.T) {
+func TestReadRows(t *testing.T) {
 	ctx, s, ok := newClient(t)
 	if !ok {
 		newTbl := btapb.Table{
@@ -914,52 +1320,31 @@ func TestReadRowsWithlabelTransformer(t *testing.T) {
 		t.Fatalf("Populating table: %v", err)
 	}
 
-	req := &btpb.ReadRowsRequest{
-		TableName: s.tblName,
-		Filter: &btpb.RowFilter{
-			Filter: &btpb.RowFilter_ApplyLabelTransformer{
-				ApplyLabelTransformer: "label",
-			},
-		},
-	}
-	responses, err := readRows(ctx, s, req)
-	if err != nil {
-		t.Fatalf("ReadRows error: %v", err)
-	}
-	if got, want := len(responses), 1; got != want {
-		t.Fatalf("response count: got %d, want %d", got, want)
-	}
-	resp := responses[0]
-	if got, want := len(resp.Chunks), 1; got != want {
-		t.Fatalf("chunks count: got %d, want %d", got, want)
-	}
-	chunk := resp.Chunks[0]
-	if got, want := len(chunk.Labels), 1; got != want {
-		t.Fatalf("labels count: got %d, want %d", got, want)
-	}
-	if got, want := chunk.Labels[0], "label"; got != want {
-		t.Fatalf("label: got %s, want %s", got, want)
-	}
-
-	req = &btpb.ReadRowsRequest{
-		TableName: s.tblName,
-		Filter: &btpb.RowFilter{
-			Filter: &btpb.RowFilter_ApplyLabelTransformer{
-				ApplyLabelTransformer: "", // invalid label
-			},
-		},
-	}
-	if _, err := readRows(ctx, s, req); err == nil {
-		t.Fatal("ReadRows want invalid label error, got none")
+	for _, rowset := range []*btpb.RowSet{
+		{RowKeys: [][]byte{[]byte("row")}},
+		{RowRanges: []*btpb.RowRange{{StartKey: &btpb.RowRange_StartKeyClosed{StartKeyClosed: []byte("")}}}},
+		{RowRanges: []*btpb.RowRange{{StartKey: &btpb.RowRange_StartKeyClosed{StartKeyClosed: []byte("r")}}}},
+		{RowRanges: []*btpb.RowRange{{
+			StartKey: &btpb.RowRange_StartKeyClosed{StartKeyClosed: []byte("")},
+			EndKey:   &btpb.RowRange_EndKeyOpen{EndKeyOpen: []byte("s")},
+		}}},
+	} {
+		responses, err := readRows(ctx, s, &btpb.ReadRowsRequest{TableName: s.tblName})
+		if err != nil {
+			t.Fatalf("ReadRows error: %v", err)
+		}
+		if got, want := len(responses), 1; got != want {
+			t.Errorf("%+v: response count: got %d, want %d", rowset, got, want)
+		}
 	}
 }
 
-func TestCheckAndMutateRowWithoutPredicate(t *testing.T) {
+func TestReadRowsError(t *testing.T) {
 	ctx, s, ok := newClient(t)
 	if !ok {
 		newTbl := btapb.Table{
 			ColumnFamilies: map[string]*btapb.ColumnFamily{
-				"cf": {GcRule: &btapb.GcRule{Rule: &btapb.GcRule_MaxNumVersions{MaxNumVersions: 1}}},
+				"cf0": {GcRule: &btapb.GcRule{Rule: &btapb.GcRule_MaxNumVersions{MaxNumVersions: 1}}},
 			},
 		}
 		_, err := s.CreateTable(ctx, &btapb.CreateTableRequest{Parent: s.parent, TableId: s.name, Table: &newTbl})
@@ -967,1059 +1352,3654 @@ func TestCheckAndMutateRowWithoutPredicate(t *testing.T) {
 			t.Fatalf("Creating table: %v", err)
 		}
 	}
-
-	val := []byte("value")
-	muts := []*btpb.Mutation{{
-		Mutation: &btpb.Mutation_SetCell_{SetCell: &btpb.Mutation_SetCell{
-			FamilyName:      "cf",
-			ColumnQualifier: []byte("col"),
-			TimestampMicros: 1000,
-			Value:           val,
+	mreq := &btpb.MutateRowRequest{
+		TableName: s.tblName,
+		RowKey:    []byte("row"),
+		Mutations: []*btpb.Mutation{{
+			Mutation: &btpb.Mutation_SetCell_{SetCell: &btpb.Mutation_SetCell{
+				FamilyName:      "cf0",
+				ColumnQualifier: []byte("col"),
+				TimestampMicros: 1000,
+				Value:           []byte{},
+			}},
 		}},
-	}}
+	}
+	if _, err := s.MutateRow(ctx, mreq); err != nil {
+		t.Fatalf("Populating table: %v", err)
+	}
 
-	// Populate the table
-	mrreq := &btpb.MutateRowRequest{
+	req := &btpb.ReadRowsRequest{TableName: s.tblName, Filter: &btpb.RowFilter{
+		Filter: &btpb.RowFilter_RowKeyRegexFilter{RowKeyRegexFilter: []byte("[")}}, // Invalid regex.
+	}
+	if _, err := readRows(ctx, s, req); err == nil {
+		t.Fatal("ReadRows got no error, want error")
+	}
+}
+
+func TestReadRowsRequestStats(t *testing.T) {
+	ctx, s, _ := newClient(t)
+
+	newTbl := btapb.Table{
+		ColumnFamilies: map[string]*btapb.ColumnFamily{
+			"cf": {},
+		},
+	}
+	if _, err := s.CreateTable(ctx, &btapb.CreateTableRequest{Parent: s.parent, TableId: s.name, Table: &newTbl}); err != nil {
+		t.Fatalf("Creating table: %v", err)
+	}
+	setCell := func(row, col string) *btpb.MutateRowsRequest_Entry {
+		return &btpb.MutateRowsRequest_Entry{
+			RowKey: []byte(row),
+			Mutations: []*btpb.Mutation{{
+				Mutation: &btpb.Mutation_SetCell_{SetCell: &btpb.Mutation_SetCell{
+					FamilyName:      "cf",
+					ColumnQualifier: []byte(col),
+					Value:           []byte("value"),
+				}},
+			}},
+		}
+	}
+	// row-2 has no cells matching the filter below.
+	for _, e := range []*btpb.MutateRowsRequest_Entry{
+		setCell("row-0", "a"), setCell("row-0", "b"),
+		setCell("row-1", "a"), setCell("row-1", "b"),
+		setCell("row-2", "b"),
+	} {
+		if _, err := s.MutateRow(ctx, &btpb.MutateRowRequest{TableName: s.tblName, RowKey: e.RowKey, Mutations: e.Mutations}); err != nil {
+			t.Fatalf("Populating table: %v", err)
+		}
+	}
+
+	readStats := func(view btpb.ReadRowsRequest_RequestStatsView) *btpb.RequestStats {
+		res, err := readRows(ctx, s, &btpb.ReadRowsRequest{
+			TableName:        s.tblName,
+			Filter:           &btpb.RowFilter{Filter: &btpb.RowFilter_ColumnQualifierRegexFilter{ColumnQualifierRegexFilter: []byte("a")}},
+			RequestStatsView: view,
+		})
+		if err != nil {
+			t.Fatalf("ReadRows: %v", err)
+		}
+		var stats *btpb.RequestStats
+		for _, r := range res {
+			if r.RequestStats != nil {
+				stats = r.RequestStats
+			}
+		}
+		return stats
+	}
+
+	if stats := readStats(btpb.ReadRowsRequest_REQUEST_STATS_NONE); stats != nil {
+		t.Errorf("Stats without a stats view: got %v, want none", stats)
+	}
+
+	stats := readStats(btpb.ReadRowsRequest_REQUEST_STATS_FULL).GetFullReadStatsView()
+	if stats == nil {
+		t.Fatalf("Missing full read stats view")
+	}
+	want := &btpb.ReadIterationStats{
+		RowsSeenCount:      3,
+		RowsReturnedCount:  2,
+		CellsSeenCount:     5,
+		CellsReturnedCount: 2,
+	}
+	if diff := cmp.Diff(want, stats.ReadIterationStats, cmp.Comparer(proto.Equal)); diff != "" {
+		t.Errorf("Read iteration stats mismatch (-want +got):\n%s", diff)
+	}
+	if stats.GetRequestLatencyStats().GetFrontendServerLatency() == nil {
+		t.Errorf("Missing frontend server latency")
+	}
+}
+
+func TestReadRowsSingleKey(t *testing.T) {
+	ctx, s, _ := newClient(t)
+	populateSingleKeyTable(ctx, t, s)
+
+	for _, tc := range []struct {
+		desc   string
+		key    string
+		filter *btpb.RowFilter
+	}{
+		{"unfiltered", "row-1", nil},
+		{"filtered", "row-1", &btpb.RowFilter{Filter: &btpb.RowFilter_ColumnQualifierRegexFilter{ColumnQualifierRegexFilter: []byte("a")}}},
+		{"filtered empty", "row-1", &btpb.RowFilter{Filter: &btpb.RowFilter_ColumnQualifierRegexFilter{ColumnQualifierRegexFilter: []byte("nope")}}},
+		{"missing row", "row-9", nil},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			single, err := readRows(ctx, s, &btpb.ReadRowsRequest{
+				TableName: s.tblName,
+				Rows:      &btpb.RowSet{RowKeys: [][]byte{[]byte(tc.key)}},
+				Filter:    tc.filter,
+			})
+			if err != nil {
+				t.Fatalf("Single key ReadRows: %v", err)
+			}
+			ranged, err := readRows(ctx, s, &btpb.ReadRowsRequest{
+				TableName: s.tblName,
+				Rows: &btpb.RowSet{RowRanges: []*btpb.RowRange{{
+					StartKey: &btpb.RowRange_StartKeyClosed{StartKeyClosed: []byte(tc.key)},
+					EndKey:   &btpb.RowRange_EndKeyClosed{EndKeyClosed: []byte(tc.key)},
+				}}},
+				Filter: tc.filter,
+			})
+			if err != nil {
+				t.Fatalf("Range ReadRows: %v", err)
+			}
+			if diff := cmp.Diff(ranged, single, cmp.Comparer(proto.Equal)); diff != "" {
+				t.Errorf("Single key read differs from range read (-range +single):\n%s", diff)
+			}
+		})
+	}
+
+	// A fully filtered row produces no responses at all.
+	res, err := readRows(ctx, s, &btpb.ReadRowsRequest{
 		TableName: s.tblName,
-		RowKey:    []byte("row-present"),
-		Mutations: muts,
+		Rows:      &btpb.RowSet{RowKeys: [][]byte{[]byte("row-1")}},
+		Filter:    &btpb.RowFilter{Filter: &btpb.RowFilter_BlockAllFilter{BlockAllFilter: true}},
+	})
+	if err != nil {
+		t.Fatalf("ReadRows: %v", err)
 	}
-	if _, err := s.MutateRow(ctx, mrreq); err != nil {
-		t.Fatalf("Populating table: %v", err)
+	if len(res) != 0 {
+		t.Errorf("Fully filtered single key read: got %v, want no responses", res)
 	}
+}
 
-	req := &btpb.CheckAndMutateRowRequest{
-		TableName:      s.tblName,
-		RowKey:         []byte("row-not-present"),
-		FalseMutations: muts,
+func BenchmarkReadRowsSingleKey(b *testing.B) {
+	ctx := context.Background()
+	svr, s := newTestServer(b, func(svr *server) { svr.storage = LeveldbMemStorage{} })
+	populateSingleKeyTable(ctx, b, s)
+	req := &btpb.ReadRowsRequest{
+		TableName: s.tblName,
+		Rows:      &btpb.RowSet{RowKeys: [][]byte{[]byte("row-1")}},
 	}
-	if res, err := s.CheckAndMutateRow(ctx, req); err != nil {
-		t.Errorf("CheckAndMutateRow error: %v", err)
-	} else if got, want := res.PredicateMatched, false; got != want {
-		t.Errorf("Invalid PredicateMatched value: got %t, want %t", got, want)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := svr.ReadRows(req, &rrAdapter{streamAdapter{ctx: ctx}}); err != nil {
+			b.Fatal(err)
+		}
 	}
+}
 
-	req = &btpb.CheckAndMutateRowRequest{
-		TableName:      s.tblName,
-		RowKey:         []byte("row-present"),
-		FalseMutations: muts,
+// countingStorage wraps a Storage, counting how often its tables' rows are scanned or fetched.
+type countingStorage struct {
+	Storage
+	reads *int32
+}
+
+func (s countingStorage) Create(tbl *btapb.Table) Rows {
+	return countingRows{Rows: s.Storage.Create(tbl), reads: s.reads}
+}
+
+type countingRows struct {
+	Rows
+	reads *int32
+}
+
+func (r countingRows) Ascend(iterator RowIterator) {
+	atomic.AddInt32(r.reads, 1)
+	r.Rows.Ascend(iterator)
+}
+
+func (r countingRows) AscendRange(greaterOrEqual, lessThan keyType, iterator RowIterator) {
+	atomic.AddInt32(r.reads, 1)
+	r.Rows.AscendRange(greaterOrEqual, lessThan, iterator)
+}
+
+func (r countingRows) AscendLessThan(lessThan keyType, iterator RowIterator) {
+	atomic.AddInt32(r.reads, 1)
+	r.Rows.AscendLessThan(lessThan, iterator)
+}
+
+func (r countingRows) AscendGreaterOrEqual(greaterOrEqual keyType, iterator RowIterator) {
+	atomic.AddInt32(r.reads, 1)
+	r.Rows.AscendGreaterOrEqual(greaterOrEqual, iterator)
+}
+
+func (r countingRows) Get(key keyType) *btpb.Row {
+	atomic.AddInt32(r.reads, 1)
+	return r.Rows.Get(key)
+}
+
+func TestReadRowsBlockAllSkipsScan(t *testing.T) {
+	ctx := context.Background()
+	var reads int32
+	_, s := newTestServer(t, func(svr *server) {
+		svr.storage = countingStorage{Storage: BtreeStorage{}, reads: &reads}
+	})
+	populateSingleKeyTable(ctx, t, s)
+
+	blockAll := &btpb.RowFilter{Filter: &btpb.RowFilter_BlockAllFilter{BlockAllFilter: true}}
+	passAll := &btpb.RowFilter{Filter: &btpb.RowFilter_PassAllFilter{PassAllFilter: true}}
+	for name, tc := range map[string]struct {
+		filter  *btpb.RowFilter
+		blocked bool
+	}{
+		"BlockAll": {blockAll, true},
+		"Chain": {&btpb.RowFilter{Filter: &btpb.RowFilter_Chain_{Chain: &btpb.RowFilter_Chain{
+			Filters: []*btpb.RowFilter{passAll, blockAll},
+		}}}, true},
+		"Interleave": {&btpb.RowFilter{Filter: &btpb.RowFilter_Interleave_{Interleave: &btpb.RowFilter_Interleave{
+			Filters: []*btpb.RowFilter{blockAll, blockAll},
+		}}}, true},
+		"ConditionNoArms": {&btpb.RowFilter{Filter: &btpb.RowFilter_Condition_{Condition: &btpb.RowFilter_Condition{
+			PredicateFilter: passAll,
+		}}}, true},
+		"ConditionPassingArm": {&btpb.RowFilter{Filter: &btpb.RowFilter_Condition_{Condition: &btpb.RowFilter_Condition{
+			PredicateFilter: passAll,
+			FalseFilter:     passAll,
+		}}}, false},
+		"PassAll": {passAll, false},
+	} {
+		t.Run(name, func(t *testing.T) {
+			atomic.StoreInt32(&reads, 0)
+			res, err := readRows(ctx, s, &btpb.ReadRowsRequest{TableName: s.tblName, Filter: tc.filter})
+			if err != nil {
+				t.Fatalf("ReadRows: %v", err)
+			}
+			if tc.blocked {
+				if len(res) != 0 {
+					t.Errorf("Got %v, want no responses", res)
+				}
+				if n := atomic.LoadInt32(&reads); n != 0 {
+					t.Errorf("Rows were read %d times, want the scan skipped", n)
+				}
+			} else if n := atomic.LoadInt32(&reads); n == 0 {
+				t.Errorf("Rows were never read, want a scan")
+			}
+		})
 	}
-	if res, err := s.CheckAndMutateRow(ctx, req); err != nil {
-		t.Errorf("CheckAndMutateRow error: %v", err)
-	} else if got, want := res.PredicateMatched, true; got != want {
-		t.Errorf("Invalid PredicateMatched value: got %t, want %t", got, want)
+
+	// An invalid sibling filter is still rejected when the scan is skipped.
+	for name, filter := range map[string]*btpb.RowFilter{
+		"InterleaveBadRegex": {Filter: &btpb.RowFilter_Interleave_{Interleave: &btpb.RowFilter_Interleave{
+			Filters: []*btpb.RowFilter{blockAll, {Filter: &btpb.RowFilter_ValueRegexFilter{ValueRegexFilter: []byte("[")}}},
+		}}},
+		"ChainBadRegex": {Filter: &btpb.RowFilter_Chain_{Chain: &btpb.RowFilter_Chain{
+			Filters: []*btpb.RowFilter{blockAll, {Filter: &btpb.RowFilter_RowKeyRegexFilter{RowKeyRegexFilter: []byte("(")}}},
+		}}},
+		"ChainTooShort": {Filter: &btpb.RowFilter_Chain_{Chain: &btpb.RowFilter_Chain{
+			Filters: []*btpb.RowFilter{blockAll, {Filter: &btpb.RowFilter_Chain_{Chain: &btpb.RowFilter_Chain{}}}},
+		}}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := readRows(ctx, s, &btpb.ReadRowsRequest{TableName: s.tblName, Filter: filter})
+			if status.Code(err) != codes.InvalidArgument {
+				t.Errorf("ReadRows: got %v, want InvalidArgument", err)
+			}
+		})
 	}
 }
 
-func TestCheckAndMutateRowWithPredicate(t *testing.T) {
-	ctx, s, ok := newClient(t)
-	if !ok {
-		tblReq := &btapb.CreateTableRequest{Parent: s.parent, TableId: s.name,
-			Table: &btapb.Table{
-				ColumnFamilies: map[string]*btapb.ColumnFamily{
-					"cf": {},
-					"df": {},
-					"ef": {},
-					"ff": {},
-					"zf": {},
-				},
-			},
+func BenchmarkReadRowsBlockAll(b *testing.B) {
+	ctx := context.Background()
+	svr, s := newTestServer(b, func(svr *server) { svr.storage = LeveldbMemStorage{} })
+	populateSingleKeyTable(ctx, b, s)
+	req := &btpb.ReadRowsRequest{
+		TableName: s.tblName,
+		Filter:    &btpb.RowFilter{Filter: &btpb.RowFilter_BlockAllFilter{BlockAllFilter: true}},
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := svr.ReadRows(req, &rrAdapter{streamAdapter{ctx: ctx}}); err != nil {
+			b.Fatal(err)
 		}
-		_, err := s.CreateTable(ctx, tblReq)
+	}
+}
+
+// populateSingleKeyTable creates a table with a few rows of two columns each.
+func TestReadRowsEmptyRowRange(t *testing.T) {
+	ctx, s, _ := newClient(t)
+	populateSingleKeyTable(ctx, t, s)
+
+	// A range with neither bound set is the whole table, whatever else the row set holds.
+	for _, rowset := range []*btpb.RowSet{
+		{RowRanges: []*btpb.RowRange{{}}},
+		{RowRanges: []*btpb.RowRange{{}}, RowKeys: [][]byte{[]byte("row-1")}},
+		{RowRanges: []*btpb.RowRange{
+			{StartKey: &btpb.RowRange_StartKeyClosed{StartKeyClosed: []byte("row-2")}},
+			{},
+		}},
+	} {
+		responses, err := readRows(ctx, s, &btpb.ReadRowsRequest{TableName: s.tblName, Rows: rowset})
 		if err != nil {
-			t.Fatalf("Failed to create the table: %v", err)
+			t.Fatalf("ReadRows error: %v", err)
+		}
+		var keys []string
+		for _, res := range responses {
+			for _, c := range res.Chunks {
+				if len(c.RowKey) > 0 && (len(keys) == 0 || keys[len(keys)-1] != string(c.RowKey)) {
+					keys = append(keys, string(c.RowKey))
+				}
+			}
+		}
+		if diff := cmp.Diff([]string{"row-0", "row-1", "row-2"}, keys); diff != "" {
+			t.Errorf("%v: row keys mismatch (-want +got):\n%s", rowset, diff)
 		}
 	}
+}
 
-	entries := []struct {
-		row                         string
-		value                       []byte
-		familyName, columnQualifier string
+func TestReadRowsUnsortedRowKeys(t *testing.T) {
+	ctx, s, _ := newClient(t)
+	populateSingleKeyTable(ctx, t, s)
+
+	// Rows come back in key order however the request lists them, even when the keys share a backing array.
+	buf := []byte("row-2row-0row-1")
+	for _, tc := range []struct {
+		rowKeys [][]byte
+		want    []string
 	}{
-		{"row1", []byte{0x11}, "cf", "cq"},
-		{"row2", []byte{0x1a}, "df", "dq"},
-		{"row3", []byte{'a'}, "ef", "eq"},
-		{"row4", []byte{'b'}, "ff", "fq"},
+		{[][]byte{[]byte("row-2"), []byte("row-0"), []byte("row-1")}, []string{"row-0", "row-1", "row-2"}},
+		{[][]byte{buf[0:5], buf[5:10], buf[10:15]}, []string{"row-0", "row-1", "row-2"}},
+		// Duplicates are read once, and missing rows skipped.
+		{[][]byte{[]byte("row-1"), []byte("row-9"), []byte("row-0"), []byte("row-1")}, []string{"row-0", "row-1"}},
+	} {
+		responses, err := readRows(ctx, s, &btpb.ReadRowsRequest{TableName: s.tblName, Rows: &btpb.RowSet{RowKeys: tc.rowKeys}})
+		if err != nil {
+			t.Fatalf("ReadRows error: %v", err)
+		}
+		var keys []string
+		for _, res := range responses {
+			for _, c := range res.Chunks {
+				if len(c.RowKey) > 0 && (len(keys) == 0 || keys[len(keys)-1] != string(c.RowKey)) {
+					keys = append(keys, string(c.RowKey))
+				}
+			}
+		}
+		if diff := cmp.Diff(tc.want, keys); diff != "" {
+			t.Errorf("%q: row keys mismatch (-want +got):\n%s", tc.rowKeys, diff)
+		}
 	}
+	if string(buf) != "row-2row-0row-1" {
+		t.Errorf("request keys were modified: %q", buf)
+	}
+}
 
-	for _, entry := range entries {
+func TestReadRowsReversed(t *testing.T) {
+	ctx, s, _ := newClient(t)
+	newTbl := btapb.Table{
+		ColumnFamilies: map[string]*btapb.ColumnFamily{
+			"cf": {},
+		},
+	}
+	if _, err := s.CreateTable(ctx, &btapb.CreateTableRequest{Parent: s.parent, TableId: s.name, Table: &newTbl}); err != nil {
+		t.Fatalf("Creating table: %v", err)
+	}
+	for i := 0; i < 10; i++ {
 		req := &btpb.MutateRowRequest{
 			TableName: s.tblName,
-			RowKey:    []byte(entry.row),
+			RowKey:    []byte("row-" + strconv.Itoa(i)),
 			Mutations: []*btpb.Mutation{{
 				Mutation: &btpb.Mutation_SetCell_{SetCell: &btpb.Mutation_SetCell{
-					FamilyName:      entry.familyName,
-					ColumnQualifier: []byte(entry.columnQualifier),
-					TimestampMicros: 1000,
-					Value:           entry.value,
+					FamilyName:      "cf",
+					ColumnQualifier: []byte("col"),
+					Value:           []byte("value"),
 				}},
 			}},
 		}
 		if _, err := s.MutateRow(ctx, req); err != nil {
-			t.Fatalf("Failed to insert entry %v into server: %v", entry, err)
+			t.Fatalf("Populating table: %v", err)
 		}
 	}
 
-	var bogusMutations = []*btpb.Mutation{{
-		Mutation: &btpb.Mutation_DeleteFromFamily_{
-			DeleteFromFamily: &btpb.Mutation_DeleteFromFamily{
-				FamilyName: "bogus_family",
-			},
+	twoRanges := &btpb.RowSet{RowRanges: []*btpb.RowRange{
+		{
+			StartKey: &btpb.RowRange_StartKeyClosed{StartKeyClosed: []byte("row-1")},
+			EndKey:   &btpb.RowRange_EndKeyOpen{EndKeyOpen: []byte("row-3")},
+		},
+		{
+			StartKey: &btpb.RowRange_StartKeyClosed{StartKeyClosed: []byte("row-5")},
+			EndKey:   &btpb.RowRange_EndKeyClosed{EndKeyClosed: []byte("row-8")},
 		},
 	}}
-
-	tests := []struct {
-		req       *btpb.CheckAndMutateRowRequest
-		wantMatch bool
-		name      string
-
-		// if wantState is nil, that means we don't care to check
-		// what the state of the world is.
-		wantState []*btpb.ReadRowsResponse_CellChunk
+	lowRows := &btpb.RowFilter{Filter: &btpb.RowFilter_RowKeyRegexFilter{RowKeyRegexFilter: []byte("row-[0-3]")}}
+	for _, tc := range []struct {
+		desc   string
+		rows   *btpb.RowSet
+		filter *btpb.RowFilter
+		limit  int64
+		want   []string
 	}{
-		{
-			req: &btpb.CheckAndMutateRowRequest{
-				TableName: s.tblName,
-				RowKey:    []byte("row1"),
-				PredicateFilter: &btpb.RowFilter{
+		{"whole table", nil, nil, 0, []string{"row-9", "row-8", "row-7", "row-6", "row-5", "row-4", "row-3", "row-2", "row-1", "row-0"}},
+		{"whole table with limit", nil, nil, 1, []string{"row-9"}},
+		{"two ranges", twoRanges, nil, 0, []string{"row-8", "row-7", "row-6", "row-5", "row-2", "row-1"}},
+		// The limit counts rows in reverse order, so it cuts into the lower range rather than the higher one.
+		{"two ranges with limit", twoRanges, nil, 5, []string{"row-8", "row-7", "row-6", "row-5", "row-2"}},
+		{"limit within highest range", twoRanges, nil, 2, []string{"row-8", "row-7"}},
+		// The highest rows are filtered out, so the range is rescanned below them to fill the limit.
+		{"filtered with limit", nil, lowRows, 3, []string{"row-3", "row-2", "row-1"}},
+	} {
+		responses, err := readRows(ctx, s, &btpb.ReadRowsRequest{
+			TableName:        s.tblName,
+			Rows:             tc.rows,
+			Filter:           tc.filter,
+			RowsLimit:        tc.limit,
+			Reversed:         true,
+			RequestStatsView: btpb.ReadRowsRequest_REQUEST_STATS_FULL,
+		})
+		if err != nil {
+			t.Fatalf("%s: ReadRows error: %v", tc.desc, err)
+		}
+		var keys []string
+		var stats *btpb.ReadIterationStats
+		for _, res := range responses {
+			for _, c := range res.Chunks {
+				if c.GetCommitRow() {
+					keys = append(keys, string(c.RowKey))
+				}
+			}
+			if res.RequestStats != nil {
+				stats = res.RequestStats.GetFullReadStatsView().GetReadIterationStats()
+			}
+		}
+		if diff := cmp.Diff(tc.want, keys); diff != "" {
+			t.Errorf("%s: row keys mismatch (-want +got):\n%s", tc.desc, diff)
+		}
+		if got, want := stats.GetRowsReturnedCount(), int64(len(tc.want)); got != want {
+			t.Errorf("%s: stats report %d rows returned, want %d", tc.desc, got, want)
+		}
+	}
+}
+
+func populateSingleKeyTable(ctx context.Context, tb testing.TB, s *clientIntf) {
+	tb.Helper()
+	newTbl := btapb.Table{
+		ColumnFamilies: map[string]*btapb.ColumnFamily{
+			"cf": {},
+		},
+	}
+	if _, err := s.CreateTable(ctx, &btapb.CreateTableRequest{Parent: s.parent, TableId: s.name, Table: &newTbl}); err != nil {
+		tb.Fatalf("Creating table: %v", err)
+	}
+	for i := 0; i < 3; i++ {
+		for _, col := range []string{"a", "b"} {
+			req := &btpb.MutateRowRequest{
+				TableName: s.tblName,
+				RowKey:    []byte("row-" + strconv.Itoa(i)),
+				Mutations: []*btpb.Mutation{{
+					Mutation: &btpb.Mutation_SetCell_{SetCell: &btpb.Mutation_SetCell{
+						FamilyName:      "cf",
+						ColumnQualifier: []byte(col),
+						Value:           []byte("value"),
+					}},
+				}},
+			}
+			if _, err := s.MutateRow(ctx, req); err != nil {
+				tb.Fatalf("Populating table: %v", err)
+			}
+		}
+	}
+}
+
+func TestGcOnRead(t *testing.T) {
+	ctx := context.Background()
+	const now = 10 * 3600 * 1e6 // 10h, in micros
+	_, s := newTestServer(t, func(svr *server) {
+		svr.clock = func() bigtable.Timestamp { return now }
+		svr.gcOnRead = true
+	})
+	maxVersions := &btapb.GcRule{Rule: &btapb.GcRule_MaxNumVersions{MaxNumVersions: 3}}
+	maxAge := &btapb.GcRule{Rule: &btapb.GcRule_MaxAge{MaxAge: durationpb.New(time.Hour)}}
+	newTbl := btapb.Table{
+		ColumnFamilies: map[string]*btapb.ColumnFamily{
+			// Collect cells only if they are beyond 3 versions and older than 1h.
+			"intersect": {GcRule: &btapb.GcRule{Rule: &btapb.GcRule_Intersection_{Intersection: &btapb.GcRule_Intersection{
+				Rules: []*btapb.GcRule{maxVersions, maxAge},
+			}}}},
+			// Collect cells if they are beyond 3 versions or older than 1h.
+			"union": {GcRule: &btapb.GcRule{Rule: &btapb.GcRule_Union_{Union: &btapb.GcRule_Union{
+				Rules: []*btapb.GcRule{maxVersions, maxAge},
+			}}}},
+		},
+	}
+	if _, err := s.CreateTable(ctx, &btapb.CreateTableRequest{Parent: s.parent, TableId: s.name, Table: &newTbl}); err != nil {
+		t.Fatalf("Creating table: %v", err)
+	}
+
+	const minute = 60 * 1e6
+	versions := map[string][]int64{
+		"young":   {now - 10*minute, now - 20*minute, now - 30*minute, now - 40*minute, now - 120*minute},
+		"old":     {now - 120*minute, now - 180*minute},
+		"expired": {now - 120*minute},
+	}
+	for _, fam := range []string{"intersect", "union"} {
+		for col, tss := range versions {
+			for _, ts := range tss {
+				if _, err := s.MutateRow(ctx, &btpb.MutateRowRequest{
+					TableName: s.tblName,
+					RowKey:    []byte(fam),
+					Mutations: []*btpb.Mutation{{
+						Mutation: &btpb.Mutation_SetCell_{SetCell: &btpb.Mutation_SetCell{
+							FamilyName:      fam,
+							ColumnQualifier: []byte(col),
+							TimestampMicros: ts,
+							Value:           []byte("value"),
+						}},
+					}},
+				}); err != nil {
+					t.Fatalf("Populating table: %v", err)
+				}
+			}
+		}
+	}
+
+	res, err := readRows(ctx, s, &btpb.ReadRowsRequest{TableName: s.tblName})
+	if err != nil {
+		t.Fatalf("ReadRows: %v", err)
+	}
+	got := map[string][]int64{}
+	for _, r := range res {
+		for _, cc := range r.Chunks {
+			k := string(cc.RowKey) + ":" + string(cc.Qualifier.Value)
+			got[k] = append(got[k], cc.TimestampMicros)
+		}
+	}
+	want := map[string][]int64{
+		// The 4th version survives because it is young; only the old 5th version goes.
+		"intersect:young": {now - 10*minute, now - 20*minute, now - 30*minute, now - 40*minute},
+		// Old versions survive while within the version limit.
+		"intersect:old":     {now - 120*minute, now - 180*minute},
+		"intersect:expired": {now - 120*minute},
+		"union:young":       {now - 10*minute, now - 20*minute, now - 30*minute},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("surviving cells mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestHealth(t *testing.T) {
+	ctx := context.Background()
+	srv, err := NewServer("localhost:0")
+	if err != nil {
+		t.Fatalf("NewServer: %v", err)
+	}
+	defer srv.Close()
+
+	conn, err := grpc.NewClient(srv.Addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("Dialing %s: %v", srv.Addr, err)
+	}
+	defer conn.Close()
+
+	res, err := healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{})
+	if err != nil {
+		t.Fatalf("Health check: %v", err)
+	}
+	if res.Status != healthpb.HealthCheckResponse_SERVING {
+		t.Errorf("Health status: got %v, want SERVING", res.Status)
+	}
+}
+
+func TestReflection(t *testing.T) {
+	for _, enable := range []bool{false, true} {
+		t.Run(fmt.Sprintf("enable=%v", enable), func(t *testing.T) {
+			srv, err := NewServerWithOptions("localhost:0", Options{EnableReflection: enable})
+			if err != nil {
+				t.Fatalf("NewServerWithOptions: %v", err)
+			}
+			defer srv.Close()
+			conn, err := grpc.NewClient(srv.Addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+			if err != nil {
+				t.Fatalf("Dialing %s: %v", srv.Addr, err)
+			}
+			defer conn.Close()
+
+			stream, err := reflectionpb.NewServerReflectionClient(conn).ServerReflectionInfo(context.Background())
+			if err != nil {
+				t.Fatalf("ServerReflectionInfo: %v", err)
+			}
+			if err := stream.Send(&reflectionpb.ServerReflectionRequest{
+				MessageRequest: &reflectionpb.ServerReflectionRequest_ListServices{},
+			}); err != nil {
+				t.Fatalf("Send: %v", err)
+			}
+			res, err := stream.Recv()
+			if !enable {
+				if status.Code(err) != codes.Unimplemented {
+					t.Errorf("reflection without EnableReflection: got %v, want Unimplemented", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Recv: %v", err)
+			}
+			var services []string
+			for _, svc := range res.GetListServicesResponse().GetService() {
+				services = append(services, svc.Name)
+			}
+			for _, want := range []string{
+				"google.bigtable.v2.Bigtable",
+				"google.bigtable.admin.v2.BigtableTableAdmin",
+				"google.bigtable.admin.v2.BigtableInstanceAdmin",
+			} {
+				found := false
+				for _, svc := range services {
+					found = found || svc == want
+				}
+				if !found {
+					t.Errorf("service %q not listed in %q", want, services)
+				}
+			}
+		})
+	}
+}
+
+func TestCustomGrpcServer(t *testing.T) {
+	var mu sync.Mutex
+	var methods []string
+	interceptor := func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		mu.Lock()
+		methods = append(methods, info.FullMethod)
+		mu.Unlock()
+		return handler(ctx, req)
+	}
+	srv, err := NewServerWithOptions("localhost:0", Options{
+		GrpcOpts:         []grpc.ServerOption{grpc.UnaryInterceptor(interceptor)},
+		RegisterServices: func(s *grpc.Server) { reflection.Register(s) },
+	})
+	if err != nil {
+		t.Fatalf("NewServerWithOptions: %v", err)
+	}
+	defer srv.Close()
+
+	if _, ok := srv.GRPC().GetServiceInfo()["grpc.reflection.v1.ServerReflection"]; !ok {
+		t.Errorf("service registered by RegisterServices missing from %v", srv.GRPC().GetServiceInfo())
+	}
+
+	conn, err := grpc.NewClient(srv.Addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("Dialing %s: %v", srv.Addr, err)
+	}
+	defer conn.Close()
+	if _, err := btapb.NewBigtableTableAdminClient(conn).ListTables(context.Background(), &btapb.ListTablesRequest{
+		Parent: "projects/p/instances/i",
+	}); err != nil {
+		t.Fatalf("ListTables: %v", err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if diff := cmp.Diff([]string{"/google.bigtable.admin.v2.BigtableTableAdmin/ListTables"}, methods); diff != "" {
+		t.Errorf("interceptor saw unexpected methods (-want +got):\n%s", diff)
+	}
+}
+
+func TestMutationErrorCodes(t *testing.T) {
+	ctx, s, ok := newClient(t)
+	if err := populateTable(ctx, s, ok); err != nil {
+		t.Fatal(err)
+	}
+
+	setCell := func(family string, ts int64) []*btpb.Mutation {
+		return []*btpb.Mutation{{Mutation: &btpb.Mutation_SetCell_{SetCell: &btpb.Mutation_SetCell{
+			FamilyName:      family,
+			ColumnQualifier: []byte("col"),
+			TimestampMicros: ts,
+			Value:           []byte("value"),
+		}}}}
+	}
+	for _, tc := range []struct {
+		desc string
+		muts []*btpb.Mutation
+		want codes.Code
+	}{
+		{"unknown family", setCell("nope", 1000), codes.NotFound},
+		{"invalid timestamp", setCell("cf0", 1001), codes.InvalidArgument},
+		{"inverted range", []*btpb.Mutation{{Mutation: &btpb.Mutation_DeleteFromColumn_{DeleteFromColumn: &btpb.Mutation_DeleteFromColumn{
+			FamilyName:      "cf0",
+			ColumnQualifier: []byte("col"),
+			TimeRange:       &btpb.TimestampRange{StartTimestampMicros: 2000, EndTimestampMicros: 1000},
+		}}}}, codes.InvalidArgument},
+	} {
+		_, err := s.MutateRow(ctx, &btpb.MutateRowRequest{TableName: s.tblName, RowKey: []byte("row"), Mutations: tc.muts})
+		if got := status.Code(err); got != tc.want {
+			t.Errorf("%s: MutateRow: got %v, want %v", tc.desc, err, tc.want)
+		}
+
+		stream, err := s.MutateRows(ctx, &btpb.MutateRowsRequest{TableName: s.tblName, Entries: []*btpb.MutateRowsRequest_Entry{
+			{RowKey: []byte("row"), Mutations: tc.muts},
+		}})
+		if err != nil {
+			t.Fatalf("%s: MutateRows: %v", tc.desc, err)
+		}
+		res, err := stream.Recv()
+		if err != nil {
+			t.Fatalf("%s: MutateRows: %v", tc.desc, err)
+		}
+		if got := codes.Code(res.Entries[0].Status.Code); got != tc.want {
+			t.Errorf("%s: MutateRows entry: got %v (%s), want %v", tc.desc, got, res.Entries[0].Status.Message, tc.want)
+		}
+	}
+}
+
+func TestMaxRowSize(t *testing.T) {
+	ctx := context.Background()
+	_, s := newTestServer(t, func(svr *server) { svr.maxRowSize = 10 })
+	newTbl := btapb.Table{
+		ColumnFamilies: map[string]*btapb.ColumnFamily{
+			"cf": {},
+		},
+	}
+	if _, err := s.CreateTable(ctx, &btapb.CreateTableRequest{Parent: s.parent, TableId: s.name, Table: &newTbl}); err != nil {
+		t.Fatalf("Creating table: %v", err)
+	}
+	setCell := func(col, val string) *btpb.Mutation {
+		return &btpb.Mutation{Mutation: &btpb.Mutation_SetCell_{SetCell: &btpb.Mutation_SetCell{
+			FamilyName:      "cf",
+			ColumnQualifier: []byte(col),
+			Value:           []byte(val),
+			TimestampMicros: 1000,
+		}}}
+	}
+	mutateRow := func(muts ...*btpb.Mutation) error {
+		_, err := s.MutateRow(ctx, &btpb.MutateRowRequest{TableName: s.tblName, RowKey: []byte("row"), Mutations: muts})
+		return err
+	}
+	readRow := func() []*btpb.ReadRowsResponse {
+		t.Helper()
+		res, err := readRows(ctx, s, &btpb.ReadRowsRequest{TableName: s.tblName})
+		if err != nil {
+			t.Fatalf("ReadRows: %v", err)
+		}
+		return res
+	}
+
+	if err := mutateRow(setCell("a", "12345")); err != nil {
+		t.Fatalf("MutateRow within limit: %v", err)
+	}
+	want := readRow()
+
+	// The first mutation alone would fit, but together they exceed the limit; neither is applied.
+	if err := mutateRow(setCell("b", "1234"), setCell("c", "12")); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("MutateRow over limit: got %v, want InvalidArgument", err)
+	}
+	if diff := cmp.Diff(want, readRow(), cmp.Comparer(proto.Equal)); diff != "" {
+		t.Errorf("Row changed by failed mutation (-want +got):\n%s", diff)
+	}
+
+	// Replacing a value counts only the new value.
+	if err := mutateRow(setCell("a", "1234567890")); err != nil {
+		t.Errorf("MutateRow replacing a value: %v", err)
+	}
+	want = readRow()
+
+	// Appends and increments that would grow the row past the limit are rejected and leave it unchanged.
+	for _, rule := range []*btpb.ReadModifyWriteRule{
+		{FamilyName: "cf", ColumnQualifier: []byte("a"), Rule: &btpb.ReadModifyWriteRule_AppendValue{AppendValue: []byte("x")}},
+		{FamilyName: "cf", ColumnQualifier: []byte("n"), Rule: &btpb.ReadModifyWriteRule_IncrementAmount{IncrementAmount: 1}},
+	} {
+		_, err := s.ReadModifyWriteRow(ctx, &btpb.ReadModifyWriteRowRequest{
+			TableName: s.tblName,
+			RowKey:    []byte("row"),
+			Rules:     []*btpb.ReadModifyWriteRule{rule},
+		})
+		if status.Code(err) != codes.InvalidArgument {
+			t.Errorf("ReadModifyWriteRow %T over limit: got %v, want InvalidArgument", rule.Rule, err)
+		}
+		if diff := cmp.Diff(want, readRow(), cmp.Comparer(proto.Equal)); diff != "" {
+			t.Errorf("Row changed by failed ReadModifyWriteRow %T (-want +got):\n%s", rule.Rule, diff)
+		}
+	}
+}
+
+func TestMutateRowsSameRowKey(t *testing.T) {
+	forEachStorage(t, func(t *testing.T, storage Storage) {
+		ctx := context.Background()
+		svr, s := newTestServer(t, func(svr *server) { svr.storage = storage })
+		newTbl := btapb.Table{
+			ColumnFamilies: map[string]*btapb.ColumnFamily{
+				"cf": {},
+				"sum": {ValueType: &btapb.Type{Kind: &btapb.Type_AggregateType{AggregateType: &btapb.Type_Aggregate{
+					InputType:  &btapb.Type{Kind: &btapb.Type_Int64Type{Int64Type: &btapb.Type_Int64{}}},
+					Aggregator: &btapb.Type_Aggregate_Sum_{Sum: &btapb.Type_Aggregate_Sum{}},
+				}}}},
+			},
+		}
+		if _, err := s.CreateTable(ctx, &btapb.CreateTableRequest{Parent: s.parent, TableId: s.name, Table: &newTbl}); err != nil {
+			t.Fatalf("Creating table: %v", err)
+		}
+
+		setCell := func(fam, col, value string) *btpb.Mutation {
+			return &btpb.Mutation{Mutation: &btpb.Mutation_SetCell_{SetCell: &btpb.Mutation_SetCell{
+				FamilyName:      fam,
+				ColumnQualifier: []byte(col),
+				TimestampMicros: 1000,
+				Value:           []byte(value),
+			}}}
+		}
+		addToCell := func(v int64) *btpb.Mutation {
+			return &btpb.Mutation{Mutation: &btpb.Mutation_AddToCell_{AddToCell: &btpb.Mutation_AddToCell{
+				FamilyName:      "sum",
+				ColumnQualifier: &btpb.Value{Kind: &btpb.Value_RawValue{RawValue: []byte("col")}},
+				Timestamp:       &btpb.Value{Kind: &btpb.Value_RawTimestampMicros{RawTimestampMicros: 1000}},
+				Input:           &btpb.Value{Kind: &btpb.Value_IntValue{IntValue: v}},
+			}}}
+		}
+
+		// Every entry targets the same row; each must see the ones before it.
+		row := []byte("row")
+		stream, err := s.MutateRows(ctx, &btpb.MutateRowsRequest{
+			TableName: s.tblName,
+			Entries: []*btpb.MutateRowsRequest_Entry{
+				{RowKey: row, Mutations: []*btpb.Mutation{setCell("cf", "a", "1")}},
+				{RowKey: row, Mutations: []*btpb.Mutation{addToCell(40)}},
+				{RowKey: row, Mutations: []*btpb.Mutation{setCell("unknown", "a", "x")}},
+				{RowKey: row, Mutations: []*btpb.Mutation{addToCell(2), setCell("cf", "b", "2")}},
+				{RowKey: row, Mutations: []*btpb.Mutation{setCell("cf", "a", "3")}},
+			},
+		})
+		if err != nil {
+			t.Fatalf("MutateRows: %v", err)
+		}
+		res, err := stream.Recv()
+		if err != nil {
+			t.Fatalf("MutateRows: %v", err)
+		}
+		for i, entry := range res.Entries {
+			want := codes.OK
+			if i == 2 {
+				want = codes.NotFound
+			}
+			if got := codes.Code(entry.Status.Code); got != want {
+				t.Errorf("entry %d: got %v, want %v", i, got, want)
+			}
+		}
+
+		tbl := svr.tables[s.tblName]
+		tbl.mu.RLock()
+		r := tbl.rows.Get(row)
+		tbl.mu.RUnlock()
+		got := map[string]string{}
+		for _, fam := range r.Families {
+			for _, col := range fam.Columns {
+				for _, cell := range col.Cells {
+					v := string(cell.Value)
+					if fam.Name == "sum" {
+						v = strconv.FormatInt(int64(binary.BigEndian.Uint64(cell.Value)), 10)
+					}
+					got[fam.Name+":"+string(col.Qualifier)] = v
+				}
+			}
+		}
+		want := map[string]string{"cf:a": "3", "cf:b": "2", "sum:col": "42"}
+		if diff := cmp.Diff(want, got); diff != "" {
+			t.Errorf("row mismatch (-want +got):\n%s", diff)
+		}
+	})
+}
+
+func TestTableStats(t *testing.T) {
+	forEachStorage(t, func(t *testing.T, storage Storage) {
+		ctx := context.Background()
+		svr, s := newTestServer(t, func(svr *server) { svr.storage = storage })
+		srv := &Server{s: svr}
+		newTbl := btapb.Table{
+			ColumnFamilies: map[string]*btapb.ColumnFamily{
+				"cf": {},
+			},
+		}
+		if _, err := s.CreateTable(ctx, &btapb.CreateTableRequest{Parent: s.parent, TableId: s.name, Table: &newTbl}); err != nil {
+			t.Fatalf("Creating table: %v", err)
+		}
+		setCell := func(row, col, val string, ts int64) {
+			t.Helper()
+			_, err := s.MutateRow(ctx, &btpb.MutateRowRequest{
+				TableName: s.tblName,
+				RowKey:    []byte(row),
+				Mutations: []*btpb.Mutation{{
+					Mutation: &btpb.Mutation_SetCell_{SetCell: &btpb.Mutation_SetCell{
+						FamilyName:      "cf",
+						ColumnQualifier: []byte(col),
+						Value:           []byte(val),
+						TimestampMicros: ts,
+					}},
+				}},
+			})
+			if err != nil {
+				t.Fatalf("MutateRow: %v", err)
+			}
+		}
+		setCell("r1", "a", "x", 1000)
+		setCell("r1", "a", "xx", 2000) // a second version
+		setCell("r1", "bb", "yyy", 1000)
+		setCell("row2", "c", "zzzz", 1000)
+		setCell("gone", "a", "x", 1000)
+		_, err := s.MutateRow(ctx, &btpb.MutateRowRequest{
+			TableName: s.tblName,
+			RowKey:    []byte("gone"),
+			Mutations: []*btpb.Mutation{{Mutation: &btpb.Mutation_DeleteFromRow_{DeleteFromRow: &btpb.Mutation_DeleteFromRow{}}}},
+		})
+		if err != nil {
+			t.Fatalf("MutateRow: %v", err)
+		}
+
+		rows, cells, bytes, err := srv.TableStats(s.tblName)
+		if err != nil {
+			t.Fatalf("TableStats: %v", err)
+		}
+		// Keys: r1 + row2 = 6; cells: a/x + a/xx + bb/yyy + c/zzzz = 2 + 3 + 5 + 5 = 15.
+		if rows != 2 || cells != 4 || bytes != 21 {
+			t.Errorf("TableStats: got (%d rows, %d cells, %d bytes), want (2, 4, 21)", rows, cells, bytes)
+		}
+
+		if _, _, _, err := srv.TableStats(s.tblName + "-missing"); status.Code(err) != codes.NotFound {
+			t.Errorf("TableStats on missing table: got %v, want NotFound", err)
+		}
+	})
+}
+
+func TestSetTableReadOnly(t *testing.T) {
+	ctx := context.Background()
+	svr, s := newTestServer(t, nil)
+	srv := &Server{s: svr}
+	newTbl := btapb.Table{
+		ColumnFamilies: map[string]*btapb.ColumnFamily{
+			"cf": {},
+		},
+	}
+	if _, err := s.CreateTable(ctx, &btapb.CreateTableRequest{Parent: s.parent, TableId: s.name, Table: &newTbl}); err != nil {
+		t.Fatalf("Creating table: %v", err)
+	}
+	muts := []*btpb.Mutation{{
+		Mutation: &btpb.Mutation_SetCell_{SetCell: &btpb.Mutation_SetCell{
+			FamilyName:      "cf",
+			ColumnQualifier: []byte("col"),
+			Value:           []byte("value"),
+		}},
+	}}
+	mutateRow := func() error {
+		_, err := s.MutateRow(ctx, &btpb.MutateRowRequest{TableName: s.tblName, RowKey: []byte("row"), Mutations: muts})
+		return err
+	}
+	if err := mutateRow(); err != nil {
+		t.Fatalf("MutateRow: %v", err)
+	}
+
+	if err := srv.SetTableReadOnly(s.tblName, true); err != nil {
+		t.Fatalf("SetTableReadOnly: %v", err)
+	}
+	if err := mutateRow(); status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("MutateRow on read-only table: got %v, want FailedPrecondition", err)
+	}
+	err := svr.MutateRows(&btpb.MutateRowsRequest{
+		TableName: s.tblName,
+		Entries:   []*btpb.MutateRowsRequest_Entry{{RowKey: []byte("row"), Mutations: muts}},
+	}, &mrAdapter{streamAdapter{ctx: ctx}})
+	if status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("MutateRows on read-only table: got %v, want FailedPrecondition", err)
+	}
+	_, err = s.CheckAndMutateRow(ctx, &btpb.CheckAndMutateRowRequest{TableName: s.tblName, RowKey: []byte("row"), TrueMutations: muts})
+	if status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("CheckAndMutateRow on read-only table: got %v, want FailedPrecondition", err)
+	}
+	_, err = s.ReadModifyWriteRow(ctx, &btpb.ReadModifyWriteRowRequest{
+		TableName: s.tblName,
+		RowKey:    []byte("row"),
+		Rules: []*btpb.ReadModifyWriteRule{{
+			FamilyName:      "cf",
+			ColumnQualifier: []byte("col"),
+			Rule:            &btpb.ReadModifyWriteRule_AppendValue{AppendValue: []byte("-more")},
+		}},
+	})
+	if status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("ReadModifyWriteRow on read-only table: got %v, want FailedPrecondition", err)
+	}
+	_, err = s.DropRowRange(ctx, &btapb.DropRowRangeRequest{Name: s.tblName, Target: &btapb.DropRowRangeRequest_DeleteAllDataFromTable{DeleteAllDataFromTable: true}})
+	if status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("DropRowRange on read-only table: got %v, want FailedPrecondition", err)
+	}
+
+	// Reads still work.
+	res, err := readRows(ctx, s, &btpb.ReadRowsRequest{TableName: s.tblName})
+	if err != nil {
+		t.Fatalf("ReadRows on read-only table: %v", err)
+	}
+	if len(res) != 1 || len(res[0].Chunks) != 1 || string(res[0].Chunks[0].Value) != "value" {
+		t.Errorf("ReadRows on read-only table: got %v, want the original cell", res)
+	}
+
+	if err := srv.SetTableReadOnly(s.tblName, false); err != nil {
+		t.Fatalf("SetTableReadOnly: %v", err)
+	}
+	if err := mutateRow(); err != nil {
+		t.Errorf("MutateRow after re-enabling writes: %v", err)
+	}
+
+	if err := srv.SetTableReadOnly(s.parent+"/tables/missing", true); status.Code(err) != codes.NotFound {
+		t.Errorf("SetTableReadOnly on unknown table: got %v, want NotFound", err)
+	}
+}
+
+func TestReadRowsErrorAfter(t *testing.T) {
+	ctx := context.Background()
+	svr, s := newTestServer(t, func(svr *server) { svr.errorAfter = 3 })
+	newTbl := btapb.Table{
+		ColumnFamilies: map[string]*btapb.ColumnFamily{
+			"cf": {},
+		},
+	}
+	if _, err := s.CreateTable(ctx, &btapb.CreateTableRequest{Parent: s.parent, TableId: s.name, Table: &newTbl}); err != nil {
+		t.Fatalf("Creating table: %v", err)
+	}
+	for i := 0; i < 5; i++ {
+		req := &btpb.MutateRowRequest{
+			TableName: s.tblName,
+			RowKey:    []byte("row-" + strconv.Itoa(i)),
+			Mutations: []*btpb.Mutation{{
+				Mutation: &btpb.Mutation_SetCell_{SetCell: &btpb.Mutation_SetCell{
+					FamilyName:      "cf",
+					ColumnQualifier: []byte("col"),
+					Value:           []byte("value"),
+				}},
+			}},
+		}
+		if _, err := s.MutateRow(ctx, req); err != nil {
+			t.Fatalf("Populating table: %v", err)
+		}
+	}
+
+	// readKeys returns the keys of rows committed before the stream ended, along with the stream's error.
+	readKeys := func(req *btpb.ReadRowsRequest) ([]string, error) {
+		stream := &rrAdapter{streamAdapter{ctx: ctx}}
+		err := svr.ReadRows(req, stream)
+		var keys []string
+		for _, msg := range stream.msgs {
+			for _, c := range msg.(*btpb.ReadRowsResponse).Chunks {
+				if c.GetCommitRow() {
+					keys = append(keys, string(c.RowKey))
+				}
+			}
+		}
+		return keys, err
+	}
+
+	// The first attempt streams three rows, then fails.
+	keys, err := readKeys(&btpb.ReadRowsRequest{TableName: s.tblName})
+	if status.Code(err) != codes.Unavailable {
+		t.Fatalf("ReadRows error: got %v, want Unavailable", err)
+	}
+	if diff := cmp.Diff([]string{"row-0", "row-1", "row-2"}, keys); diff != "" {
+		t.Fatalf("Partial rows mismatch (-want +got):\n%s", diff)
+	}
+
+	// Resuming after the last committed row yields the remainder.
+	keys, err = readKeys(&btpb.ReadRowsRequest{
+		TableName: s.tblName,
+		Rows: &btpb.RowSet{RowRanges: []*btpb.RowRange{{
+			StartKey: &btpb.RowRange_StartKeyOpen{StartKeyOpen: []byte("row-2")},
+		}}},
+	})
+	if err != nil {
+		t.Fatalf("Resumed ReadRows error: %v", err)
+	}
+	if diff := cmp.Diff([]string{"row-3", "row-4"}, keys); diff != "" {
+		t.Fatalf("Resumed rows mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestReadChunkFlushThreshold(t *testing.T) {
+	ctx := context.Background()
+	svr, s := newTestServer(t, func(svr *server) { svr.flushChunks = 2 })
+	newTbl := btapb.Table{
+		ColumnFamilies: map[string]*btapb.ColumnFamily{
+			"cf": {},
+		},
+	}
+	if _, err := s.CreateTable(ctx, &btapb.CreateTableRequest{Parent: s.parent, TableId: s.name, Table: &newTbl}); err != nil {
+		t.Fatalf("Creating table: %v", err)
+	}
+	var muts []*btpb.Mutation
+	for i := 0; i < 5; i++ {
+		muts = append(muts, &btpb.Mutation{
+			Mutation: &btpb.Mutation_SetCell_{SetCell: &btpb.Mutation_SetCell{
+				FamilyName:      "cf",
+				ColumnQualifier: []byte("col-" + strconv.Itoa(i)),
+				Value:           []byte("value"),
+			}},
+		})
+	}
+	if _, err := s.MutateRow(ctx, &btpb.MutateRowRequest{TableName: s.tblName, RowKey: []byte("row"), Mutations: muts}); err != nil {
+		t.Fatalf("Populating table: %v", err)
+	}
+
+	stream := &rrAdapter{streamAdapter{ctx: ctx}}
+	if err := svr.ReadRows(&btpb.ReadRowsRequest{TableName: s.tblName}, stream); err != nil {
+		t.Fatalf("ReadRows: %v", err)
+	}
+	if got, want := len(stream.msgs), 3; got != want {
+		t.Fatalf("got %d responses, want %d", got, want)
+	}
+	var cells, commits int
+	for i, msg := range stream.msgs {
+		chunks := msg.(*btpb.ReadRowsResponse).Chunks
+		if len(chunks) > 2 {
+			t.Errorf("response %d has %d chunks, want at most 2", i, len(chunks))
+		}
+		for _, c := range chunks {
+			cells++
+			if c.GetCommitRow() {
+				commits++
+			}
+		}
+	}
+	if cells != 5 || commits != 1 {
+		t.Errorf("got %d cells and %d commits, want 5 cells and 1 commit", cells, commits)
+	}
+	if last := stream.msgs[len(stream.msgs)-1].(*btpb.ReadRowsResponse).Chunks; !last[len(last)-1].GetCommitRow() {
+		t.Error("row not committed by its last chunk")
+	}
+}
+
+func TestReadRowsAfterDeletion(t *testing.T) {
+	ctx, s, ok := newClient(t)
+	if err := populateTable(ctx, s, ok); err != nil {
+		t.Fatal(err)
+	}
+	dreq := &btpb.MutateRowRequest{
+		TableName: s.tblName,
+		RowKey:    []byte("row"),
+		Mutations: []*btpb.Mutation{{
+			Mutation: &btpb.Mutation_DeleteFromRow_{
+				DeleteFromRow: &btpb.Mutation_DeleteFromRow{},
+			},
+		}},
+	}
+	if _, err := s.MutateRow(ctx, dreq); err != nil {
+		t.Fatalf("Deleting from table: %v", err)
+	}
+
+	responses, err := readRows(ctx, s, &btpb.ReadRowsRequest{TableName: s.tblName})
+	if err != nil {
+		t.Fatalf("ReadRows error: %v", err)
+	}
+	if got, want := len(responses), 0; got != want {
+		t.Errorf("response count: got %d, want %d", got, want)
+	}
+}
+
+func TestReadRowsOrder(t *testing.T) {
+	ctx, s, ok := newClient(t)
+	if !ok {
+		newTbl := btapb.Table{
+			ColumnFamilies: map[string]*btapb.ColumnFamily{
+				"cf0": {GcRule: &btapb.GcRule{Rule: &btapb.GcRule_MaxNumVersions{MaxNumVersions: 1}}},
+			},
+		}
+		_, err := s.CreateTable(ctx, &btapb.CreateTableRequest{Parent: s.parent, TableId: s.name, Table: &newTbl})
+		if err != nil {
+			t.Fatalf("Creating table: %v", err)
+		}
+	}
+	count := 3
+	mcf := func(i int) *btapb.ModifyColumnFamiliesRequest {
+		return &btapb.ModifyColumnFamiliesRequest{
+			Name: s.tblName,
+			Modifications: []*btapb.ModifyColumnFamiliesRequest_Modification{{
+				Id:  "cf" + strconv.Itoa(i),
+				Mod: &btapb.ModifyColumnFamiliesRequest_Modification_Create{Create: &btapb.ColumnFamily{}},
+			}},
+		}
+	}
+	for i := 1; i <= count; i++ {
+		_, err := s.ModifyColumnFamilies(ctx, mcf(i))
+		if err != nil {
+			if s, ok := status.FromError(err); !ok || s.Code() != codes.AlreadyExists {
+				t.Fatal(err)
+			}
+		}
+	}
+	// Populate the table
+	for fc := 0; fc < count; fc++ {
+		for cc := count; cc > 0; cc-- {
+			for tc := 0; tc < count; tc++ {
+				req := &btpb.MutateRowRequest{
+					TableName: s.tblName,
+					RowKey:    []byte("row"),
+					Mutations: []*btpb.Mutation{{
+						Mutation: &btpb.Mutation_SetCell_{SetCell: &btpb.Mutation_SetCell{
+							FamilyName:      "cf" + strconv.Itoa(fc),
+							ColumnQualifier: []byte("col" + strconv.Itoa(cc)),
+							TimestampMicros: int64((tc + 1) * 1000),
+							Value:           []byte{},
+						}},
+					}},
+				}
+				if _, err := s.MutateRow(ctx, req); err != nil {
+					t.Fatalf("Populating table: %v", err)
+				}
+			}
+		}
+	}
+	req := &btpb.ReadRowsRequest{
+		TableName: s.tblName,
+		Rows:      &btpb.RowSet{RowKeys: [][]byte{[]byte("row")}},
+	}
+	responses, err := readRows(ctx, s, req)
+	if err != nil {
+		t.Fatalf("ReadRows error: %v", err)
+	}
+
+	if got, want := len(responses[0].Chunks), 27; got != want {
+		t.Errorf("Chunk count: got %d, want %d", got, want)
+	}
+	testOrder := func(responses []*btpb.ReadRowsResponse) {
+		var prevFam string
+		var prevCol []byte
+		var prevTime int64
+		for _, cc := range responses[0].Chunks {
+			if prevFam == "" {
+				prevFam = cc.FamilyName.Value
+				prevCol = cc.Qualifier.Value
+				prevTime = cc.TimestampMicros
+				continue
+			}
+			if cc.FamilyName.Value < prevFam {
+				t.Errorf("Family order is not correct: got %s < %s", cc.FamilyName.Value, prevFam)
+			} else if cc.FamilyName.Value == prevFam {
+				if comp := bytes.Compare(cc.Qualifier.Value, prevCol); comp < 0 {
+					t.Errorf("Column order is not correct: got %s < %s", cc.Qualifier.Value, prevCol)
+				} else if comp == 0 {
+					if cc.TimestampMicros > prevTime {
+						t.Errorf("cell order is not correct: got %d > %d", cc.TimestampMicros, prevTime)
+					}
+				}
+			}
+			prevFam = cc.FamilyName.Value
+			prevCol = cc.Qualifier.Value
+			prevTime = cc.TimestampMicros
+		}
+	}
+	testOrder(responses)
+
+	// Read with interleave filter
+	inter := &btpb.RowFilter_Interleave{}
+	fnr := &btpb.RowFilter{Filter: &btpb.RowFilter_FamilyNameRegexFilter{FamilyNameRegexFilter: "cf1"}}
+	cqr := &btpb.RowFilter{Filter: &btpb.RowFilter_ColumnQualifierRegexFilter{ColumnQualifierRegexFilter: []byte("col2")}}
+	inter.Filters = append(inter.Filters, fnr, cqr)
+	req = &btpb.ReadRowsRequest{
+		TableName: s.tblName,
+		Rows:      &btpb.RowSet{RowKeys: [][]byte{[]byte("row")}},
+		Filter: &btpb.RowFilter{
+			Filter: &btpb.RowFilter_Interleave_{Interleave: inter},
+		},
+	}
+
+	responses, err = readRows(ctx, s, req)
+	if err != nil {
+		t.Fatalf("ReadRows error: %v", err)
+	}
+	if len(responses) == 0 {
+		t.Fatal("Response count: got 0, want > 0")
+	}
+
+	// cf1 12 cells will be returned (3 are dups)
+	// cf0 and cf2 the 3 cells in col2 will be returned
+	if got, want := len(responses[0].Chunks), 3+12+3; got != want {
+		t.Errorf("Chunk count: got %d, want %d", got, want)
+	}
+	testOrder(responses)
+
+	// Check order after ReadModifyWriteRow
+	rmw := func(i int) *btpb.ReadModifyWriteRowRequest {
+		return &btpb.ReadModifyWriteRowRequest{
+			TableName: s.tblName,
+			RowKey:    []byte("row"),
+			Rules: []*btpb.ReadModifyWriteRule{{
+				FamilyName:      "cf3",
+				ColumnQualifier: []byte("col" + strconv.Itoa(i)),
+				Rule:            &btpb.ReadModifyWriteRule_IncrementAmount{IncrementAmount: 1},
+			}},
+		}
+	}
+	for i := count; i > 0; i-- {
+		if _, err := s.ReadModifyWriteRow(ctx, rmw(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	req = &btpb.ReadRowsRequest{
+		TableName: s.tblName,
+		Rows:      &btpb.RowSet{RowKeys: [][]byte{[]byte("row")}},
+	}
+	responses, err = readRows(ctx, s, req)
+	if err != nil {
+		t.Fatalf("ReadRows error: %v", err)
+	}
+	if len(responses) == 0 {
+		t.Fatal("Response count: got 0, want > 0")
+	}
+
+	// cf0, cf1, and cf2 all 9 cells will be returned
+	// cf3 all 3 cells will be returned
+	if got, want := len(responses[0].Chunks), 9+9+9+3; got != want {
+		t.Errorf("Chunk count: got %d, want %d", got, want)
+	}
+	testOrder(responses)
+}
+
+func TestReadRowsWithlabelTransformer(t *testing.T) {
+	ctx, s, ok := newClient(t)
+	if !ok {
+		newTbl := btapb.Table{
+			ColumnFamilies: map[string]*btapb.ColumnFamily{
+				"cf0": {GcRule: &btapb.GcRule{Rule: &btapb.GcRule_MaxNumVersions{MaxNumVersions: 1}}},
+			},
+		}
+		_, err := s.CreateTable(ctx, &btapb.CreateTableRequest{Parent: s.parent, TableId: s.name, Table: &newTbl})
+		if err != nil {
+			t.Fatalf("Creating table: %v", err)
+		}
+	}
+	mreq := &btpb.MutateRowRequest{
+		TableName: s.tblName,
+		RowKey:    []byte("row"),
+		Mutations: []*btpb.Mutation{{
+			Mutation: &btpb.Mutation_SetCell_{SetCell: &btpb.Mutation_SetCell{
+				FamilyName:      "cf0",
+				ColumnQualifier: []byte("col"),
+				TimestampMicros: 1000,
+				Value:           []byte{},
+			}},
+		}},
+	}
+	if _, err := s.MutateRow(ctx, mreq); err != nil {
+		t.Fatalf("Populating table: %v", err)
+	}
+
+	req := &btpb.ReadRowsRequest{
+		TableName: s.tblName,
+		Filter: &btpb.RowFilter{
+			Filter: &btpb.RowFilter_ApplyLabelTransformer{
+				ApplyLabelTransformer: "label",
+			},
+		},
+	}
+	responses, err := readRows(ctx, s, req)
+	if err != nil {
+		t.Fatalf("ReadRows error: %v", err)
+	}
+	if got, want := len(responses), 1; got != want {
+		t.Fatalf("response count: got %d, want %d", got, want)
+	}
+	resp := responses[0]
+	if got, want := len(resp.Chunks), 1; got != want {
+		t.Fatalf("chunks count: got %d, want %d", got, want)
+	}
+	chunk := resp.Chunks[0]
+	if got, want := len(chunk.Labels), 1; got != want {
+		t.Fatalf("labels count: got %d, want %d", got, want)
+	}
+	if got, want := chunk.Labels[0], "label"; got != want {
+		t.Fatalf("label: got %s, want %s", got, want)
+	}
+
+	req = &btpb.ReadRowsRequest{
+		TableName: s.tblName,
+		Filter: &btpb.RowFilter{
+			Filter: &btpb.RowFilter_ApplyLabelTransformer{
+				ApplyLabelTransformer: "", // invalid label
+			},
+		},
+	}
+	if _, err := readRows(ctx, s, req); err == nil {
+		t.Fatal("ReadRows want invalid label error, got none")
+	}
+}
+
+func TestCheckAndMutateRowWithoutPredicate(t *testing.T) {
+	ctx, s, ok := newClient(t)
+	if !ok {
+		newTbl := btapb.Table{
+			ColumnFamilies: map[string]*btapb.ColumnFamily{
+				"cf": {GcRule: &btapb.GcRule{Rule: &btapb.GcRule_MaxNumVersions{MaxNumVersions: 1}}},
+			},
+		}
+		_, err := s.CreateTable(ctx, &btapb.CreateTableRequest{Parent: s.parent, TableId: s.name, Table: &newTbl})
+		if err != nil {
+			t.Fatalf("Creating table: %v", err)
+		}
+	}
+
+	val := []byte("value")
+	muts := []*btpb.Mutation{{
+		Mutation: &btpb.Mutation_SetCell_{SetCell: &btpb.Mutation_SetCell{
+			FamilyName:      "cf",
+			ColumnQualifier: []byte("col"),
+			TimestampMicros: 1000,
+			Value:           val,
+		}},
+	}}
+
+	// Populate the table
+	mrreq := &btpb.MutateRowRequest{
+		TableName: s.tblName,
+		RowKey:    []byte("row-present"),
+		Mutations: muts,
+	}
+	if _, err := s.MutateRow(ctx, mrreq); err != nil {
+		t.Fatalf("Populating table: %v", err)
+	}
+
+	req := &btpb.CheckAndMutateRowRequest{
+		TableName:      s.tblName,
+		RowKey:         []byte("row-not-present"),
+		FalseMutations: muts,
+	}
+	if res, err := s.CheckAndMutateRow(ctx, req); err != nil {
+		t.Errorf("CheckAndMutateRow error: %v", err)
+	} else if got, want := res.PredicateMatched, false; got != want {
+		t.Errorf("Invalid PredicateMatched value: got %t, want %t", got, want)
+	}
+
+	req = &btpb.CheckAndMutateRowRequest{
+		TableName:      s.tblName,
+		RowKey:         []byte("row-present"),
+		FalseMutations: muts,
+	}
+	if res, err := s.CheckAndMutateRow(ctx, req); err != nil {
+		t.Errorf("CheckAndMutateRow error: %v", err)
+	} else if got, want := res.PredicateMatched, true; got != want {
+		t.Errorf("Invalid PredicateMatched value: got %t, want %t", got, want)
+	}
+}
+
+func TestCheckAndMutateRowWithPredicate(t *testing.T) {
+	ctx, s, ok := newClient(t)
+	if !ok {
+		tblReq := &btapb.CreateTableRequest{Parent: s.parent, TableId: s.name,
+			Table: &btapb.Table{
+				ColumnFamilies: map[string]*btapb.ColumnFamily{
+					"cf": {},
+					"df": {},
+					"ef": {},
+					"ff": {},
+					"zf": {},
+				},
+			},
+		}
+		_, err := s.CreateTable(ctx, tblReq)
+		if err != nil {
+			t.Fatalf("Failed to create the table: %v", err)
+		}
+	}
+
+	entries := []struct {
+		row                         string
+		value                       []byte
+		familyName, columnQualifier string
+	}{
+		{"row1", []byte{0x11}, "cf", "cq"},
+		{"row2", []byte{0x1a}, "df", "dq"},
+		{"row3", []byte{'a'}, "ef", "eq"},
+		{"row4", []byte{'b'}, "ff", "fq"},
+	}
+
+	for _, entry := range entries {
+		req := &btpb.MutateRowRequest{
+			TableName: s.tblName,
+			RowKey:    []byte(entry.row),
+			Mutations: []*btpb.Mutation{{
+				Mutation: &btpb.Mutation_SetCell_{SetCell: &btpb.Mutation_SetCell{
+					FamilyName:      entry.familyName,
+					ColumnQualifier: []byte(entry.columnQualifier),
+					TimestampMicros: 1000,
+					Value:           entry.value,
+				}},
+			}},
+		}
+		if _, err := s.MutateRow(ctx, req); err != nil {
+			t.Fatalf("Failed to insert entry %v into server: %v", entry, err)
+		}
+	}
+
+	var bogusMutations = []*btpb.Mutation{{
+		Mutation: &btpb.Mutation_DeleteFromFamily_{
+			DeleteFromFamily: &btpb.Mutation_DeleteFromFamily{
+				FamilyName: "bogus_family",
+			},
+		},
+	}}
+
+	// keyAndValue chains a row key regex with a value regex, so both must match some cell of the target row.
+	keyAndValue := func(key, value string) *btpb.RowFilter {
+		return &btpb.RowFilter{Filter: &btpb.RowFilter_Chain_{Chain: &btpb.RowFilter_Chain{Filters: []*btpb.RowFilter{
+			{Filter: &btpb.RowFilter_RowKeyRegexFilter{RowKeyRegexFilter: []byte(key)}},
+			{Filter: &btpb.RowFilter_ValueRegexFilter{ValueRegexFilter: []byte(value)}},
+		}}}}
+	}
+
+	tests := []struct {
+		req       *btpb.CheckAndMutateRowRequest
+		wantMatch bool
+		name      string
+
+		// if wantState is nil, that means we don't care to check
+		// what the state of the world is.
+		wantState []*btpb.ReadRowsResponse_CellChunk
+	}{
+		{
+			req: &btpb.CheckAndMutateRowRequest{
+				TableName: s.tblName,
+				RowKey:    []byte("row1"),
+				PredicateFilter: &btpb.RowFilter{
 					Filter: &btpb.RowFilter_RowKeyRegexFilter{
 						RowKeyRegexFilter: []byte("not-one"),
 					},
 				},
-				TrueMutations: bogusMutations,
+				TrueMutations: bogusMutations,
+			},
+			name: "no match",
+		},
+		{
+			req: &btpb.CheckAndMutateRowRequest{
+				TableName: s.tblName,
+				RowKey:    []byte("row1"),
+				PredicateFilter: &btpb.RowFilter{
+					Filter: &btpb.RowFilter_RowKeyRegexFilter{
+						RowKeyRegexFilter: []byte("ro.+"),
+					},
+				},
+				FalseMutations: bogusMutations,
+			},
+			wantMatch: true,
+			name:      "rowkey regex",
+		},
+		{
+			req: &btpb.CheckAndMutateRowRequest{
+				TableName: s.tblName,
+				RowKey:    []byte("row1"),
+				PredicateFilter: &btpb.RowFilter{
+					Filter: &btpb.RowFilter_PassAllFilter{
+						PassAllFilter: true,
+					},
+				},
+				FalseMutations: bogusMutations,
+			},
+			wantMatch: true,
+			name:      "pass all",
+		},
+		{
+			req: &btpb.CheckAndMutateRowRequest{
+				TableName:       s.tblName,
+				RowKey:          []byte("row1"),
+				PredicateFilter: keyAndValue("row[12]", `\x11`),
+				FalseMutations:  bogusMutations,
+			},
+			wantMatch: true,
+			name:      "rowkey and value regex",
+		},
+		{
+			req: &btpb.CheckAndMutateRowRequest{
+				TableName:       s.tblName,
+				RowKey:          []byte("row2"),
+				PredicateFilter: keyAndValue("row[12]", `\x11`),
+				TrueMutations:   bogusMutations,
+			},
+			name: "rowkey regex matches, value regex matches another row",
+		},
+		{
+			req: &btpb.CheckAndMutateRowRequest{
+				TableName:       s.tblName,
+				RowKey:          []byte("row1"),
+				PredicateFilter: keyAndValue("row2", `\x11`),
+				TrueMutations:   bogusMutations,
+			},
+			name: "value regex matches, rowkey regex matches another row",
+		},
+		{
+			req: &btpb.CheckAndMutateRowRequest{
+				TableName: s.tblName,
+				RowKey:    []byte("row1"),
+				PredicateFilter: &btpb.RowFilter{
+					Filter: &btpb.RowFilter_BlockAllFilter{
+						BlockAllFilter: true,
+					},
+				},
+				FalseMutations: []*btpb.Mutation{
+					{
+						Mutation: &btpb.Mutation_SetCell_{
+							SetCell: &btpb.Mutation_SetCell{
+								FamilyName:      "zf",
+								Value:           []byte("foo"),
+								TimestampMicros: 2000,
+								ColumnQualifier: []byte("et"),
+							},
+						},
+					},
+				},
+			},
+			name:      "BlockAll for row1",
+			wantMatch: false,
+			wantState: []*btpb.ReadRowsResponse_CellChunk{
+				{
+					RowKey: []byte("row1"),
+					FamilyName: &wrappers.StringValue{
+						Value: "cf",
+					},
+					Qualifier: &wrappers.BytesValue{
+						Value: []byte("cq"),
+					},
+					TimestampMicros: 1000,
+					Value:           []byte{0x11},
+				},
+				{
+					RowKey: []byte("row1"),
+					FamilyName: &wrappers.StringValue{
+						Value: "zf",
+					},
+					Qualifier: &wrappers.BytesValue{
+						Value: []byte("et"),
+					},
+					TimestampMicros: 2000,
+					Value:           []byte("foo"),
+					RowStatus: &btpb.ReadRowsResponse_CellChunk_CommitRow{
+						CommitRow: true,
+					},
+				},
+				{
+					RowKey: []byte("row2"),
+					FamilyName: &wrappers.StringValue{
+						Value: "df",
+					},
+					Qualifier: &wrappers.BytesValue{
+						Value: []byte("dq"),
+					},
+					TimestampMicros: 1000,
+					Value:           []byte{0x1a},
+					RowStatus: &btpb.ReadRowsResponse_CellChunk_CommitRow{
+						CommitRow: true,
+					},
+				},
+				{
+					RowKey: []byte("row3"),
+					FamilyName: &wrappers.StringValue{
+						Value: "ef",
+					},
+					Qualifier: &wrappers.BytesValue{
+						Value: []byte("eq"),
+					},
+					TimestampMicros: 1000,
+					Value:           []byte("a"),
+					RowStatus: &btpb.ReadRowsResponse_CellChunk_CommitRow{
+						CommitRow: true,
+					},
+				},
+				{
+					RowKey: []byte("row4"),
+					FamilyName: &wrappers.StringValue{
+						Value: "ff",
+					},
+					Qualifier: &wrappers.BytesValue{
+						Value: []byte("fq"),
+					},
+					TimestampMicros: 1000,
+					Value:           []byte("b"),
+					RowStatus: &btpb.ReadRowsResponse_CellChunk_CommitRow{
+						CommitRow: true,
+					},
+				},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res, err := s.CheckAndMutateRow(ctx, tt.req)
+			if err != nil {
+				t.Fatalf("CheckAndMutateRow error: %v", err)
+			}
+			got, want := res.PredicateMatched, tt.wantMatch
+			if got != want {
+				t.Fatalf("Invalid PredicateMatched value: got %t, want %t\nRequest: %+v", got, want, tt.req)
+			}
+
+			if tt.wantState == nil {
+				return
+			}
+
+			rreq := &btpb.ReadRowsRequest{TableName: s.tblName}
+			responses, err := readRows(ctx, s, rreq)
+			if err != nil {
+				t.Fatalf("ReadRows error: %v", err)
+			}
+
+			// Collect all the cellChunks
+			var gotCellChunks []*btpb.ReadRowsResponse_CellChunk
+			for _, res := range responses {
+				gotCellChunks = append(gotCellChunks, res.Chunks...)
+			}
+			sort.Slice(gotCellChunks, func(i, j int) bool {
+				ci, cj := gotCellChunks[i], gotCellChunks[j]
+				return compareCellChunks(ci, cj)
+			})
+			wantCellChunks := tt.wantState[0:]
+			sort.Slice(wantCellChunks, func(i, j int) bool {
+				return compareCellChunks(wantCellChunks[i], wantCellChunks[j])
+			})
+
+			// bttest for some reason undeterministically returns:
+			//      RowStatus: &bigtable.ReadRowsResponse_CellChunk_CommitRow{CommitRow: true},
+			// so we'll ignore that field during comparison.
+			scrubRowStatus := func(cs []*btpb.ReadRowsResponse_CellChunk) []*btpb.ReadRowsResponse_CellChunk {
+				for _, c := range cs {
+					c.RowStatus = nil
+				}
+				return cs
+			}
+			diff := cmp.Diff(scrubRowStatus(gotCellChunks), scrubRowStatus(wantCellChunks), cmp.Comparer(proto.Equal))
+			if diff != "" {
+				t.Fatalf("unexpected response: %s", diff)
+			}
+		})
+	}
+}
+
+// compareCellChunks is a comparator that is passed
+// into sort.Slice to stably sort cell chunks.
+func compareCellChunks(ci, cj *btpb.ReadRowsResponse_CellChunk) bool {
+	if bytes.Compare(ci.RowKey, cj.RowKey) > 0 {
+		return false
+	}
+	if bytes.Compare(ci.Value, cj.Value) > 0 {
+		return false
+	}
+	return ci.FamilyName.GetValue() < cj.FamilyName.GetValue()
+}
+
+func TestServer_ReadModifyWriteRow(t *testing.T) {
+	ctx, s, ok := newClient(t)
+	if !ok {
+		newTbl := btapb.Table{
+			ColumnFamilies: map[string]*btapb.ColumnFamily{
+				"cf": {GcRule: &btapb.GcRule{Rule: &btapb.GcRule_MaxNumVersions{MaxNumVersions: 1}}},
+			},
+		}
+		_, err := s.CreateTable(ctx, &btapb.CreateTableRequest{Parent: s.parent, TableId: s.name, Table: &newTbl})
+		if err != nil {
+			t.Fatalf("Creating table: %v", err)
+		}
+	}
+
+	req := &btpb.ReadModifyWriteRowRequest{
+		TableName: s.tblName,
+		RowKey:    []byte("row-key"),
+		Rules: []*btpb.ReadModifyWriteRule{
+			{
+				FamilyName:      "cf",
+				ColumnQualifier: []byte("q1"),
+				Rule: &btpb.ReadModifyWriteRule_AppendValue{
+					AppendValue: []byte("a"),
+				},
+			},
+			// multiple ops for same cell
+			{
+				FamilyName:      "cf",
+				ColumnQualifier: []byte("q1"),
+				Rule: &btpb.ReadModifyWriteRule_AppendValue{
+					AppendValue: []byte("b"),
+				},
+			},
+			// different cell whose qualifier should sort before the prior rules
+			{
+				FamilyName:      "cf",
+				ColumnQualifier: []byte("q0"),
+				Rule: &btpb.ReadModifyWriteRule_IncrementAmount{
+					IncrementAmount: 1,
+				},
+			},
+		},
+	}
+
+	got, err := s.ReadModifyWriteRow(ctx, req)
+	if err != nil {
+		t.Fatalf("ReadModifyWriteRow error: %v", err)
+	}
+
+	want := &btpb.ReadModifyWriteRowResponse{
+		Row: &btpb.Row{
+			Key: []byte("row-key"),
+			Families: []*btpb.Family{{
+				Name: "cf",
+				Columns: []*btpb.Column{
+					{
+						Qualifier: []byte("q0"),
+						Cells: []*btpb.Cell{{
+							Value: []byte{0, 0, 0, 0, 0, 0, 0, 1},
+						}},
+					},
+					{
+						Qualifier: []byte("q1"),
+						Cells: []*btpb.Cell{{
+							Value: []byte("ab"),
+						}},
+					},
+				},
+			}},
+		},
+	}
+
+	scrubTimestamp := func(resp *btpb.ReadModifyWriteRowResponse) *btpb.ReadModifyWriteRowResponse {
+		for _, fam := range resp.GetRow().GetFamilies() {
+			for _, col := range fam.GetColumns() {
+				for _, cell := range col.GetCells() {
+					cell.TimestampMicros = 0
+				}
+			}
+		}
+		return resp
+	}
+	diff := cmp.Diff(scrubTimestamp(got), scrubTimestamp(want), cmp.Comparer(proto.Equal))
+	if diff != "" {
+		t.Errorf("unexpected response: %s", diff)
+	}
+}
+
+// helper function to populate table data
+func populateTable(ctx context.Context, s *clientIntf, exists bool) error {
+	if !exists {
+		newTbl := btapb.Table{
+			ColumnFamilies: map[string]*btapb.ColumnFamily{
+				"cf0": {GcRule: &btapb.GcRule{Rule: &btapb.GcRule_MaxNumVersions{MaxNumVersions: 1}}},
+			},
+		}
+		_, err := s.CreateTable(ctx, &btapb.CreateTableRequest{Parent: s.parent, TableId: s.name, Table: &newTbl})
+		if err != nil {
+			return err
+		}
+	}
+
+	count := 3
+	mcf := func(i int) *btapb.ModifyColumnFamiliesRequest {
+		return &btapb.ModifyColumnFamiliesRequest{
+			Name: s.tblName,
+			Modifications: []*btapb.ModifyColumnFamiliesRequest_Modification{{
+				Id:  "cf" + strconv.Itoa(i),
+				Mod: &btapb.ModifyColumnFamiliesRequest_Modification_Create{Create: &btapb.ColumnFamily{}},
+			}},
+		}
+	}
+	for i := 1; i <= count; i++ {
+		_, err := s.ModifyColumnFamilies(ctx, mcf(i))
+		if err != nil {
+			if s, ok := status.FromError(err); !ok || s.Code() != codes.AlreadyExists {
+				return err
+			}
+		}
+	}
+	// Populate the table
+	for fc := 0; fc < count; fc++ {
+		for cc := count; cc > 0; cc-- {
+			for tc := 0; tc < count; tc++ {
+				req := &btpb.MutateRowRequest{
+					TableName: s.tblName,
+					RowKey:    []byte("row"),
+					Mutations: []*btpb.Mutation{{
+						Mutation: &btpb.Mutation_SetCell_{SetCell: &btpb.Mutation_SetCell{
+							FamilyName:      "cf" + strconv.Itoa(fc),
+							ColumnQualifier: []byte("col" + strconv.Itoa(cc)),
+							TimestampMicros: int64((tc + 1) * 1000),
+							Value:           []byte{},
+						}},
+					}},
+				}
+				if _, err := s.MutateRow(ctx, req); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+func TestFilters(t *testing.T) {
+	tests := []struct {
+		in   *btpb.RowFilter
+		code codes.Code
+		out  int
+	}{
+		{in: &btpb.RowFilter{Filter: &btpb.RowFilter_BlockAllFilter{BlockAllFilter: true}}, out: 0},
+		{in: &btpb.RowFilter{Filter: &btpb.RowFilter_BlockAllFilter{BlockAllFilter: false}}, code: codes.InvalidArgument},
+		{in: &btpb.RowFilter{Filter: &btpb.RowFilter_PassAllFilter{PassAllFilter: true}}, out: 1},
+		{in: &btpb.RowFilter{Filter: &btpb.RowFilter_PassAllFilter{PassAllFilter: false}}, code: codes.InvalidArgument},
+	}
+
+	ctx, s, ok := newClient(t)
+	if err := populateTable(ctx, s, ok); err != nil {
+		t.Fatal(err)
+	}
+
+	req := &btpb.ReadRowsRequest{
+		TableName: s.tblName,
+		Rows:      &btpb.RowSet{RowKeys: [][]byte{[]byte("row")}},
+	}
+
+	for _, tc := range tests {
+		req.Filter = tc.in
+
+		responses, err := readRows(ctx, s, req)
+		if tc.code != codes.OK {
+			s, _ := status.FromError(err)
+			if s.Code() != tc.code {
+				t.Errorf("error code: got %d, want %d", s.Code(), tc.code)
+			}
+		} else if err != nil {
+			t.Errorf("ReadRows error: %v", err)
+		} else if len(responses) != tc.out {
+			t.Errorf("Response count: got %d, want %d", len(responses), tc.out)
+		}
+	}
+}
+
+func Test_Mutation_DeleteFromFamily(t *testing.T) {
+	ctx, s, ok := newClient(t)
+	if err := populateTable(ctx, s, ok); err != nil {
+		t.Fatal(err)
+	}
+
+	// cellsByFamily counts the cells of "row" in each family.
+	cellsByFamily := func() map[string]int {
+		res, err := readRows(ctx, s, &btpb.ReadRowsRequest{
+			TableName: s.tblName,
+			Rows:      &btpb.RowSet{RowKeys: [][]byte{[]byte("row")}},
+		})
+		if err != nil {
+			t.Fatalf("ReadRows: %v", err)
+		}
+		got := map[string]int{}
+		for _, r := range res {
+			for _, cc := range r.Chunks {
+				got[cc.FamilyName.GetValue()]++
+			}
+		}
+		return got
+	}
+	before := cellsByFamily()
+	if len(before) != 3 {
+		t.Fatalf("Populated families: got %v, want cf0, cf1 and cf2", before)
+	}
+
+	if _, err := s.MutateRow(ctx, &btpb.MutateRowRequest{
+		TableName: s.tblName,
+		RowKey:    []byte("row"),
+		Mutations: []*btpb.Mutation{{
+			Mutation: &btpb.Mutation_DeleteFromFamily_{DeleteFromFamily: &btpb.Mutation_DeleteFromFamily{
+				FamilyName: "cf1",
+			}},
+		}},
+	}); err != nil {
+		t.Fatalf("DeleteFromFamily: %v", err)
+	}
+
+	want := map[string]int{"cf0": before["cf0"], "cf2": before["cf2"]}
+	if diff := cmp.Diff(want, cellsByFamily()); diff != "" {
+		t.Errorf("cells by family mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func Test_Mutation_DeleteFromColumn(t *testing.T) {
+	ctx, s, ok := newClient(t)
+	if err := populateTable(ctx, s, ok); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		in   *btpb.MutateRowRequest
+		fail bool
+	}{
+		{
+			in: &btpb.MutateRowRequest{
+				TableName: s.tblName,
+				RowKey:    []byte("row"),
+				Mutations: []*btpb.Mutation{{
+					Mutation: &btpb.Mutation_DeleteFromColumn_{DeleteFromColumn: &btpb.Mutation_DeleteFromColumn{
+						FamilyName:      "cf1",
+						ColumnQualifier: []byte("col1"),
+						TimeRange: &btpb.TimestampRange{
+							StartTimestampMicros: 2000,
+							EndTimestampMicros:   1000,
+						},
+					}},
+				}},
+			},
+			fail: true,
+		},
+		{
+			in: &btpb.MutateRowRequest{
+				TableName: s.tblName,
+				RowKey:    []byte("row"),
+				Mutations: []*btpb.Mutation{{
+					Mutation: &btpb.Mutation_DeleteFromColumn_{DeleteFromColumn: &btpb.Mutation_DeleteFromColumn{
+						FamilyName:      "cf2",
+						ColumnQualifier: []byte("col2"),
+						TimeRange: &btpb.TimestampRange{
+							StartTimestampMicros: 1000,
+							EndTimestampMicros:   2000,
+						},
+					}},
+				}},
+			},
+			fail: false,
+		},
+		{
+			in: &btpb.MutateRowRequest{
+				TableName: s.tblName,
+				RowKey:    []byte("row"),
+				Mutations: []*btpb.Mutation{{
+					Mutation: &btpb.Mutation_DeleteFromColumn_{DeleteFromColumn: &btpb.Mutation_DeleteFromColumn{
+						FamilyName:      "cf3",
+						ColumnQualifier: []byte("col3"),
+						TimeRange: &btpb.TimestampRange{
+							StartTimestampMicros: 1000,
+							EndTimestampMicros:   0,
+						},
+					}},
+				}},
+			},
+			fail: false,
+		},
+		{
+			in: &btpb.MutateRowRequest{
+				TableName: s.tblName,
+				RowKey:    []byte("row"),
+				Mutations: []*btpb.Mutation{{
+					Mutation: &btpb.Mutation_DeleteFromColumn_{DeleteFromColumn: &btpb.Mutation_DeleteFromColumn{
+						FamilyName:      "cf4",
+						ColumnQualifier: []byte("col4"),
+						TimeRange: &btpb.TimestampRange{
+							StartTimestampMicros: 0,
+							EndTimestampMicros:   1000,
+						},
+					}},
+				}},
+			},
+			fail: true,
+		},
+	}
+	for _, test := range tests {
+		_, err := s.MutateRow(ctx, test.in)
+
+		if err != nil && !test.fail {
+			t.Errorf("expected passed got failure for : %v \n with err: %v", test.in, err)
+		}
+
+		if err == nil && test.fail {
+			t.Errorf("expected failure got passed for : %v", test)
+		}
+	}
+}
+
+func Test_Mutation_DeleteFromColumnMicrosecondRange(t *testing.T) {
+	ctx, s, ok := newClient(t)
+	if err := populateTable(ctx, s, ok); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tsr := range []*btpb.TimestampRange{
+		{StartTimestampMicros: 1500, EndTimestampMicros: 3000},
+		{StartTimestampMicros: 1000, EndTimestampMicros: 2001},
+		{StartTimestampMicros: 0, EndTimestampMicros: 999},
+	} {
+		for _, key := range []string{"row", "missing-row"} {
+			_, err := s.MutateRow(ctx, &btpb.MutateRowRequest{
+				TableName: s.tblName,
+				RowKey:    []byte(key),
+				Mutations: []*btpb.Mutation{{
+					Mutation: &btpb.Mutation_DeleteFromColumn_{DeleteFromColumn: &btpb.Mutation_DeleteFromColumn{
+						FamilyName:      "cf1",
+						ColumnQualifier: []byte("col1"),
+						TimeRange:       tsr,
+					}},
+				}},
+			})
+			if status.Code(err) != codes.InvalidArgument {
+				t.Fatalf("%s %v: got %v, want InvalidArgument", key, tsr, err)
+			}
+			if !strings.Contains(err.Error(), "Millisecond precision required") {
+				t.Errorf("%s %v: unexpected error message: %v", key, tsr, err)
+			}
+		}
+	}
+}
+
+func Test_Mutation_DeleteFromColumnAbsentFamily(t *testing.T) {
+	ctx, s, ok := newClient(t)
+	if err := populateTable(ctx, s, ok); err != nil {
+		t.Fatal(err)
+	}
+	readRow := func() []*btpb.ReadRowsResponse {
+		t.Helper()
+		res, err := readRows(ctx, s, &btpb.ReadRowsRequest{TableName: s.tblName})
+		if err != nil {
+			t.Fatalf("ReadRows: %v", err)
+		}
+		return res
+	}
+	deleteFrom := func(family string) error {
+		_, err := s.MutateRow(ctx, &btpb.MutateRowRequest{
+			TableName: s.tblName,
+			RowKey:    []byte("row"),
+			Mutations: []*btpb.Mutation{{
+				Mutation: &btpb.Mutation_DeleteFromColumn_{DeleteFromColumn: &btpb.Mutation_DeleteFromColumn{
+					FamilyName:      family,
+					ColumnQualifier: []byte("col1"),
+				}},
+			}},
+		})
+		return err
+	}
+	before := readRow()
+
+	// cf3 is a table family the row has no cells in; like production, deleting from it is a no-op.
+	if err := deleteFrom("cf3"); err != nil {
+		t.Fatalf("deleting from a family absent on the row: %v", err)
+	}
+	after := readRow()
+	if len(after) != len(before) {
+		t.Fatalf("got %d responses after no-op delete, want %d", len(after), len(before))
+	}
+	for i := range before {
+		if !proto.Equal(before[i], after[i]) {
+			t.Errorf("row changed after no-op delete:\nbefore: %v\nafter:  %v", before[i], after[i])
+		}
+	}
+
+	if err := deleteFrom("nonexistent"); status.Code(err) != codes.NotFound {
+		t.Errorf("deleting from an unknown family: got %v, want NotFound", err)
+	}
+}
+
+func TestFilterRow(t *testing.T) {
+	row := &btpb.Row{
+		Key: []byte("row"),
+		Families: []*btpb.Family{
+			{
+				Name: "fam",
+				Columns: []*btpb.Column{
+					{
+						Qualifier: []byte("col"),
+						Cells:     []*btpb.Cell{{TimestampMicros: 1000, Value: []byte("val")}},
+					},
+				},
 			},
-			name: "no match",
 		},
-		{
-			req: &btpb.CheckAndMutateRowRequest{
-				TableName: s.tblName,
-				RowKey:    []byte("row1"),
-				PredicateFilter: &btpb.RowFilter{
-					Filter: &btpb.RowFilter_RowKeyRegexFilter{
-						RowKeyRegexFilter: []byte("ro.+"),
-					},
+	}
+	for _, test := range []struct {
+		filter *btpb.RowFilter
+		want   bool
+	}{
+		// The regexp-based filters perform whole-string, case-sensitive matches.
+		{&btpb.RowFilter{Filter: &btpb.RowFilter_RowKeyRegexFilter{RowKeyRegexFilter: []byte("row")}}, true},
+		{&btpb.RowFilter{Filter: &btpb.RowFilter_RowKeyRegexFilter{RowKeyRegexFilter: []byte("ro")}}, false},
+		{&btpb.RowFilter{Filter: &btpb.RowFilter_RowKeyRegexFilter{RowKeyRegexFilter: []byte("ROW")}}, false},
+		{&btpb.RowFilter{Filter: &btpb.RowFilter_RowKeyRegexFilter{RowKeyRegexFilter: []byte("moo")}}, false},
+
+		{&btpb.RowFilter{Filter: &btpb.RowFilter_FamilyNameRegexFilter{FamilyNameRegexFilter: "fam"}}, true},
+		{&btpb.RowFilter{Filter: &btpb.RowFilter_FamilyNameRegexFilter{FamilyNameRegexFilter: "f.*"}}, true},
+		{&btpb.RowFilter{Filter: &btpb.RowFilter_FamilyNameRegexFilter{FamilyNameRegexFilter: "[fam]+"}}, true},
+		{&btpb.RowFilter{Filter: &btpb.RowFilter_FamilyNameRegexFilter{FamilyNameRegexFilter: "fa"}}, false},
+		{&btpb.RowFilter{Filter: &btpb.RowFilter_FamilyNameRegexFilter{FamilyNameRegexFilter: "FAM"}}, false},
+		{&btpb.RowFilter{Filter: &btpb.RowFilter_FamilyNameRegexFilter{FamilyNameRegexFilter: "moo"}}, false},
+
+		{&btpb.RowFilter{Filter: &btpb.RowFilter_ColumnQualifierRegexFilter{ColumnQualifierRegexFilter: []byte("col")}}, true},
+		{&btpb.RowFilter{Filter: &btpb.RowFilter_ColumnQualifierRegexFilter{ColumnQualifierRegexFilter: []byte("co")}}, false},
+		{&btpb.RowFilter{Filter: &btpb.RowFilter_ColumnQualifierRegexFilter{ColumnQualifierRegexFilter: []byte("COL")}}, false},
+		{&btpb.RowFilter{Filter: &btpb.RowFilter_ColumnQualifierRegexFilter{ColumnQualifierRegexFilter: []byte("moo")}}, false},
+
+		{&btpb.RowFilter{Filter: &btpb.RowFilter_ValueRegexFilter{ValueRegexFilter: []byte("val")}}, true},
+		{&btpb.RowFilter{Filter: &btpb.RowFilter_ValueRegexFilter{ValueRegexFilter: []byte("va")}}, false},
+		{&btpb.RowFilter{Filter: &btpb.RowFilter_ValueRegexFilter{ValueRegexFilter: []byte("VAL")}}, false},
+		{&btpb.RowFilter{Filter: &btpb.RowFilter_ValueRegexFilter{ValueRegexFilter: []byte("moo")}}, false},
+
+		{&btpb.RowFilter{Filter: &btpb.RowFilter_TimestampRangeFilter{TimestampRangeFilter: &btpb.TimestampRange{StartTimestampMicros: int64(0), EndTimestampMicros: int64(1000)}}}, false},
+		{&btpb.RowFilter{Filter: &btpb.RowFilter_TimestampRangeFilter{TimestampRangeFilter: &btpb.TimestampRange{StartTimestampMicros: int64(1000), EndTimestampMicros: int64(2000)}}}, true},
+	} {
+		got, err := filterRow(test.filter, copyRow(row), nil)
+		if err != nil {
+			t.Errorf("%s: got unexpected error: %v", test.filter, err)
+		}
+		if got != test.want {
+			t.Errorf("%s: got %t, want %t", test.filter, got, test.want)
+		}
+	}
+}
+
+func TestFilterRowCellsPerRowOffset(t *testing.T) {
+	cells := func(tss ...int64) []*btpb.Cell {
+		var ret []*btpb.Cell
+		for _, ts := range tss {
+			ret = append(ret, &btpb.Cell{TimestampMicros: ts, Value: []byte("val")})
+		}
+		return ret
+	}
+	row := &btpb.Row{
+		Key: []byte("row"),
+		Families: []*btpb.Family{
+			{
+				Name: "fam",
+				Columns: []*btpb.Column{
+					{Qualifier: []byte("col1"), Cells: cells(2000, 1000)},
+					{Qualifier: []byte("col2"), Cells: cells(3000, 2000, 1000)},
 				},
-				FalseMutations: bogusMutations,
 			},
-			wantMatch: true,
-			name:      "rowkey regex",
 		},
-		{
-			req: &btpb.CheckAndMutateRowRequest{
-				TableName: s.tblName,
-				RowKey:    []byte("row1"),
-				PredicateFilter: &btpb.RowFilter{
-					Filter: &btpb.RowFilter_PassAllFilter{
-						PassAllFilter: true,
-					},
+	}
+
+	// The offset spans all of col1 and the first cell of col2.
+	f := &btpb.RowFilter{Filter: &btpb.RowFilter_CellsPerRowOffsetFilter{CellsPerRowOffsetFilter: 3}}
+	if match, err := filterRow(f, row, nil); err != nil || !match {
+		t.Fatalf("filterRow: got %v, %v", match, err)
+	}
+	want := []*btpb.Column{
+		{Qualifier: []byte("col1"), Cells: []*btpb.Cell{}},
+		{Qualifier: []byte("col2"), Cells: cells(2000, 1000)},
+	}
+	if diff := cmp.Diff(want, row.Families[0].Columns, cmp.Comparer(proto.Equal)); diff != "" {
+		t.Errorf("columns mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestCellsPerRowLimitMultipleFamilies(t *testing.T) {
+	ctx, s, _ := newClient(t)
+	newTbl := btapb.Table{
+		ColumnFamilies: map[string]*btapb.ColumnFamily{
+			"a": {},
+			"b": {},
+		},
+	}
+	if _, err := s.CreateTable(ctx, &btapb.CreateTableRequest{Parent: s.parent, TableId: s.name, Table: &newTbl}); err != nil {
+		t.Fatalf("Creating table: %v", err)
+	}
+	setCell := func(fam, col string, ts int64) *btpb.Mutation {
+		return &btpb.Mutation{Mutation: &btpb.Mutation_SetCell_{SetCell: &btpb.Mutation_SetCell{
+			FamilyName:      fam,
+			ColumnQualifier: []byte(col),
+			TimestampMicros: ts,
+			Value:           []byte("val"),
+		}}}
+	}
+	// Write family "b" before "a", so insertion order differs from name order.
+	for _, muts := range [][]*btpb.Mutation{
+		{setCell("b", "x", 1000)},
+		{setCell("a", "z", 1000), setCell("a", "y", 1000), setCell("a", "y", 2000)},
+	} {
+		if _, err := s.MutateRow(ctx, &btpb.MutateRowRequest{TableName: s.tblName, RowKey: []byte("row"), Mutations: muts}); err != nil {
+			t.Fatalf("MutateRow: %v", err)
+		}
+	}
+
+	for _, tc := range []struct {
+		limit int32
+		want  []string
+	}{
+		{1, []string{"a:y@2000"}},
+		{3, []string{"a:y@2000", "a:y@1000", "a:z@1000"}},
+		{4, []string{"a:y@2000", "a:y@1000", "a:z@1000", "b:x@1000"}},
+	} {
+		responses, err := readRows(ctx, s, &btpb.ReadRowsRequest{
+			TableName: s.tblName,
+			Filter:    &btpb.RowFilter{Filter: &btpb.RowFilter_CellsPerRowLimitFilter{CellsPerRowLimitFilter: tc.limit}},
+		})
+		if err != nil {
+			t.Fatalf("ReadRows error: %v", err)
+		}
+		var got []string
+		var fam, col string
+		for _, res := range responses {
+			for _, c := range res.Chunks {
+				if c.FamilyName != nil {
+					fam = c.FamilyName.Value
+				}
+				if c.Qualifier != nil {
+					col = string(c.Qualifier.Value)
+				}
+				got = append(got, fmt.Sprintf("%s:%s@%d", fam, col, c.TimestampMicros))
+			}
+		}
+		if diff := cmp.Diff(tc.want, got); diff != "" {
+			t.Errorf("limit %d: cells mismatch (-want +got):\n%s", tc.limit, diff)
+		}
+	}
+}
+
+func TestCellsPerRowUnsortedStoredRow(t *testing.T) {
+	forEachStorage(t, func(t *testing.T, storage Storage) {
+		ctx := context.Background()
+		svr, s := newTestServer(t, func(svr *server) { svr.storage = storage })
+		newTbl := btapb.Table{
+			ColumnFamilies: map[string]*btapb.ColumnFamily{
+				"a": {},
+				"b": {},
+			},
+		}
+		if _, err := s.CreateTable(ctx, &btapb.CreateTableRequest{Parent: s.parent, TableId: s.name, Table: &newTbl}); err != nil {
+			t.Fatalf("Creating table: %v", err)
+		}
+		// Store a row with its families out of name order, as rows persisted before families were sorted on write are.
+		cell := func(ts int64) []*btpb.Column {
+			return []*btpb.Column{{Qualifier: []byte("x"), Cells: []*btpb.Cell{{TimestampMicros: ts, Value: []byte("val")}}}}
+		}
+		svr.tables[s.tblName].rows.ReplaceOrInsert(&btpb.Row{
+			Key:      []byte("row"),
+			Families: []*btpb.Family{{Name: "b", Columns: cell(2000)}, {Name: "a", Columns: cell(1000)}},
+		})
+
+		for name, tc := range map[string]struct {
+			filter *btpb.RowFilter
+			want   string
+		}{
+			"limit":  {&btpb.RowFilter{Filter: &btpb.RowFilter_CellsPerRowLimitFilter{CellsPerRowLimitFilter: 1}}, "a"},
+			"offset": {&btpb.RowFilter{Filter: &btpb.RowFilter_CellsPerRowOffsetFilter{CellsPerRowOffsetFilter: 1}}, "b"},
+		} {
+			responses, err := readRows(ctx, s, &btpb.ReadRowsRequest{TableName: s.tblName, Filter: tc.filter})
+			if err != nil {
+				t.Fatalf("%s: ReadRows error: %v", name, err)
+			}
+			if len(responses) != 1 || len(responses[0].Chunks) != 1 {
+				t.Fatalf("%s: got %v, want a single cell", name, responses)
+			}
+			if got := responses[0].Chunks[0].FamilyName.GetValue(); got != tc.want {
+				t.Errorf("%s: got family %q, want %q", name, got, tc.want)
+			}
+		}
+	})
+}
+
+func TestFilterRowValueRangeVersions(t *testing.T) {
+	cell := func(ts int64, v ...byte) *btpb.Cell {
+		return &btpb.Cell{TimestampMicros: ts, Value: v}
+	}
+	row := &btpb.Row{
+		Key: []byte("row"),
+		Families: []*btpb.Family{
+			{
+				Name: "fam",
+				Columns: []*btpb.Column{
+					{Qualifier: []byte("col"), Cells: []*btpb.Cell{
+						cell(5000, 0x05), cell(4000, 0xff, 0x00), cell(3000, 0x02), cell(2000, 0x00), cell(1000, 0x03),
+					}},
+					{Qualifier: []byte("other"), Cells: []*btpb.Cell{cell(1000, 0x02)}},
 				},
-				FalseMutations: bogusMutations,
 			},
-			wantMatch: true,
-			name:      "pass all",
 		},
-		{
-			req: &btpb.CheckAndMutateRowRequest{
-				TableName: s.tblName,
-				RowKey:    []byte("row1"),
-				PredicateFilter: &btpb.RowFilter{
-					Filter: &btpb.RowFilter_BlockAllFilter{
-						BlockAllFilter: true,
-					},
-				},
-				FalseMutations: []*btpb.Mutation{
+	}
+
+	// Only versions of fam:col with values in [0x02, 0x05) survive, still newest first.
+	f := &btpb.RowFilter{Filter: &btpb.RowFilter_Chain_{Chain: &btpb.RowFilter_Chain{Filters: []*btpb.RowFilter{
+		{Filter: &btpb.RowFilter_FamilyNameRegexFilter{FamilyNameRegexFilter: "fam"}},
+		{Filter: &btpb.RowFilter_ColumnQualifierRegexFilter{ColumnQualifierRegexFilter: []byte("col")}},
+		{Filter: &btpb.RowFilter_ValueRangeFilter{ValueRangeFilter: &btpb.ValueRange{
+			StartValue: &btpb.ValueRange_StartValueClosed{StartValueClosed: []byte{0x02}},
+			EndValue:   &btpb.ValueRange_EndValueOpen{EndValueOpen: []byte{0x05}},
+		}}},
+	}}}}
+	if match, err := filterRow(f, row, nil); err != nil || !match {
+		t.Fatalf("filterRow: got %v, %v", match, err)
+	}
+	want := []*btpb.Column{
+		{Qualifier: []byte("col"), Cells: []*btpb.Cell{cell(3000, 0x02), cell(1000, 0x03)}},
+		{Qualifier: []byte("other")}, // emptied, scrubbed later when the row is sent
+	}
+	if diff := cmp.Diff(want, row.Families[0].Columns, cmp.Comparer(proto.Equal)); diff != "" {
+		t.Errorf("columns mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestFilterRowWithErrors(t *testing.T) {
+	row := &btpb.Row{
+		Key: []byte("row"),
+		Families: []*btpb.Family{
+			{
+				Name: "fam",
+				Columns: []*btpb.Column{
 					{
-						Mutation: &btpb.Mutation_SetCell_{
-							SetCell: &btpb.Mutation_SetCell{
-								FamilyName:      "zf",
-								Value:           []byte("foo"),
-								TimestampMicros: 2000,
-								ColumnQualifier: []byte("et"),
-							},
-						},
+						Qualifier: []byte("col"),
+						Cells:     []*btpb.Cell{{TimestampMicros: 1000, Value: []byte("val")}},
 					},
 				},
 			},
-			name:      "BlockAll for row1",
-			wantMatch: false,
-			wantState: []*btpb.ReadRowsResponse_CellChunk{
-				{
-					RowKey: []byte("row1"),
-					FamilyName: &wrappers.StringValue{
-						Value: "cf",
-					},
-					Qualifier: &wrappers.BytesValue{
-						Value: []byte("cq"),
-					},
-					TimestampMicros: 1000,
-					Value:           []byte{0x11},
-				},
-				{
-					RowKey: []byte("row1"),
-					FamilyName: &wrappers.StringValue{
-						Value: "zf",
-					},
-					Qualifier: &wrappers.BytesValue{
-						Value: []byte("et"),
-					},
-					TimestampMicros: 2000,
-					Value:           []byte("foo"),
-					RowStatus: &btpb.ReadRowsResponse_CellChunk_CommitRow{
-						CommitRow: true,
-					},
-				},
-				{
-					RowKey: []byte("row2"),
-					FamilyName: &wrappers.StringValue{
-						Value: "df",
-					},
-					Qualifier: &wrappers.BytesValue{
-						Value: []byte("dq"),
-					},
-					TimestampMicros: 1000,
-					Value:           []byte{0x1a},
-					RowStatus: &btpb.ReadRowsResponse_CellChunk_CommitRow{
-						CommitRow: true,
-					},
-				},
-				{
-					RowKey: []byte("row3"),
-					FamilyName: &wrappers.StringValue{
-						Value: "ef",
-					},
-					Qualifier: &wrappers.BytesValue{
-						Value: []byte("eq"),
-					},
-					TimestampMicros: 1000,
-					Value:           []byte("a"),
-					RowStatus: &btpb.ReadRowsResponse_CellChunk_CommitRow{
-						CommitRow: true,
-					},
-				},
-				{
-					RowKey: []byte("row4"),
-					FamilyName: &wrappers.StringValue{
-						Value: "ff",
-					},
-					Qualifier: &wrappers.BytesValue{
-						Value: []byte("fq"),
-					},
-					TimestampMicros: 1000,
-					Value:           []byte("b"),
-					RowStatus: &btpb.ReadRowsResponse_CellChunk_CommitRow{
-						CommitRow: true,
+		},
+	}
+	for _, test := range []struct {
+		badRegex *btpb.RowFilter
+	}{
+		{badRegex: &btpb.RowFilter{Filter: &btpb.RowFilter_RowKeyRegexFilter{RowKeyRegexFilter: []byte("[")}}},
+		{badRegex: &btpb.RowFilter{Filter: &btpb.RowFilter_FamilyNameRegexFilter{FamilyNameRegexFilter: "["}}},
+		{badRegex: &btpb.RowFilter{Filter: &btpb.RowFilter_ColumnQualifierRegexFilter{ColumnQualifierRegexFilter: []byte("[")}}},
+		{badRegex: &btpb.RowFilter{Filter: &btpb.RowFilter_ValueRegexFilter{ValueRegexFilter: []byte("[")}}},
+		{badRegex: &btpb.RowFilter{Filter: &btpb.RowFilter_Chain_{
+			Chain: &btpb.RowFilter_Chain{Filters: []*btpb.RowFilter{
+				{Filter: &btpb.RowFilter_ValueRegexFilter{ValueRegexFilter: []byte("[")}}},
+			},
+		}}},
+		{badRegex: &btpb.RowFilter{Filter: &btpb.RowFilter_Condition_{
+			Condition: &btpb.RowFilter_Condition{
+				PredicateFilter: &btpb.RowFilter{Filter: &btpb.RowFilter_ValueRegexFilter{ValueRegexFilter: []byte("[")}},
+			},
+		}}},
+
+		{badRegex: &btpb.RowFilter{Filter: &btpb.RowFilter_RowSampleFilter{RowSampleFilter: 0}}},                                                                                               // 0.0 is invalid.
+		{badRegex: &btpb.RowFilter{Filter: &btpb.RowFilter_RowSampleFilter{RowSampleFilter: 1}}},                                                                                               // 1.0 is invalid.
+		{badRegex: &btpb.RowFilter{Filter: &btpb.RowFilter_TimestampRangeFilter{TimestampRangeFilter: &btpb.TimestampRange{StartTimestampMicros: int64(1), EndTimestampMicros: int64(1000)}}}}, // Server only supports millisecond precision.
+		{badRegex: &btpb.RowFilter{Filter: &btpb.RowFilter_TimestampRangeFilter{TimestampRangeFilter: &btpb.TimestampRange{StartTimestampMicros: int64(1000), EndTimestampMicros: int64(1)}}}}, // Server only supports millisecond precision.
+	} {
+		got, err := filterRow(test.badRegex, copyRow(row), nil)
+		if got != false {
+			t.Errorf("%s: got true, want false", test.badRegex)
+		}
+		if err == nil {
+			t.Errorf("%s: got no error, want error", test.badRegex)
+		}
+	}
+}
+
+func TestFilterRowColumnRange(t *testing.T) {
+	row := &btpb.Row{
+		Key: []byte("row"),
+		Families: []*btpb.Family{
+			{
+				Name: "fam",
+				Columns: []*btpb.Column{
+					{
+						Qualifier: []byte("col"),
+						Cells:     []*btpb.Cell{{TimestampMicros: 1000, Value: []byte("val")}},
 					},
 				},
 			},
 		},
 	}
 
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			res, err := s.CheckAndMutateRow(ctx, tt.req)
-			if err != nil {
-				t.Fatalf("CheckAndMutateRow error: %v", err)
-			}
-			got, want := res.PredicateMatched, tt.wantMatch
-			if got != want {
-				t.Fatalf("Invalid PredicateMatched value: got %t, want %t\nRequest: %+v", got, want, tt.req)
-			}
-
-			if tt.wantState == nil {
-				return
-			}
-
-			rreq := &btpb.ReadRowsRequest{TableName: s.tblName}
-			responses, err := readRows(ctx, s, rreq)
-			if err != nil {
-				t.Fatalf("ReadRows error: %v", err)
-			}
-
-			// Collect all the cellChunks
-			var gotCellChunks []*btpb.ReadRowsResponse_CellChunk
-			for _, res := range responses {
-				gotCellChunks = append(gotCellChunks, res.Chunks...)
-			}
-			sort.Slice(gotCellChunks, func(i, j int) bool {
-				ci, cj := gotCellChunks[i], gotCellChunks[j]
-				return compareCellChunks(ci, cj)
-			})
-			wantCellChunks := tt.wantState[0:]
-			sort.Slice(wantCellChunks, func(i, j int) bool {
-				return compareCellChunks(wantCellChunks[i], wantCellChunks[j])
-			})
+	// The family name is required.
+	noFamily := &btpb.RowFilter{Filter: &btpb.RowFilter_ColumnRangeFilter{ColumnRangeFilter: &btpb.ColumnRange{
+		StartQualifier: &btpb.ColumnRange_StartQualifierClosed{StartQualifierClosed: []byte("a")},
+	}}}
+	if _, err := filterRow(noFamily, copyRow(row), nil); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Empty family name: got %v, want InvalidArgument", err)
+	}
 
-			// bttest for some reason undeterministically returns:
-			//      RowStatus: &bigtable.ReadRowsResponse_CellChunk_CommitRow{CommitRow: true},
-			// so we'll ignore that field during comparison.
-			scrubRowStatus := func(cs []*btpb.ReadRowsResponse_CellChunk) []*btpb.ReadRowsResponse_CellChunk {
-				for _, c := range cs {
-					c.RowStatus = nil
-				}
-				return cs
-			}
-			diff := cmp.Diff(scrubRowStatus(gotCellChunks), scrubRowStatus(wantCellChunks), cmp.Comparer(proto.Equal))
-			if diff != "" {
-				t.Fatalf("unexpected response: %s", diff)
-			}
-		})
+	// An inverted range is allowed, and matches nothing.
+	inverted := &btpb.RowFilter{Filter: &btpb.RowFilter_ColumnRangeFilter{ColumnRangeFilter: &btpb.ColumnRange{
+		FamilyName:     "fam",
+		StartQualifier: &btpb.ColumnRange_StartQualifierClosed{StartQualifierClosed: []byte("z")},
+		EndQualifier:   &btpb.ColumnRange_EndQualifierClosed{EndQualifierClosed: []byte("a")},
+	}}}
+	if got, err := filterRow(inverted, copyRow(row), nil); err != nil || got {
+		t.Errorf("Inverted range: got (%v, %v), want (false, nil)", got, err)
 	}
 }
 
-// compareCellChunks is a comparator that is passed
-// into sort.Slice to stably sort cell chunks.
-func compareCellChunks(ci, cj *btpb.ReadRowsResponse_CellChunk) bool {
-	if bytes.Compare(ci.RowKey, cj.RowKey) > 0 {
-		return false
+func TestFilterRowInvertedTimestampRange(t *testing.T) {
+	row := &btpb.Row{
+		Key: []byte("row"),
+		Families: []*btpb.Family{
+			{
+				Name: "fam",
+				Columns: []*btpb.Column{
+					{
+						Qualifier: []byte("col"),
+						Cells:     []*btpb.Cell{{TimestampMicros: 1000, Value: []byte("val")}},
+					},
+				},
+			},
+		},
 	}
-	if bytes.Compare(ci.Value, cj.Value) > 0 {
-		return false
+	for _, test := range []struct {
+		start, end int64
+		code       codes.Code
+	}{
+		{2000, 1000, codes.InvalidArgument},
+		{1000, 2000, codes.OK},
+		{2000, 0, codes.OK}, // no upper bound
+		{1000, 1000, codes.OK},
+	} {
+		filter := &btpb.RowFilter{Filter: &btpb.RowFilter_TimestampRangeFilter{TimestampRangeFilter: &btpb.TimestampRange{
+			StartTimestampMicros: test.start,
+			EndTimestampMicros:   test.end,
+		}}}
+		if _, err := filterRow(filter, copyRow(row), nil); status.Code(err) != test.code {
+			t.Errorf("[%d, %d): got %v, want %v", test.start, test.end, err, test.code)
+		}
 	}
-	return ci.FamilyName.GetValue() < cj.FamilyName.GetValue()
 }
 
-func TestServer_ReadModifyWriteRow(t *testing.T) {
-	ctx, s, ok := newClient(t)
-	if !ok {
-		newTbl := btapb.Table{
-			ColumnFamilies: map[string]*btapb.ColumnFamily{
-				"cf": {GcRule: &btapb.GcRule{Rule: &btapb.GcRule_MaxNumVersions{MaxNumVersions: 1}}},
-			},
-		}
-		_, err := s.CreateTable(ctx, &btapb.CreateTableRequest{Parent: s.parent, TableId: s.name, Table: &newTbl})
+func TestFilterRowWithRowSampleFilter(t *testing.T) {
+	prev := randFloat
+	randFloat = func() float64 { return 0.5 }
+	defer func() { randFloat = prev }()
+	for _, test := range []struct {
+		p    float64
+		want bool
+	}{
+		{0.1, false}, // Less than random float. Return no rows.
+		{0.5, false}, // Equal to random float. Return no rows.
+		{0.9, true},  // Greater than random float. Return all rows.
+	} {
+		got, err := filterRow(&btpb.RowFilter{Filter: &btpb.RowFilter_RowSampleFilter{RowSampleFilter: test.p}}, &btpb.Row{}, nil)
 		if err != nil {
-			t.Fatalf("Creating table: %v", err)
+			t.Fatalf("%f: %v", test.p, err)
+		}
+		if got != test.want {
+			t.Errorf("%v: got %t, want %t", test.p, got, test.want)
 		}
 	}
+}
 
-	req := &btpb.ReadModifyWriteRowRequest{
-		TableName: s.tblName,
-		RowKey:    []byte("row-key"),
-		Rules: []*btpb.ReadModifyWriteRule{
+func TestFilterRowWithBinaryColumnQualifier(t *testing.T) {
+	rs := []byte{128, 128}
+	row := &btpb.Row{
+		Key: rs,
+		Families: []*btpb.Family{
 			{
-				FamilyName:      "cf",
-				ColumnQualifier: []byte("q1"),
-				Rule: &btpb.ReadModifyWriteRule_AppendValue{
-					AppendValue: []byte("a"),
+				Name: "fam",
+				Columns: []*btpb.Column{
+					{
+						Qualifier: rs,
+						Cells:     []*btpb.Cell{{TimestampMicros: 1000, Value: []byte("val")}},
+					},
 				},
 			},
-			// multiple ops for same cell
+		},
+	}
+	for _, test := range []struct {
+		filter string
+		want   bool
+	}{
+		{`\x80\x80`, true},      // succeeds, exact match
+		{`\x80\x81`, false},     // fails
+		{`\x80`, false},         // fails, because the regexp must match the entire input
+		{`\x80*`, true},         // succeeds: 0 or more 128s
+		{`[\x7f\x80]{2}`, true}, // succeeds: exactly two of either 127 or 128
+		{`\C{2}`, true},         // succeeds: two bytes
+	} {
+		got, _ := filterRow(&btpb.RowFilter{Filter: &btpb.RowFilter_ColumnQualifierRegexFilter{ColumnQualifierRegexFilter: []byte(test.filter)}}, copyRow(row), nil)
+		if got != test.want {
+			t.Errorf("%v: got %t, want %t", test.filter, got, test.want)
+		}
+	}
+}
+
+func TestFilterRowWithUnicodeColumnQualifier(t *testing.T) {
+	rs := []byte("a§b")
+	row := &btpb.Row{
+		Key: rs,
+		Families: []*btpb.Family{
 			{
-				FamilyName:      "cf",
-				ColumnQualifier: []byte("q1"),
-				Rule: &btpb.ReadModifyWriteRule_AppendValue{
-					AppendValue: []byte("b"),
+				Name: "fam",
+				Columns: []*btpb.Column{
+					{
+						Qualifier: rs,
+						Cells:     []*btpb.Cell{{TimestampMicros: 1000, Value: []byte("val")}},
+					},
 				},
 			},
-			// different cell whose qualifier should sort before the prior rules
-			{
-				FamilyName:      "cf",
-				ColumnQualifier: []byte("q0"),
-				Rule: &btpb.ReadModifyWriteRule_IncrementAmount{
-					IncrementAmount: 1,
+		},
+	}
+	for _, test := range []struct {
+		filter string
+		want   bool
+	}{
+		{`a§b`, true},        // succeeds, exact match
+		{`a\xC2\xA7b`, true}, // succeeds, exact match
+		{`a\xC2.+`, true},    // succeeds, prefix match
+		{`a\xC2\C{2}`, true}, // succeeds, prefix match
+		{`a\xC.+`, false},    // fails, prefix match, bad escape
+		{`a§.+`, true},       // succeeds, prefix match
+		{`.+§b`, true},       // succeeds, suffix match
+		{`.§b`, true},        // succeeds
+		{`a§c`, false},       // fails
+		{`§b`, false},        // fails, because the regexp must match the entire input
+		{`.*§.*`, true},      // succeeds: anything with a §
+		{`.+§.+`, true},      // succeeds: anything with a § in the middle
+		{`a\C{2}b`, true},    // succeeds: § is two bytes
+		{`\C{4}`, true},      // succeeds: four bytes
+	} {
+		got, _ := filterRow(&btpb.RowFilter{Filter: &btpb.RowFilter_ColumnQualifierRegexFilter{ColumnQualifierRegexFilter: []byte(test.filter)}}, copyRow(row), nil)
+		if got != test.want {
+			t.Errorf("%v: got %t, want %t", test.filter, got, test.want)
+		}
+	}
+}
+
+// Test that a single column qualifier with the interleave filter returns
+// the correct result and not return every single row.
+// See Issue https://github.com/googleapis/google-cloud-go/issues/1399
+func TestFilterRowWithSingleColumnQualifier(t *testing.T) {
+	ctx, s, ok := newClient(t)
+	if !ok {
+		tblReq := &btapb.CreateTableRequest{Parent: s.parent, TableId: s.name,
+			Table: &btapb.Table{
+				ColumnFamilies: map[string]*btapb.ColumnFamily{
+					"cf": {},
 				},
 			},
-		},
+		}
+		_, err := s.CreateTable(ctx, tblReq)
+		if err != nil {
+			t.Fatalf("Failed to create the table: %v", err)
+		}
 	}
 
-	got, err := s.ReadModifyWriteRow(ctx, req)
-	if err != nil {
-		t.Fatalf("ReadModifyWriteRow error: %v", err)
+	entries := []struct {
+		row   string
+		value []byte
+	}{
+		{"row1", []byte{0x11}},
+		{"row2", []byte{0x1a}},
+		{"row3", []byte{'a'}},
+		{"row4", []byte{'b'}},
+	}
+
+	for _, entry := range entries {
+		req := &btpb.MutateRowRequest{
+			TableName: s.tblName,
+			RowKey:    []byte(entry.row),
+			Mutations: []*btpb.Mutation{{
+				Mutation: &btpb.Mutation_SetCell_{SetCell: &btpb.Mutation_SetCell{
+					FamilyName:      "cf",
+					ColumnQualifier: []byte("cq"),
+					TimestampMicros: 1000,
+					Value:           entry.value,
+				}},
+			}},
+		}
+		if _, err := s.MutateRow(ctx, req); err != nil {
+			t.Fatalf("Failed to insert entry %v into server: %v", entry, err)
+		}
 	}
 
-	want := &btpb.ReadModifyWriteRowResponse{
-		Row: &btpb.Row{
-			Key: []byte("row-key"),
-			Families: []*btpb.Family{{
-				Name: "cf",
-				Columns: []*btpb.Column{
-					{
-						Qualifier: []byte("q0"),
-						Cells: []*btpb.Cell{{
-							Value: []byte{0, 0, 0, 0, 0, 0, 0, 1},
-						}},
-					},
-					{
-						Qualifier: []byte("q1"),
-						Cells: []*btpb.Cell{{
-							Value: []byte("ab"),
+	// After insertion now it is time for querying.
+	req := &btpb.ReadRowsRequest{
+		TableName: s.tblName,
+		Filter: &btpb.RowFilter{Filter: &btpb.RowFilter_Chain_{
+			Chain: &btpb.RowFilter_Chain{Filters: []*btpb.RowFilter{{
+				Filter: &btpb.RowFilter_Interleave_{
+					Interleave: &btpb.RowFilter_Interleave{
+						Filters: []*btpb.RowFilter{{Filter: &btpb.RowFilter_Condition_{
+							Condition: &btpb.RowFilter_Condition{
+								PredicateFilter: &btpb.RowFilter{Filter: &btpb.RowFilter_Chain_{
+									Chain: &btpb.RowFilter_Chain{Filters: []*btpb.RowFilter{
+										{
+											Filter: &btpb.RowFilter_ValueRangeFilter{ValueRangeFilter: &btpb.ValueRange{
+												StartValue: &btpb.ValueRange_StartValueClosed{
+													StartValueClosed: []byte("a"),
+												},
+												EndValue: &btpb.ValueRange_EndValueClosed{EndValueClosed: []byte("a")},
+											}},
+										},
+										{Filter: &btpb.RowFilter_PassAllFilter{PassAllFilter: true}},
+									}},
+								}},
+								TrueFilter: &btpb.RowFilter{Filter: &btpb.RowFilter_PassAllFilter{PassAllFilter: true}},
+							},
 						}},
+							{Filter: &btpb.RowFilter_BlockAllFilter{BlockAllFilter: true}},
+						},
 					},
 				},
+			},
+				{Filter: &btpb.RowFilter_PassAllFilter{PassAllFilter: true}},
 			}},
-		},
+		}},
 	}
 
-	scrubTimestamp := func(resp *btpb.ReadModifyWriteRowResponse) *btpb.ReadModifyWriteRowResponse {
-		for _, fam := range resp.GetRow().GetFamilies() {
-			for _, col := range fam.GetColumns() {
-				for _, cell := range col.GetCells() {
-					cell.TimestampMicros = 0
-				}
-			}
-		}
-		return resp
+	responses, err := readRows(ctx, s, req)
+	if err != nil {
+		t.Fatalf("ReadRows error: %v", err)
 	}
-	diff := cmp.Diff(scrubTimestamp(got), scrubTimestamp(want), cmp.Comparer(proto.Equal))
-	if diff != "" {
-		t.Errorf("unexpected response: %s", diff)
+	if g, w := len(responses), 1; g != w {
+		t.Fatalf("Results/Streamed chunks mismatch:: got %d want %d", g, w)
 	}
-}
 
-// helper function to populate table data
-func populateTable(ctx context.Context, s *clientIntf, exists bool) error {
-	if !exists {
-		newTbl := btapb.Table{
-			ColumnFamilies: map[string]*btapb.ColumnFamily{
-				"cf0": {GcRule: &btapb.GcRule{Rule: &btapb.GcRule_MaxNumVersions{MaxNumVersions: 1}}},
+	got := responses[0]
+	// Only row3 should be matched.
+	want := &btpb.ReadRowsResponse{
+		Chunks: []*btpb.ReadRowsResponse_CellChunk{
+			{
+				RowKey:          []byte("row3"),
+				FamilyName:      &wrappers.StringValue{Value: "cf"},
+				Qualifier:       &wrappers.BytesValue{Value: []byte("cq")},
+				TimestampMicros: 1000,
+				Value:           []byte("a"),
+				RowStatus: &btpb.ReadRowsResponse_CellChunk_CommitRow{
+					CommitRow: true,
+				},
 			},
-		}
-		_, err := s.CreateTable(ctx, &btapb.CreateTableRequest{Parent: s.parent, TableId: s.name, Table: &newTbl})
-		if err != nil {
-			return err
-		}
+		},
+	}
+	if diff := cmp.Diff(got, want, cmp.Comparer(proto.Equal)); diff != "" {
+		t.Fatalf("Response mismatch: got: + want -\n%s", diff)
 	}
+}
 
-	count := 3
-	mcf := func(i int) *btapb.ModifyColumnFamiliesRequest {
-		return &btapb.ModifyColumnFamiliesRequest{
-			Name: s.tblName,
-			Modifications: []*btapb.ModifyColumnFamiliesRequest_Modification{{
-				Id:  "cf" + strconv.Itoa(i),
-				Mod: &btapb.ModifyColumnFamiliesRequest_Modification_Create{Create: &btapb.ColumnFamily{}},
+func TestValueFilterRowWithLiteralBinaryValue(t *testing.T) {
+	value := []byte{'a', '.', '(', '\\', 0xff, '*'}
+	decoy := []byte{'a', 'x', '(', '\\', 0xff, '*'}
+	cellRow := func(v []byte) *btpb.Row {
+		return &btpb.Row{
+			Key: []byte("row"),
+			Families: []*btpb.Family{{
+				Name: "fam",
+				Columns: []*btpb.Column{{
+					Qualifier: []byte("col"),
+					Cells:     []*btpb.Cell{{TimestampMicros: 1000, Value: v}},
+				}},
 			}},
 		}
 	}
-	for i := 1; i <= count; i++ {
-		_, err := s.ModifyColumnFamilies(ctx, mcf(i))
-		if err != nil {
-			if s, ok := status.FromError(err); !ok || s.Code() != codes.AlreadyExists {
-				return err
-			}
+	for _, test := range []struct {
+		desc                   string
+		pattern                []byte
+		matchValue, matchDecoy bool
+	}{
+		// A quoted literal matches exactly the bytes it was made from.
+		{"quoted", []byte(regexp.QuoteMeta(string(value))), true, false},
+		// Unquoted, "." matches any byte, so the decoy matches too.
+		{"unquoted dot", []byte{'a', '.', '\\', '(', '\\', '\\', 0xff, '\\', '*'}, true, true},
+	} {
+		filter := &btpb.RowFilter{Filter: &btpb.RowFilter_ValueRegexFilter{ValueRegexFilter: test.pattern}}
+		if got, _ := filterRow(filter, cellRow(value), nil); got != test.matchValue {
+			t.Errorf("%s: match against value: got %t, want %t", test.desc, got, test.matchValue)
 		}
-	}
-	// Populate the table
-	for fc := 0; fc < count; fc++ {
-		for cc := count; cc > 0; cc-- {
-			for tc := 0; tc < count; tc++ {
-				req := &btpb.MutateRowRequest{
-					TableName: s.tblName,
-					RowKey:    []byte("row"),
-					Mutations: []*btpb.Mutation{{
-						Mutation: &btpb.Mutation_SetCell_{SetCell: &btpb.Mutation_SetCell{
-							FamilyName:      "cf" + strconv.Itoa(fc),
-							ColumnQualifier: []byte("col" + strconv.Itoa(cc)),
-							TimestampMicros: int64((tc + 1) * 1000),
-							Value:           []byte{},
-						}},
-					}},
-				}
-				if _, err := s.MutateRow(ctx, req); err != nil {
-					return err
-				}
-			}
+		if got, _ := filterRow(filter, cellRow(decoy), nil); got != test.matchDecoy {
+			t.Errorf("%s: match against decoy: got %t, want %t", test.desc, got, test.matchDecoy)
 		}
 	}
-
-	return nil
 }
 
-func TestFilters(t *testing.T) {
-	tests := []struct {
-		in   *btpb.RowFilter
-		code codes.Code
-		out  int
-	}{
-		{in: &btpb.RowFilter{Filter: &btpb.RowFilter_BlockAllFilter{BlockAllFilter: true}}, out: 0},
-		{in: &btpb.RowFilter{Filter: &btpb.RowFilter_BlockAllFilter{BlockAllFilter: false}}, code: codes.InvalidArgument},
-		{in: &btpb.RowFilter{Filter: &btpb.RowFilter_PassAllFilter{PassAllFilter: true}}, out: 1},
-		{in: &btpb.RowFilter{Filter: &btpb.RowFilter_PassAllFilter{PassAllFilter: false}}, code: codes.InvalidArgument},
-	}
-
+func TestValueFilterRowWithAlternationInRegex(t *testing.T) {
+	// Test that regex alternation is applied properly.
+	// See Issue https://github.com/googleapis/google-cloud-go/issues/1499
 	ctx, s, ok := newClient(t)
-	if err := populateTable(ctx, s, ok); err != nil {
-		t.Fatal(err)
-	}
-
-	req := &btpb.ReadRowsRequest{
-		TableName: s.tblName,
-		Rows:      &btpb.RowSet{RowKeys: [][]byte{[]byte("row")}},
-	}
-
-	for _, tc := range tests {
-		req.Filter = tc.in
+	if !ok {
 
-		responses, err := readRows(ctx, s, req)
-		if tc.code != codes.OK {
-			s, _ := status.FromError(err)
-			if s.Code() != tc.code {
-				t.Errorf("error code: got %d, want %d", s.Code(), tc.code)
-			}
-		} else if err != nil {
-			t.Errorf("ReadRows error: %v", err)
-		} else if len(responses) != tc.out {
-			t.Errorf("Response count: got %d, want %d", len(responses), tc.out)
+		tblReq := &btapb.CreateTableRequest{
+			Parent:  s.parent,
+			TableId: s.name,
+			Table: &btapb.Table{
+				ColumnFamilies: map[string]*btapb.ColumnFamily{
+					"cf": {},
+				},
+			},
+		}
+		_, err := s.CreateTable(ctx, tblReq)
+		if err != nil {
+			t.Fatalf("Failed to create the table: %v", err)
 		}
 	}
-}
 
-func Test_Mutation_DeleteFromColumn(t *testing.T) {
-	ctx, s, ok := newClient(t)
-	if err := populateTable(ctx, s, ok); err != nil {
-		t.Fatal(err)
+	entries := []struct {
+		row   string
+		value []byte
+	}{
+		{"row1", []byte("")},
+		{"row2", []byte{'x'}},
+		{"row3", []byte{'a'}},
+		{"row4", []byte{'m'}},
 	}
 
-	tests := []struct {
-		in   *btpb.MutateRowRequest
-		fail bool
-	}{
-		{
-			in: &btpb.MutateRowRequest{
-				TableName: s.tblName,
-				RowKey:    []byte("row"),
-				Mutations: []*btpb.Mutation{{
-					Mutation: &btpb.Mutation_DeleteFromColumn_{DeleteFromColumn: &btpb.Mutation_DeleteFromColumn{
-						FamilyName:      "cf1",
-						ColumnQualifier: []byte("col1"),
-						TimeRange: &btpb.TimestampRange{
-							StartTimestampMicros: 2000,
-							EndTimestampMicros:   1000,
-						},
-					}},
-				}},
-			},
-			fail: true,
-		},
-		{
-			in: &btpb.MutateRowRequest{
-				TableName: s.tblName,
-				RowKey:    []byte("row"),
-				Mutations: []*btpb.Mutation{{
-					Mutation: &btpb.Mutation_DeleteFromColumn_{DeleteFromColumn: &btpb.Mutation_DeleteFromColumn{
-						FamilyName:      "cf2",
-						ColumnQualifier: []byte("col2"),
-						TimeRange: &btpb.TimestampRange{
-							StartTimestampMicros: 1000,
-							EndTimestampMicros:   2000,
-						},
-					}},
+	for _, entry := range entries {
+		req := &btpb.MutateRowRequest{
+			TableName: s.tblName,
+			RowKey:    []byte(entry.row),
+			Mutations: []*btpb.Mutation{{
+				Mutation: &btpb.Mutation_SetCell_{SetCell: &btpb.Mutation_SetCell{
+					FamilyName:      "cf",
+					ColumnQualifier: []byte("cq"),
+					TimestampMicros: 1000,
+					Value:           entry.value,
 				}},
+			}},
+		}
+		if _, err := s.MutateRow(ctx, req); err != nil {
+			t.Fatalf("Failed to insert entry %v into server: %v", entry, err)
+		}
+	}
+
+	// After insertion now it is time for querying.
+	req := &btpb.ReadRowsRequest{
+		TableName: s.tblName,
+		Rows:      &btpb.RowSet{},
+		Filter: &btpb.RowFilter{
+			Filter: &btpb.RowFilter_ValueRegexFilter{
+				ValueRegexFilter: []byte("|a"),
 			},
-			fail: false,
 		},
+	}
+
+	responses, err := readRows(ctx, s, req)
+	if err != nil {
+		t.Fatalf("ReadRows error: %v", err)
+	}
+	var gotChunks []*btpb.ReadRowsResponse_CellChunk
+	for _, res := range responses {
+		gotChunks = append(gotChunks, res.Chunks...)
+	}
+
+	// Only row1 "" and row3 "a" should be matched.
+	wantChunks := []*btpb.ReadRowsResponse_CellChunk{
 		{
-			in: &btpb.MutateRowRequest{
-				TableName: s.tblName,
-				RowKey:    []byte("row"),
-				Mutations: []*btpb.Mutation{{
-					Mutation: &btpb.Mutation_DeleteFromColumn_{DeleteFromColumn: &btpb.Mutation_DeleteFromColumn{
-						FamilyName:      "cf3",
-						ColumnQualifier: []byte("col3"),
-						TimeRange: &btpb.TimestampRange{
-							StartTimestampMicros: 1000,
-							EndTimestampMicros:   0,
-						},
-					}},
-				}},
+			RowKey:          []byte("row1"),
+			FamilyName:      &wrappers.StringValue{Value: "cf"},
+			Qualifier:       &wrappers.BytesValue{Value: []byte("cq")},
+			TimestampMicros: 1000,
+			Value:           []byte(""),
+			RowStatus: &btpb.ReadRowsResponse_CellChunk_CommitRow{
+				CommitRow: true,
 			},
-			fail: false,
 		},
 		{
-			in: &btpb.MutateRowRequest{
-				TableName: s.tblName,
-				RowKey:    []byte("row"),
-				Mutations: []*btpb.Mutation{{
-					Mutation: &btpb.Mutation_DeleteFromColumn_{DeleteFromColumn: &btpb.Mutation_DeleteFromColumn{
-						FamilyName:      "cf4",
-						ColumnQualifier: []byte("col4"),
-						TimeRange: &btpb.TimestampRange{
-							StartTimestampMicros: 0,
-							EndTimestampMicros:   1000,
-						},
-					}},
-				}},
+			RowKey:          []byte("row3"),
+			FamilyName:      &wrappers.StringValue{Value: "cf"},
+			Qualifier:       &wrappers.BytesValue{Value: []byte("cq")},
+			TimestampMicros: 1000,
+			Value:           []byte("a"),
+			RowStatus: &btpb.ReadRowsResponse_CellChunk_CommitRow{
+				CommitRow: true,
 			},
-			fail: true,
 		},
 	}
-	for _, test := range tests {
-		_, err := s.MutateRow(ctx, test.in)
+	if diff := cmp.Diff(gotChunks, wantChunks, cmp.Comparer(proto.Equal)); diff != "" {
+		t.Fatalf("Response chunks mismatch: got: + want -\n%s", diff)
+	}
+}
 
-		if err != nil && !test.fail {
-			t.Errorf("expected passed got failure for : %v \n with err: %v", test.in, err)
+func readRows(ctx context.Context, s *clientIntf, req *btpb.ReadRowsRequest) ([]*btpb.ReadRowsResponse, error) {
+	stream, err := s.ReadRows(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	if err := stream.CloseSend(); err != nil {
+		return nil, err
+	}
+	var ret []*btpb.ReadRowsResponse
+	var lastRow, lastQual []byte
+	var lastFamily string
+	for {
+		msg, err := stream.Recv()
+		if err == io.EOF {
+			return ret, nil
+		} else if err != nil {
+			return ret, err
 		}
-
-		if err == nil && test.fail {
-			t.Errorf("expected failure got passed for : %v", test)
+		// Resolve sparse cell chunks.
+		for _, c := range msg.Chunks {
+			if c.RowKey == nil {
+				c.RowKey = lastRow
+			} else {
+				lastRow = c.RowKey
+			}
+			if c.FamilyName == nil {
+				c.FamilyName = &wrappers.StringValue{Value: lastFamily}
+			} else {
+				lastFamily = c.FamilyName.Value
+			}
+			if c.Qualifier == nil {
+				c.Qualifier = &wrappers.BytesValue{Value: lastQual}
+			} else {
+				lastQual = c.Qualifier.Value
+			}
 		}
+		ret = append(ret, msg)
 	}
 }
 
-func TestFilterRow(t *testing.T) {
-	row := &btpb.Row{
-		Key: []byte("row"),
-		Families: []*btpb.Family{
-			{
-				Name: "fam",
-				Columns: []*btpb.Column{
-					{
-						Qualifier: []byte("col"),
-						Cells:     []*btpb.Cell{{TimestampMicros: 1000, Value: []byte("val")}},
-					},
-				},
-			},
-		},
+func sampleRowKeys(ctx context.Context, s *clientIntf, req *btpb.SampleRowKeysRequest) ([]*btpb.SampleRowKeysResponse, error) {
+	stream, err := s.SampleRowKeys(ctx, req)
+	if err != nil {
+		return nil, err
 	}
-	for _, test := range []struct {
-		filter *btpb.RowFilter
-		want   bool
-	}{
-		// The regexp-based filters perform whole-string, case-sensitive matches.
-		{&btpb.RowFilter{Filter: &btpb.RowFilter_RowKeyRegexFilter{RowKeyRegexFilter: []byte("row")}}, true},
-		{&btpb.RowFilter{Filter: &btpb.RowFilter_RowKeyRegexFilter{RowKeyRegexFilter: []byte("ro")}}, false},
-		{&btpb.RowFilter{Filter: &btpb.RowFilter_RowKeyRegexFilter{RowKeyRegexFilter: []byte("ROW")}}, false},
-		{&btpb.RowFilter{Filter: &btpb.RowFilter_RowKeyRegexFilter{RowKeyRegexFilter: []byte("moo")}}, false},
-
-		{&btpb.RowFilter{Filter: &btpb.RowFilter_FamilyNameRegexFilter{FamilyNameRegexFilter: "fam"}}, true},
-		{&btpb.RowFilter{Filter: &btpb.RowFilter_FamilyNameRegexFilter{FamilyNameRegexFilter: "f.*"}}, true},
-		{&btpb.RowFilter{Filter: &btpb.RowFilter_FamilyNameRegexFilter{FamilyNameRegexFilter: "[fam]+"}}, true},
-		{&btpb.RowFilter{Filter: &btpb.RowFilter_FamilyNameRegexFilter{FamilyNameRegexFilter: "fa"}}, false},
-		{&btpb.RowFilter{Filter: &btpb.RowFilter_FamilyNameRegexFilter{FamilyNameRegexFilter: "FAM"}}, false},
-		{&btpb.RowFilter{Filter: &btpb.RowFilter_FamilyNameRegexFilter{FamilyNameRegexFilter: "moo"}}, false},
-
-		{&btpb.RowFilter{Filter: &btpb.RowFilter_ColumnQualifierRegexFilter{ColumnQualifierRegexFilter: []byte("col")}}, true},
-		{&btpb.RowFilter{Filter: &btpb.RowFilter_ColumnQualifierRegexFilter{ColumnQualifierRegexFilter: []byte("co")}}, false},
-		{&btpb.RowFilter{Filter: &btpb.RowFilter_ColumnQualifierRegexFilter{ColumnQualifierRegexFilter: []byte("COL")}}, false},
-		{&btpb.RowFilter{Filter: &btpb.RowFilter_ColumnQualifierRegexFilter{ColumnQualifierRegexFilter: []byte("moo")}}, false},
-
-		{&btpb.RowFilter{Filter: &btpb.RowFilter_ValueRegexFilter{ValueRegexFilter: []byte("val")}}, true},
-		{&btpb.RowFilter{Filter: &btpb.RowFilter_ValueRegexFilter{ValueRegexFilter: []byte("va")}}, false},
-		{&btpb.RowFilter{Filter: &btpb.RowFilter_ValueRegexFilter{ValueRegexFilter: []byte("VAL")}}, false},
-		{&btpb.RowFilter{Filter: &btpb.RowFilter_ValueRegexFilter{ValueRegexFilter: []byte("moo")}}, false},
-
-		{&btpb.RowFilter{Filter: &btpb.RowFilter_TimestampRangeFilter{TimestampRangeFilter: &btpb.TimestampRange{StartTimestampMicros: int64(0), EndTimestampMicros: int64(1000)}}}, false},
-		{&btpb.RowFilter{Filter: &btpb.RowFilter_TimestampRangeFilter{TimestampRangeFilter: &btpb.TimestampRange{StartTimestampMicros: int64(1000), EndTimestampMicros: int64(2000)}}}, true},
-	} {
-		got, err := filterRow(test.filter, copyRow(row))
-		if err != nil {
-			t.Errorf("%s: got unexpected error: %v", test.filter, err)
-		}
-		if got != test.want {
-			t.Errorf("%s: got %t, want %t", test.filter, got, test.want)
+	if err := stream.CloseSend(); err != nil {
+		return nil, err
+	}
+	var ret []*btpb.SampleRowKeysResponse
+	for {
+		msg, err := stream.Recv()
+		if err == io.EOF {
+			return ret, nil
+		} else if err != nil {
+			return ret, err
 		}
+		ret = append(ret, msg)
 	}
 }
 
-func TestFilterRowWithErrors(t *testing.T) {
-	row := &btpb.Row{
-		Key: []byte("row"),
-		Families: []*btpb.Family{
-			{
-				Name: "fam",
-				Columns: []*btpb.Column{
-					{
-						Qualifier: []byte("col"),
-						Cells:     []*btpb.Cell{{TimestampMicros: 1000, Value: []byte("val")}},
-					},
-				},
-			},
-		},
-	}
-	for _, test := range []struct {
-		badRegex *btpb.RowFilter
-	}{
-		{badRegex: &btpb.RowFilter{Filter: &btpb.RowFilter_RowKeyRegexFilter{RowKeyRegexFilter: []byte("[")}}},
-		{badRegex: &btpb.RowFilter{Filter: &btpb.RowFilter_FamilyNameRegexFilter{FamilyNameRegexFilter: "["}}},
-		{badRegex: &btpb.RowFilter{Filter: &btpb.RowFilter_ColumnQualifierRegexFilter{ColumnQualifierRegexFilter: []byte("[")}}},
-		{badRegex: &btpb.RowFilter{Filter: &btpb.RowFilter_ValueRegexFilter{ValueRegexFilter: []byte("[")}}},
-		{badRegex: &btpb.RowFilter{Filter: &btpb.RowFilter_Chain_{
-			Chain: &btpb.RowFilter_Chain{Filters: []*btpb.RowFilter{
-				{Filter: &btpb.RowFilter_ValueRegexFilter{ValueRegexFilter: []byte("[")}}},
-			},
-		}}},
-		{badRegex: &btpb.RowFilter{Filter: &btpb.RowFilter_Condition_{
-			Condition: &btpb.RowFilter_Condition{
-				PredicateFilter: &btpb.RowFilter{Filter: &btpb.RowFilter_ValueRegexFilter{ValueRegexFilter: []byte("[")}},
+func TestModifyColumnFamiliesAtomic(t *testing.T) {
+	ctx, s, ok := newClient(t)
+	if !ok {
+		newTbl := btapb.Table{
+			ColumnFamilies: map[string]*btapb.ColumnFamily{
+				"cf": {},
 			},
-		}}},
-
-		{badRegex: &btpb.RowFilter{Filter: &btpb.RowFilter_RowSampleFilter{RowSampleFilter: 0}}},                                                                                               // 0.0 is invalid.
-		{badRegex: &btpb.RowFilter{Filter: &btpb.RowFilter_RowSampleFilter{RowSampleFilter: 1}}},                                                                                               // 1.0 is invalid.
-		{badRegex: &btpb.RowFilter{Filter: &btpb.RowFilter_TimestampRangeFilter{TimestampRangeFilter: &btpb.TimestampRange{StartTimestampMicros: int64(1), EndTimestampMicros: int64(1000)}}}}, // Server only supports millisecond precision.
-		{badRegex: &btpb.RowFilter{Filter: &btpb.RowFilter_TimestampRangeFilter{TimestampRangeFilter: &btpb.TimestampRange{StartTimestampMicros: int64(1000), EndTimestampMicros: int64(1)}}}}, // Server only supports millisecond precision.
-	} {
-		got, err := filterRow(test.badRegex, copyRow(row))
-		if got != false {
-			t.Errorf("%s: got true, want false", test.badRegex)
 		}
-		if err == nil {
-			t.Errorf("%s: got no error, want error", test.badRegex)
+		if _, err := s.CreateTable(ctx, &btapb.CreateTableRequest{Parent: s.parent, TableId: s.name, Table: &newTbl}); err != nil {
+			t.Fatalf("Creating table: %v", err)
 		}
 	}
+
+	req := &btapb.ModifyColumnFamiliesRequest{
+		Name: s.tblName,
+		Modifications: []*btapb.ModifyColumnFamiliesRequest_Modification{{
+			Id:  "new",
+			Mod: &btapb.ModifyColumnFamiliesRequest_Modification_Create{Create: &btapb.ColumnFamily{}},
+		}, {
+			Id:  "cf",
+			Mod: &btapb.ModifyColumnFamiliesRequest_Modification_Drop{Drop: true},
+		}, {
+			Id:  "missing",
+			Mod: &btapb.ModifyColumnFamiliesRequest_Modification_Drop{Drop: true},
+		}},
+	}
+	if _, err := s.ModifyColumnFamilies(ctx, req); err == nil {
+		t.Fatal("expected dropping an unknown family to fail")
+	}
+
+	tbl, err := s.GetTable(ctx, &btapb.GetTableRequest{Name: s.tblName})
+	if err != nil {
+		t.Fatalf("Getting table: %v", err)
+	}
+	if len(tbl.ColumnFamilies) != 1 || tbl.ColumnFamilies["cf"] == nil {
+		t.Errorf("column families changed by failed request: %v", tbl.ColumnFamilies)
+	}
 }
 
-func TestFilterRowWithRowSampleFilter(t *testing.T) {
-	prev := randFloat
-	randFloat = func() float64 { return 0.5 }
-	defer func() { randFloat = prev }()
-	for _, test := range []struct {
-		p    float64
-		want bool
-	}{
-		{0.1, false}, // Less than random float. Return no rows.
-		{0.5, false}, // Equal to random float. Return no rows.
-		{0.9, true},  // Greater than random float. Return all rows.
-	} {
-		got, err := filterRow(&btpb.RowFilter{Filter: &btpb.RowFilter_RowSampleFilter{RowSampleFilter: test.p}}, &btpb.Row{})
+func TestUpdateTableDeletionProtection(t *testing.T) {
+	ctx, s, ok := newClient(t)
+	if !ok {
+		if _, err := s.CreateTable(ctx, &btapb.CreateTableRequest{Parent: s.parent, TableId: s.name}); err != nil {
+			t.Fatalf("Creating table: %v", err)
+		}
+	}
+
+	setProtection := func(on bool) {
+		t.Helper()
+		op, err := s.UpdateTable(ctx, &btapb.UpdateTableRequest{
+			Table:      &btapb.Table{Name: s.tblName, DeletionProtection: on},
+			UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"deletion_protection"}},
+		})
 		if err != nil {
-			t.Fatalf("%f: %v", test.p, err)
+			t.Fatalf("Updating table: %v", err)
 		}
-		if got != test.want {
-			t.Errorf("%v: got %t, want %t", test.p, got, test.want)
+		if !op.Done {
+			t.Fatal("expected a completed operation")
+		}
+		var tbl btapb.Table
+		if err := op.GetResponse().UnmarshalTo(&tbl); err != nil {
+			t.Fatalf("Unmarshaling response: %v", err)
 		}
+		if tbl.DeletionProtection != on {
+			t.Fatalf("DeletionProtection = %v, want %v", tbl.DeletionProtection, on)
+		}
+	}
+
+	setProtection(true)
+	_, err := s.DeleteTable(ctx, &btapb.DeleteTableRequest{Name: s.tblName})
+	if status.Code(err) != codes.FailedPrecondition {
+		t.Fatalf("DeleteTable on protected table: got %v, want FailedPrecondition", err)
+	}
+
+	setProtection(false)
+	if _, err := s.DeleteTable(ctx, &btapb.DeleteTableRequest{Name: s.tblName}); err != nil {
+		t.Fatalf("Deleting table: %v", err)
+	}
+	if _, err := s.GetTable(ctx, &btapb.GetTableRequest{Name: s.tblName}); status.Code(err) != codes.NotFound {
+		t.Fatalf("GetTable after delete: got %v, want NotFound", err)
 	}
 }
 
-func TestFilterRowWithBinaryColumnQualifier(t *testing.T) {
-	rs := []byte{128, 128}
-	row := &btpb.Row{
-		Key: rs,
-		Families: []*btpb.Family{
-			{
-				Name: "fam",
-				Columns: []*btpb.Column{
-					{
-						Qualifier: rs,
-						Cells:     []*btpb.Cell{{TimestampMicros: 1000, Value: []byte("val")}},
-					},
-				},
+func TestDropRowRangeDeletionProtection(t *testing.T) {
+	ctx, s, ok := newClient(t)
+	if !ok {
+		newTbl := btapb.Table{
+			ColumnFamilies: map[string]*btapb.ColumnFamily{
+				"cf": {},
 			},
-		},
+		}
+		if _, err := s.CreateTable(ctx, &btapb.CreateTableRequest{Parent: s.parent, TableId: s.name, Table: &newTbl}); err != nil {
+			t.Fatalf("Creating table: %v", err)
+		}
 	}
-	for _, test := range []struct {
-		filter string
-		want   bool
-	}{
-		{`\x80\x80`, true},      // succeeds, exact match
-		{`\x80\x81`, false},     // fails
-		{`\x80`, false},         // fails, because the regexp must match the entire input
-		{`\x80*`, true},         // succeeds: 0 or more 128s
-		{`[\x7f\x80]{2}`, true}, // succeeds: exactly two of either 127 or 128
-		{`\C{2}`, true},         // succeeds: two bytes
-	} {
-		got, _ := filterRow(&btpb.RowFilter{Filter: &btpb.RowFilter_ColumnQualifierRegexFilter{ColumnQualifierRegexFilter: []byte(test.filter)}}, copyRow(row))
-		if got != test.want {
-			t.Errorf("%v: got %t, want %t", test.filter, got, test.want)
+	mreq := &btpb.MutateRowRequest{
+		TableName: s.tblName,
+		RowKey:    []byte("row"),
+		Mutations: []*btpb.Mutation{{
+			Mutation: &btpb.Mutation_SetCell_{SetCell: &btpb.Mutation_SetCell{
+				FamilyName:      "cf",
+				ColumnQualifier: []byte("col"),
+				Value:           []byte("value"),
+			}},
+		}},
+	}
+	if _, err := s.MutateRow(ctx, mreq); err != nil {
+		t.Fatalf("Populating table: %v", err)
+	}
+
+	setProtection := func(on bool) {
+		t.Helper()
+		if _, err := s.UpdateTable(ctx, &btapb.UpdateTableRequest{
+			Table:      &btapb.Table{Name: s.tblName, DeletionProtection: on},
+			UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"deletion_protection"}},
+		}); err != nil {
+			t.Fatalf("Updating table: %v", err)
 		}
 	}
+	dropAll := &btapb.DropRowRangeRequest{
+		Name:   s.tblName,
+		Target: &btapb.DropRowRangeRequest_DeleteAllDataFromTable{DeleteAllDataFromTable: true},
+	}
+
+	setProtection(true)
+	if _, err := s.DropRowRange(ctx, dropAll); status.Code(err) != codes.FailedPrecondition {
+		t.Fatalf("DropRowRange on protected table: got %v, want FailedPrecondition", err)
+	}
+	if resps, err := readRows(ctx, s, &btpb.ReadRowsRequest{TableName: s.tblName}); err != nil || len(resps) == 0 {
+		t.Fatalf("expected the row to survive a blocked drop, got %v, %v", resps, err)
+	}
+
+	setProtection(false)
+	if _, err := s.DropRowRange(ctx, dropAll); err != nil {
+		t.Fatalf("Dropping all rows: %v", err)
+	}
+	if resps, err := readRows(ctx, s, &btpb.ReadRowsRequest{TableName: s.tblName}); err != nil || len(resps) != 0 {
+		t.Fatalf("expected no rows after drop, got %v, %v", resps, err)
+	}
 }
 
-func TestFilterRowWithUnicodeColumnQualifier(t *testing.T) {
-	rs := []byte("a§b")
-	row := &btpb.Row{
-		Key: rs,
-		Families: []*btpb.Family{
-			{
-				Name: "fam",
-				Columns: []*btpb.Column{
-					{
-						Qualifier: rs,
-						Cells:     []*btpb.Cell{{TimestampMicros: 1000, Value: []byte("val")}},
-					},
-				},
-			},
+func TestLogHook(t *testing.T) {
+	ctx := context.Background()
+	var mu sync.Mutex
+	var logged []string
+	_, s := newTestServer(t, func(svr *server) {
+		svr.log = func(err error, format string, args ...interface{}) {
+			mu.Lock()
+			defer mu.Unlock()
+			logged = append(logged, fmt.Sprintf(format, args...))
+		}
+	})
+	newTbl := btapb.Table{
+		ColumnFamilies: map[string]*btapb.ColumnFamily{
+			"cf": {},
 		},
 	}
-	for _, test := range []struct {
-		filter string
-		want   bool
-	}{
-		{`a§b`, true},        // succeeds, exact match
-		{`a\xC2\xA7b`, true}, // succeeds, exact match
-		{`a\xC2.+`, true},    // succeeds, prefix match
-		{`a\xC2\C{2}`, true}, // succeeds, prefix match
-		{`a\xC.+`, false},    // fails, prefix match, bad escape
-		{`a§.+`, true},       // succeeds, prefix match
-		{`.+§b`, true},       // succeeds, suffix match
-		{`.§b`, true},        // succeeds
-		{`a§c`, false},       // fails
-		{`§b`, false},        // fails, because the regexp must match the entire input
-		{`.*§.*`, true},      // succeeds: anything with a §
-		{`.+§.+`, true},      // succeeds: anything with a § in the middle
-		{`a\C{2}b`, true},    // succeeds: § is two bytes
-		{`\C{4}`, true},      // succeeds: four bytes
-	} {
-		got, _ := filterRow(&btpb.RowFilter{Filter: &btpb.RowFilter_ColumnQualifierRegexFilter{ColumnQualifierRegexFilter: []byte(test.filter)}}, copyRow(row))
-		if got != test.want {
-			t.Errorf("%v: got %t, want %t", test.filter, got, test.want)
-		}
+	if _, err := s.CreateTable(ctx, &btapb.CreateTableRequest{Parent: s.parent, TableId: s.name, Table: &newTbl}); err != nil {
+		t.Fatalf("Creating table: %v", err)
+	}
+	mreq := &btpb.MutateRowRequest{
+		TableName: s.tblName,
+		RowKey:    []byte("row"),
+		Mutations: []*btpb.Mutation{{
+			Mutation: &btpb.Mutation_SetCell_{SetCell: &btpb.Mutation_SetCell{
+				FamilyName:      "cf",
+				ColumnQualifier: []byte("col"),
+				Value:           []byte("value"),
+			}},
+		}},
+	}
+	if _, err := s.MutateRow(ctx, mreq); err != nil {
+		t.Fatalf("Populating table: %v", err)
+	}
+
+	req := &btpb.ReadRowsRequest{
+		TableName: s.tblName,
+		Filter:    &btpb.RowFilter{Filter: &btpb.RowFilter_Sink{Sink: true}},
+	}
+	if _, err := readRows(ctx, s, req); err != nil {
+		t.Fatalf("Reading rows: %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(logged) != 1 || !strings.Contains(logged[0], "don't know how to handle filter of type *bigtablepb.RowFilter_Sink") {
+		t.Errorf("unexpected log messages: %q", logged)
 	}
 }
 
-// Test that a single column qualifier with the interleave filter returns
-// the correct result and not return every single row.
-// See Issue https://github.com/googleapis/google-cloud-go/issues/1399
-func TestFilterRowWithSingleColumnQualifier(t *testing.T) {
-	ctx, s, ok := newClient(t)
-	if !ok {
-		tblReq := &btapb.CreateTableRequest{Parent: s.parent, TableId: s.name,
-			Table: &btapb.Table{
-				ColumnFamilies: map[string]*btapb.ColumnFamily{
-					"cf": {},
-				},
-			},
+func TestBackgroundGc(t *testing.T) {
+	ctx := context.Background()
+	svr, s := newTestServer(t, func(svr *server) {
+		svr.gcInterval = 10 * time.Millisecond
+		svr.gcQuiesce = 10 * time.Millisecond
+		svr.done = make(chan struct{})
+	})
+	defer close(svr.done)
+	newTbl := btapb.Table{
+		ColumnFamilies: map[string]*btapb.ColumnFamily{
+			"cf": {GcRule: &btapb.GcRule{Rule: &btapb.GcRule_MaxNumVersions{MaxNumVersions: 1}}},
+		},
+	}
+	if _, err := s.CreateTable(ctx, &btapb.CreateTableRequest{Parent: s.parent, TableId: s.name, Table: &newTbl}); err != nil {
+		t.Fatalf("Creating table: %v", err)
+	}
+	for ts := int64(1000); ts <= 3000; ts += 1000 {
+		req := &btpb.MutateRowRequest{
+			TableName: s.tblName,
+			RowKey:    []byte("row"),
+			Mutations: []*btpb.Mutation{{
+				Mutation: &btpb.Mutation_SetCell_{SetCell: &btpb.Mutation_SetCell{
+					FamilyName:      "cf",
+					ColumnQualifier: []byte("col"),
+					TimestampMicros: ts,
+					Value:           []byte("value"),
+				}},
+			}},
 		}
-		_, err := s.CreateTable(ctx, tblReq)
-		if err != nil {
-			t.Fatalf("Failed to create the table: %v", err)
+		if _, err := s.MutateRow(ctx, req); err != nil {
+			t.Fatalf("Populating table: %v", err)
 		}
 	}
 
-	entries := []struct {
-		row   string
-		value []byte
-	}{
-		{"row1", []byte{0x11}},
-		{"row2", []byte{0x1a}},
-		{"row3", []byte{'a'}},
-		{"row4", []byte{'b'}},
+	go svr.gcloop()
+
+	// Inspect the stored rows directly, since reads would keep the table from going idle.
+	tbl := svr.tables[s.tblName]
+	cells := func() int {
+		tbl.mu.RLock()
+		defer tbl.mu.RUnlock()
+		n := 0
+		tbl.rows.Ascend(func(r *btpb.Row) bool {
+			n += countCells(r)
+			return true
+		})
+		return n
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for cells() != 1 {
+		if time.Now().After(deadline) {
+			t.Fatalf("background GC did not run; %d cells remain", cells())
+		}
+		time.Sleep(10 * time.Millisecond)
 	}
+}
 
-	for _, entry := range entries {
+func TestDisableBackgroundGc(t *testing.T) {
+	ctx := context.Background()
+	srv, err := NewServerWithOptions("localhost:0", Options{
+		Storage:             BtreeStorage{},
+		DisableBackgroundGc: true,
+		GcInterval:          10 * time.Millisecond,
+		GcQuiesce:           10 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("NewServerWithOptions: %v", err)
+	}
+	defer srv.Close()
+	s := newTestClient(srv.s, t.Name())
+	newTbl := btapb.Table{
+		ColumnFamilies: map[string]*btapb.ColumnFamily{
+			"cf": {GcRule: &btapb.GcRule{Rule: &btapb.GcRule_MaxNumVersions{MaxNumVersions: 1}}},
+		},
+	}
+	if _, err := s.CreateTable(ctx, &btapb.CreateTableRequest{Parent: s.parent, TableId: s.name, Table: &newTbl}); err != nil {
+		t.Fatalf("Creating table: %v", err)
+	}
+	for ts := int64(1000); ts <= 3000; ts += 1000 {
 		req := &btpb.MutateRowRequest{
 			TableName: s.tblName,
-			RowKey:    []byte(entry.row),
+			RowKey:    []byte("row"),
 			Mutations: []*btpb.Mutation{{
 				Mutation: &btpb.Mutation_SetCell_{SetCell: &btpb.Mutation_SetCell{
 					FamilyName:      "cf",
-					ColumnQualifier: []byte("cq"),
-					TimestampMicros: 1000,
-					Value:           entry.value,
+					ColumnQualifier: []byte("col"),
+					TimestampMicros: ts,
+					Value:           []byte("value"),
 				}},
 			}},
 		}
 		if _, err := s.MutateRow(ctx, req); err != nil {
-			t.Fatalf("Failed to insert entry %v into server: %v", entry, err)
+			t.Fatalf("Populating table: %v", err)
 		}
 	}
 
-	// After insertion now it is time for querying.
-	req := &btpb.ReadRowsRequest{
-		TableName: s.tblName,
-		Filter: &btpb.RowFilter{Filter: &btpb.RowFilter_Chain_{
-			Chain: &btpb.RowFilter_Chain{Filters: []*btpb.RowFilter{{
-				Filter: &btpb.RowFilter_Interleave_{
-					Interleave: &btpb.RowFilter_Interleave{
-						Filters: []*btpb.RowFilter{{Filter: &btpb.RowFilter_Condition_{
-							Condition: &btpb.RowFilter_Condition{
-								PredicateFilter: &btpb.RowFilter{Filter: &btpb.RowFilter_Chain_{
-									Chain: &btpb.RowFilter_Chain{Filters: []*btpb.RowFilter{
-										{
-											Filter: &btpb.RowFilter_ValueRangeFilter{ValueRangeFilter: &btpb.ValueRange{
-												StartValue: &btpb.ValueRange_StartValueClosed{
-													StartValueClosed: []byte("a"),
-												},
-												EndValue: &btpb.ValueRange_EndValueClosed{EndValueClosed: []byte("a")},
-											}},
-										},
-										{Filter: &btpb.RowFilter_PassAllFilter{PassAllFilter: true}},
-									}},
-								}},
-								TrueFilter: &btpb.RowFilter{Filter: &btpb.RowFilter_PassAllFilter{PassAllFilter: true}},
-							},
-						}},
-							{Filter: &btpb.RowFilter_BlockAllFilter{BlockAllFilter: true}},
-						},
-					},
-				},
+	// Background GC would have collected the old versions many times over by now.
+	time.Sleep(200 * time.Millisecond)
+	if _, cells, _, err := srv.TableStats(s.tblName); err != nil || cells != 3 {
+		t.Fatalf("TableStats: got %d cells (err %v), want 3", cells, err)
+	}
+
+	// Explicit GC still works.
+	if err := srv.GarbageCollect(s.tblName); err != nil {
+		t.Fatalf("GarbageCollect: %v", err)
+	}
+	if _, cells, _, err := srv.TableStats(s.tblName); err != nil || cells != 1 {
+		t.Fatalf("TableStats after GarbageCollect: got %d cells (err %v), want 1", cells, err)
+	}
+}
+
+func TestReadRowsEmptyTable(t *testing.T) {
+	ctx, s, ok := newClient(t)
+	if !ok {
+		newTbl := btapb.Table{
+			ColumnFamilies: map[string]*btapb.ColumnFamily{
+				"cf": {},
 			},
-				{Filter: &btpb.RowFilter_PassAllFilter{PassAllFilter: true}},
+		}
+		if _, err := s.CreateTable(ctx, &btapb.CreateTableRequest{Parent: s.parent, TableId: s.name, Table: &newTbl}); err != nil {
+			t.Fatalf("Creating table: %v", err)
+		}
+	}
+
+	for name, req := range map[string]*btpb.ReadRowsRequest{
+		"filter": {
+			TableName: s.tblName,
+			Filter:    &btpb.RowFilter{Filter: &btpb.RowFilter_FamilyNameRegexFilter{FamilyNameRegexFilter: "cf"}},
+		},
+		"limit": {
+			TableName: s.tblName,
+			RowsLimit: 10,
+			Filter:    &btpb.RowFilter{Filter: &btpb.RowFilter_PassAllFilter{PassAllFilter: true}},
+		},
+		"ranges": {
+			TableName: s.tblName,
+			Rows: &btpb.RowSet{RowRanges: []*btpb.RowRange{{
+				StartKey: &btpb.RowRange_StartKeyClosed{StartKeyClosed: []byte("a")},
+				EndKey:   &btpb.RowRange_EndKeyOpen{EndKeyOpen: []byte("z")},
+			}}},
+			Filter: &btpb.RowFilter{Filter: &btpb.RowFilter_CellsPerColumnLimitFilter{CellsPerColumnLimitFilter: 1}},
+		},
+		"single key": {
+			TableName: s.tblName,
+			Rows:      &btpb.RowSet{RowKeys: [][]byte{[]byte("row")}},
+			Filter:    &btpb.RowFilter{Filter: &btpb.RowFilter_PassAllFilter{PassAllFilter: true}},
+		},
+	} {
+		responses, err := readRows(ctx, s, req)
+		if err != nil {
+			t.Errorf("%s: ReadRows error: %v", name, err)
+		} else if len(responses) != 0 {
+			t.Errorf("%s: got %d responses from an empty table, want 0: %v", name, len(responses), responses)
+		}
+	}
+}
+
+func TestOnMutation(t *testing.T) {
+	ctx := context.Background()
+	type observed struct {
+		table, row string
+		muts       []*btpb.Mutation
+	}
+	var mu sync.Mutex
+	var got []observed
+	var s *clientIntf
+	_, s = newTestServer(t, func(svr *server) {
+		svr.onMutation = func(table string, rowKey []byte, muts []*btpb.Mutation) {
+			// Reading back from the callback would deadlock if the table lock were still held.
+			if _, err := readRows(ctx, s, &btpb.ReadRowsRequest{TableName: table}); err != nil {
+				t.Errorf("Reading from callback: %v", err)
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			got = append(got, observed{table, string(rowKey), muts})
+		}
+	})
+	newTbl := btapb.Table{
+		ColumnFamilies: map[string]*btapb.ColumnFamily{
+			"cf": {},
+		},
+	}
+	if _, err := s.CreateTable(ctx, &btapb.CreateTableRequest{Parent: s.parent, TableId: s.name, Table: &newTbl}); err != nil {
+		t.Fatalf("Creating table: %v", err)
+	}
+	setCell := func(val string) []*btpb.Mutation {
+		return []*btpb.Mutation{{
+			Mutation: &btpb.Mutation_SetCell_{SetCell: &btpb.Mutation_SetCell{
+				FamilyName:      "cf",
+				ColumnQualifier: []byte("col"),
+				Value:           []byte(val),
 			}},
-		}},
+		}}
 	}
+	deleteRow := []*btpb.Mutation{{Mutation: &btpb.Mutation_DeleteFromRow_{DeleteFromRow: &btpb.Mutation_DeleteFromRow{}}}}
 
-	responses, err := readRows(ctx, s, req)
+	if _, err := s.MutateRow(ctx, &btpb.MutateRowRequest{TableName: s.tblName, RowKey: []byte("row1"), Mutations: setCell("a")}); err != nil {
+		t.Fatalf("MutateRow: %v", err)
+	}
+	stream, err := s.MutateRows(ctx, &btpb.MutateRowsRequest{TableName: s.tblName, Entries: []*btpb.MutateRowsRequest_Entry{
+		{RowKey: []byte("row2"), Mutations: setCell("b")},
+		{RowKey: []byte("row3"), Mutations: []*btpb.Mutation{{
+			Mutation: &btpb.Mutation_SetCell_{SetCell: &btpb.Mutation_SetCell{FamilyName: "nope"}},
+		}}},
+	}})
 	if err != nil {
-		t.Fatalf("ReadRows error: %v", err)
+		t.Fatalf("MutateRows: %v", err)
 	}
-	if g, w := len(responses), 1; g != w {
-		t.Fatalf("Results/Streamed chunks mismatch:: got %d want %d", g, w)
+	if _, err := stream.Recv(); err != nil {
+		t.Fatalf("MutateRows: %v", err)
+	}
+	if _, err := s.CheckAndMutateRow(ctx, &btpb.CheckAndMutateRowRequest{
+		TableName:      s.tblName,
+		RowKey:         []byte("row1"),
+		TrueMutations:  deleteRow,
+		FalseMutations: setCell("c"),
+	}); err != nil {
+		t.Fatalf("CheckAndMutateRow: %v", err)
 	}
 
-	got := responses[0]
-	// Only row3 should be matched.
-	want := &btpb.ReadRowsResponse{
-		Chunks: []*btpb.ReadRowsResponse_CellChunk{
-			{
-				RowKey:          []byte("row3"),
-				FamilyName:      &wrappers.StringValue{Value: "cf"},
-				Qualifier:       &wrappers.BytesValue{Value: []byte("cq")},
-				TimestampMicros: 1000,
-				Value:           []byte("a"),
-				RowStatus: &btpb.ReadRowsResponse_CellChunk_CommitRow{
-					CommitRow: true,
-				},
-			},
-		},
+	// The failed row3 entry isn't reported.
+	want := []observed{
+		{s.tblName, "row1", setCell("a")},
+		{s.tblName, "row2", setCell("b")},
+		{s.tblName, "row1", deleteRow},
 	}
-	if diff := cmp.Diff(got, want, cmp.Comparer(proto.Equal)); diff != "" {
-		t.Fatalf("Response mismatch: got: + want -\n%s", diff)
+	mu.Lock()
+	defer mu.Unlock()
+	if diff := cmp.Diff(want, got, cmp.AllowUnexported(observed{}), cmp.Comparer(proto.Equal)); diff != "" {
+		t.Errorf("Observed mutations mismatch (-want +got):\n%s", diff)
 	}
 }
 
-func TestValueFilterRowWithAlternationInRegex(t *testing.T) {
-	// Test that regex alternation is applied properly.
-	// See Issue https://github.com/googleapis/google-cloud-go/issues/1499
-	ctx, s, ok := newClient(t)
-	if !ok {
+func TestInterleaveLabels(t *testing.T) {
+	ctx := context.Background()
+	_, s := newTestServer(t, nil)
+	populateSingleKeyTable(ctx, t, s)
 
-		tblReq := &btapb.CreateTableRequest{
-			Parent:  s.parent,
-			TableId: s.name,
-			Table: &btapb.Table{
-				ColumnFamilies: map[string]*btapb.ColumnFamily{
-					"cf": {},
-				},
+	// Both branches yield every cell; only the first labels its copies.
+	res, err := readRows(ctx, s, &btpb.ReadRowsRequest{
+		TableName: s.tblName,
+		Rows:      &btpb.RowSet{RowKeys: [][]byte{[]byte("row-1")}},
+		Filter: &btpb.RowFilter{Filter: &btpb.RowFilter_Interleave_{Interleave: &btpb.RowFilter_Interleave{
+			Filters: []*btpb.RowFilter{
+				{Filter: &btpb.RowFilter_ApplyLabelTransformer{ApplyLabelTransformer: "labeled"}},
+				{Filter: &btpb.RowFilter_PassAllFilter{PassAllFilter: true}},
 			},
+		}}},
+	})
+	if err != nil {
+		t.Fatalf("ReadRows: %v", err)
+	}
+	labeled := map[string]int{}
+	plain := map[string]int{}
+	var col string
+	for _, rsp := range res {
+		for _, chunk := range rsp.Chunks {
+			if q := chunk.GetQualifier(); q != nil {
+				col = string(q.GetValue())
+			}
+			switch {
+			case len(chunk.Labels) == 0:
+				plain[col]++
+			case len(chunk.Labels) == 1 && chunk.Labels[0] == "labeled":
+				labeled[col]++
+			default:
+				t.Errorf("Unexpected labels %q on column %q", chunk.Labels, col)
+			}
 		}
-		_, err := s.CreateTable(ctx, tblReq)
-		if err != nil {
-			t.Fatalf("Failed to create the table: %v", err)
-		}
 	}
+	want := map[string]int{"a": 1, "b": 1}
+	if diff := cmp.Diff(want, labeled); diff != "" {
+		t.Errorf("Labeled cells mismatch (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff(want, plain); diff != "" {
+		t.Errorf("Plain cells mismatch (-want +got):\n%s", diff)
+	}
+}
 
-	entries := []struct {
-		row   string
-		value []byte
-	}{
-		{"row1", []byte("")},
-		{"row2", []byte{'x'}},
-		{"row3", []byte{'a'}},
-		{"row4", []byte{'m'}},
+// cancellingRRAdapter cancels its context as soon as the first response is sent.
+type cancellingRRAdapter struct {
+	rrAdapter
+	cancel context.CancelFunc
+	sends  int
+}
+
+func (r *cancellingRRAdapter) Send(response *btpb.ReadRowsResponse) error {
+	r.sends++
+	defer r.cancel()
+	return r.rrAdapter.Send(response)
+}
+
+func TestReadRowsCancelled(t *testing.T) {
+	ctx := context.Background()
+	svr, s := newTestServer(t, nil)
+	newTbl := btapb.Table{
+		ColumnFamilies: map[string]*btapb.ColumnFamily{
+			"cf": {},
+		},
+	}
+	if _, err := s.CreateTable(ctx, &btapb.CreateTableRequest{Parent: s.parent, TableId: s.name, Table: &newTbl}); err != nil {
+		t.Fatalf("Creating table: %v", err)
+	}
+	// Enough single-cell rows for several flushes of 1024 chunks.
+	var entries []*btpb.MutateRowsRequest_Entry
+	for i := 0; i < 5000; i++ {
+		entries = append(entries, &btpb.MutateRowsRequest_Entry{
+			RowKey: []byte(fmt.Sprintf("row-%05d", i)),
+			Mutations: []*btpb.Mutation{{
+				Mutation: &btpb.Mutation_SetCell_{SetCell: &btpb.Mutation_SetCell{
+					FamilyName:      "cf",
+					ColumnQualifier: []byte("col"),
+					Value:           []byte("value"),
+				}},
+			}},
+		})
+	}
+	if _, err := s.MutateRows(ctx, &btpb.MutateRowsRequest{TableName: s.tblName, Entries: entries}); err != nil {
+		t.Fatalf("Populating table: %v", err)
 	}
 
-	for _, entry := range entries {
-		req := &btpb.MutateRowRequest{
+	cctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stream := &cancellingRRAdapter{rrAdapter: rrAdapter{streamAdapter{ctx: cctx}}, cancel: cancel}
+	err := svr.ReadRows(&btpb.ReadRowsRequest{TableName: s.tblName}, stream)
+	if status.Code(err) != codes.Canceled {
+		t.Errorf("ReadRows: got %v, want Canceled", err)
+	}
+	if stream.sends != 1 {
+		t.Errorf("Got %d responses, want the scan to stop after the first", stream.sends)
+	}
+}
+
+func TestFakeClockMaxAgeGC(t *testing.T) {
+	ctx := context.Background()
+	t0 := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := NewFakeClock(t0)
+	svr, s := newTestServer(t, func(svr *server) { svr.clock = clock.Now })
+	srv := &Server{s: svr}
+	newTbl := btapb.Table{
+		ColumnFamilies: map[string]*btapb.ColumnFamily{
+			"cf": {GcRule: &btapb.GcRule{Rule: &btapb.GcRule_MaxAge{MaxAge: durationpb.New(time.Hour)}}},
+		},
+	}
+	if _, err := s.CreateTable(ctx, &btapb.CreateTableRequest{Parent: s.parent, TableId: s.name, Table: &newTbl}); err != nil {
+		t.Fatalf("Creating table: %v", err)
+	}
+	// Cells written with server time (-1) take the fake clock's time.
+	setCell := func(col string) {
+		t.Helper()
+		_, err := s.MutateRow(ctx, &btpb.MutateRowRequest{
 			TableName: s.tblName,
-			RowKey:    []byte(entry.row),
+			RowKey:    []byte("row"),
 			Mutations: []*btpb.Mutation{{
 				Mutation: &btpb.Mutation_SetCell_{SetCell: &btpb.Mutation_SetCell{
 					FamilyName:      "cf",
-					ColumnQualifier: []byte("cq"),
-					TimestampMicros: 1000,
-					Value:           entry.value,
+					ColumnQualifier: []byte(col),
+					Value:           []byte("value"),
+					TimestampMicros: -1,
 				}},
 			}},
+		})
+		if err != nil {
+			t.Fatalf("MutateRow: %v", err)
 		}
-		if _, err := s.MutateRow(ctx, req); err != nil {
-			t.Fatalf("Failed to insert entry %v into server: %v", entry, err)
+	}
+	cellTimes := func() map[string]time.Time {
+		t.Helper()
+		if err := srv.GarbageCollect(s.tblName); err != nil {
+			t.Fatalf("GarbageCollect: %v", err)
+		}
+		responses, err := readRows(ctx, s, &btpb.ReadRowsRequest{TableName: s.tblName})
+		if err != nil {
+			t.Fatalf("ReadRows: %v", err)
 		}
+		got := map[string]time.Time{}
+		for _, res := range responses {
+			for _, c := range res.Chunks {
+				got[string(c.Qualifier.GetValue())] = bigtable.Timestamp(c.TimestampMicros).Time().UTC()
+			}
+		}
+		return got
 	}
 
-	// After insertion now it is time for querying.
-	req := &btpb.ReadRowsRequest{
-		TableName: s.tblName,
-		Rows:      &btpb.RowSet{},
-		Filter: &btpb.RowFilter{
-			Filter: &btpb.RowFilter_ValueRegexFilter{
-				ValueRegexFilter: []byte("|a"),
-			},
-		},
+	setCell("old")
+	clock.Advance(30 * time.Minute)
+	setCell("new")
+	want := map[string]time.Time{"old": t0, "new": t0.Add(30 * time.Minute)}
+	if diff := cmp.Diff(want, cellTimes()); diff != "" {
+		t.Fatalf("Cells before MaxAge mismatch (-want +got):\n%s", diff)
 	}
 
-	responses, err := readRows(ctx, s, req)
-	if err != nil {
-		t.Fatalf("ReadRows error: %v", err)
-	}
-	var gotChunks []*btpb.ReadRowsResponse_CellChunk
-	for _, res := range responses {
-		gotChunks = append(gotChunks, res.Chunks...)
+	// Only the older cell is past MaxAge.
+	clock.Advance(45 * time.Minute)
+	delete(want, "old")
+	if diff := cmp.Diff(want, cellTimes()); diff != "" {
+		t.Fatalf("Cells after MaxAge mismatch (-want +got):\n%s", diff)
 	}
 
-	// Only row1 "" and row3 "a" should be matched.
-	wantChunks := []*btpb.ReadRowsResponse_CellChunk{
-		{
-			RowKey:          []byte("row1"),
-			FamilyName:      &wrappers.StringValue{Value: "cf"},
-			Qualifier:       &wrappers.BytesValue{Value: []byte("cq")},
-			TimestampMicros: 1000,
-			Value:           []byte(""),
-			RowStatus: &btpb.ReadRowsResponse_CellChunk_CommitRow{
-				CommitRow: true,
-			},
-		},
-		{
-			RowKey:          []byte("row3"),
-			FamilyName:      &wrappers.StringValue{Value: "cf"},
-			Qualifier:       &wrappers.BytesValue{Value: []byte("cq")},
-			TimestampMicros: 1000,
-			Value:           []byte("a"),
-			RowStatus: &btpb.ReadRowsResponse_CellChunk_CommitRow{
-				CommitRow: true,
-			},
-		},
-	}
-	if diff := cmp.Diff(gotChunks, wantChunks, cmp.Comparer(proto.Equal)); diff != "" {
-		t.Fatalf("Response chunks mismatch: got: + want -\n%s", diff)
+	if err := srv.GarbageCollect(s.tblName + "-missing"); status.Code(err) != codes.NotFound {
+		t.Errorf("GarbageCollect on missing table: got %v, want NotFound", err)
 	}
 }
 
-func readRows(ctx context.Context, s *clientIntf, req *btpb.ReadRowsRequest) ([]*btpb.ReadRowsResponse, error) {
-	stream, err := s.ReadRows(ctx, req)
-	if err != nil {
-		return nil, err
-	}
-	if err := stream.CloseSend(); err != nil {
-		return nil, err
-	}
-	var ret []*btpb.ReadRowsResponse
-	var lastRow, lastQual []byte
-	var lastFamily string
-	for {
-		msg, err := stream.Recv()
-		if err == io.EOF {
-			return ret, nil
-		} else if err != nil {
-			return ret, err
-		}
-		// Resolve sparse cell chunks.
-		for _, c := range msg.Chunks {
-			if c.RowKey == nil {
-				c.RowKey = lastRow
-			} else {
-				lastRow = c.RowKey
+func TestValidateResourcePrefix(t *testing.T) {
+	for _, validate := range []bool{false, true} {
+		t.Run(fmt.Sprintf("validate=%v", validate), func(t *testing.T) {
+			srv, err := NewServerWithOptions("localhost:0", Options{ValidateResourcePrefix: validate})
+			if err != nil {
+				t.Fatalf("NewServerWithOptions: %v", err)
 			}
-			if c.FamilyName == nil {
-				c.FamilyName = &wrappers.StringValue{Value: lastFamily}
-			} else {
-				lastFamily = c.FamilyName.Value
+			defer srv.Close()
+			conn, err := grpc.NewClient(srv.Addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+			if err != nil {
+				t.Fatalf("Dialing %s: %v", srv.Addr, err)
 			}
-			if c.Qualifier == nil {
-				c.Qualifier = &wrappers.BytesValue{Value: lastQual}
-			} else {
-				lastQual = c.Qualifier.Value
+			defer conn.Close()
+			admin := btapb.NewBigtableTableAdminClient(conn)
+			data := btpb.NewBigtableClient(conn)
+
+			const parent = "projects/project/instances/cluster"
+			const tblName = parent + "/tables/tbl"
+			withPrefix := func(prefix string) context.Context {
+				return metadata.AppendToOutgoingContext(context.Background(), "google-cloud-resource-prefix", prefix)
 			}
-		}
-		ret = append(ret, msg)
+			wantCode := codes.OK
+			if validate {
+				wantCode = codes.InvalidArgument
+			}
+
+			// A matching prefix, or none at all, always works.
+			if _, err := admin.CreateTable(withPrefix(parent), &btapb.CreateTableRequest{
+				Parent:  parent,
+				TableId: "tbl",
+				Table:   &btapb.Table{ColumnFamilies: map[string]*btapb.ColumnFamily{"cf": {}}},
+			}); err != nil {
+				t.Fatalf("CreateTable: %v", err)
+			}
+			mutateRow := func(ctx context.Context) error {
+				_, err := data.MutateRow(ctx, &btpb.MutateRowRequest{
+					TableName: tblName,
+					RowKey:    []byte("row"),
+					Mutations: []*btpb.Mutation{{Mutation: &btpb.Mutation_SetCell_{SetCell: &btpb.Mutation_SetCell{
+						FamilyName:      "cf",
+						ColumnQualifier: []byte("col"),
+						Value:           []byte("value"),
+					}}}},
+				})
+				return err
+			}
+			if err := mutateRow(withPrefix(tblName)); err != nil {
+				t.Errorf("MutateRow with table prefix: %v", err)
+			}
+			if err := mutateRow(context.Background()); err != nil {
+				t.Errorf("MutateRow without prefix: %v", err)
+			}
+
+			// A mismatched prefix fails unary and streaming calls when validating.
+			other := "projects/project/instances/other"
+			if err := mutateRow(withPrefix(other)); status.Code(err) != wantCode {
+				t.Errorf("MutateRow with mismatched prefix: got %v, want %v", err, wantCode)
+			}
+			if err := mutateRow(withPrefix(parent + "-2")); status.Code(err) != wantCode {
+				t.Errorf("MutateRow with sibling prefix: got %v, want %v", err, wantCode)
+			}
+			stream, err := data.ReadRows(withPrefix(other), &btpb.ReadRowsRequest{TableName: tblName})
+			if err != nil {
+				t.Fatalf("ReadRows: %v", err)
+			}
+			for err == nil {
+				_, err = stream.Recv()
+			}
+			if err == io.EOF {
+				err = nil
+			}
+			if status.Code(err) != wantCode {
+				t.Errorf("ReadRows with mismatched prefix: got %v, want %v", err, wantCode)
+			}
+		})
 	}
 }
 
-func sampleRowKeys(ctx context.Context, s *clientIntf, req *btpb.SampleRowKeysRequest) ([]*btpb.SampleRowKeysResponse, error) {
-	stream, err := s.SampleRowKeys(ctx, req)
-	if err != nil {
-		return nil, err
-	}
-	if err := stream.CloseSend(); err != nil {
-		return nil, err
-	}
-	var ret []*btpb.SampleRowKeysResponse
-	for {
-		msg, err := stream.Recv()
-		if err == io.EOF {
-			return ret, nil
-		} else if err != nil {
-			return ret, err
-		}
-		ret = append(ret, msg)
+func TestValidateAppProfile(t *testing.T) {
+	for _, ids := range [][]string{nil, {"batch"}} {
+		t.Run(fmt.Sprintf("ids=%v", ids), func(t *testing.T) {
+			srv, err := NewServerWithOptions("localhost:0", Options{AppProfileIds: ids})
+			if err != nil {
+				t.Fatalf("NewServerWithOptions: %v", err)
+			}
+			defer srv.Close()
+			conn, err := grpc.NewClient(srv.Addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+			if err != nil {
+				t.Fatalf("Dialing %s: %v", srv.Addr, err)
+			}
+			defer conn.Close()
+			admin := btapb.NewBigtableTableAdminClient(conn)
+			data := btpb.NewBigtableClient(conn)
+
+			const tblName = "projects/project/instances/cluster/tables/tbl"
+			if _, err := admin.CreateTable(context.Background(), &btapb.CreateTableRequest{
+				Parent:  "projects/project/instances/cluster",
+				TableId: "tbl",
+				Table:   &btapb.Table{ColumnFamilies: map[string]*btapb.ColumnFamily{"cf": {}}},
+			}); err != nil {
+				t.Fatalf("CreateTable: %v", err)
+			}
+			mutateRow := func(appProfileId string) error {
+				_, err := data.MutateRow(context.Background(), &btpb.MutateRowRequest{
+					TableName:    tblName,
+					AppProfileId: appProfileId,
+					RowKey:       []byte("row"),
+					Mutations: []*btpb.Mutation{{Mutation: &btpb.Mutation_SetCell_{SetCell: &btpb.Mutation_SetCell{
+						FamilyName:      "cf",
+						ColumnQualifier: []byte("col"),
+						Value:           []byte("value"),
+					}}}},
+				})
+				return err
+			}
+
+			// No app profile, the default one, or a configured one always work.
+			for _, id := range append([]string{"", "default"}, ids...) {
+				if err := mutateRow(id); err != nil {
+					t.Errorf("MutateRow with app profile %q: %v", id, err)
+				}
+			}
+
+			// An unknown app profile fails unary and streaming calls when validating.
+			wantCode := codes.OK
+			if ids != nil {
+				wantCode = codes.InvalidArgument
+			}
+			if err := mutateRow("unknown"); status.Code(err) != wantCode {
+				t.Errorf("MutateRow with unknown app profile: got %v, want %v", err, wantCode)
+			}
+			stream, err := data.ReadRows(context.Background(), &btpb.ReadRowsRequest{TableName: tblName, AppProfileId: "unknown"})
+			if err != nil {
+				t.Fatalf("ReadRows: %v", err)
+			}
+			for err == nil {
+				_, err = stream.Recv()
+			}
+			if err == io.EOF {
+				err = nil
+			}
+			if status.Code(err) != wantCode {
+				t.Errorf("ReadRows with unknown app profile: got %v, want %v", err, wantCode)
+			}
+		})
 	}
 }
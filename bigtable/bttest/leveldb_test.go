@@ -2,10 +2,16 @@ package bttest
 
 import (
 	"context"
+	"encoding/binary"
 	"fmt"
+	"sync"
 	"testing"
 
 	"cloud.google.com/go/bigtable"
+	btapb "cloud.google.com/go/bigtable/admin/apiv2/adminpb"
+	btpb "cloud.google.com/go/bigtable/apiv2/bigtablepb"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 var (
@@ -31,6 +37,7 @@ var (
 		{"TestServer_ReadModifyWriteRow", TestServer_ReadModifyWriteRow},
 		{"TestFilters", TestFilters},
 		{"Test_Mutation_DeleteFromColumn", Test_Mutation_DeleteFromColumn},
+		{"Test_Mutation_DeleteFromFamily", Test_Mutation_DeleteFromFamily},
 		{"TestFilterRowWithSingleColumnQualifier", TestFilterRowWithSingleColumnQualifier},
 		{"TestValueFilterRowWithAlternationInRegex", TestValueFilterRowWithAlternationInRegex},
 	}
@@ -41,8 +48,8 @@ func TestLevelDbMem(t *testing.T) {
 		ctx := context.Background()
 
 		svr := &server{
-			tables:  make(map[string]*table),
-			storage: LeveldbMemStorage{},
+			tableSet: newTableSet(),
+			storage:  LeveldbMemStorage{},
 			clock: func() bigtable.Timestamp {
 				return 0
 			},
@@ -68,8 +75,8 @@ func TestLevelDbDisk(t *testing.T) {
 		ctx := context.Background()
 
 		svr := &server{
-			tables:  make(map[string]*table),
-			storage: LeveldbDiskStorage{Root: "./test-out"},
+			tableSet: newTableSet(),
+			storage:  LeveldbDiskStorage{Root: "./test-out"},
 			clock: func() bigtable.Timestamp {
 				return 0
 			},
@@ -89,3 +96,225 @@ func TestLevelDbDisk(t *testing.T) {
 		t.Run(tc.name, tc.f)
 	}
 }
+
+func TestSharedMem(t *testing.T) {
+	clientIntfFuncs[t.Name()] = func(t *testing.T, name string) (context.Context, *clientIntf, bool) {
+		ctx := context.Background()
+
+		svr := &server{
+			tableSet: newTableSet(),
+			storage:  &SharedMemStorage{},
+			clock: func() bigtable.Timestamp {
+				return 0
+			},
+		}
+
+		cl := &clientIntf{
+			parent:                   fmt.Sprintf("projects/%s/instances/%s", "project", "cluster"),
+			name:                     name,
+			tblName:                  fmt.Sprintf("projects/%s/instances/%s/tables/%s", "project", "cluster", name),
+			BigtableClient:           btServer2Client{s: svr},
+			BigtableTableAdminClient: btServer2AdminClient{s: svr},
+		}
+
+		return ctx, cl, false
+	}
+	for _, tc := range testMeta {
+		t.Run(tc.name, tc.f)
+	}
+}
+
+func TestSharedMemAcrossServers(t *testing.T) {
+	ctx := context.Background()
+	var shared SharedMemStorage
+	parent := "projects/project/instances/cluster"
+	tblName := parent + "/tables/" + t.Name()
+
+	srv1, err := NewServerWithOptions("localhost:0", Options{Storage: &shared})
+	if err != nil {
+		t.Fatalf("NewServerWithOptions: %v", err)
+	}
+	defer srv1.Close()
+	admin1, client1 := btServer2AdminClient{s: srv1.s}, btServer2Client{s: srv1.s}
+	if _, err := admin1.CreateTable(ctx, &btapb.CreateTableRequest{
+		Parent:  parent,
+		TableId: t.Name(),
+		Table:   &btapb.Table{ColumnFamilies: map[string]*btapb.ColumnFamily{"cf": {}}},
+	}); err != nil {
+		t.Fatalf("Creating table: %v", err)
+	}
+
+	// The second server picks up the table from the shared storage.
+	srv2, err := NewServerWithOptions("localhost:0", Options{Storage: &shared})
+	if err != nil {
+		t.Fatalf("NewServerWithOptions: %v", err)
+	}
+	defer srv2.Close()
+	client2 := btServer2Client{s: srv2.s}
+
+	if _, err := client1.MutateRow(ctx, &btpb.MutateRowRequest{
+		TableName: tblName,
+		RowKey:    []byte("row"),
+		Mutations: []*btpb.Mutation{{
+			Mutation: &btpb.Mutation_SetCell_{SetCell: &btpb.Mutation_SetCell{
+				FamilyName:      "cf",
+				ColumnQualifier: []byte("col"),
+				Value:           []byte("value"),
+			}},
+		}},
+	}); err != nil {
+		t.Fatalf("MutateRow via first server: %v", err)
+	}
+
+	cl2 := &clientIntf{BigtableClient: client2}
+	res, err := readRows(ctx, cl2, &btpb.ReadRowsRequest{TableName: tblName})
+	if err != nil {
+		t.Fatalf("ReadRows via second server: %v", err)
+	}
+	if len(res) != 1 || len(res[0].Chunks) != 1 || string(res[0].Chunks[0].Value) != "value" {
+		t.Fatalf("ReadRows via second server: got %v, want the cell written via the first", res)
+	}
+
+	// Dropping all rows via one server is visible through the other.
+	if _, err := srv2.s.DropRowRange(ctx, &btapb.DropRowRangeRequest{
+		Name:   tblName,
+		Target: &btapb.DropRowRangeRequest_DeleteAllDataFromTable{DeleteAllDataFromTable: true},
+	}); err != nil {
+		t.Fatalf("DropRowRange via second server: %v", err)
+	}
+	res, err = readRows(ctx, &clientIntf{BigtableClient: client1}, &btpb.ReadRowsRequest{TableName: tblName})
+	if err != nil {
+		t.Fatalf("ReadRows via first server: %v", err)
+	}
+	if len(res) != 0 {
+		t.Errorf("ReadRows via first server after drop: got %v, want no rows", res)
+	}
+}
+
+func TestSharedMemSchema(t *testing.T) {
+	ctx := context.Background()
+	storage := &SharedMemStorage{}
+	parent := "projects/project/instances/cluster"
+	tblName := parent + "/tables/" + t.Name()
+
+	srv1, err := NewServerWithOptions("localhost:0", Options{Storage: storage})
+	if err != nil {
+		t.Fatalf("NewServerWithOptions: %v", err)
+	}
+	defer srv1.Close()
+	admin1 := btServer2AdminClient{s: srv1.s}
+	if _, err := admin1.CreateTable(ctx, &btapb.CreateTableRequest{
+		Parent:  parent,
+		TableId: t.Name(),
+		Table:   &btapb.Table{ColumnFamilies: map[string]*btapb.ColumnFamily{"cf": {}}},
+	}); err != nil {
+		t.Fatalf("Creating table: %v", err)
+	}
+	if _, err := admin1.ModifyColumnFamilies(ctx, &btapb.ModifyColumnFamiliesRequest{
+		Name: tblName,
+		Modifications: []*btapb.ModifyColumnFamiliesRequest_Modification{{
+			Id: "cf2",
+			Mod: &btapb.ModifyColumnFamiliesRequest_Modification_Create{Create: &btapb.ColumnFamily{
+				GcRule: &btapb.GcRule{Rule: &btapb.GcRule_MaxNumVersions{MaxNumVersions: 1}},
+			}},
+		}},
+	}); err != nil {
+		t.Fatalf("ModifyColumnFamilies: %v", err)
+	}
+
+	// A second server over the same storage sees the table and its current schema.
+	srv2, err := NewServerWithOptions("localhost:0", Options{Storage: storage})
+	if err != nil {
+		t.Fatalf("NewServerWithOptions: %v", err)
+	}
+	defer srv2.Close()
+	tbl, err := btServer2AdminClient{s: srv2.s}.GetTable(ctx, &btapb.GetTableRequest{Name: tblName})
+	if err != nil {
+		t.Fatalf("GetTable via second server: %v", err)
+	}
+	if len(tbl.ColumnFamilies) != 2 || tbl.ColumnFamilies["cf"] == nil {
+		t.Fatalf("Column families via second server: got %v, want cf and cf2", tbl.ColumnFamilies)
+	}
+	if got := tbl.ColumnFamilies["cf2"].GetGcRule().GetMaxNumVersions(); got != 1 {
+		t.Errorf("cf2 GC rule via second server: got max versions %d, want 1", got)
+	}
+}
+
+func TestSharedMemTablesAcrossServers(t *testing.T) {
+	ctx := context.Background()
+	storage := &SharedMemStorage{}
+	parent := "projects/project/instances/cluster"
+	tblName := parent + "/tables/" + t.Name()
+
+	srv1, err := NewServerWithOptions("localhost:0", Options{Storage: storage})
+	if err != nil {
+		t.Fatalf("NewServerWithOptions: %v", err)
+	}
+	defer srv1.Close()
+	srv2, err := NewServerWithOptions("localhost:0", Options{Storage: storage})
+	if err != nil {
+		t.Fatalf("NewServerWithOptions: %v", err)
+	}
+	defer srv2.Close()
+
+	// A table created through one server after both started is visible through the other.
+	if _, err := srv1.s.CreateTable(ctx, &btapb.CreateTableRequest{
+		Parent:  parent,
+		TableId: t.Name(),
+		Table:   &btapb.Table{ColumnFamilies: map[string]*btapb.ColumnFamily{"cf": {}}},
+	}); err != nil {
+		t.Fatalf("Creating table: %v", err)
+	}
+	if _, err := srv2.s.GetTable(ctx, &btapb.GetTableRequest{Name: tblName}); err != nil {
+		t.Fatalf("GetTable via second server: %v", err)
+	}
+
+	// Increments through both servers are atomic with respect to each other.
+	const perServer = 50
+	var wg sync.WaitGroup
+	for _, srv := range []*Server{srv1, srv2} {
+		srv := srv
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < perServer; i++ {
+				if _, err := srv.s.ReadModifyWriteRow(ctx, &btpb.ReadModifyWriteRowRequest{
+					TableName: tblName,
+					RowKey:    []byte("row"),
+					Rules: []*btpb.ReadModifyWriteRule{{
+						FamilyName:      "cf",
+						ColumnQualifier: []byte("count"),
+						Rule:            &btpb.ReadModifyWriteRule_IncrementAmount{IncrementAmount: 1},
+					}},
+				}); err != nil {
+					t.Errorf("ReadModifyWriteRow: %v", err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	row, err := srv1.s.ReadModifyWriteRow(ctx, &btpb.ReadModifyWriteRowRequest{
+		TableName: tblName,
+		RowKey:    []byte("row"),
+		Rules: []*btpb.ReadModifyWriteRule{{
+			FamilyName:      "cf",
+			ColumnQualifier: []byte("count"),
+			Rule:            &btpb.ReadModifyWriteRule_IncrementAmount{IncrementAmount: 0},
+		}},
+	})
+	if err != nil {
+		t.Fatalf("ReadModifyWriteRow: %v", err)
+	}
+	if got, want := binary.BigEndian.Uint64(row.Row.Families[0].Columns[0].Cells[0].Value), uint64(2*perServer); got != want {
+		t.Errorf("Counter after concurrent increments: got %d, want %d", got, want)
+	}
+
+	// A table deleted through one server is gone from the other.
+	if _, err := srv2.s.DeleteTable(ctx, &btapb.DeleteTableRequest{Name: tblName}); err != nil {
+		t.Fatalf("DeleteTable via second server: %v", err)
+	}
+	if _, err := srv1.s.GetTable(ctx, &btapb.GetTableRequest{Name: tblName}); status.Code(err) != codes.NotFound {
+		t.Errorf("GetTable via first server after delete: got %v, want NotFound", err)
+	}
+}
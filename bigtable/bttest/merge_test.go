@@ -4,6 +4,8 @@ import (
 	"math/rand"
 	"testing"
 	"time"
+
+	btpb "cloud.google.com/go/bigtable/apiv2/bigtablepb"
 )
 
 func TestMergeRanges(t *testing.T) {
@@ -130,3 +132,41 @@ func TestMergeRangesMultiple(t *testing.T) {
 		}
 	}
 }
+
+func TestMergeRowRangesKeysAndRanges(t *testing.T) {
+	// Explicit keys sharing one backing array; appending to one in place would clobber the next.
+	buf := []byte("abcd")
+	keys := []keyType{buf[0:1], buf[1:2], buf[2:3]}
+	rrs := []*btpb.RowRange{{
+		StartKey: &btpb.RowRange_StartKeyClosed{StartKeyClosed: keyType("b")},
+		EndKey:   &btpb.RowRange_EndKeyOpen{EndKeyOpen: keyType("d")},
+	}}
+
+	got := mergeRowRanges(keys, rrs)
+	want := []simpleRange{
+		{keyType("a"), keyType("a\x00")},
+		{keyType("b"), keyType("d")}, // keys "b" and "c" fall inside the range
+	}
+	if len(got) != len(want) {
+		t.Fatalf("want=%d, got=%d: %q", len(want), len(got), got)
+	}
+	for i := range want {
+		if string(want[i].start) != string(got[i].start) || string(want[i].end) != string(got[i].end) {
+			t.Errorf("range %d want=[%q, %q), got=[%q, %q)", i, want[i].start, want[i].end, got[i].start, got[i].end)
+		}
+	}
+	if string(buf) != "abcd" {
+		t.Errorf("request keys were modified: %q", buf)
+	}
+}
+
+func TestMergeRowRangesEmptyRange(t *testing.T) {
+	// A range with neither bound set is infinite, and absorbs everything else.
+	got := mergeRowRanges([]keyType{keyType("a")}, []*btpb.RowRange{
+		{StartKey: &btpb.RowRange_StartKeyClosed{StartKeyClosed: keyType("m")}},
+		{},
+	})
+	if len(got) != 1 || len(got[0].start) != 0 || len(got[0].end) != 0 {
+		t.Errorf("want a single infinite range, got %q", got)
+	}
+}
@@ -0,0 +1,67 @@
+package bttest
+
+import (
+	"context"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// resourcePrefixHeader is the metadata key clients use to route a request to the instance or table it targets.
+const resourcePrefixHeader = "google-cloud-resource-prefix"
+
+// checkResourcePrefix returns InvalidArgument if ctx carries a resource prefix that the resource req targets doesn't
+// fall under. Requests without the header, or without a table, name or parent field, always pass.
+func checkResourcePrefix(ctx context.Context, req interface{}) error {
+	md, _ := metadata.FromIncomingContext(ctx)
+	prefixes := md.Get(resourcePrefixHeader)
+	if len(prefixes) == 0 {
+		return nil
+	}
+
+	var target string
+	switch r := req.(type) {
+	case interface{ GetTableName() string }:
+		target = r.GetTableName()
+	case interface{ GetName() string }:
+		target = r.GetName()
+	case interface{ GetParent() string }:
+		target = r.GetParent()
+	}
+	if target == "" {
+		return nil
+	}
+	for _, prefix := range prefixes {
+		prefix = strings.TrimSuffix(prefix, "/")
+		if target != prefix && !strings.HasPrefix(target, prefix+"/") {
+			return status.Errorf(codes.InvalidArgument, "%s %q does not match the requested resource %q", resourcePrefixHeader, prefix, target)
+		}
+	}
+	return nil
+}
+
+func resourcePrefixUnaryInterceptor(ctx context.Context, req interface{}, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	if err := checkResourcePrefix(ctx, req); err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+func resourcePrefixStreamInterceptor(srv interface{}, ss grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	return handler(srv, &resourcePrefixStream{ServerStream: ss})
+}
+
+// resourcePrefixStream checks the resource prefix against each request received on a stream.
+type resourcePrefixStream struct {
+	grpc.ServerStream
+}
+
+func (s *resourcePrefixStream) RecvMsg(m interface{}) error {
+	if err := s.ServerStream.RecvMsg(m); err != nil {
+		return err
+	}
+	return checkResourcePrefix(s.Context(), m)
+}
@@ -11,6 +11,7 @@ import (
 	"cloud.google.com/go/bigtable"
 	btapb "cloud.google.com/go/bigtable/admin/apiv2/adminpb"
 	btpb "cloud.google.com/go/bigtable/apiv2/bigtablepb"
+	longrunningpb "cloud.google.com/go/longrunning/autogen/longrunningpb"
 	emptypb "github.com/golang/protobuf/ptypes/empty"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/metadata"
@@ -32,23 +33,46 @@ func newClient(t *testing.T) (context.Context, *clientIntf, bool) {
 		return clientIntfFuncs[parts[0]](t, parts[1])
 	}
 
+	_, cl := newTestServer(t, nil)
+	return ctx, cl, false
+}
+
+// newTestServer returns an in-process server backed by BtreeStorage with a clock fixed at zero, adjusted by
+// configure if non-nil, and a client for it whose table is named after the test.
+func newTestServer(tb testing.TB, configure func(*server)) (*server, *clientIntf) {
 	svr := &server{
-		tables:  make(map[string]*table),
-		storage: BtreeStorage{},
+		tableSet: newTableSet(),
+		storage:  BtreeStorage{},
 		clock: func() bigtable.Timestamp {
 			return 0
 		},
 	}
+	if configure != nil {
+		configure(svr)
+	}
+	return svr, newTestClient(svr, tb.Name())
+}
 
-	cl := &clientIntf{
+// forEachStorage runs f as a subtest against each in-memory Storage implementation.
+func forEachStorage(t *testing.T, f func(t *testing.T, storage Storage)) {
+	for name, storage := range map[string]Storage{
+		"Btree":      BtreeStorage{},
+		"LeveldbMem": LeveldbMemStorage{},
+	} {
+		storage := storage
+		t.Run(name, func(t *testing.T) { f(t, storage) })
+	}
+}
+
+// newTestClient returns a client calling svr directly, for a table with the given name.
+func newTestClient(svr *server, name string) *clientIntf {
+	return &clientIntf{
 		parent:                   fmt.Sprintf("projects/%s/instances/%s", "project", "cluster"),
-		name:                     t.Name(),
-		tblName:                  fmt.Sprintf("projects/%s/instances/%s/tables/%s", "project", "cluster", t.Name()),
+		name:                     name,
+		tblName:                  fmt.Sprintf("projects/%s/instances/%s/tables/%s", "project", "cluster", name),
 		BigtableClient:           btServer2Client{s: svr},
 		BigtableTableAdminClient: btServer2AdminClient{s: svr},
 	}
-
-	return ctx, cl, false
 }
 
 type streamAdapter struct {
@@ -150,6 +174,44 @@ func (b btServer2Client) SampleRowKeys(ctx context.Context, in *btpb.SampleRowKe
 	return cl, err
 }
 
+type gicspAdapter struct {
+	streamAdapter
+}
+
+func (r *gicspAdapter) Send(response *btpb.GenerateInitialChangeStreamPartitionsResponse) error {
+	return r.streamAdapter.SendMsg(response)
+}
+
+func (r *gicspAdapter) Recv() (*btpb.GenerateInitialChangeStreamPartitionsResponse, error) {
+	ret := &btpb.GenerateInitialChangeStreamPartitionsResponse{}
+	return ret, r.streamAdapter.RecvMsg(ret)
+}
+
+func (b btServer2Client) GenerateInitialChangeStreamPartitions(ctx context.Context, in *btpb.GenerateInitialChangeStreamPartitionsRequest, _ ...grpc.CallOption) (btpb.Bigtable_GenerateInitialChangeStreamPartitionsClient, error) {
+	cl := &gicspAdapter{streamAdapter{ctx: ctx}}
+	err := b.s.GenerateInitialChangeStreamPartitions(in, cl)
+	return cl, err
+}
+
+type rcsAdapter struct {
+	streamAdapter
+}
+
+func (r *rcsAdapter) Send(response *btpb.ReadChangeStreamResponse) error {
+	return r.streamAdapter.SendMsg(response)
+}
+
+func (r *rcsAdapter) Recv() (*btpb.ReadChangeStreamResponse, error) {
+	ret := &btpb.ReadChangeStreamResponse{}
+	return ret, r.streamAdapter.RecvMsg(ret)
+}
+
+func (b btServer2Client) ReadChangeStream(ctx context.Context, in *btpb.ReadChangeStreamRequest, _ ...grpc.CallOption) (btpb.Bigtable_ReadChangeStreamClient, error) {
+	cl := &rcsAdapter{streamAdapter{ctx: ctx}}
+	err := b.s.ReadChangeStream(in, cl)
+	return cl, err
+}
+
 func (b btServer2Client) MutateRow(ctx context.Context, in *btpb.MutateRowRequest, _ ...grpc.CallOption) (*btpb.MutateRowResponse, error) {
 	return b.s.MutateRow(ctx, in)
 }
@@ -202,6 +264,10 @@ func (b btServer2AdminClient) DeleteTable(ctx context.Context, in *btapb.DeleteT
 	return b.s.DeleteTable(ctx, in)
 }
 
+func (b btServer2AdminClient) UpdateTable(ctx context.Context, in *btapb.UpdateTableRequest, _ ...grpc.CallOption) (*longrunningpb.Operation, error) {
+	return b.s.UpdateTable(ctx, in)
+}
+
 func (b btServer2AdminClient) ModifyColumnFamilies(ctx context.Context, in *btapb.ModifyColumnFamiliesRequest, _ ...grpc.CallOption) (*btapb.Table, error) {
 	return b.s.ModifyColumnFamilies(ctx, in)
 }
@@ -11,7 +11,8 @@ import (
 // LeveldbMemStorage stores data in an in-memory level db. This is the default.
 // Unlike BtreeStorage, LeveldbMemStorage is resilient against concurrent insertions and deletions during
 // row scans. Concurrently added and deleted rows may or may be scanned (as with real bigtable), but the
-// general row scan semantics should hold.
+// general row scan semantics should hold. Each Server has its own tables; to share them between Servers, use
+// SharedMemStorage.
 type LeveldbMemStorage struct {
 }
 
@@ -0,0 +1,57 @@
+package bttest
+
+import (
+	"sync"
+
+	btapb "cloud.google.com/go/bigtable/admin/apiv2/adminpb"
+)
+
+// SharedMemStorage stores data in in-memory level dbs that several Servers can share, e.g. to simulate multiple
+// clients of the same cluster. Pass the same *SharedMemStorage in each Server's Options; the zero value is ready
+// to use.
+//
+// Servers sharing a SharedMemStorage share their tables outright, including each table's lock and definition, so
+// tables created, modified or deleted through one Server are immediately visible through the others, and
+// conditional and read-modify-write mutations are atomic across all of them.
+type SharedMemStorage struct {
+	once   sync.Once
+	tables *tableSet
+}
+
+// sharedTables returns the tables shared by every Server using this storage.
+func (f *SharedMemStorage) sharedTables() *tableSet {
+	f.once.Do(func() {
+		f.tables = newTableSet()
+	})
+	return f.tables
+}
+
+// Create a new table, destroying any existing table.
+func (f *SharedMemStorage) Create(_ *btapb.Table) Rows {
+	return sharedMemRows{&leveldbRows{db: newMemDb(false), newFunc: newMemDb}}
+}
+
+// GetTables returns nothing; Servers find existing tables in the shared table set rather than reopening them.
+func (f *SharedMemStorage) GetTables() []*btapb.Table {
+	return nil
+}
+
+// Open the given table, which must have been previously returned by GetTables().
+func (f *SharedMemStorage) Open(_ *btapb.Table) Rows {
+	panic("should not get here")
+}
+
+// SetTableMeta is a no-op; the definition lives on the shared table itself.
+func (f *SharedMemStorage) SetTableMeta(_ *btapb.Table) {
+}
+
+var _ Storage = &SharedMemStorage{}
+
+// sharedMemRows is a leveldbRows that no single Server may close, since other Servers may still be using it.
+type sharedMemRows struct {
+	*leveldbRows
+}
+
+// Close is a no-op; the data outlives any one Server.
+func (rows sharedMemRows) Close() {
+}
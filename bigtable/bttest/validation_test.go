@@ -111,7 +111,7 @@ func TestValidateReadRowsRequestSendsRPCError(t *testing.T) {
 	tableName := "foo.org/bar"
 	// Minimal server to reproduce failures.
 	srv := &server{
-		tables: map[string]*table{tableName: new(table)},
+		tableSet: &tableSet{tables: map[string]*table{tableName: new(table)}},
 	}
 
 	badValues := []struct {
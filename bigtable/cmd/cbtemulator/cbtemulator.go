@@ -28,9 +28,11 @@ import (
 )
 
 var (
-	host = flag.String("host", "localhost", "the address to bind to on the local machine")
-	port = flag.Int("port", 9000, "the port number to bind to on the local machine")
-	dir  = flag.String("dir", "", "if set, use persistence in the given directory")
+	host       = flag.String("host", "localhost", "the address to bind to on the local machine")
+	port       = flag.Int("port", 9000, "the port number to bind to on the local machine")
+	dir        = flag.String("dir", "", "if set, use persistence in the given directory")
+	maxKeySize = flag.Int("maxkeysize", 0, "if set, the maximum row key length in bytes (defaults to 4KB)")
+	reflection = flag.Bool("reflection", false, "if set, register the gRPC server reflection service, e.g. for grpcurl")
 )
 
 const (
@@ -42,7 +44,9 @@ func main() {
 	flag.Parse()
 
 	opts := bttest.Options{
-		Storage: nil,
+		Storage:          nil,
+		MaxRowKeyLength:  *maxKeySize,
+		EnableReflection: *reflection,
 		GrpcOpts: []grpc.ServerOption{
 			grpc.MaxRecvMsgSize(maxMsgSize),
 			grpc.MaxSendMsgSize(maxMsgSize),
@@ -10,16 +10,18 @@ import (
 )
 
 var (
-	host    = flag.String("host", "localhost", "the address to bind to on the local machine")
-	port    = flag.Int("port", 9000, "the port number to bind to on the local machine")
-	dir     = flag.String("dir", "", "if set, use persistence in the given directory")
-	verbose = flag.Bool("verbose", true, "log verbosely")
+	host      = flag.String("host", "localhost", "the address to bind to on the local machine")
+	port      = flag.Int("port", 9000, "the port number to bind to on the local machine")
+	dir       = flag.String("dir", "", "if set, use persistence in the given directory")
+	verbose   = flag.Bool("verbose", true, "log verbosely")
+	publicUrl = flag.String("public-url", "", "if set, the base URL clients reach the emulator at, used for links in responses")
 )
 
 func main() {
 	flag.Parse()
 	opts := gcsemu.Options{
-		Verbose: *verbose,
+		Verbose:       *verbose,
+		PublicBaseURL: *publicUrl,
 		Log: func(err error, fmt string, args ...interface{}) {
 			if err != nil {
 				fmt = "ERROR: " + fmt + ": %s"
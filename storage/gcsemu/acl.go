@@ -0,0 +1,288 @@
+package gcsemu
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"google.golang.org/api/storage/v1"
+)
+
+// The emulator is unauthenticated, so ACLs are expanded against a synthetic owner and project. The owner can be
+// overridden with Options.Owner.
+const (
+	aclOwnerEntity   = "user-owner@gcsemu.invalid"
+	aclOwnerEntityId = "0"
+	aclProjectNumber = "0"
+)
+
+func defaultObjectOwner() *storage.ObjectOwner {
+	return &storage.ObjectOwner{Entity: aclOwnerEntity, EntityId: aclOwnerEntityId}
+}
+
+// predefinedObjectAcl expands a predefinedAcl query parameter into the full object ACL it stands for, replacing any
+// existing entries. See https://cloud.google.com/storage/docs/access-control/lists#predefined-acl
+func (g *GcsEmu) predefinedObjectAcl(predefined string) ([]*storage.ObjectAccessControl, error) {
+	owner := g.ownerAcl()
+	projectAcl := func(team string, role string) *storage.ObjectAccessControl {
+		return objectAcl("project-"+team+"-"+aclProjectNumber, role, &storage.ObjectAccessControlProjectTeam{
+			ProjectNumber: aclProjectNumber,
+			Team:          team,
+		})
+	}
+
+	switch predefined {
+	case "private":
+		return []*storage.ObjectAccessControl{owner}, nil
+	case "projectPrivate":
+		return []*storage.ObjectAccessControl{
+			owner,
+			projectAcl("owners", "OWNER"),
+			projectAcl("editors", "OWNER"),
+			projectAcl("viewers", "READER"),
+		}, nil
+	case "publicRead":
+		return []*storage.ObjectAccessControl{owner, objectAcl("allUsers", "READER", nil)}, nil
+	case "authenticatedRead":
+		return []*storage.ObjectAccessControl{owner, objectAcl("allAuthenticatedUsers", "READER", nil)}, nil
+	case "bucketOwnerRead":
+		return []*storage.ObjectAccessControl{owner, projectAcl("owners", "READER")}, nil
+	case "bucketOwnerFullControl":
+		return []*storage.ObjectAccessControl{owner, projectAcl("owners", "OWNER")}, nil
+	default:
+		return nil, fmtErrorfCode(http.StatusBadRequest, "invalid predefinedAcl %q", predefined)
+	}
+}
+
+// applyPredefinedAcl replaces the object's ACL with the expansion of the given predefinedAcl, if one was requested.
+func (g *GcsEmu) applyPredefinedAcl(obj *storage.Object, predefined string) error {
+	if predefined == "" {
+		return nil
+	}
+	acl, err := g.predefinedObjectAcl(predefined)
+	if err != nil {
+		return err
+	}
+	obj.Acl = acl
+	return nil
+}
+
+func objectAcl(entity string, role string, team *storage.ObjectAccessControlProjectTeam) *storage.ObjectAccessControl {
+	return &storage.ObjectAccessControl{
+		Kind:        "storage#objectAccessControl",
+		Entity:      entity,
+		Role:        role,
+		ProjectTeam: team,
+	}
+}
+
+// ownerAcl is the ACL entry giving the owner full control.
+func (g *GcsEmu) ownerAcl() *storage.ObjectAccessControl {
+	acl := objectAcl(g.owner.Entity, "OWNER", nil)
+	acl.EntityId = g.owner.EntityId
+	return acl
+}
+
+// defaultObjectAcl is the ACL reported for an object that has never had one set: the owner has full control.
+func (g *GcsEmu) defaultObjectAcl() []*storage.ObjectAccessControl {
+	return []*storage.ObjectAccessControl{g.ownerAcl()}
+}
+
+// effectiveObjectAcl returns the object's stored ACL, or the default ACL if none was ever set.
+func (g *GcsEmu) effectiveObjectAcl(obj *storage.Object) []*storage.ObjectAccessControl {
+	if len(obj.Acl) == 0 {
+		return g.defaultObjectAcl()
+	}
+	return obj.Acl
+}
+
+// applyProjection shapes object metadata for the requested projection. "full" includes the ACL and owner, "noAcl"
+// omits them, and no projection leaves the stored metadata as is.
+func (g *GcsEmu) applyProjection(obj *storage.Object, projection string) error {
+	switch projection {
+	case "":
+	case "full":
+		obj.Acl = g.effectiveObjectAcl(obj)
+		if obj.Owner == nil {
+			owner := *g.owner
+			obj.Owner = &owner
+		}
+	case "noAcl":
+		obj.Acl = nil
+		obj.Owner = nil
+	default:
+		return fmtErrorfCode(http.StatusBadRequest, "invalid projection %q", projection)
+	}
+	return nil
+}
+
+// validAclEntity reports whether entity has one of the forms GCS accepts in an ACL entry, e.g. "user-{email}",
+// "group-{email}", "domain-{domain}", "project-{team}-{projectNumber}", "allUsers", or "allAuthenticatedUsers".
+func validAclEntity(entity string) bool {
+	switch entity {
+	case "allUsers", "allAuthenticatedUsers":
+		return true
+	}
+	for _, prefix := range []string{"user-", "group-", "domain-"} {
+		if strings.HasPrefix(entity, prefix) {
+			return len(entity) > len(prefix)
+		}
+	}
+	for _, team := range []string{"owners", "editors", "viewers"} {
+		if prefix := "project-" + team + "-"; strings.HasPrefix(entity, prefix) {
+			return len(entity) > len(prefix)
+		}
+	}
+	return false
+}
+
+// projectAcls applies a projection to any object metadata in rsp, leaving the original, possibly stored, metadata
+// untouched.
+func (g *GcsEmu) projectAcls(rsp interface{}, projection string) interface{} {
+	project := func(obj *storage.Object) *storage.Object {
+		if obj == nil {
+			return nil
+		}
+		c := *obj
+		_ = g.applyProjection(&c, projection) // validated when the request was parsed
+		return &c
+	}
+	switch v := rsp.(type) {
+	case *storage.Object:
+		return project(v)
+	case *storage.Objects:
+		c := *v
+		c.Items = make([]*storage.Object, len(v.Items))
+		for i, obj := range v.Items {
+			c.Items[i] = project(obj)
+		}
+		return &c
+	case *storage.RewriteResponse:
+		c := *v
+		c.Resource = project(v.Resource)
+		return &c
+	}
+	return rsp
+}
+
+// handleGcsObjectAcl serves the objectAccessControls resource of an object: listing, getting, setting, and deleting
+// individual ACL entries. entity is empty when the request addresses the whole ACL.
+//
+// There is no separate ACL store: entries live in the object's stored metadata, so they persist with the object in
+// any Store, and every change goes through UpdateMeta and bumps the object's metageneration, as GCS does.
+func (g *GcsEmu) handleGcsObjectAcl(ctx context.Context, baseUrl HttpBaseUrl, w http.ResponseWriter, r *http.Request, bucket, filename, entity string) {
+	var body storage.ObjectAccessControl
+	switch r.Method {
+	case "GET":
+	case "DELETE":
+		if entity == "" {
+			g.gapiError(w, http.StatusMethodNotAllowed, "")
+			return
+		}
+	case "POST", "PUT", "PATCH":
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			g.gapiError(w, http.StatusBadRequest, fmt.Sprintf("failed to parse request: %s", err))
+			return
+		}
+		if r.Method == "POST" {
+			if entity != "" {
+				g.gapiError(w, http.StatusMethodNotAllowed, "")
+				return
+			}
+			entity = body.Entity
+		} else if entity == "" {
+			g.gapiError(w, http.StatusMethodNotAllowed, "")
+			return
+		}
+		if entity == "" || body.Role == "" {
+			g.gapiError(w, http.StatusBadRequest, "entity and role are required")
+			return
+		}
+		if !validAclEntity(entity) {
+			g.gapiError(w, http.StatusBadRequest, fmt.Sprintf("invalid entity %q", entity))
+			return
+		}
+		if body.Role != "OWNER" && body.Role != "READER" {
+			g.gapiError(w, http.StatusBadRequest, fmt.Sprintf("invalid role %q", body.Role))
+			return
+		}
+	default:
+		g.gapiError(w, http.StatusMethodNotAllowed, "")
+		return
+	}
+
+	var acl []*storage.ObjectAccessControl
+	var entry *storage.ObjectAccessControl // the single entry addressed by entity, if any
+	err := g.locks.Run(ctx, lockName(bucket, filename), func(ctx context.Context) error {
+		obj, err := g.store.GetMeta(baseUrl, bucket, filename)
+		if err != nil {
+			return fmt.Errorf("failed to check existence of %s/%s: %w", bucket, filename, err)
+		}
+		if obj == nil {
+			return g.objectNotFound(bucket, filename)
+		}
+
+		acl = g.effectiveObjectAcl(obj)
+		i := 0
+		for i < len(acl) && acl[i].Entity != entity {
+			i++
+		}
+		switch r.Method {
+		case "GET":
+			if entity != "" {
+				if i == len(acl) {
+					return fmtErrorfCode(http.StatusNotFound, "no ACL entry for %s on %s/%s", entity, bucket, filename)
+				}
+				entry = acl[i]
+			}
+			return nil
+		case "DELETE":
+			if i == len(acl) {
+				return fmtErrorfCode(http.StatusNotFound, "no ACL entry for %s on %s/%s", entity, bucket, filename)
+			}
+			acl = append(acl[:i:i], acl[i+1:]...)
+		default:
+			entry = objectAcl(entity, body.Role, body.ProjectTeam)
+			acl = append([]*storage.ObjectAccessControl(nil), acl...)
+			if i == len(acl) {
+				acl = append(acl, entry)
+			} else {
+				acl[i] = entry
+			}
+		}
+
+		obj.Acl = acl
+		if err := g.store.UpdateMeta(bucket, filename, obj, obj.Metageneration+1); err != nil {
+			return fmt.Errorf("failed to update acl of %s/%s: %w", bucket, filename, err)
+		}
+		return nil
+	})
+	if err != nil {
+		g.gapiError(w, httpStatusCodeOf(err), err.Error())
+		return
+	}
+
+	// Entries are reported with the object they belong to, which isn't worth persisting.
+	withObject := func(e *storage.ObjectAccessControl) *storage.ObjectAccessControl {
+		c := *e
+		c.Bucket, c.Object = bucket, filename
+		return &c
+	}
+	switch {
+	case r.Method == "DELETE":
+		w.WriteHeader(http.StatusNoContent)
+	case entry != nil:
+		g.jsonRespond(w, withObject(entry))
+	default:
+		items := make([]*storage.ObjectAccessControl, len(acl))
+		for i, e := range acl {
+			items[i] = withObject(e)
+		}
+		g.jsonRespond(w, &storage.ObjectAccessControls{
+			Kind:  "storage#objectAccessControls",
+			Items: items,
+		})
+	}
+}
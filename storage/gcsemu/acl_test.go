@@ -0,0 +1,242 @@
+package gcsemu
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"cloud.google.com/go/storage"
+	api "google.golang.org/api/storage/v1"
+	"gotest.tools/v3/assert"
+)
+
+func TestPredefinedAcl(t *testing.T) {
+	ctx := context.Background()
+	svr, gcsClient := newTestServer(t, Options{})
+	assert.NilError(t, svr.InitBucket("acl-bucket"))
+	bh := gcsClient.Bucket("acl-bucket")
+
+	type rule struct {
+		Entity, Role string
+	}
+	owner := rule{"user-owner@gcsemu.invalid", "OWNER"}
+	aclRules := func(attrs *storage.ObjectAttrs) []rule {
+		var ret []rule
+		for _, r := range attrs.ACL {
+			ret = append(ret, rule{string(r.Entity), string(r.Role)})
+		}
+		return ret
+	}
+
+	for _, tc := range []struct {
+		predefined string
+		want       []rule
+	}{
+		{"private", []rule{owner}},
+		{"projectPrivate", []rule{owner, {"project-owners-0", "OWNER"}, {"project-editors-0", "OWNER"}, {"project-viewers-0", "READER"}}},
+		{"publicRead", []rule{owner, {"allUsers", "READER"}}},
+		{"authenticatedRead", []rule{owner, {"allAuthenticatedUsers", "READER"}}},
+		{"bucketOwnerRead", []rule{owner, {"project-owners-0", "READER"}}},
+		{"bucketOwnerFullControl", []rule{owner, {"project-owners-0", "OWNER"}}},
+	} {
+		t.Run(tc.predefined, func(t *testing.T) {
+			oh := bh.Object("acl-" + tc.predefined + ".txt")
+			w := oh.NewWriter(ctx)
+			w.PredefinedACL = tc.predefined
+			assert.NilError(t, write(w, v1))
+			assert.DeepEqual(t, tc.want, aclRules(w.Attrs()))
+
+			attrs, err := oh.Attrs(ctx)
+			assert.NilError(t, err)
+			assert.DeepEqual(t, tc.want, aclRules(attrs))
+		})
+	}
+
+	// The ACL api sees the predefined entries.
+	oh := bh.Object("acl-publicRead.txt")
+	rules, err := oh.ACL().List(ctx)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, []storage.ACLRule{
+		{Entity: "user-owner@gcsemu.invalid", EntityID: "0", Role: storage.RoleOwner},
+		{Entity: storage.AllUsers, Role: storage.RoleReader},
+	}, rules)
+
+	// Copies take the destination's predefined ACL.
+	copier := bh.Object("acl-copy.txt").CopierFrom(bh.Object("acl-private.txt"))
+	copier.PredefinedACL = "publicRead"
+	attrs, err := copier.Run(ctx)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, []rule{owner, {"allUsers", "READER"}}, aclRules(attrs))
+	copier = bh.Object("acl-copy.txt").CopierFrom(bh.Object("acl-private.txt"))
+	copier.PredefinedACL = "bogus"
+	_, err = copier.Run(ctx)
+	assert.Equal(t, http.StatusBadRequest, httpStatusCodeOf(err), "wrong error %T: %s", err, err)
+
+	// Patching with "private" removes everything but the owner.
+	attrs, err = oh.Update(ctx, storage.ObjectAttrsToUpdate{PredefinedACL: "private"})
+	assert.NilError(t, err)
+	assert.DeepEqual(t, []rule{owner}, aclRules(attrs))
+
+	// Unknown predefined ACLs are rejected.
+	w := bh.Object("acl-bogus.txt").NewWriter(ctx)
+	w.PredefinedACL = "bogus"
+	err = write(w, v1)
+	assert.Equal(t, http.StatusBadRequest, httpStatusCodeOf(err), "wrong error %T: %s", err, err)
+}
+
+func TestObjectAcl(t *testing.T) {
+	ctx := context.Background()
+	svr, gcsClient := newTestServer(t, Options{})
+	assert.NilError(t, svr.InitBucket("acl-bucket"))
+	oh := gcsClient.Bucket("acl-bucket").Object("dir/acl.txt")
+	assert.NilError(t, write(oh.NewWriter(ctx), v1))
+
+	getMeta := func(projection string) *api.Object {
+		t.Helper()
+		rsp, err := http.Get(svr.URL + "/storage/v1/b/acl-bucket/o/dir%2Facl.txt?projection=" + projection)
+		assert.NilError(t, err)
+		defer rsp.Body.Close()
+		assert.Equal(t, http.StatusOK, rsp.StatusCode)
+		var obj api.Object
+		assert.NilError(t, json.NewDecoder(rsp.Body).Decode(&obj))
+		return &obj
+	}
+
+	// Full projection reports the default owner entry; noAcl leaves it out.
+	obj := getMeta("full")
+	assert.Equal(t, 1, len(obj.Acl))
+	assert.Equal(t, "user-owner@gcsemu.invalid", obj.Acl[0].Entity)
+	assert.Equal(t, "OWNER", obj.Acl[0].Role)
+	assert.Equal(t, "user-owner@gcsemu.invalid", obj.Owner.Entity)
+	obj = getMeta("noAcl")
+	assert.Equal(t, 0, len(obj.Acl))
+
+	// Entries round-trip through the objectAccessControls api, bumping the metageneration like any metadata change.
+	metagen := obj.Metageneration
+	assert.NilError(t, oh.ACL().Set(ctx, storage.AllUsers, storage.RoleReader))
+	rules, err := oh.ACL().List(ctx)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, []storage.ACLRule{
+		{Entity: "user-owner@gcsemu.invalid", EntityID: "0", Role: storage.RoleOwner},
+		{Entity: storage.AllUsers, Role: storage.RoleReader},
+	}, rules)
+	obj = getMeta("full")
+	assert.Equal(t, 2, len(obj.Acl))
+	assert.Equal(t, metagen+1, obj.Metageneration)
+
+	assert.NilError(t, oh.ACL().Delete(ctx, storage.AllUsers))
+	rules, err = oh.ACL().List(ctx)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, []storage.ACLRule{{Entity: "user-owner@gcsemu.invalid", EntityID: "0", Role: storage.RoleOwner}}, rules)
+
+	// Deleting an absent entry, or touching a missing object, is not found.
+	err = oh.ACL().Delete(ctx, storage.AllUsers)
+	assert.Equal(t, http.StatusNotFound, httpStatusCodeOf(err), "wrong error %T: %s", err, err)
+	_, err = gcsClient.Bucket("acl-bucket").Object("missing.txt").ACL().List(ctx)
+	assert.Equal(t, http.StatusNotFound, httpStatusCodeOf(err), "wrong error %T: %s", err, err)
+
+	// A predefined ACL replaces the stored one under full projection.
+	_, err = oh.Update(ctx, storage.ObjectAttrsToUpdate{PredefinedACL: "publicRead"})
+	assert.NilError(t, err)
+	obj = getMeta("full")
+	assert.Equal(t, 2, len(obj.Acl))
+	assert.Equal(t, "allUsers", obj.Acl[1].Entity)
+	assert.Equal(t, "READER", obj.Acl[1].Role)
+}
+
+func TestObjectOwner(t *testing.T) {
+	ctx := context.Background()
+	for name, tc := range map[string]struct {
+		owner *api.ObjectOwner
+		want  api.ObjectOwner
+	}{
+		"Default": {nil, api.ObjectOwner{Entity: "user-owner@gcsemu.invalid", EntityId: "0"}},
+		"Custom": {
+			&api.ObjectOwner{Entity: "user-someone@example.com", EntityId: "12345"},
+			api.ObjectOwner{Entity: "user-someone@example.com", EntityId: "12345"},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			svr, gcsClient := newTestServer(t, Options{Owner: tc.owner})
+			assert.NilError(t, svr.InitBucket("owner-bucket"))
+			assert.NilError(t, write(gcsClient.Bucket("owner-bucket").Object("owned.txt").NewWriter(ctx), v1))
+
+			getMeta := func(query string) *api.Object {
+				t.Helper()
+				rsp, err := http.Get(svr.URL + "/storage/v1/b/owner-bucket/o/owned.txt" + query)
+				assert.NilError(t, err)
+				defer rsp.Body.Close()
+				assert.Equal(t, http.StatusOK, rsp.StatusCode)
+				var obj api.Object
+				assert.NilError(t, json.NewDecoder(rsp.Body).Decode(&obj))
+				return &obj
+			}
+
+			// The owner, and its OWNER entry in the default ACL, only appear under full projection.
+			obj := getMeta("?projection=full")
+			assert.Assert(t, obj.Owner != nil)
+			assert.DeepEqual(t, tc.want, *obj.Owner)
+			assert.Equal(t, 1, len(obj.Acl))
+			assert.Equal(t, tc.want.Entity, obj.Acl[0].Entity)
+			assert.Equal(t, tc.want.EntityId, obj.Acl[0].EntityId)
+
+			for _, query := range []string{"", "?projection=noAcl"} {
+				obj = getMeta(query)
+				assert.Assert(t, obj.Owner == nil, "owner reported for %q", query)
+			}
+
+			// The client library sees the owner too, since it always asks for full projection.
+			attrs, err := gcsClient.Bucket("owner-bucket").Object("owned.txt").Attrs(ctx)
+			assert.NilError(t, err)
+			assert.Equal(t, tc.want.Entity, attrs.Owner)
+		})
+	}
+}
+
+func TestObjectAclRaw(t *testing.T) {
+	ctx := context.Background()
+	svr, gcsClient := newTestServer(t, Options{})
+	assert.NilError(t, svr.InitBucket("acl-bucket"))
+	assert.NilError(t, write(gcsClient.Bucket("acl-bucket").Object("raw.txt").NewWriter(ctx), v1))
+	u := svr.URL + "/storage/v1/b/acl-bucket/o/raw.txt/acl"
+
+	insert := func(body string) *http.Response {
+		t.Helper()
+		rsp, err := http.Post(u, "application/json", strings.NewReader(body))
+		assert.NilError(t, err)
+		t.Cleanup(func() { _ = rsp.Body.Close() })
+		return rsp
+	}
+
+	rsp := insert(`{"entity": "allUsers", "role": "READER"}`)
+	assert.Equal(t, http.StatusOK, rsp.StatusCode)
+	var entry api.ObjectAccessControl
+	assert.NilError(t, json.NewDecoder(rsp.Body).Decode(&entry))
+	assert.Equal(t, "allUsers", entry.Entity)
+	assert.Equal(t, "READER", entry.Role)
+	assert.Equal(t, "raw.txt", entry.Object)
+
+	rsp, err := http.Get(u)
+	assert.NilError(t, err)
+	defer rsp.Body.Close()
+	assert.Equal(t, http.StatusOK, rsp.StatusCode)
+	var acl api.ObjectAccessControls
+	assert.NilError(t, json.NewDecoder(rsp.Body).Decode(&acl))
+	var entities []string
+	for _, e := range acl.Items {
+		entities = append(entities, e.Entity+":"+e.Role)
+	}
+	assert.DeepEqual(t, []string{"user-owner@gcsemu.invalid:OWNER", "allUsers:READER"}, entities)
+
+	// Malformed entities and roles are rejected.
+	for _, body := range []string{
+		`{"entity": "someone@example.com", "role": "READER"}`,
+		`{"entity": "user-", "role": "READER"}`,
+		`{"entity": "project-admins-0", "role": "READER"}`,
+		`{"entity": "allUsers", "role": "WRITER"}`,
+	} {
+		assert.Equal(t, http.StatusBadRequest, insert(body).StatusCode, body)
+	}
+}
@@ -0,0 +1,56 @@
+package gcsemu
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+
+	"google.golang.org/api/storage/v1"
+)
+
+// parseEncryptionHeaders reads a customer-supplied encryption key from the x-goog-encryption-* request headers. It
+// returns nil if no key was supplied, and a 400 error if the key is malformed or doesn't match its SHA-256 hash.
+// The emulator never encrypts anything; it only records the key hash so that reads can require the same key.
+func parseEncryptionHeaders(h http.Header) (*storage.ObjectCustomerEncryption, error) {
+	alg := h.Get("X-Goog-Encryption-Algorithm")
+	key := h.Get("X-Goog-Encryption-Key")
+	keyHash := h.Get("X-Goog-Encryption-Key-Sha256")
+	if alg == "" && key == "" && keyHash == "" {
+		return nil, nil
+	}
+	if alg != "AES256" {
+		return nil, fmtErrorfCode(http.StatusBadRequest, "unsupported encryption algorithm %q", alg)
+	}
+	rawKey, err := base64.StdEncoding.DecodeString(key)
+	if err != nil || len(rawKey) != 32 {
+		return nil, fmtErrorfCode(http.StatusBadRequest, "encryption key must be a base64-encoded 256-bit key")
+	}
+	sum := sha256.Sum256(rawKey)
+	computed := base64.StdEncoding.EncodeToString(sum[:])
+	if keyHash != "" && keyHash != computed {
+		return nil, fmtErrorfCode(http.StatusBadRequest, "encryption key does not match its SHA-256 hash")
+	}
+	return &storage.ObjectCustomerEncryption{
+		EncryptionAlgorithm: alg,
+		KeySha256:           computed,
+	}, nil
+}
+
+// checkEncryptionKey returns a 400 error if obj was written with a customer-supplied encryption key and the request
+// doesn't supply the same key.
+func checkEncryptionKey(obj *storage.Object, h http.Header) error {
+	if obj.CustomerEncryption == nil {
+		return nil
+	}
+	enc, err := parseEncryptionHeaders(h)
+	if err != nil {
+		return err
+	}
+	if enc == nil {
+		return fmtErrorfCode(http.StatusBadRequest, "object %s is encrypted by a customer-supplied encryption key, but none was provided", obj.Name)
+	}
+	if enc.KeySha256 != obj.CustomerEncryption.KeySha256 {
+		return fmtErrorfCode(http.StatusBadRequest, "the provided encryption key does not match the key used to encrypt object %s", obj.Name)
+	}
+	return nil
+}
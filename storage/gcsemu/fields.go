@@ -0,0 +1,136 @@
+package gcsemu
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// fieldMask is a parsed `fields` partial-response parameter, such as "items(name,size),nextPageToken". Each key
+// maps to the mask for its sub-fields, or nil to keep the whole value.
+type fieldMask map[string]fieldMask
+
+// parseFieldMask parses a `fields` parameter. Both "a(b,c)" and "a/b" select sub-fields.
+func parseFieldMask(in string) (fieldMask, error) {
+	mask, rest, err := parseFieldList(in)
+	if err != nil {
+		return nil, err
+	}
+	if rest != "" {
+		return nil, fmt.Errorf("invalid fields parameter %q: unexpected %q", in, rest)
+	}
+	return mask, nil
+}
+
+// parseFieldList parses a comma-separated list of fields, stopping at an unmatched ')' or the end of the input.
+func parseFieldList(in string) (fieldMask, string, error) {
+	mask := fieldMask{}
+	for {
+		name, sub, rest, err := parseField(in)
+		if err != nil {
+			return nil, rest, err
+		}
+		mask.merge(name, sub)
+		if !strings.HasPrefix(rest, ",") {
+			return mask, rest, nil
+		}
+		in = rest[1:]
+	}
+}
+
+// parseField parses a single field and its sub-field selection, if any.
+func parseField(in string) (string, fieldMask, string, error) {
+	i := strings.IndexAny(in, ",()/")
+	if i < 0 {
+		i = len(in)
+	}
+	name := strings.TrimSpace(in[:i])
+	if name == "" {
+		return "", nil, in, fmt.Errorf("invalid fields parameter: missing field name before %q", in)
+	}
+	in = in[i:]
+
+	switch {
+	case strings.HasPrefix(in, "("):
+		sub, rest, err := parseFieldList(in[1:])
+		if err != nil {
+			return "", nil, rest, err
+		}
+		if !strings.HasPrefix(rest, ")") {
+			return "", nil, rest, fmt.Errorf("invalid fields parameter: missing ')'")
+		}
+		return name, sub, rest[1:], nil
+	case strings.HasPrefix(in, "/"):
+		// "a/b" is shorthand for "a(b)".
+		subName, subSub, rest, err := parseField(in[1:])
+		if err != nil {
+			return "", nil, rest, err
+		}
+		return name, fieldMask{subName: subSub}, rest, nil
+	default:
+		return name, nil, in, nil
+	}
+}
+
+// merge adds a field to the mask, combining it with any sub-fields already selected.
+func (m fieldMask) merge(name string, sub fieldMask) {
+	cur, ok := m[name]
+	if !ok {
+		m[name] = sub
+		return
+	}
+	if cur == nil || sub == nil {
+		// One selection already keeps the whole value.
+		m[name] = nil
+		return
+	}
+	for k, v := range sub {
+		cur.merge(k, v)
+	}
+}
+
+// project returns only the parts of a decoded json value selected by the mask. Arrays are projected element-wise.
+func (m fieldMask) project(val interface{}) interface{} {
+	if m == nil {
+		return val
+	}
+	switch v := val.(type) {
+	case map[string]interface{}:
+		ret := map[string]interface{}{}
+		for k, sub := range m {
+			if fv, ok := v[k]; ok {
+				ret[k] = sub.project(fv)
+			}
+		}
+		return ret
+	case []interface{}:
+		ret := make([]interface{}, len(v))
+		for i, ev := range v {
+			ret[i] = m.project(ev)
+		}
+		return ret
+	default:
+		return val
+	}
+}
+
+// shapedResponseWriter carries a request's field mask and projection through to jsonRespond.
+type shapedResponseWriter struct {
+	http.ResponseWriter
+	mask       fieldMask
+	projection string
+}
+
+// projectFields trims rsp to the given mask by round-tripping it through json.
+func projectFields(rsp interface{}, mask fieldMask) (interface{}, error) {
+	b, err := json.Marshal(rsp)
+	if err != nil {
+		return nil, err
+	}
+	var val interface{}
+	if err := json.Unmarshal(b, &val); err != nil {
+		return nil, err
+	}
+	return mask.project(val), nil
+}
@@ -0,0 +1,68 @@
+package gcsemu
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"gotest.tools/v3/assert"
+)
+
+func TestParseFieldMask(t *testing.T) {
+	tcs := []struct {
+		in     string
+		expect fieldMask
+	}{
+		{in: "name", expect: fieldMask{"name": nil}},
+		{in: "name,size", expect: fieldMask{"name": nil, "size": nil}},
+		{in: "items(name,size),nextPageToken", expect: fieldMask{"items": {"name": nil, "size": nil}, "nextPageToken": nil}},
+		{in: "owner/entity", expect: fieldMask{"owner": {"entity": nil}}},
+		{in: "items/owner(entity),items/name", expect: fieldMask{"items": {"owner": {"entity": nil}, "name": nil}}},
+		{in: "metadata(a),metadata", expect: fieldMask{"metadata": nil}},
+	}
+	for _, tc := range tcs {
+		t.Logf("test case: %s", tc.in)
+		mask, err := parseFieldMask(tc.in)
+		assert.NilError(t, err)
+		assert.DeepEqual(t, tc.expect, mask)
+	}
+
+	for _, in := range []string{",name", "items(name", "name)", "items()"} {
+		_, err := parseFieldMask(in)
+		assert.Assert(t, err != nil, "expected an error for %q", in)
+	}
+}
+
+func TestFieldsParameter(t *testing.T) {
+	svr, _ := newTestServer(t, Options{})
+	for _, name := range []string{"a.txt", "b.txt"} {
+		assert.NilError(t, svr.Seed("fields-bucket", name, []byte("hello"), nil))
+	}
+
+	get := func(path string) map[string]interface{} {
+		t.Helper()
+		rsp, err := http.Get(svr.URL + path)
+		assert.NilError(t, err)
+		defer rsp.Body.Close()
+		assert.Equal(t, http.StatusOK, rsp.StatusCode)
+		var val map[string]interface{}
+		assert.NilError(t, json.NewDecoder(rsp.Body).Decode(&val))
+		return val
+	}
+
+	obj := get("/storage/v1/b/fields-bucket/o/a.txt?fields=name")
+	assert.DeepEqual(t, map[string]interface{}{"name": "a.txt"}, obj)
+
+	list := get("/storage/v1/b/fields-bucket/o?fields=items(name,size)")
+	assert.DeepEqual(t, map[string]interface{}{
+		"items": []interface{}{
+			map[string]interface{}{"name": "a.txt", "size": "5"},
+			map[string]interface{}{"name": "b.txt", "size": "5"},
+		},
+	}, list)
+
+	rsp, err := http.Get(svr.URL + "/storage/v1/b/fields-bucket/o/a.txt?fields=" + "items(name")
+	assert.NilError(t, err)
+	defer rsp.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, rsp.StatusCode)
+}
@@ -11,22 +11,25 @@ import (
 	"time"
 
 	cloudstorage "cloud.google.com/go/storage"
+	"github.com/fullstorydev/emulators/storage/gcsutil"
 	"google.golang.org/api/storage/v1"
 )
 
 const (
 	metaExtention = ".emumeta"
+	tmpExtention  = ".emutmp"
 )
 
 type filestore struct {
 	gcsDir string
+	locks  *gcsutil.TransientLockMap // serializes access to the same object or bucket path
 }
 
 var _ Store = (*filestore)(nil)
 
 // NewFileStore returns a new Store that writes to the given directory.
 func NewFileStore(gcsDir string) *filestore {
-	return &filestore{gcsDir: gcsDir}
+	return &filestore{gcsDir: gcsDir, locks: gcsutil.NewTransientLockMap()}
 }
 
 type composeObj struct {
@@ -36,7 +39,41 @@ type composeObj struct {
 
 func (fs *filestore) CreateBucket(bucket string) error {
 	bucketDir := filepath.Join(fs.gcsDir, bucket)
-	return os.MkdirAll(bucketDir, 0777)
+	if err := os.MkdirAll(bucketDir, 0777); err != nil {
+		return err
+	}
+
+	// Record the creation time in a new bucket's metadata, since the directory's own times change with its contents.
+	f := fs.filename(bucket, "")
+	return fs.withLock(f, func() error {
+		fMeta := metaFilename(f)
+		if _, err := os.Stat(fMeta); !os.IsNotExist(err) {
+			return err
+		}
+		meta := &storage.Bucket{TimeCreated: time.Now().UTC().Format(time.RFC3339Nano)}
+		if err := writeFileAtomic(fMeta, mustJson(meta), time.Time{}); err != nil {
+			return fmt.Errorf("could not write metadata file: %s: %w", fMeta, err)
+		}
+		return nil
+	})
+}
+
+// bucketNames returns the names of all buckets, one per directory under gcsDir; see bucketLister.
+func (fs *filestore) bucketNames() ([]string, error) {
+	entries, err := os.ReadDir(fs.gcsDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var names []string
+	for _, e := range entries {
+		if e.IsDir() {
+			names = append(names, e.Name())
+		}
+	}
+	return names, nil
 }
 
 func (fs *filestore) GetBucketMeta(baseUrl HttpBaseUrl, bucket string) (*storage.Bucket, error) {
@@ -49,29 +86,77 @@ func (fs *filestore) GetBucketMeta(baseUrl HttpBaseUrl, bucket string) (*storage
 		return nil, fmt.Errorf("stating %s: %w", f, err)
 	}
 
-	obj := BucketMeta(baseUrl, bucket)
+	obj := &storage.Bucket{}
+	fMeta := metaFilename(f)
+	buf, err := os.ReadFile(fMeta)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("could not read metadata file %s: %w", fMeta, err)
+	}
+	if len(buf) != 0 {
+		if err := json.NewDecoder(bytes.NewReader(buf)).Decode(obj); err != nil {
+			return nil, fmt.Errorf("could not parse bucket attributes %q for %s: %w", buf, f, err)
+		}
+	}
+
+	InitBucketMetaWithUrls(baseUrl, obj, bucket)
 	obj.Updated = fInfo.ModTime().UTC().Format(time.RFC3339Nano)
+	if obj.TimeCreated == "" {
+		// Created before creation times were recorded.
+		obj.TimeCreated = obj.Updated
+	}
 	return obj, nil
 }
 
-func (fs *filestore) Get(baseUrl HttpBaseUrl, bucket string, filename string) (*storage.Object, []byte, error) {
-	obj, err := fs.GetMeta(baseUrl, bucket, filename)
-	if err != nil {
-		return nil, nil, err
-	}
-	if obj == nil {
-		return nil, nil, nil
+func (fs *filestore) UpdateBucketMeta(bucket string, meta *storage.Bucket) error {
+	f := fs.filename(bucket, "")
+	if _, err := os.Stat(f); err != nil {
+		return err
 	}
 
+	ScrubBucketMeta(meta)
+	return fs.withLock(f, func() error {
+		fMeta := metaFilename(f)
+		if err := writeFileAtomic(fMeta, mustJson(meta), time.Time{}); err != nil {
+			return fmt.Errorf("could not write metadata file: %s: %w", fMeta, err)
+		}
+		return nil
+	})
+}
+
+func (fs *filestore) Get(baseUrl HttpBaseUrl, bucket string, filename string) (*storage.Object, []byte, error) {
+	var obj *storage.Object
+	var contents []byte
 	f := fs.filename(bucket, filename)
-	contents, err := os.ReadFile(f)
-	if err != nil {
-		return nil, nil, fmt.Errorf("reading  %s: %w", f, err)
+	err := fs.withLock(f, func() error {
+		var err error
+		obj, err = fs.getMetaLocked(baseUrl, bucket, filename)
+		if err != nil || obj == nil {
+			return err
+		}
+
+		contents, err = os.ReadFile(f)
+		if err != nil {
+			return fmt.Errorf("reading  %s: %w", f, err)
+		}
+		return nil
+	})
+	if err != nil || obj == nil {
+		return nil, nil, err
 	}
 	return obj, contents, nil
 }
 
 func (fs *filestore) GetMeta(baseUrl HttpBaseUrl, bucket string, filename string) (*storage.Object, error) {
+	var obj *storage.Object
+	err := fs.withLock(fs.filename(bucket, filename), func() error {
+		var err error
+		obj, err = fs.getMetaLocked(baseUrl, bucket, filename)
+		return err
+	})
+	return obj, err
+}
+
+func (fs *filestore) getMetaLocked(baseUrl HttpBaseUrl, bucket string, filename string) (*storage.Object, error) {
 	f := fs.filename(bucket, filename)
 	fInfo, err := os.Stat(f)
 	if err != nil {
@@ -84,44 +169,69 @@ func (fs *filestore) GetMeta(baseUrl HttpBaseUrl, bucket string, filename string
 	return fs.ReadMeta(baseUrl, bucket, filename, fInfo)
 }
 
+func (fs *filestore) Stat(bucket string, filename string) (bool, int64, error) {
+	f := fs.filename(bucket, filename)
+	var fInfo os.FileInfo
+	err := fs.withLock(f, func() error {
+		var err error
+		fInfo, err = os.Stat(f)
+		return err
+	})
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, 0, nil
+		}
+		return false, 0, fmt.Errorf("stating %s: %w", f, err)
+	}
+	if fInfo.IsDir() {
+		// Directories aren't objects; see ReadMeta.
+		return false, 0, nil
+	}
+	return true, fInfo.Size(), nil
+}
+
 func (fs *filestore) Add(bucket string, filename string, contents []byte, meta *storage.Object) error {
 	f := fs.filename(bucket, filename)
 	if err := os.MkdirAll(filepath.Dir(f), 0777); err != nil {
 		return fmt.Errorf("could not create dirs for:  %s: %w", f, err)
 	}
 
-	if err := os.WriteFile(f, contents, 0666); err != nil {
-		return fmt.Errorf("could not write:  %s: %w", f, err)
-	}
-
-	// Force a new modification time, since this is what Generation is based on.
-	now := time.Now().UTC()
-	_ = os.Chtimes(f, now, now)
+	return fs.withLock(f, func() error {
+		// Force a new modification time, since this is what Generation is based on.
+		now := time.Now().UTC()
 
-	InitScrubbedMeta(meta, filename)
-	meta.Metageneration = 1
-	if meta.TimeCreated == "" {
-		meta.TimeCreated = now.UTC().Format(time.RFC3339Nano)
-	}
-
-	fMeta := metaFilename(f)
-	if err := os.WriteFile(fMeta, mustJson(meta), 0666); err != nil {
-		return fmt.Errorf("could not write metadata file: %s: %w", fMeta, err)
-	}
+		InitScrubbedMeta(meta, filename)
+		meta.Metageneration = 1
+		if meta.TimeCreated == "" {
+			meta.TimeCreated = now.UTC().Format(time.RFC3339Nano)
+		}
+		if meta.TimeStorageClassUpdated == "" {
+			meta.TimeStorageClassUpdated = meta.TimeCreated
+		}
 
-	return nil
+		fMeta := metaFilename(f)
+		if err := writeFileAtomic(fMeta, mustJson(meta), time.Time{}); err != nil {
+			return fmt.Errorf("could not write metadata file: %s: %w", fMeta, err)
+		}
+		if err := writeFileAtomic(f, contents, now); err != nil {
+			return fmt.Errorf("could not write:  %s: %w", f, err)
+		}
+		return nil
+	})
 }
 
 func (fs *filestore) UpdateMeta(bucket string, filename string, meta *storage.Object, metagen int64) error {
 	InitScrubbedMeta(meta, filename)
 	meta.Metageneration = metagen
 
-	fMeta := metaFilename(fs.filename(bucket, filename))
-	if err := os.WriteFile(fMeta, mustJson(meta), 0666); err != nil {
-		return fmt.Errorf("could not write metadata file: %s: %w", fMeta, err)
-	}
-
-	return nil
+	f := fs.filename(bucket, filename)
+	return fs.withLock(f, func() error {
+		fMeta := metaFilename(f)
+		if err := writeFileAtomic(fMeta, mustJson(meta), time.Time{}); err != nil {
+			return fmt.Errorf("could not write metadata file: %s: %w", fMeta, err)
+		}
+		return nil
+	})
 }
 
 func (fs *filestore) Copy(srcBucket string, srcFile string, dstBucket string, dstFile string) (bool, error) {
@@ -142,6 +252,7 @@ func (fs *filestore) Copy(srcBucket string, srcFile string, dstBucket string, ds
 		return false, err
 	}
 	meta.TimeCreated = "" // reset creation time on the dest file
+	meta.TimeStorageClassUpdated = ""
 	err = fs.Add(dstBucket, dstFile, contents, meta)
 	if err != nil {
 		return false, err
@@ -153,15 +264,18 @@ func (fs *filestore) Copy(srcBucket string, srcFile string, dstBucket string, ds
 func (fs *filestore) Delete(bucket string, filename string) error {
 	f := fs.filename(bucket, filename)
 
-	err := func() error {
+	err := fs.withLock(f, func() error {
 		// Check if the bucket exists
 		if _, err := os.Stat(f); os.IsNotExist(err) {
 			return os.ErrNotExist
 		}
 
-		// Remove the bucket
+		// Remove the bucket and the associated metadata file
 		if filename == "" {
-			return os.RemoveAll(f)
+			if err := os.RemoveAll(f); err != nil {
+				return err
+			}
+			return os.RemoveAll(metaFilename(f))
 		}
 
 		// Remove just the file and the associated metadata file
@@ -174,7 +288,7 @@ func (fs *filestore) Delete(bucket string, filename string) error {
 			return nil
 		}
 		return err
-	}()
+	})
 	if err != nil {
 		if os.IsNotExist(err) {
 			return err
@@ -182,7 +296,58 @@ func (fs *filestore) Delete(bucket string, filename string) error {
 		return fmt.Errorf("could not delete %s: %w", f, err)
 	}
 
-	// Try to delete empty directories
+	fs.removeEmptyDirs(bucket, f)
+	return nil
+}
+
+func (fs *filestore) Move(bucket string, srcFile string, dstFile string) (bool, error) {
+	f1 := fs.filename(bucket, srcFile)
+	f2 := fs.filename(bucket, dstFile)
+	if err := os.MkdirAll(filepath.Dir(f2), 0777); err != nil {
+		return false, fmt.Errorf("could not create dirs for:  %s: %w", f2, err)
+	}
+
+	// Lock both paths, in a consistent order.
+	first, second := f1, f2
+	if second < first {
+		first, second = second, first
+	}
+	found := false
+	err := fs.withLock(first, func() error {
+		return fs.withLock(second, func() error {
+			if _, err := os.Stat(f1); err != nil {
+				if os.IsNotExist(err) {
+					return nil
+				}
+				return err
+			}
+			found = true
+
+			// Force a new modification time, since this is what Generation is based on.
+			now := time.Now()
+			_ = os.Chtimes(f1, now, now)
+
+			// Move the metadata first, so the data file never appears without it.
+			_ = os.Remove(metaFilename(f2))
+			if err := os.Rename(metaFilename(f1), metaFilename(f2)); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+			return os.Rename(f1, f2)
+		})
+	})
+	if err != nil {
+		return false, fmt.Errorf("could not move %s to %s: %w", f1, f2, err)
+	}
+	if found {
+		fs.removeEmptyDirs(bucket, f1)
+	} else {
+		fs.removeEmptyDirs(bucket, f2)
+	}
+	return found, nil
+}
+
+// removeEmptyDirs removes any directories left empty between the given file and its bucket directory.
+func (fs *filestore) removeEmptyDirs(bucket string, f string) {
 	for fp := filepath.Dir(f); len(fp) > len(fs.filename(bucket, "")); fp = filepath.Dir(fp) {
 		files, err := os.ReadDir(fp)
 		if err != nil || len(files) > 0 {
@@ -194,7 +359,6 @@ func (fs *filestore) Delete(bucket string, filename string) error {
 			break
 		}
 	}
-	return nil
 }
 
 func (fs *filestore) ReadMeta(baseUrl HttpBaseUrl, bucket string, filename string, fInfo os.FileInfo) (*storage.Object, error) {
@@ -235,11 +399,35 @@ func metaFilename(filename string) string {
 	return filename + metaExtention
 }
 
+// withLock runs f while holding the lock for the given path.
+func (fs *filestore) withLock(path string, f func() error) error {
+	return fs.locks.Run(context.Background(), path, func(context.Context) error {
+		return f()
+	})
+}
+
+// writeFileAtomic writes contents to a temp file and renames it into place, so readers never observe a partial
+// write. If modTime is non-zero, it is applied to the file before the rename. Callers must hold the path's lock.
+func writeFileAtomic(path string, contents []byte, modTime time.Time) error {
+	tmpPath := path + tmpExtention
+	if err := os.WriteFile(tmpPath, contents, 0666); err != nil {
+		return err
+	}
+	if !modTime.IsZero() {
+		_ = os.Chtimes(tmpPath, modTime, modTime)
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		_ = os.Remove(tmpPath)
+		return err
+	}
+	return nil
+}
+
 func (fs *filestore) Walk(ctx context.Context, bucket string, cb func(ctx context.Context, filename string, fInfo os.FileInfo) error) error {
 	root := filepath.Join(fs.gcsDir, bucket)
 	return filepath.Walk(root, func(path string, fInfo os.FileInfo, err error) error {
-		if strings.HasSuffix(path, metaExtention) {
-			// Ignore metadata files
+		if strings.HasSuffix(path, metaExtention) || strings.HasSuffix(path, tmpExtention) {
+			// Ignore metadata and in-progress temp files
 			return nil
 		}
 
@@ -2,14 +2,20 @@ package gcsemu
 
 import (
 	"context"
+	"crypto/md5"
+	"encoding/base64"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
+	api "google.golang.org/api/storage/v1"
 	"gotest.tools/v3/assert"
 )
 
@@ -65,3 +71,69 @@ func TestFileStore(t *testing.T) {
 		testRawHttp(t, bh, http.DefaultClient, svr.URL)
 	})
 }
+
+func TestFileStoreConcurrentWrites(t *testing.T) {
+	fs := NewFileStore(t.TempDir())
+	const bucket, filename = "concurrent-bucket", "dir/hot-object.txt"
+	assert.NilError(t, fs.CreateBucket(bucket))
+
+	// checkConsistent verifies that contents and metadata came from the same writer.
+	checkConsistent := func() error {
+		meta, contents, err := fs.Get(dontNeedUrls, bucket, filename)
+		if err != nil || meta == nil {
+			return err
+		}
+		sum := md5.Sum(contents)
+		if got := base64.StdEncoding.EncodeToString(sum[:]); got != meta.Md5Hash {
+			return fmt.Errorf("md5 mismatch: contents=%s meta=%s", got, meta.Md5Hash)
+		}
+		if uint64(len(contents)) != meta.Size {
+			return fmt.Errorf("size mismatch: contents=%d meta=%d", len(contents), meta.Size)
+		}
+		if !strings.HasPrefix(string(contents), fmt.Sprintf("writer %s;", meta.Metadata["writer"])) {
+			return fmt.Errorf("contents not written by writer %s", meta.Metadata["writer"])
+		}
+		return nil
+	}
+
+	const writers, rounds = 16, 20
+	var wg sync.WaitGroup
+	errs := make(chan error, 2*writers*rounds)
+	for i := 0; i < writers; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			contents := []byte(strings.Repeat(fmt.Sprintf("writer %d;", i), 10000*(i+1)))
+			sum := md5.Sum(contents)
+			for r := 0; r < rounds; r++ {
+				meta := &api.Object{
+					Md5Hash:  base64.StdEncoding.EncodeToString(sum[:]),
+					Metadata: map[string]string{"writer": strconv.Itoa(i)},
+				}
+				errs <- fs.Add(bucket, filename, contents, meta)
+			}
+		}(i)
+		go func() {
+			defer wg.Done()
+			for r := 0; r < rounds; r++ {
+				errs <- checkConsistent()
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		assert.NilError(t, err)
+	}
+	assert.NilError(t, checkConsistent())
+
+	// No temp files are left behind or visible to listing.
+	var found []string
+	assert.NilError(t, fs.Walk(context.Background(), bucket, func(_ context.Context, filename string, fInfo os.FileInfo) error {
+		if !fInfo.IsDir() {
+			found = append(found, filename)
+		}
+		return nil
+	}))
+	assert.DeepEqual(t, []string{filename}, found)
+}
@@ -13,9 +13,12 @@ import (
 	"net/http"
 	"net/url"
 	"os"
+	"regexp"
 	"strconv"
 	"strings"
+	"sync"
 	"sync/atomic"
+	"time"
 
 	cloudstorage "cloud.google.com/go/storage"
 	"github.com/bluele/gcache"
@@ -35,6 +38,49 @@ type Options struct {
 
 	// Optional log function. `err` will be `nil` for informational/debug messages.
 	Log func(err error, fmt string, args ...interface{})
+
+	// If true, requests carrying V4 signed URL query parameters are checked for structural validity and expiry,
+	// and rejected with 403 if invalid. Signatures themselves are not cryptographically verified.
+	VerifySignedUrls bool
+
+	// Optional email address reported by the project service account endpoint; if empty, a synthetic
+	// address is derived from the requested project.
+	ServiceAccountEmail string
+
+	// Optional content type detection for objects uploaded without one, including by Seed, e.g. sniffing with
+	// http.DetectContentType. As in GCS, compose and copy never detect a type: a composed object has only the type
+	// given in the compose request, and a copy keeps its source's.
+	// If nil, the type is looked up from the file extension, falling back to "application/octet-stream".
+	DetectContentType func(filename string, contents []byte) string
+
+	// If >0, a deleted object's stale metadata and contents can still be read for this long after the delete, to
+	// exercise code that must tolerate eventually consistent deletes. Listings never include deleted objects.
+	PostDeleteVisibility time.Duration
+
+	// Optional owner reported on object metadata under projection=full, and granted OWNER in default and predefined
+	// ACLs; if nil, a synthetic "user-owner@gcsemu.invalid" owner is used.
+	Owner *storage.ObjectOwner
+
+	// If >0, the in-memory store rejects writes that would take the total size of stored object contents past this
+	// many bytes, failing them with 507 Insufficient Storage. Other stores ignore it.
+	MaxStorageBytes int64
+
+	// Optional callback invoked after every request Handler serves, including each request in a batch, with the
+	// bucket and object it addressed (empty if none), the response status, and how long it took.
+	OnRequest func(method, bucket, object string, status int, dur time.Duration)
+
+	// Optional hook consulted before an uploaded object is persisted, to test client handling of failed writes. A
+	// non-nil error fails the upload; return a *googleapi.Error to choose the HTTP status, e.g. 503.
+	FailWrite func(bucket, object string) error
+
+	// Optional base URL, e.g. "https://gcs.example.com/", used in place of the request's scheme and host to build
+	// the MediaLink, SelfLink and upload URLs in responses; set it when clients reach the emulator through a proxy.
+	PublicBaseURL string
+
+	// If >0, lifecycle Delete rules are applied to every bucket in the background this often, as RunLifecycle does
+	// with the current time. Close stops the sweep. Only the built-in stores can list their buckets; with any other
+	// Store the sweep does nothing.
+	LifecycleInterval time.Duration
 }
 
 // GcsEmu is a Google Cloud Storage emulator for development.
@@ -42,12 +88,28 @@ type GcsEmu struct {
 	// The directory which contains gcs emulation.
 	store Store
 	locks *gcsutil.TransientLockMap
+	iam   *iamPolicies
+	// Just-deleted objects that are still readable; see Options.PostDeleteVisibility.
+	deleted *tombstones
 
 	uploadIds gcache.Cache
 	idCounter int32
 
 	verbose bool
 	log     func(err error, fmt string, args ...interface{})
+
+	verifySignedUrls     bool
+	serviceAccountEmail  string
+	detectContentType    func(filename string, contents []byte) string
+	postDeleteVisibility time.Duration
+	owner                *storage.ObjectOwner
+	failWrite            func(bucket, object string) error
+	onRequest            func(method, bucket, object string, status int, dur time.Duration)
+	publicBaseUrl        HttpBaseUrl
+	now                  func() time.Time
+
+	done      chan struct{} // closed by Close, to stop the lifecycle sweep
+	closeOnce sync.Once
 }
 
 // NewGcsEmu creates a new Google Cloud Storage emulator.
@@ -55,16 +117,52 @@ func NewGcsEmu(opts Options) *GcsEmu {
 	if opts.Store == nil {
 		opts.Store = NewMemStore()
 	}
+	if ms, ok := opts.Store.(*memstore); ok && opts.MaxStorageBytes > 0 {
+		ms.setMaxBytes(opts.MaxStorageBytes)
+	}
 	if opts.Log == nil {
 		opts.Log = func(_ error, _ string, _ ...interface{}) {}
 	}
-	return &GcsEmu{
+	if opts.DetectContentType == nil {
+		opts.DetectContentType = detectContentTypeByExtension
+	}
+	if opts.Owner == nil {
+		opts.Owner = defaultObjectOwner()
+	}
+	if opts.PublicBaseURL != "" && !strings.HasSuffix(opts.PublicBaseURL, "/") {
+		opts.PublicBaseURL += "/"
+	}
+	g := &GcsEmu{
 		store:     opts.Store,
 		locks:     gcsutil.NewTransientLockMap(),
+		iam:       newIamPolicies(),
+		deleted:   newTombstones(),
 		uploadIds: gcache.New(1024).LRU().Build(),
 		verbose:   opts.Verbose,
 		log:       opts.Log,
-	}
+
+		verifySignedUrls:     opts.VerifySignedUrls,
+		serviceAccountEmail:  opts.ServiceAccountEmail,
+		detectContentType:    opts.DetectContentType,
+		postDeleteVisibility: opts.PostDeleteVisibility,
+		owner:                opts.Owner,
+		failWrite:            opts.FailWrite,
+		onRequest:            opts.OnRequest,
+		publicBaseUrl:        HttpBaseUrl(opts.PublicBaseURL),
+		now:                  time.Now,
+		done:                 make(chan struct{}),
+	}
+	if opts.LifecycleInterval > 0 {
+		go g.lifecycleLoop(opts.LifecycleInterval)
+	}
+	return g
+}
+
+// Close stops any background work started by the emulator.
+func (g *GcsEmu) Close() {
+	g.closeOnce.Do(func() {
+		close(g.done)
+	})
 }
 
 func lockName(bucket string, filename string) string {
@@ -79,8 +177,21 @@ func (g *GcsEmu) Register(mux *http.ServeMux) {
 
 // Handler handles emulated GCS http requests for "storage.googleapis.com".
 func (g *GcsEmu) Handler(w http.ResponseWriter, r *http.Request) {
-	baseUrl := dontNeedUrls
-	{
+	if g.onRequest != nil {
+		start := time.Now()
+		sw := &statusResponseWriter{ResponseWriter: w}
+		w = sw
+		defer func() {
+			var bucket, object string
+			if p, ok := ParseGcsUrl(r.URL); ok {
+				bucket, object = p.Bucket, p.Object
+			}
+			g.onRequest(r.Method, bucket, object, sw.Status(), time.Since(start))
+		}()
+	}
+
+	baseUrl := g.publicBaseUrl
+	if baseUrl == dontNeedUrls {
 		host := requestHost(r)
 		if host != "" {
 			// Prepend the proto.
@@ -92,6 +203,22 @@ func (g *GcsEmu) Handler(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	if g.verifySignedUrls && r.URL.Query().Get("X-Goog-Signature") != "" {
+		if err := validateSignedUrl(r.URL.Query(), g.now()); err != nil {
+			g.gapiError(w, httpStatusCodeOf(err), err.Error())
+			return
+		}
+	}
+
+	if project, ok := ParseServiceAccountUrl(r.URL); ok {
+		if r.Method != "GET" {
+			g.gapiError(w, http.StatusMethodNotAllowed, "")
+			return
+		}
+		g.handleGcsServiceAccount(w, project)
+		return
+	}
+
 	ctx := r.Context()
 	p, ok := ParseGcsUrl(r.URL)
 	if !ok {
@@ -112,6 +239,22 @@ func (g *GcsEmu) Handler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	var mask fieldMask
+	if fields := r.Form.Get("fields"); fields != "" {
+		if mask, err = parseFieldMask(fields); err != nil {
+			g.gapiError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+	projection := r.Form.Get("projection")
+	if err := g.applyProjection(&storage.Object{}, projection); err != nil {
+		g.gapiError(w, httpStatusCodeOf(err), err.Error())
+		return
+	}
+	if mask != nil || projection != "" {
+		w = &shapedResponseWriter{ResponseWriter: w, mask: mask, projection: projection}
+	}
+
 	if g.verbose {
 		if object == "" {
 			g.log(nil, "%s request for bucket %q", r.Method, bucket)
@@ -120,30 +263,52 @@ func (g *GcsEmu) Handler(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	if aclBucket, aclObject, entity, ok := ParseObjectAclUrl(r.URL); ok {
+		g.handleGcsObjectAcl(ctx, baseUrl, w, r, aclBucket, aclObject, entity)
+		return
+	}
+
 	switch r.Method {
 	case "DELETE":
-		g.handleGcsDelete(ctx, w, bucket, object, conds)
+		var generation int64
+		if v := r.Form.Get("generation"); v != "" && object != "" {
+			if generation, err = strconv.ParseInt(v, 10, 64); err != nil {
+				g.gapiError(w, http.StatusBadRequest, fmt.Sprintf("invalid generation: %q", v))
+				return
+			}
+		}
+		g.handleGcsDelete(ctx, w, bucket, object, generation, conds)
 	case "GET":
 		if object == "" {
 			if strings.HasSuffix(r.URL.Path, "/o") {
 				g.handleGcsListBucket(ctx, baseUrl, w, r.URL.Query(), bucket)
+			} else if strings.HasSuffix(r.URL.Path, "/iam") {
+				g.handleGcsGetIamPolicy(baseUrl, w, bucket)
 			} else {
-				g.handleGcsMetadataRequest(baseUrl, w, bucket, object)
+				g.handleGcsMetadataRequest(baseUrl, w, bucket, object, emptyConds)
 			}
 		} else {
-			alt := r.URL.Query().Get("alt")
-			if alt == "media" || (p.IsPublic && alt == "") {
-				g.handleGcsMediaRequest(baseUrl, w, r.Header.Get("Accept-Encoding"), bucket, object)
-			} else if alt == "json" || (!p.IsPublic && alt == "") {
-				g.handleGcsMetadataRequest(baseUrl, w, bucket, object)
+			media, err := wantsMedia(r.URL, p.IsPublic)
+			if err != nil {
+				g.gapiError(w, httpStatusCodeOf(err), err.Error())
+			} else if media {
+				g.handleGcsMediaRequest(baseUrl, w, r, bucket, object, conds)
 			} else {
-				// should never happen?
-				g.gapiError(w, http.StatusBadRequest, fmt.Sprintf("unsupported value for alt param to GET: %q\n%s", alt, maybeNotImplementedErrorMsg))
+				g.handleGcsMetadataRequest(baseUrl, w, bucket, object, conds)
 			}
 		}
+	case "HEAD":
+		if object == "" {
+			g.gapiError(w, http.StatusMethodNotAllowed, "")
+		} else {
+			// Same headers as a media GET; handleGcsMediaRequest skips the body.
+			g.handleGcsMediaRequest(baseUrl, w, r, bucket, object, conds)
+		}
 	case "PATCH":
 		alt := r.URL.Query().Get("alt")
-		if alt == "json" || r.Header.Get("Content-Type") == "application/json" {
+		if object == "" {
+			g.handleGcsUpdateBucketRequest(ctx, baseUrl, w, r, bucket, conds)
+		} else if alt == "json" || r.Header.Get("Content-Type") == "application/json" {
 			g.handleGcsUpdateMetadataRequest(ctx, baseUrl, w, r, bucket, object, conds)
 		} else {
 			// should never happen?
@@ -151,14 +316,16 @@ func (g *GcsEmu) Handler(w http.ResponseWriter, r *http.Request) {
 		}
 	case "POST":
 		if bucket == "" {
-			g.handleGcsNewBucket(ctx, w, r, conds)
+			g.handleGcsNewBucket(ctx, baseUrl, w, r, conds)
 		} else if object == "" {
 			g.handleGcsNewObject(ctx, baseUrl, w, r, bucket, conds)
 		} else if strings.Contains(object, "/compose") {
 			// TODO: enforce other conditions outside of generation
 			g.handleGcsCompose(ctx, baseUrl, w, r, bucket, object, conds)
 		} else if strings.Contains(object, "/rewriteTo/") {
-			g.handleGcsCopy(ctx, baseUrl, w, bucket, object)
+			g.handleGcsCopy(ctx, baseUrl, w, r, bucket, object)
+		} else if strings.Contains(object, "/moveTo/") {
+			g.handleGcsMove(ctx, baseUrl, w, r, bucket, object, conds)
 		} else if r.Form.Get("upload_id") != "" {
 			g.handleGcsNewObjectResume(ctx, baseUrl, w, r, r.Form.Get("upload_id"))
 		} else {
@@ -166,7 +333,9 @@ func (g *GcsEmu) Handler(w http.ResponseWriter, r *http.Request) {
 			g.gapiError(w, http.StatusBadRequest, fmt.Sprintf("unsupported POST request: %v\n%s", r.URL, maybeNotImplementedErrorMsg))
 		}
 	case "PUT":
-		if r.Form.Get("upload_id") != "" {
+		if object == "" && strings.HasSuffix(r.URL.Path, "/iam") {
+			g.handleGcsSetIamPolicy(ctx, baseUrl, w, r, bucket)
+		} else if r.Form.Get("upload_id") != "" {
 			g.handleGcsNewObjectResume(ctx, baseUrl, w, r, r.Form.Get("upload_id"))
 		} else {
 			// unsupported method, or maybe should never happen
@@ -177,10 +346,48 @@ func (g *GcsEmu) Handler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// wantsMedia reports whether an object GET should return the object's contents rather than its json metadata. The
+// alt param decides; without it, public and download urls return contents, and api urls return metadata.
+func wantsMedia(u *url.URL, isPublic bool) (bool, error) {
+	isDownload := strings.HasPrefix(u.Path, "/download/")
+	alt := ""
+	for _, v := range u.Query()["alt"] {
+		if alt != "" && v != alt {
+			return false, fmtErrorfCode(http.StatusBadRequest, "conflicting values for alt param: %q and %q", alt, v)
+		}
+		alt = v
+	}
+
+	switch alt {
+	case "":
+		return isPublic || isDownload, nil
+	case "media":
+		return true, nil
+	case "json":
+		if isDownload {
+			return false, fmtErrorfCode(http.StatusBadRequest, "alt=json is not supported for download urls")
+		}
+		return false, nil
+	default:
+		return false, fmtErrorfCode(http.StatusBadRequest, "unsupported value for alt param to GET: %q\n%s", alt, maybeNotImplementedErrorMsg)
+	}
+}
+
+func (g *GcsEmu) handleGcsServiceAccount(w http.ResponseWriter, project string) {
+	email := g.serviceAccountEmail
+	if email == "" {
+		email = fmt.Sprintf("service-%s@gs-project-accounts.iam.gserviceaccount.com", project)
+	}
+	g.jsonRespond(w, &storage.ServiceAccount{
+		Kind:         "storage#serviceAccount",
+		EmailAddress: email,
+	})
+}
+
 func (g *GcsEmu) handleGcsCompose(ctx context.Context, baseUrl HttpBaseUrl, w http.ResponseWriter, r *http.Request, bucket, object string, conds cloudstorage.Conditions) {
 	var req storage.ComposeRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		g.gapiError(w, http.StatusBadRequest, "bad compose request")
+		g.gapiError(w, http.StatusBadRequest, fmt.Sprintf("failed to parse compose request: %s", err))
 		return
 	}
 	// Get the composed object name from the path
@@ -216,13 +423,25 @@ func (g *GcsEmu) handleGcsCompose(ctx context.Context, baseUrl HttpBaseUrl, w ht
 		g.gapiError(w, httpStatusCodeOf(err), fmt.Sprintf("failed to compose objects: %s", err))
 		return
 	}
-	g.jsonRespond(w, &obj)
+	g.jsonRespond(w, obj)
 }
 
 func (g *GcsEmu) handleGcsListBucket(ctx context.Context, baseUrl HttpBaseUrl, w http.ResponseWriter, params url.Values, bucket string) {
 	delimiter := params.Get("delimiter")
 	prefix := params.Get("prefix")
 	pageToken := params.Get("pageToken")
+	startOffset := params.Get("startOffset")
+	endOffset := params.Get("endOffset")
+
+	var glob *regexp.Regexp
+	if matchGlob := params.Get("matchGlob"); matchGlob != "" {
+		var err error
+		glob, err = globRegexp(matchGlob)
+		if err != nil {
+			g.gapiError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
 
 	var cursor string
 	if pageToken != "" {
@@ -245,10 +464,12 @@ func (g *GcsEmu) handleGcsListBucket(ctx context.Context, baseUrl HttpBaseUrl, w
 		}
 	}
 
-	g.makeBucketListResults(ctx, baseUrl, w, delimiter, cursor, prefix, bucket, maxResults)
+	g.makeBucketListResults(ctx, baseUrl, w, delimiter, cursor, prefix, startOffset, endOffset, glob, bucket, maxResults)
 }
 
-func (g *GcsEmu) handleGcsDelete(ctx context.Context, w http.ResponseWriter, bucket string, filename string, conds cloudstorage.Conditions) {
+// handleGcsDelete deletes a bucket, or an object. If generation is non-zero, the object is only deleted if that is
+// its live generation; the emulator keeps no older generations.
+func (g *GcsEmu) handleGcsDelete(ctx context.Context, w http.ResponseWriter, bucket string, filename string, generation int64, conds cloudstorage.Conditions) {
 	err := g.locks.Run(ctx, lockName(bucket, filename), func(ctx context.Context) error {
 		// Find the existing file / meta.
 		obj, err := g.store.GetMeta(dontNeedUrls, bucket, filename)
@@ -256,17 +477,45 @@ func (g *GcsEmu) handleGcsDelete(ctx context.Context, w http.ResponseWriter, buc
 			return fmt.Errorf("failed to check existence of %s/%s: %w", bucket, filename, err)
 		}
 
+		if generation != 0 && (obj == nil || obj.Generation != generation) {
+			return fmtErrorfCode(http.StatusNotFound, "No such object: %s/%s#%d", bucket, filename, generation)
+		}
+
 		if err := validateConds(obj, conds); err != nil {
 			return err
 		}
 
+		if err := checkRetention(obj, g.now()); err != nil {
+			return err
+		}
+
+		var contents []byte
+		keep := filename != "" && obj != nil && g.postDeleteVisibility > 0
+		if keep {
+			// Keep the contents around, so reads can still see the object for a while.
+			if obj, contents, err = g.store.Get(dontNeedUrls, bucket, filename); err != nil {
+				return fmt.Errorf("failed to read %s/%s: %w", bucket, filename, err)
+			}
+		}
+
 		if err := g.store.Delete(bucket, filename); err != nil {
 			if os.IsNotExist(err) {
-				return fmtErrorfCode(http.StatusNotFound, "%s/%s not found", bucket, filename)
+				if filename != "" {
+					return g.objectNotFound(bucket, filename)
+				}
+				return fmtErrorfCode(http.StatusNotFound, "%s not found", bucket)
 			}
 			return fmt.Errorf("failed to delete %s/%s: %w", bucket, filename, err)
 		}
 
+		if filename == "" {
+			g.iam.delete(bucket)
+		}
+		if keep && obj != nil {
+			now := g.now()
+			g.deleted.add(bucket, filename, obj, contents, now, now.Add(g.postDeleteVisibility))
+		}
+
 		return nil
 	})
 	if err != nil {
@@ -277,14 +526,28 @@ func (g *GcsEmu) handleGcsDelete(ctx context.Context, w http.ResponseWriter, buc
 	w.WriteHeader(http.StatusNoContent)
 }
 
-func (g *GcsEmu) handleGcsMediaRequest(baseUrl HttpBaseUrl, w http.ResponseWriter, acceptEncoding, bucket, filename string) {
+// handleGcsMediaRequest writes an object's contents. Generation and metageneration preconditions are checked against
+// the object being read, so a missing object is still not found rather than a failed precondition.
+func (g *GcsEmu) handleGcsMediaRequest(baseUrl HttpBaseUrl, w http.ResponseWriter, r *http.Request, bucket, filename string, conds cloudstorage.Conditions) {
 	obj, contents, err := g.store.Get(baseUrl, bucket, filename)
 	if err != nil {
 		g.gapiError(w, http.StatusInternalServerError, fmt.Sprintf("failed to check existence of %s/%s: %s", bucket, filename, err))
 		return
 	}
 	if obj == nil {
-		g.gapiError(w, http.StatusNotFound, fmt.Sprintf("%s/%s not found", bucket, filename))
+		obj, contents = g.deleted.get(baseUrl, bucket, filename, g.now())
+	}
+	if obj == nil {
+		err := g.objectNotFound(bucket, filename)
+		g.gapiError(w, httpStatusCodeOf(err), err.Error())
+		return
+	}
+	if err := validateConds(obj, conds); err != nil {
+		g.gapiError(w, httpStatusCodeOf(err), err.Error())
+		return
+	}
+	if err := checkEncryptionKey(obj, r.Header); err != nil {
+		g.gapiError(w, httpStatusCodeOf(err), err.Error())
 		return
 	}
 
@@ -292,12 +555,31 @@ func (g *GcsEmu) handleGcsMediaRequest(baseUrl HttpBaseUrl, w http.ResponseWrite
 	w.Header().Set("X-Goog-Generation", strconv.FormatInt(obj.Generation, 10))
 	w.Header().Set("X-Goog-Metageneration", strconv.FormatInt(obj.Metageneration, 10))
 	w.Header().Set("Access-Control-Allow-Origin", "*")
-	w.Header().Set("Access-Control-Expose-Headers", "Content-Type, Content-Length, Content-Encoding, Date, X-Goog-Generation, X-Goog-Metageneration")
-	w.Header().Set("Content-Disposition", obj.ContentDisposition)
+	w.Header().Set("Access-Control-Expose-Headers", "Content-Type, Content-Length, Content-Encoding, Content-Range, Date, X-Goog-Generation, X-Goog-Metageneration")
+	if obj.ContentDisposition != "" {
+		w.Header().Set("Content-Disposition", obj.ContentDisposition)
+	}
+	if obj.CacheControl != "" {
+		w.Header().Set("Cache-Control", obj.CacheControl)
+	}
+	etag := fmt.Sprintf(`"%d-%d"`, obj.Generation, obj.Metageneration)
+	w.Header().Set("ETag", etag)
+	updated, err := time.Parse(time.RFC3339Nano, obj.Updated)
+	if err == nil {
+		w.Header().Set("Last-Modified", updated.UTC().Format(http.TimeFormat))
+	}
+	if notModified(r, etag, updated) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	head := r.Method == "HEAD"
 
 	if obj.ContentEncoding == "gzip" {
-		if strings.Contains(acceptEncoding, "gzip") {
+		if strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") {
 			w.Header().Set("Content-Encoding", "gzip")
+		} else if head {
+			// The uncompressed length isn't known without decompressing.
+			return
 		} else {
 			// Uncompress on behalf of the client.
 			buf := bytes.NewBuffer(contents)
@@ -315,14 +597,57 @@ func (g *GcsEmu) handleGcsMediaRequest(baseUrl HttpBaseUrl, w http.ResponseWrite
 		}
 	}
 
+	if rng := parseRangeHeader(r.Header.Get("Range"), int64(len(contents))); rng != nil {
+		if rng.lo >= rng.sz {
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", rng.sz))
+			g.gapiError(w, http.StatusRequestedRangeNotSatisfiable, "The requested range cannot be satisfied.")
+			return
+		}
+		contents = contents[rng.lo : rng.hi+1]
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", rng.lo, rng.hi, rng.sz))
+		w.Header().Set("Content-Length", strconv.Itoa(len(contents)))
+		w.WriteHeader(http.StatusPartialContent)
+		if head {
+			return
+		}
+		if _, err := w.Write(contents); err != nil {
+			g.log(err, "failed to copy from %s/%s", bucket, filename)
+		}
+		return
+	}
+
 	// Just write the contents
 	w.Header().Set("Content-Length", strconv.Itoa(len(contents)))
+	if head {
+		return
+	}
 	if _, err := w.Write(contents); err != nil {
 		g.gapiError(w, http.StatusInternalServerError, fmt.Sprintf("failed to copy from %s/%s: %s", bucket, filename, err))
 	}
 }
 
-func (g *GcsEmu) handleGcsMetadataRequest(baseUrl HttpBaseUrl, w http.ResponseWriter, bucket string, filename string) {
+// notModified reports whether the request's If-None-Match or, absent that, If-Modified-Since header shows the
+// client already has the current version of an object.
+func notModified(r *http.Request, etag string, updated time.Time) bool {
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		for _, tag := range strings.Split(inm, ",") {
+			tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+			if tag == "*" || tag == etag {
+				return true
+			}
+		}
+		return false
+	}
+	if ims := r.Header.Get("If-Modified-Since"); ims != "" && !updated.IsZero() {
+		t, err := http.ParseTime(ims)
+		return err == nil && !updated.Truncate(time.Second).After(t)
+	}
+	return false
+}
+
+// handleGcsMetadataRequest writes bucket or object metadata, checking any preconditions against the object as for
+// media reads.
+func (g *GcsEmu) handleGcsMetadataRequest(baseUrl HttpBaseUrl, w http.ResponseWriter, bucket string, filename string, conds cloudstorage.Conditions) {
 	var obj interface{}
 	var err error
 	if filename == "" {
@@ -334,6 +659,9 @@ func (g *GcsEmu) handleGcsMetadataRequest(baseUrl HttpBaseUrl, w http.ResponseWr
 	} else {
 		var o *storage.Object
 		o, err = g.store.GetMeta(baseUrl, bucket, filename)
+		if o == nil && err == nil {
+			o, _ = g.deleted.get(baseUrl, bucket, filename, g.now())
+		}
 		if o != nil {
 			obj = o
 		}
@@ -344,9 +672,20 @@ func (g *GcsEmu) handleGcsMetadataRequest(baseUrl HttpBaseUrl, w http.ResponseWr
 		return
 	}
 	if obj == nil {
-		g.gapiError(w, http.StatusNotFound, fmt.Sprintf("%s/%s not found", bucket, filename))
+		if filename == "" {
+			g.gapiError(w, http.StatusNotFound, fmt.Sprintf("%s not found", bucket))
+		} else {
+			err := g.objectNotFound(bucket, filename)
+			g.gapiError(w, httpStatusCodeOf(err), err.Error())
+		}
 		return
 	}
+	if o, ok := obj.(*storage.Object); ok {
+		if err := validateConds(o, conds); err != nil {
+			g.gapiError(w, httpStatusCodeOf(err), err.Error())
+			return
+		}
+	}
 	g.jsonRespond(w, obj)
 }
 
@@ -370,10 +709,35 @@ func (g *GcsEmu) handleGcsUpdateMetadataRequest(ctx context.Context, baseUrl Htt
 
 		// Update via json decode.
 		metagen := obj.Metageneration
-		err = json.NewDecoder(r.Body).Decode(&obj)
+		retentionExpirationTime := obj.RetentionExpirationTime
+		storageClass, timeStorageClassUpdated := obj.StorageClass, obj.TimeStorageClassUpdated
+		metadata := obj.Metadata
+		body, err := io.ReadAll(r.Body)
 		if err != nil {
+			return fmtErrorfCode(http.StatusBadRequest, "failed to read request: %w", err)
+		}
+		var patch struct {
+			Metadata json.RawMessage `json:"metadata"`
+		}
+		if err := json.Unmarshal(body, &patch); err != nil {
 			return fmtErrorfCode(http.StatusBadRequest, "failed to parse request: %w", err)
 		}
+		obj.Metadata = nil
+		if err := json.Unmarshal(body, &obj); err != nil {
+			return fmtErrorfCode(http.StatusBadRequest, "failed to parse request: %w", err)
+		}
+		if obj.Metadata, err = patchMetadata(metadata, patch.Metadata); err != nil {
+			return fmtErrorfCode(http.StatusBadRequest, "failed to parse metadata: %w", err)
+		}
+		if err := validateMetadataSize(obj.Metadata); err != nil {
+			return err
+		}
+		obj.RetentionExpirationTime = retentionExpirationTime // output only
+		// Storage class can only be changed by a rewrite.
+		obj.StorageClass, obj.TimeStorageClassUpdated = storageClass, timeStorageClassUpdated
+		if err := g.applyPredefinedAcl(obj, r.Form.Get("predefinedAcl")); err != nil {
+			return err
+		}
 
 		if err := g.store.UpdateMeta(bucket, filename, obj, metagen+1); err != nil {
 			return fmt.Errorf("failed to update attrs of %s/%s: %w", bucket, filename, err)
@@ -387,7 +751,8 @@ func (g *GcsEmu) handleGcsUpdateMetadataRequest(ctx context.Context, baseUrl Htt
 		return
 	}
 	if obj == nil {
-		g.gapiError(w, http.StatusNotFound, fmt.Sprintf("%s/%s not found", bucket, filename))
+		err := g.objectNotFound(bucket, filename)
+		g.gapiError(w, httpStatusCodeOf(err), err.Error())
 		return
 	}
 
@@ -400,8 +765,9 @@ func (g *GcsEmu) handleGcsUpdateMetadataRequest(ctx context.Context, baseUrl Htt
 	g.jsonRespond(w, obj)
 }
 
-func (g *GcsEmu) handleGcsCopy(ctx context.Context, baseUrl HttpBaseUrl, w http.ResponseWriter, b1 string, objectPaths string) {
+func (g *GcsEmu) handleGcsCopy(ctx context.Context, baseUrl HttpBaseUrl, w http.ResponseWriter, r *http.Request, b1 string, objectPaths string) {
 	// TODO(dk): this operation supports conditionals and metadata rewriting, but the emulator implementation currently does not.
+	// Only a change of storage class is honored from the destination metadata, along with destinationPredefinedAcl.
 	// See https://cloud.google.com/storage/docs/json_api/v1/objects/rewrite
 	parts := strings.Split(objectPaths, "/rewriteTo/b/")
 	// Copy is implemented using the Rewrite API, with object strings of format /o/sourceObject/rewriteTo/b/destinationBucket/o/destinationObject
@@ -418,24 +784,67 @@ func (g *GcsEmu) handleGcsCopy(ctx context.Context, baseUrl HttpBaseUrl, w http.
 	b2 := destParts[0]
 	f2 := destParts[1]
 
+	var dstMeta storage.Object
+	if err := json.NewDecoder(r.Body).Decode(&dstMeta); err != nil && err != io.EOF {
+		g.gapiError(w, http.StatusBadRequest, fmt.Sprintf("failed to parse rewrite request: %s", err))
+		return
+	}
+
+	predefinedAcl := r.Form.Get("destinationPredefinedAcl")
+	if predefinedAcl != "" {
+		if _, err := g.predefinedObjectAcl(predefinedAcl); err != nil {
+			g.gapiError(w, httpStatusCodeOf(err), err.Error())
+			return
+		}
+	}
+
 	// Must lock the destination object.
 	var obj *storage.Object
 	err := g.locks.Run(ctx, lockName(b2, f2), func(ctx context.Context) error {
+		existing, err := g.store.GetMeta(baseUrl, b2, f2)
+		if err != nil {
+			return fmt.Errorf("failed to check existence of %s/%s: %w", b2, f2, err)
+		}
+		if err := checkRetention(existing, g.now()); err != nil {
+			return err
+		}
+
 		if ok, err := g.store.Copy(b1, f1, b2, f2); err != nil {
 			return err
 		} else if !ok {
 			return nil // file missing
-		} else {
-			obj, err = g.store.GetMeta(baseUrl, b2, f2)
+		}
+
+		obj, err = g.store.GetMeta(baseUrl, b2, f2)
+		if err != nil {
+			return err
+		}
+		changeClass := dstMeta.StorageClass != "" && dstMeta.StorageClass != obj.StorageClass
+		if !changeClass && predefinedAcl == "" {
+			return nil
+		}
+
+		if changeClass {
+			// The rewritten object is new, so its storage class changed at creation time.
+			obj.StorageClass = dstMeta.StorageClass
+			obj.TimeStorageClassUpdated = obj.TimeCreated
+		}
+		if err := g.applyPredefinedAcl(obj, predefinedAcl); err != nil {
 			return err
 		}
+		if err := g.store.UpdateMeta(b2, f2, obj, obj.Metageneration); err != nil {
+			return fmt.Errorf("failed to update meta of %s/%s: %w", b2, f2, err)
+		}
+		obj, err = g.store.GetMeta(baseUrl, b2, f2)
+		return err
 	})
 	if err != nil {
 		g.gapiError(w, httpStatusCodeOf(err), fmt.Sprintf("failed to copy: %s", err))
 		return
 	}
 	if obj == nil {
-		g.gapiError(w, http.StatusNotFound, fmt.Sprintf("%s not found", b1+"/"+f1))
+		err := g.objectNotFound(b1, f1)
+		g.gapiError(w, httpStatusCodeOf(err), err.Error())
 		return
 	}
 
@@ -451,13 +860,84 @@ func (g *GcsEmu) handleGcsCopy(ctx context.Context, baseUrl HttpBaseUrl, w http.
 	g.jsonRespond(w, &rr)
 }
 
+func (g *GcsEmu) handleGcsMove(ctx context.Context, baseUrl HttpBaseUrl, w http.ResponseWriter, r *http.Request, bucket string, objectPaths string, conds cloudstorage.Conditions) {
+	// Move renames within a bucket, with object strings of format /o/sourceObject/moveTo/o/destinationObject.
+	// See https://cloud.google.com/storage/docs/json_api/v1/objects/move
+	parts := strings.Split(objectPaths, "/moveTo/o/")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		g.gapiError(w, http.StatusBadRequest, fmt.Sprintf("Bad move request format: %s", objectPaths))
+		return
+	}
+	src, dst := parts[0], parts[1]
+	if src == dst {
+		g.gapiError(w, http.StatusBadRequest, "source and destination objects must differ")
+		return
+	}
+
+	// The usual preconditions apply to the destination; ifSource* preconditions apply to the source.
+	srcConds, err := parseSourceConds(r.Form)
+	if err != nil {
+		g.gapiError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// Must lock both objects, in a consistent order.
+	first, second := lockName(bucket, src), lockName(bucket, dst)
+	if second < first {
+		first, second = second, first
+	}
+	var obj *storage.Object
+	err = g.locks.Run(ctx, first, func(ctx context.Context) error {
+		return g.locks.Run(ctx, second, func(ctx context.Context) error {
+			srcObj, err := g.store.GetMeta(dontNeedUrls, bucket, src)
+			if err != nil {
+				return fmt.Errorf("failed to check existence of %s/%s: %w", bucket, src, err)
+			}
+			if srcObj == nil {
+				return g.objectNotFound(bucket, src)
+			}
+			if err := validateConds(srcObj, srcConds); err != nil {
+				return err
+			}
+			if err := checkRetention(srcObj, g.now()); err != nil {
+				return err
+			}
+
+			dstObj, err := g.store.GetMeta(dontNeedUrls, bucket, dst)
+			if err != nil {
+				return fmt.Errorf("failed to check existence of %s/%s: %w", bucket, dst, err)
+			}
+			if err := validateConds(dstObj, conds); err != nil {
+				return err
+			}
+			if err := checkRetention(dstObj, g.now()); err != nil {
+				return err
+			}
+
+			if ok, err := g.store.Move(bucket, src, dst); err != nil {
+				return err
+			} else if !ok {
+				return fmtErrorfCode(http.StatusNotFound, "%s/%s not found", bucket, src)
+			}
+			obj, err = g.store.GetMeta(baseUrl, bucket, dst)
+			return err
+		})
+	})
+	if err != nil {
+		g.gapiError(w, httpStatusCodeOf(err), fmt.Sprintf("failed to move: %s", err))
+		return
+	}
+
+	g.jsonRespond(w, obj)
+}
+
 type uploadData struct {
 	Object storage.Object
 	Conds  cloudstorage.Conditions
 	data   []byte
 }
 
-func (g *GcsEmu) handleGcsNewBucket(ctx context.Context, w http.ResponseWriter, r *http.Request, _ cloudstorage.Conditions) {
+func (g *GcsEmu) handleGcsNewBucket(ctx context.Context, baseUrl HttpBaseUrl, w http.ResponseWriter, r *http.Request, _ cloudstorage.Conditions) {
 	var bucket storage.Bucket
 	if err := json.NewDecoder(r.Body).Decode(&bucket); err != nil {
 		g.gapiError(w, http.StatusBadRequest, "failed to parse body as json")
@@ -465,11 +945,97 @@ func (g *GcsEmu) handleGcsNewBucket(ctx context.Context, w http.ResponseWriter,
 	}
 	bucketName := bucket.Name
 
+	var meta *storage.Bucket
 	err := g.locks.Run(ctx, lockName(bucketName, ""), func(ctx context.Context) error {
+		existing, err := g.store.GetBucketMeta(baseUrl, bucketName)
+		if err != nil {
+			return fmt.Errorf("failed to get meta for %s: %w", bucketName, err)
+		}
 		if err := g.store.CreateBucket(bucketName); err != nil {
 			return fmt.Errorf("could not create bucket %s: %w", bucketName, err)
 		}
-		return nil
+		if existing == nil {
+			// Only a newly created bucket takes on the requested metadata, keeping the creation time the store
+			// recorded.
+			created, err := g.store.GetBucketMeta(baseUrl, bucketName)
+			if err != nil {
+				return fmt.Errorf("failed to get meta for %s: %w", bucketName, err)
+			}
+			bucket.TimeCreated = created.TimeCreated
+			if bucket.RetentionPolicy != nil {
+				bucket.RetentionPolicy.EffectiveTime = g.now().UTC().Format(time.RFC3339Nano)
+			}
+			if err := g.store.UpdateBucketMeta(bucketName, &bucket); err != nil {
+				return fmt.Errorf("could not update bucket %s: %w", bucketName, err)
+			}
+		}
+		meta, err = g.store.GetBucketMeta(baseUrl, bucketName)
+		return err
+	})
+
+	if err != nil {
+		g.gapiError(w, httpStatusCodeOf(err), err.Error())
+		return
+	}
+
+	g.jsonRespond(w, meta)
+}
+
+func (g *GcsEmu) handleGcsUpdateBucketRequest(ctx context.Context, baseUrl HttpBaseUrl, w http.ResponseWriter, r *http.Request, bucket string, conds cloudstorage.Conditions) {
+	var meta *storage.Bucket
+	err := g.locks.Run(ctx, lockName(bucket, ""), func(ctx context.Context) error {
+		var err error
+		meta, err = g.store.GetBucketMeta(baseUrl, bucket)
+		if err != nil {
+			return fmt.Errorf("failed to get meta for %s: %w", bucket, err)
+		}
+		if meta == nil {
+			return fmtErrorfCode(http.StatusNotFound, "The specified bucket does not exist: %s", bucket)
+		}
+		if conds.MetagenerationMatch != 0 && meta.Metageneration != conds.MetagenerationMatch {
+			return fmtErrorfCode(http.StatusPreconditionFailed, "precondition failed")
+		}
+		if conds.MetagenerationNotMatch != 0 && meta.Metageneration == conds.MetagenerationNotMatch {
+			// not-match failures use a different code
+			return fmtErrorfCode(http.StatusNotModified, "precondition failed")
+		}
+
+		// Update via json decode; fields absent from the request keep their values.
+		metagen := meta.Metageneration
+		retentionPolicy := meta.RetentionPolicy
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			return fmtErrorfCode(http.StatusBadRequest, "failed to read request: %w", err)
+		}
+		var patch struct {
+			Labels json.RawMessage `json:"labels"`
+		}
+		if err := json.Unmarshal(body, &patch); err != nil {
+			return fmtErrorfCode(http.StatusBadRequest, "failed to parse request: %w", err)
+		}
+		labels := meta.Labels
+		meta.Labels = nil
+		meta.RetentionPolicy = nil
+		if err := json.Unmarshal(body, meta); err != nil {
+			return fmtErrorfCode(http.StatusBadRequest, "failed to parse request: %w", err)
+		}
+		if meta.Labels, err = patchMetadata(labels, patch.Labels); err != nil {
+			return fmtErrorfCode(http.StatusBadRequest, "failed to parse labels: %w", err)
+		}
+		// The bucket's identity comes from the URL, not the request body.
+		meta.Name, meta.Id = bucket, bucket
+		if meta.RetentionPolicy == nil {
+			meta.RetentionPolicy = retentionPolicy
+		} else if retentionPolicy == nil || meta.RetentionPolicy.RetentionPeriod != retentionPolicy.RetentionPeriod {
+			meta.RetentionPolicy.EffectiveTime = g.now().UTC().Format(time.RFC3339Nano)
+		}
+		meta.Metageneration = metagen + 1
+
+		if err := g.store.UpdateBucketMeta(bucket, meta); err != nil {
+			return fmt.Errorf("could not update bucket %s: %w", bucket, err)
+		}
+		meta, err = g.store.GetBucketMeta(baseUrl, bucket)
+		return err
 	})
 
 	if err != nil {
@@ -477,7 +1043,7 @@ func (g *GcsEmu) handleGcsNewBucket(ctx context.Context, w http.ResponseWriter,
 		return
 	}
 
-	g.jsonRespond(w, bucket)
+	g.jsonRespond(w, meta)
 }
 
 func (g *GcsEmu) handleGcsNewObject(ctx context.Context, baseUrl HttpBaseUrl, w http.ResponseWriter, r *http.Request, bucket string, conds cloudstorage.Conditions) {
@@ -497,10 +1063,19 @@ func (g *GcsEmu) handleGcsNewObject(ctx context.Context, baseUrl HttpBaseUrl, w
 		}
 
 		obj := &storage.Object{
-			Bucket:      bucket,
-			ContentType: r.Header.Get("Content-Type"),
-			Name:        name,
-			Size:        uint64(len(contents)),
+			Bucket:          bucket,
+			ContentEncoding: r.Form.Get("contentEncoding"),
+			ContentType:     r.Header.Get("Content-Type"),
+			Name:            name,
+			Size:            uint64(len(contents)),
+		}
+		if err := g.applyPredefinedAcl(obj, r.Form.Get("predefinedAcl")); err != nil {
+			g.gapiError(w, httpStatusCodeOf(err), err.Error())
+			return
+		}
+		if obj.CustomerEncryption, err = parseEncryptionHeaders(r.Header); err != nil {
+			g.gapiError(w, httpStatusCodeOf(err), err.Error())
+			return
 		}
 
 		meta, err := g.finishUpload(ctx, baseUrl, obj, contents, bucket, conds)
@@ -516,10 +1091,20 @@ func (g *GcsEmu) handleGcsNewObject(ctx context.Context, baseUrl HttpBaseUrl, w
 	case "resumable":
 		var obj storage.Object
 		if err := json.NewDecoder(r.Body).Decode(&obj); err != nil {
-			g.gapiError(w, http.StatusBadRequest, "failed to parse body as json")
+			g.gapiError(w, http.StatusBadRequest, fmt.Sprintf("failed to parse body as json: %s", err))
 			return
 		}
 		obj.Bucket = bucket
+		if err := g.applyPredefinedAcl(&obj, r.Form.Get("predefinedAcl")); err != nil {
+			g.gapiError(w, httpStatusCodeOf(err), err.Error())
+			return
+		}
+		enc, err := parseEncryptionHeaders(r.Header)
+		if err != nil {
+			g.gapiError(w, httpStatusCodeOf(err), err.Error())
+			return
+		}
+		obj.CustomerEncryption = enc
 
 		nextId := atomic.AddInt32(&g.idCounter, 1)
 		id := strconv.Itoa(int(nextId))
@@ -538,6 +1123,14 @@ func (g *GcsEmu) handleGcsNewObject(ctx context.Context, baseUrl HttpBaseUrl, w
 			g.gapiError(w, http.StatusBadRequest, fmt.Sprintf("failed to parse request: %s", err))
 			return
 		}
+		if err := g.applyPredefinedAcl(obj, r.Form.Get("predefinedAcl")); err != nil {
+			g.gapiError(w, httpStatusCodeOf(err), err.Error())
+			return
+		}
+		if obj.CustomerEncryption, err = parseEncryptionHeaders(r.Header); err != nil {
+			g.gapiError(w, httpStatusCodeOf(err), err.Error())
+			return
+		}
 
 		meta, err := g.finishUpload(ctx, baseUrl, obj, contents, bucket, conds)
 		if err != nil {
@@ -647,24 +1240,56 @@ func (g *GcsEmu) finishUpload(ctx context.Context, baseUrl HttpBaseUrl, obj *sto
 		}
 	}
 	obj.Md5Hash = md5Hash
+	if obj.ContentType == "" {
+		obj.ContentType = g.detectContentType(filename, contents)
+	}
+	if err := validateMetadataSize(obj.Metadata); err != nil {
+		return nil, err
+	}
 
 	err := g.locks.Run(ctx, lockName(bucket, filename), func(ctx context.Context) error {
-		// Find the existing file / meta.
-		existing, err := g.store.GetMeta(baseUrl, bucket, filename)
-		if err != nil {
-			return fmt.Errorf("failed to check existence of %s/%s: %w", bucket, filename, err)
-		}
+		if conds == doesNotExistConds {
+			// A create-only write just needs to know whether the file exists.
+			exists, _, err := g.store.Stat(bucket, filename)
+			if err != nil {
+				return fmt.Errorf("failed to check existence of %s/%s: %w", bucket, filename, err)
+			}
+			if exists {
+				return fmtErrorfCode(http.StatusPreconditionFailed, "precondition failed")
+			}
+		} else {
+			// Find the existing file / meta.
+			existing, err := g.store.GetMeta(baseUrl, bucket, filename)
+			if err != nil {
+				return fmt.Errorf("failed to check existence of %s/%s: %w", bucket, filename, err)
+			}
 
-		if err := validateConds(existing, conds); err != nil {
-			return err
+			if err := validateConds(existing, conds); err != nil {
+				return err
+			}
+
+			if err := checkRetention(existing, g.now()); err != nil {
+				return err
+			}
+
+			if existing != nil {
+				obj.TimeCreated = existing.TimeCreated
+			}
 		}
 
-		if existing != nil {
-			obj.TimeCreated = existing.TimeCreated
+		bucketMeta, err := g.store.GetBucketMeta(baseUrl, bucket)
+		if err != nil {
+			return fmt.Errorf("failed to get meta for %s: %w", bucket, err)
 		}
+		applyRetention(obj, bucketMeta, g.now())
 
+		if g.failWrite != nil {
+			if err := g.failWrite(bucket, filename); err != nil {
+				return err
+			}
+		}
 		if err := g.store.Add(bucket, filename, contents, obj); err != nil {
-			return fmt.Errorf("failed to create %s/%s: %w", bucket, filename, err)
+			return fmtErrorfCode(httpStatusCodeOf(err), "failed to create %s/%s: %w", bucket, filename, err)
 		}
 		return nil
 	})
@@ -702,6 +1327,15 @@ var (
 	doesNotExistConds = cloudstorage.Conditions{DoesNotExist: true}
 )
 
+// objectNotFound returns a 404 error for a missing object. Like GCS, it reports a missing bucket differently from a
+// missing object in an existing bucket.
+func (g *GcsEmu) objectNotFound(bucket string, filename string) error {
+	if b, err := g.store.GetBucketMeta(dontNeedUrls, bucket); err == nil && b == nil {
+		return fmtErrorfCode(http.StatusNotFound, "The specified bucket does not exist: %s", bucket)
+	}
+	return fmtErrorfCode(http.StatusNotFound, "No such object: %s/%s", bucket, filename)
+}
+
 func validateConds(obj *storage.Object, cond cloudstorage.Conditions) error {
 	if obj == nil {
 		// The only way a nil object can succeed is if the conds are exactly equal to empty or doesNotExist
@@ -767,6 +1401,17 @@ func parseConds(vals url.Values) (cloudstorage.Conditions, error) {
 	return ret, nil
 }
 
+// parseSourceConds parses the ifSource* preconditions, which move requests apply to the source object.
+func parseSourceConds(vals url.Values) (cloudstorage.Conditions, error) {
+	src := url.Values{}
+	for _, name := range []string{"GenerationMatch", "GenerationNotMatch", "MetagenerationMatch", "MetagenerationNotMatch"} {
+		if v := vals.Get("ifSource" + name); v != "" {
+			src.Set("if"+name, v)
+		}
+	}
+	return parseConds(src)
+}
+
 const (
 	gcsMaxComposeSources = 32
 )
@@ -775,6 +1420,9 @@ func (g *GcsEmu) finishCompose(baseUrl HttpBaseUrl, bucket string, dst composeOb
 	if len(srcs) > gcsMaxComposeSources {
 		return nil, fmtErrorfCode(http.StatusBadRequest, "too many sources")
 	}
+	if err := validateMetadataSize(meta.Metadata); err != nil {
+		return nil, err
+	}
 
 	// TODO: consider moving this to disk to handle very large compose operations
 	var data []byte
@@ -807,11 +1455,20 @@ func (g *GcsEmu) finishCompose(baseUrl HttpBaseUrl, bucket string, dst composeOb
 	if err := validateConds(dstMeta, dst.conds); err != nil {
 		return nil, err
 	}
+	if err := checkRetention(dstMeta, g.now()); err != nil {
+		return nil, err
+	}
 	if dstMeta != nil {
 		meta.TimeCreated = dstMeta.TimeCreated
 	}
+
+	bucketMeta, err := g.store.GetBucketMeta(baseUrl, bucket)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get meta for %s: %w", bucket, err)
+	}
+	applyRetention(meta, bucketMeta, g.now())
 	if err := g.store.Add(bucket, dst.filename, data, meta); err != nil {
-		return nil, fmt.Errorf("failed to add new file: %w", err)
+		return nil, fmtErrorfCode(httpStatusCodeOf(err), "failed to add new file: %w", err)
 	}
 	return g.store.GetMeta(baseUrl, bucket, dst.filename)
 }
@@ -825,3 +1482,19 @@ func (g *GcsEmu) InitBucket(bucketName string) error {
 		return nil
 	})
 }
+
+// Seed inserts an object directly into storage without an HTTP round-trip, creating the bucket as needed.
+// The given meta is optional; intrinsic fields such as the md5 hash are computed from the contents.
+func (g *GcsEmu) Seed(bucket string, filename string, contents []byte, meta *storage.Object) error {
+	if err := g.InitBucket(bucket); err != nil {
+		return err
+	}
+	obj := &storage.Object{}
+	if meta != nil {
+		*obj = *meta
+	}
+	obj.Name = filename
+	obj.Md5Hash = ""
+	_, err := g.finishUpload(context.Background(), dontNeedUrls, obj, contents, bucket, emptyConds)
+	return err
+}
@@ -1,18 +1,24 @@
 package gcsemu
 
 import (
+	"bytes"
 	"context"
 	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"cloud.google.com/go/storage"
 	"google.golang.org/api/googleapi"
 	"google.golang.org/api/iterator"
+	api "google.golang.org/api/storage/v1"
 	"gotest.tools/v3/assert"
 )
 
@@ -528,6 +534,23 @@ func testCompose(t *testing.T, bh BucketHandle) {
 	assert.ErrorContains(t, err, "googleapi: Error 412")
 	assert.Equal(t, http.StatusPreconditionFailed, httpStatusCodeOf(err), "expected precondition failed")
 
+	// Bump the destination's metageneration with a patch, then compose with a stale metageneration.
+	patched, err := dest.Update(ctx, storage.ObjectAttrsToUpdate{Metadata: map[string]string{"patched": "true"}})
+	assert.NilError(t, err, "failed to patch destination")
+	assert.Equal(t, attrs.Metageneration+1, patched.Metageneration, "patch should bump metageneration")
+	composer = dest.If(storage.Conditions{MetagenerationMatch: attrs.Metageneration}).ComposerFrom(srcs...)
+	composer.ContentType = "text/plain"
+	_, err = composer.Run(ctx)
+	assert.ErrorContains(t, err, "googleapi: Error 412")
+	assert.Equal(t, http.StatusPreconditionFailed, httpStatusCodeOf(err), "expected precondition failed")
+
+	// The current metageneration succeeds and yields a new generation.
+	composer = dest.If(storage.Conditions{MetagenerationMatch: patched.Metageneration}).ComposerFrom(srcs...)
+	composer.ContentType = "text/plain"
+	attrs, err = composer.Run(ctx)
+	assert.NilError(t, err, "failed to run compose")
+	assert.Equal(t, int64(1), attrs.Metageneration, "new generation should reset metageneration")
+
 	// Issue the a request does not exist destination.
 	composer = destSecondary.If(storage.Conditions{DoesNotExist: true}).ComposerFrom(
 		srcs[0].If(storage.Conditions{GenerationMatch: srcGens[0]}),
@@ -591,3 +614,801 @@ func write(w *storage.Writer, content string) error {
 	}
 	return w.Close()
 }
+
+// newTestServer starts an in-memory emulator with the given options and returns a client connected to it.
+func newTestServer(t *testing.T, opts Options) (*Server, *storage.Client) {
+	t.Helper()
+	if opts.Log == nil {
+		opts.Log = func(err error, fmt string, args ...interface{}) {
+			if err != nil {
+				fmt = "ERROR: " + fmt + ": %s"
+				args = append(args, err)
+			}
+			t.Logf(fmt, args...)
+		}
+	}
+	svr, err := NewServer("127.0.0.1:0", opts)
+	assert.NilError(t, err)
+	t.Cleanup(svr.Close)
+
+	gcsClient, err := NewTestClientWithHost(context.Background(), svr.URL)
+	assert.NilError(t, err)
+	t.Cleanup(func() {
+		_ = gcsClient.Close()
+	})
+	return svr, gcsClient
+}
+
+func TestServiceAccount(t *testing.T) {
+	ctx := context.Background()
+
+	_, gcsClient := newTestServer(t, Options{})
+	email, err := gcsClient.ServiceAccount(ctx, "my-project")
+	assert.NilError(t, err)
+	assert.Equal(t, "service-my-project@gs-project-accounts.iam.gserviceaccount.com", email)
+
+	_, gcsClient = newTestServer(t, Options{ServiceAccountEmail: "emulator@example.iam.gserviceaccount.com"})
+	email, err = gcsClient.ServiceAccount(ctx, "my-project")
+	assert.NilError(t, err)
+	assert.Equal(t, "emulator@example.iam.gserviceaccount.com", email)
+}
+
+func TestTimeStorageClassUpdated(t *testing.T) {
+	for name, store := range map[string]Store{
+		"MemStore":  NewMemStore(),
+		"FileStore": NewFileStore(t.TempDir()),
+	} {
+		store := store
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			svr, gcsClient := newTestServer(t, Options{Store: store})
+			assert.NilError(t, svr.InitBucket("class-bucket"))
+			oh := gcsClient.Bucket("class-bucket").Object("obj.txt")
+
+			getRawMeta := func() *api.Object {
+				rsp, err := http.Get(svr.URL + "/storage/v1/b/class-bucket/o/obj.txt")
+				assert.NilError(t, err)
+				defer rsp.Body.Close()
+				assert.Equal(t, http.StatusOK, rsp.StatusCode)
+				var obj api.Object
+				assert.NilError(t, json.NewDecoder(rsp.Body).Decode(&obj))
+				return &obj
+			}
+
+			w := oh.NewWriter(ctx)
+			assert.NilError(t, write(w, v1))
+			created := getRawMeta()
+			assert.Equal(t, "STANDARD", created.StorageClass)
+			assert.Assert(t, created.TimeStorageClassUpdated != "")
+			assert.Equal(t, created.TimeCreated, created.TimeStorageClassUpdated)
+
+			// A metadata patch does not touch the storage class.
+			_, err := oh.Update(ctx, storage.ObjectAttrsToUpdate{ContentType: "text/csv"})
+			assert.NilError(t, err)
+			patched := getRawMeta()
+			assert.Equal(t, "STANDARD", patched.StorageClass)
+			assert.Equal(t, created.TimeStorageClassUpdated, patched.TimeStorageClassUpdated)
+
+			// Rewriting in place to a new storage class updates the timestamp.
+			time.Sleep(time.Millisecond)
+			copier := oh.CopierFrom(oh)
+			copier.StorageClass = "NEARLINE"
+			attrs, err := copier.Run(ctx)
+			assert.NilError(t, err)
+			assert.Equal(t, "NEARLINE", attrs.StorageClass)
+			rewritten := getRawMeta()
+			assert.Equal(t, "NEARLINE", rewritten.StorageClass)
+			assert.Equal(t, rewritten.TimeCreated, rewritten.TimeStorageClassUpdated)
+			assert.Assert(t, rewritten.TimeStorageClassUpdated != created.TimeStorageClassUpdated)
+		})
+	}
+}
+
+func TestCopyPreservesContentHeaders(t *testing.T) {
+	for name, store := range map[string]Store{
+		"MemStore":  NewMemStore(),
+		"FileStore": NewFileStore(t.TempDir()),
+	} {
+		store := store
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			svr, gcsClient := newTestServer(t, Options{Store: store})
+			assert.NilError(t, svr.InitBucket("copy-bucket"))
+			bh := gcsClient.Bucket("copy-bucket")
+
+			src := bh.Object("src.txt")
+			w := src.NewWriter(ctx)
+			w.CacheControl = "public, max-age=60"
+			w.ContentDisposition = "attachment; filename=src.txt"
+			w.ContentLanguage = "en"
+			w.ContentEncoding = "identity"
+			assert.NilError(t, write(w, v1))
+
+			_, err := bh.Object("dst.txt").CopierFrom(src).Run(ctx)
+			assert.NilError(t, err)
+			attrs, err := bh.Object("dst.txt").Attrs(ctx)
+			assert.NilError(t, err)
+			assert.Equal(t, "public, max-age=60", attrs.CacheControl)
+			assert.Equal(t, "attachment; filename=src.txt", attrs.ContentDisposition)
+			assert.Equal(t, "en", attrs.ContentLanguage)
+			assert.Equal(t, "identity", attrs.ContentEncoding)
+		})
+	}
+}
+
+func TestMoveObject(t *testing.T) {
+	for name, store := range map[string]Store{
+		"MemStore":  NewMemStore(),
+		"FileStore": NewFileStore(t.TempDir()),
+	} {
+		store := store
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			svr, gcsClient := newTestServer(t, Options{Store: store})
+			assert.NilError(t, svr.InitBucket("move-bucket"))
+			bh := gcsClient.Bucket("move-bucket")
+
+			src := bh.Object("dir/src.txt")
+			w := src.NewWriter(ctx)
+			w.ContentType = "text/plain"
+			assert.NilError(t, write(w, v1))
+			srcAttrs, err := src.Attrs(ctx)
+			assert.NilError(t, err)
+			assert.NilError(t, write(bh.Object("taken.txt").NewWriter(ctx), v2))
+
+			move := func(dst string, params string) *http.Response {
+				u := fmt.Sprintf("%s/storage/v1/b/move-bucket/o/%s/moveTo/o/%s?%s", svr.URL, "dir%2Fsrc.txt", dst, params)
+				rsp, err := http.Post(u, "application/json", nil)
+				assert.NilError(t, err)
+				_ = rsp.Body.Close()
+				return rsp
+			}
+
+			// Preconditions on either side block the move.
+			rsp := move("moved.txt", fmt.Sprintf("ifSourceGenerationMatch=%d", srcAttrs.Generation+1))
+			assert.Equal(t, http.StatusPreconditionFailed, rsp.StatusCode)
+			rsp = move("taken.txt", "ifGenerationMatch=0")
+			assert.Equal(t, http.StatusPreconditionFailed, rsp.StatusCode)
+
+			rsp = move("moved.txt", fmt.Sprintf("ifSourceGenerationMatch=%d&ifGenerationMatch=0", srcAttrs.Generation))
+			assert.Equal(t, http.StatusOK, rsp.StatusCode)
+
+			_, err = src.Attrs(ctx)
+			assert.Equal(t, storage.ErrObjectNotExist, err, "wrong error")
+			r, err := bh.Object("moved.txt").NewReader(ctx)
+			assert.NilError(t, err)
+			data, err := io.ReadAll(r)
+			assert.NilError(t, err)
+			assert.NilError(t, r.Close())
+			assert.Equal(t, v1, string(data))
+			assert.Equal(t, "text/plain", r.Attrs.ContentType)
+
+			// The source is gone, so moving it again fails.
+			rsp = move("moved-again.txt", "")
+			assert.Equal(t, http.StatusNotFound, rsp.StatusCode)
+		})
+	}
+}
+
+func TestStoreStat(t *testing.T) {
+	for name, store := range map[string]Store{
+		"MemStore":  NewMemStore(),
+		"FileStore": NewFileStore(t.TempDir()),
+	} {
+		store := store
+		t.Run(name, func(t *testing.T) {
+			assert.NilError(t, store.CreateBucket("stat-bucket"))
+			assert.NilError(t, store.Add("stat-bucket", "dir/obj.txt", []byte(v1), &api.Object{}))
+
+			exists, size, err := store.Stat("stat-bucket", "dir/obj.txt")
+			assert.NilError(t, err)
+			assert.Assert(t, exists)
+			assert.Equal(t, int64(len(v1)), size)
+
+			// Implicit directories and missing files don't exist.
+			for _, name := range []string{"dir", "missing.txt"} {
+				exists, size, err = store.Stat("stat-bucket", name)
+				assert.NilError(t, err)
+				assert.Assert(t, !exists, name)
+				assert.Equal(t, int64(0), size, name)
+			}
+		})
+	}
+}
+
+func TestDirectoryObjectGenerationStable(t *testing.T) {
+	for name, store := range map[string]Store{
+		"MemStore":  NewMemStore(),
+		"FileStore": NewFileStore(t.TempDir()),
+	} {
+		store := store
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			svr, gcsClient := newTestServer(t, Options{Store: store})
+			assert.NilError(t, svr.InitBucket("dir-bucket"))
+			oh := gcsClient.Bucket("dir-bucket").Object("dir/")
+			assert.NilError(t, write(oh.NewWriter(ctx), ""))
+
+			attrs, err := oh.Attrs(ctx)
+			assert.NilError(t, err)
+			assert.Assert(t, attrs.Generation > 1, "unexpected fallback generation %d", attrs.Generation)
+			for i := 0; i < 3; i++ {
+				again, err := oh.Attrs(ctx)
+				assert.NilError(t, err)
+				assert.Equal(t, attrs.Generation, again.Generation)
+			}
+		})
+	}
+}
+
+func TestEmptyObjectSize(t *testing.T) {
+	for name, store := range map[string]Store{
+		"MemStore":  NewMemStore(),
+		"FileStore": NewFileStore(t.TempDir()),
+	} {
+		store := store
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			svr, gcsClient := newTestServer(t, Options{Store: store})
+			assert.NilError(t, svr.InitBucket("empty-bucket"))
+			oh := gcsClient.Bucket("empty-bucket").Object("empty.txt")
+			assert.NilError(t, write(oh.NewWriter(ctx), ""))
+
+			attrs, err := oh.Attrs(ctx)
+			assert.NilError(t, err)
+			assert.Equal(t, int64(0), attrs.Size)
+
+			// The JSON metadata must include the size rather than omitting it.
+			rawSize := func(u string, items bool) json.RawMessage {
+				rsp, err := http.Get(u)
+				assert.NilError(t, err)
+				defer rsp.Body.Close()
+				assert.Equal(t, http.StatusOK, rsp.StatusCode)
+				var fields map[string]json.RawMessage
+				if items {
+					var list struct {
+						Items []map[string]json.RawMessage `json:"items"`
+					}
+					assert.NilError(t, json.NewDecoder(rsp.Body).Decode(&list))
+					assert.Equal(t, 1, len(list.Items))
+					fields = list.Items[0]
+				} else {
+					assert.NilError(t, json.NewDecoder(rsp.Body).Decode(&fields))
+				}
+				return fields["size"]
+			}
+			assert.Equal(t, `"0"`, string(rawSize(svr.URL+"/storage/v1/b/empty-bucket/o/empty.txt", false)))
+			assert.Equal(t, `"0"`, string(rawSize(svr.URL+"/storage/v1/b/empty-bucket/o", true)))
+		})
+	}
+}
+
+func TestListOffsets(t *testing.T) {
+	for name, store := range map[string]Store{
+		"MemStore":  NewMemStore(),
+		"FileStore": NewFileStore(t.TempDir()),
+	} {
+		store := store
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			svr, gcsClient := newTestServer(t, Options{Store: store})
+			for _, name := range []string{"a", "b", "c", "d"} {
+				assert.NilError(t, svr.Seed("offset-bucket", name, []byte(v1), nil))
+			}
+
+			list := func(q *storage.Query) []string {
+				var names []string
+				it := gcsClient.Bucket("offset-bucket").Objects(ctx, q)
+				for {
+					attrs, err := it.Next()
+					if err == iterator.Done {
+						return names
+					}
+					assert.NilError(t, err)
+					names = append(names, attrs.Name)
+				}
+			}
+			assert.DeepEqual(t, []string{"b", "c"}, list(&storage.Query{StartOffset: "b", EndOffset: "d"}))
+			assert.DeepEqual(t, []string{"c", "d"}, list(&storage.Query{StartOffset: "bb"}))
+			assert.DeepEqual(t, []string{"a"}, list(&storage.Query{EndOffset: "b"}))
+		})
+	}
+}
+
+func TestListPagesWithDelimiter(t *testing.T) {
+	for name, store := range map[string]Store{
+		"MemStore":  NewMemStore(),
+		"FileStore": NewFileStore(t.TempDir()),
+	} {
+		store := store
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			svr, gcsClient := newTestServer(t, Options{Store: store})
+			for _, name := range []string{"a/1", "a/2", "a/3", "b"} {
+				assert.NilError(t, svr.Seed("delim-bucket", name, []byte(v1), nil))
+			}
+
+			// Each prefix is returned once, even when pages end partway through the files under it.
+			var names []string
+			it := gcsClient.Bucket("delim-bucket").Objects(ctx, &storage.Query{Delimiter: "/"})
+			it.PageInfo().MaxSize = 1
+			for {
+				attrs, err := it.Next()
+				if err == iterator.Done {
+					break
+				}
+				assert.NilError(t, err)
+				names = append(names, attrs.Name+attrs.Prefix)
+			}
+			assert.DeepEqual(t, []string{"a/", "b"}, names)
+		})
+	}
+}
+
+func TestListMatchGlob(t *testing.T) {
+	for name, store := range map[string]Store{
+		"MemStore":  NewMemStore(),
+		"FileStore": NewFileStore(t.TempDir()),
+	} {
+		store := store
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			svr, gcsClient := newTestServer(t, Options{Store: store})
+			for _, name := range []string{"bar/e.txt", "foo/a.txt", "foo/b.log", "foo/x/c.txt", "foo/x/y/d.txt"} {
+				assert.NilError(t, svr.Seed("glob-bucket", name, []byte(v1), nil))
+			}
+
+			list := func(q *storage.Query) ([]string, error) {
+				var names []string
+				it := gcsClient.Bucket("glob-bucket").Objects(ctx, q)
+				for {
+					attrs, err := it.Next()
+					if err == iterator.Done {
+						return names, nil
+					} else if err != nil {
+						return nil, err
+					}
+					// Prefixes show up as entries with only Prefix set.
+					names = append(names, attrs.Name+attrs.Prefix)
+				}
+			}
+			for glob, expect := range map[string][]string{
+				"foo/**/*.txt":   {"foo/a.txt", "foo/x/c.txt", "foo/x/y/d.txt"},
+				"foo/*.txt":      {"foo/a.txt"},
+				"**/{a,c}.txt":   {"foo/a.txt", "foo/x/c.txt"},
+				"foo/?/[!d].tx?": {"foo/x/c.txt"},
+			} {
+				names, err := list(&storage.Query{MatchGlob: glob, Delimiter: "/"})
+				assert.NilError(t, err, glob)
+				assert.DeepEqual(t, expect, names)
+			}
+
+			_, err := list(&storage.Query{MatchGlob: "foo/[a"})
+			assert.ErrorContains(t, err, "unterminated")
+		})
+	}
+}
+
+func TestDetectContentType(t *testing.T) {
+	ctx := context.Background()
+	const html = "<html><body>hi</body></html>"
+
+	svr, gcsClient := newTestServer(t, Options{})
+	assert.NilError(t, svr.Seed("ct-bucket", "page.unknownext", []byte(html), nil))
+	assert.NilError(t, svr.Seed("ct-bucket", "page.html", []byte(html), nil))
+	assert.NilError(t, svr.Seed("ct-bucket", "typed.unknownext", []byte(html), &api.Object{ContentType: "text/x-custom"}))
+	for name, expect := range map[string]string{
+		"page.unknownext":  "application/octet-stream",
+		"page.html":        "text/html; charset=utf-8",
+		"typed.unknownext": "text/x-custom",
+	} {
+		attrs, err := gcsClient.Bucket("ct-bucket").Object(name).Attrs(ctx)
+		assert.NilError(t, err)
+		assert.Equal(t, expect, attrs.ContentType, name)
+	}
+
+	svr, gcsClient = newTestServer(t, Options{
+		DetectContentType: func(_ string, contents []byte) string {
+			return http.DetectContentType(contents)
+		},
+	})
+	assert.NilError(t, svr.Seed("ct-bucket", "page.unknownext", []byte(html), nil))
+	bh := gcsClient.Bucket("ct-bucket")
+	attrs, err := bh.Object("page.unknownext").Attrs(ctx)
+	assert.NilError(t, err)
+	assert.Equal(t, "text/html; charset=utf-8", attrs.ContentType)
+
+	// Compose doesn't detect a type, and copy keeps the source's.
+	attrs, err = bh.Object("composed.unknownext").ComposerFrom(bh.Object("page.unknownext")).Run(ctx)
+	assert.NilError(t, err)
+	assert.Equal(t, "", attrs.ContentType)
+	attrs, err = bh.Object("copied.txt").CopierFrom(bh.Object("page.unknownext")).Run(ctx)
+	assert.NilError(t, err)
+	assert.Equal(t, "text/html; charset=utf-8", attrs.ContentType)
+}
+
+func TestCustomerSuppliedEncryptionKey(t *testing.T) {
+	ctx := context.Background()
+	key := bytes.Repeat([]byte{1}, 32)
+	wrongKey := bytes.Repeat([]byte{2}, 32)
+
+	svr, gcsClient := newTestServer(t, Options{})
+	assert.NilError(t, svr.InitBucket("csek-bucket"))
+	oh := gcsClient.Bucket("csek-bucket").Object("secret.txt")
+	assert.NilError(t, write(oh.Key(key).NewWriter(ctx), v1))
+
+	attrs, err := oh.Attrs(ctx)
+	assert.NilError(t, err)
+	sum := sha256.Sum256(key)
+	assert.Equal(t, base64.StdEncoding.EncodeToString(sum[:]), attrs.CustomerKeySHA256)
+
+	r, err := oh.Key(key).NewReader(ctx)
+	assert.NilError(t, err)
+	got, err := io.ReadAll(r)
+	assert.NilError(t, err)
+	assert.Equal(t, v1, string(got))
+	assert.NilError(t, r.Close())
+
+	_, err = oh.Key(wrongKey).NewReader(ctx)
+	assert.ErrorContains(t, err, "does not match")
+	_, err = oh.NewReader(ctx)
+	assert.ErrorContains(t, err, "customer-supplied encryption key")
+}
+
+func TestPostDeleteVisibility(t *testing.T) {
+	ctx := context.Background()
+	svr, gcsClient := newTestServer(t, Options{PostDeleteVisibility: time.Minute})
+	assert.NilError(t, svr.InitBucket("eventual-bucket"))
+	bh := gcsClient.Bucket("eventual-bucket")
+	oh := bh.Object("deleted.txt")
+	assert.NilError(t, write(oh.NewWriter(ctx), v1))
+	assert.NilError(t, oh.Delete(ctx))
+
+	// Within the window, the stale object is still readable, but not listed.
+	attrs, err := oh.Attrs(ctx)
+	assert.NilError(t, err)
+	assert.Equal(t, int64(len(v1)), attrs.Size)
+	r, err := oh.NewReader(ctx)
+	assert.NilError(t, err)
+	got, err := io.ReadAll(r)
+	assert.NilError(t, err)
+	assert.Equal(t, v1, string(got))
+	assert.NilError(t, r.Close())
+	_, err = bh.Objects(ctx, nil).Next()
+	assert.Equal(t, iterator.Done, err)
+
+	// Afterwards, it's gone.
+	svr.now = func() time.Time {
+		return time.Now().Add(2 * time.Minute)
+	}
+	_, err = oh.Attrs(ctx)
+	assert.Equal(t, storage.ErrObjectNotExist, err, "wrong error")
+	_, err = oh.NewReader(ctx)
+	assert.Equal(t, storage.ErrObjectNotExist, err, "wrong error")
+}
+
+func TestDeleteGeneration(t *testing.T) {
+	ctx := context.Background()
+	svr, gcsClient := newTestServer(t, Options{})
+	assert.NilError(t, svr.InitBucket("gen-bucket"))
+	oh := gcsClient.Bucket("gen-bucket").Object("gen.txt")
+	assert.NilError(t, write(oh.NewWriter(ctx), v1))
+	old, err := oh.Attrs(ctx)
+	assert.NilError(t, err)
+	assert.NilError(t, write(oh.NewWriter(ctx), v2))
+	live, err := oh.Attrs(ctx)
+	assert.NilError(t, err)
+	assert.Assert(t, old.Generation != live.Generation)
+
+	// Older generations aren't kept, so deleting one finds nothing, and leaves the live generation alone.
+	err = oh.Generation(old.Generation).Delete(ctx)
+	assert.Equal(t, storage.ErrObjectNotExist, err, "wrong error")
+	attrs, err := oh.Attrs(ctx)
+	assert.NilError(t, err)
+	assert.Equal(t, live.Generation, attrs.Generation)
+
+	assert.NilError(t, oh.Generation(live.Generation).Delete(ctx))
+	_, err = oh.Attrs(ctx)
+	assert.Equal(t, storage.ErrObjectNotExist, err, "wrong error")
+}
+
+func TestClearMetadata(t *testing.T) {
+	ctx := context.Background()
+	svr, gcsClient := newTestServer(t, Options{})
+	assert.NilError(t, svr.InitBucket("meta-bucket"))
+	oh := gcsClient.Bucket("meta-bucket").Object("obj.txt")
+	u := svr.URL + "/storage/v1/b/meta-bucket/o/obj.txt"
+
+	w := oh.NewWriter(ctx)
+	w.Metadata = map[string]string{"a": "1", "b": "2"}
+	assert.NilError(t, write(w, v1))
+
+	// rawMeta returns the object's metadata as raw JSON fields, so absence can be distinguished from emptiness.
+	rawMeta := func(rsp *http.Response) map[string]json.RawMessage {
+		defer rsp.Body.Close()
+		assert.Equal(t, http.StatusOK, rsp.StatusCode)
+		var fields map[string]json.RawMessage
+		assert.NilError(t, json.NewDecoder(rsp.Body).Decode(&fields))
+		return fields
+	}
+	patch := func(body string) map[string]json.RawMessage {
+		req, err := http.NewRequest("PATCH", u, strings.NewReader(body))
+		assert.NilError(t, err)
+		req.Header.Set("Content-Type", "application/json")
+		rsp, err := http.DefaultClient.Do(req)
+		assert.NilError(t, err)
+		return rawMeta(rsp)
+	}
+
+	// Null values remove individual keys.
+	fields := patch(`{"metadata": {"a": null}}`)
+	assert.Equal(t, `{"b":"2"}`, string(fields["metadata"]))
+
+	// Removing the last key leaves no metadata field at all.
+	fields = patch(`{"metadata": {"b": null}}`)
+	_, ok := fields["metadata"]
+	assert.Assert(t, !ok, "metadata should be absent, got %s", fields["metadata"])
+	rsp, err := http.Get(u)
+	assert.NilError(t, err)
+	_, ok = rawMeta(rsp)["metadata"]
+	assert.Assert(t, !ok, "metadata should be absent")
+
+	// Clearing everything through the client behaves the same.
+	_, err = oh.Update(ctx, storage.ObjectAttrsToUpdate{Metadata: map[string]string{"c": "3"}})
+	assert.NilError(t, err)
+	attrs, err := oh.Update(ctx, storage.ObjectAttrsToUpdate{Metadata: map[string]string{}})
+	assert.NilError(t, err)
+	assert.Assert(t, attrs.Metadata == nil)
+	rsp, err = http.Get(u)
+	assert.NilError(t, err)
+	_, ok = rawMeta(rsp)["metadata"]
+	assert.Assert(t, !ok, "metadata should be absent")
+}
+
+func TestMetadataRoundTrip(t *testing.T) {
+	for name, store := range map[string]Store{
+		"MemStore":  NewMemStore(),
+		"FileStore": NewFileStore(t.TempDir()),
+	} {
+		store := store
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			svr, gcsClient := newTestServer(t, Options{Store: store})
+			assert.NilError(t, svr.InitBucket("meta-bucket"))
+			oh := gcsClient.Bucket("meta-bucket").Object("obj.txt")
+
+			// Empty values and mixed-case keys survive a write and a read back.
+			want := map[string]string{"empty": "", "Mixed-Case": "Value"}
+			w := oh.NewWriter(ctx)
+			w.Metadata = want
+			assert.NilError(t, write(w, v1))
+			attrs, err := oh.Attrs(ctx)
+			assert.NilError(t, err)
+			assert.DeepEqual(t, want, attrs.Metadata)
+
+			// Patching another key keeps the empty value.
+			attrs, err = oh.Update(ctx, storage.ObjectAttrsToUpdate{Metadata: map[string]string{"other": "1"}})
+			assert.NilError(t, err)
+			assert.DeepEqual(t, map[string]string{"empty": "", "Mixed-Case": "Value", "other": "1"}, attrs.Metadata)
+
+			// Metadata right at the 8 KiB limit is accepted; one byte more is rejected.
+			atLimit := map[string]string{"big": strings.Repeat("x", gcsMaxMetadataSize-len("big"))}
+			w = oh.NewWriter(ctx)
+			w.Metadata = atLimit
+			assert.NilError(t, write(w, v2))
+			attrs, err = oh.Attrs(ctx)
+			assert.NilError(t, err)
+			assert.DeepEqual(t, atLimit, attrs.Metadata)
+
+			w = oh.NewWriter(ctx)
+			w.Metadata = map[string]string{"big": atLimit["big"] + "x"}
+			err = write(w, v1)
+			assert.Equal(t, http.StatusBadRequest, httpStatusCodeOf(err), "wrong error %T: %s", err, err)
+
+			_, err = oh.Update(ctx, storage.ObjectAttrsToUpdate{Metadata: map[string]string{"more": "x"}})
+			assert.Equal(t, http.StatusBadRequest, httpStatusCodeOf(err), "wrong error %T: %s", err, err)
+			attrs, err = oh.Attrs(ctx)
+			assert.NilError(t, err)
+			assert.DeepEqual(t, atLimit, attrs.Metadata)
+		})
+	}
+}
+
+func TestPublicBaseURL(t *testing.T) {
+	ctx := context.Background()
+	svr, gcsClient := newTestServer(t, Options{PublicBaseURL: "https://gcs.example.com"})
+	assert.NilError(t, svr.InitBucket("proxied-bucket"))
+	oh := gcsClient.Bucket("proxied-bucket").Object("obj.txt")
+	assert.NilError(t, write(oh.NewWriter(ctx), v1))
+
+	attrs, err := oh.Attrs(ctx)
+	assert.NilError(t, err)
+	assert.Equal(t, "https://gcs.example.com/storage/v1/b/proxied-bucket/o/obj.txt?alt=media", attrs.MediaLink)
+
+	rsp, err := http.Get(svr.URL + "/storage/v1/b/proxied-bucket/o/obj.txt")
+	assert.NilError(t, err)
+	defer rsp.Body.Close()
+	var obj api.Object
+	assert.NilError(t, json.NewDecoder(rsp.Body).Decode(&obj))
+	assert.Equal(t, "https://gcs.example.com/storage/v1/b/proxied-bucket/o/obj.txt", obj.SelfLink)
+}
+
+func TestBucketAttrs(t *testing.T) {
+	for name, store := range map[string]Store{
+		"MemStore":  NewMemStore(),
+		"FileStore": NewFileStore(t.TempDir()),
+	} {
+		store := store
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			svr, gcsClient := newTestServer(t, Options{Store: store})
+
+			// A bucket created directly gets defaults.
+			before := time.Now().Add(-time.Second)
+			assert.NilError(t, svr.InitBucket("default-bucket"))
+			attrs, err := gcsClient.Bucket("default-bucket").Attrs(ctx)
+			assert.NilError(t, err)
+			assert.Equal(t, "default-bucket", attrs.Name)
+			assert.Equal(t, "STANDARD", attrs.StorageClass)
+			assert.Equal(t, "US", attrs.Location)
+			assert.Equal(t, "multi-region", attrs.LocationType)
+			assert.Assert(t, !attrs.VersioningEnabled)
+			assert.Assert(t, !attrs.Created.Before(before), "created %v", attrs.Created)
+			created := attrs.Created
+
+			// Writing objects doesn't change the creation time.
+			assert.NilError(t, write(gcsClient.Bucket("default-bucket").Object("file.txt").NewWriter(ctx), v1))
+			attrs, err = gcsClient.Bucket("default-bucket").Attrs(ctx)
+			assert.NilError(t, err)
+			assert.Equal(t, created, attrs.Created)
+
+			// Requested values are kept, alongside the creation time.
+			bh := gcsClient.Bucket("custom-bucket")
+			assert.NilError(t, bh.Create(ctx, "dev", &storage.BucketAttrs{
+				Location:          "EU",
+				StorageClass:      "NEARLINE",
+				VersioningEnabled: true,
+			}))
+			attrs, err = bh.Attrs(ctx)
+			assert.NilError(t, err)
+			assert.Equal(t, "NEARLINE", attrs.StorageClass)
+			assert.Equal(t, "EU", attrs.Location)
+			assert.Assert(t, attrs.VersioningEnabled)
+			assert.Assert(t, !attrs.Created.IsZero())
+		})
+	}
+}
+
+func TestUpdateBucket(t *testing.T) {
+	for name, store := range map[string]Store{
+		"MemStore":  NewMemStore(),
+		"FileStore": NewFileStore(t.TempDir()),
+	} {
+		store := store
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			svr, gcsClient := newTestServer(t, Options{Store: store})
+			assert.NilError(t, svr.InitBucket("patch-bucket"))
+			bh := gcsClient.Bucket("patch-bucket")
+
+			attrs, err := bh.Update(ctx, storage.BucketAttrsToUpdate{VersioningEnabled: true})
+			assert.NilError(t, err)
+			assert.Assert(t, attrs.VersioningEnabled)
+			assert.Equal(t, int64(1), attrs.MetaGeneration)
+
+			// A lifecycle rule is added without disturbing versioning.
+			lifecycle := storage.Lifecycle{Rules: []storage.LifecycleRule{{
+				Action:    storage.LifecycleAction{Type: storage.DeleteAction},
+				Condition: storage.LifecycleCondition{AgeInDays: 30},
+			}}}
+			_, err = bh.Update(ctx, storage.BucketAttrsToUpdate{Lifecycle: &lifecycle})
+			assert.NilError(t, err)
+
+			attrs, err = bh.Attrs(ctx)
+			assert.NilError(t, err)
+			assert.Assert(t, attrs.VersioningEnabled)
+			assert.Equal(t, 1, len(attrs.Lifecycle.Rules))
+			assert.Equal(t, int64(30), attrs.Lifecycle.Rules[0].Condition.AgeInDays)
+			assert.Equal(t, int64(2), attrs.MetaGeneration)
+
+			// Metageneration preconditions are honored.
+			_, err = bh.If(storage.BucketConditions{MetagenerationMatch: 1}).Update(ctx, storage.BucketAttrsToUpdate{VersioningEnabled: false})
+			assert.Equal(t, http.StatusPreconditionFailed, httpStatusCodeOf(err), "wrong error %T: %s", err, err)
+
+			_, err = gcsClient.Bucket("missing-bucket").Update(ctx, storage.BucketAttrsToUpdate{VersioningEnabled: true})
+			assert.Equal(t, http.StatusNotFound, httpStatusCodeOf(err), "wrong error %T: %s", err, err)
+
+			// A name or id in the body doesn't rename the bucket.
+			req, err := http.NewRequest("PATCH", svr.URL+"/storage/v1/b/patch-bucket", strings.NewReader(`{"name": "other-bucket", "id": "other-bucket", "labels": {"k": "v"}}`))
+			assert.NilError(t, err)
+			req.Header.Set("Content-Type", "application/json")
+			rsp, err := http.DefaultClient.Do(req)
+			assert.NilError(t, err)
+			defer rsp.Body.Close()
+			assert.Equal(t, http.StatusOK, rsp.StatusCode)
+			var patched api.Bucket
+			assert.NilError(t, json.NewDecoder(rsp.Body).Decode(&patched))
+			assert.Equal(t, "patch-bucket", patched.Name)
+			assert.Equal(t, "patch-bucket", patched.Id)
+			attrs, err = bh.Attrs(ctx)
+			assert.NilError(t, err)
+			assert.Equal(t, "patch-bucket", attrs.Name)
+			assert.Equal(t, "v", attrs.Labels["k"])
+			_, err = gcsClient.Bucket("other-bucket").Attrs(ctx)
+			assert.Equal(t, storage.ErrBucketNotExist, err)
+		})
+	}
+}
+
+func TestFailWrite(t *testing.T) {
+	ctx := context.Background()
+	failures := 0
+	svr, gcsClient := newTestServer(t, Options{
+		FailWrite: func(bucket, object string) error {
+			if object != "flaky.txt" || failures == 0 {
+				return nil
+			}
+			failures--
+			return &googleapi.Error{Code: http.StatusServiceUnavailable, Message: "injected failure"}
+		},
+	})
+	assert.NilError(t, svr.InitBucket("fail-bucket"))
+	bh := gcsClient.Bucket("fail-bucket")
+
+	// Unconditional writes aren't retried by the client, so the injected failure surfaces as a retriable error,
+	// and nothing is written.
+	failures = 1
+	oh := bh.Object("flaky.txt")
+	err := write(oh.NewWriter(ctx), v1)
+	assert.Equal(t, http.StatusServiceUnavailable, httpStatusCodeOf(err), "wrong error %T: %s", err, err)
+	assert.Assert(t, storage.ShouldRetry(err))
+	_, err = oh.Attrs(ctx)
+	assert.Equal(t, storage.ErrObjectNotExist, err, "wrong error")
+
+	// A create-only write is idempotent, so the client retries through the failure.
+	failures = 1
+	assert.NilError(t, write(oh.If(storage.Conditions{DoesNotExist: true}).NewWriter(ctx), v1))
+	assert.Equal(t, 0, failures)
+	attrs, err := oh.Attrs(ctx)
+	assert.NilError(t, err)
+	assert.Equal(t, int64(len(v1)), attrs.Size)
+
+	// Other objects are unaffected.
+	failures = 1
+	assert.NilError(t, write(bh.Object("steady.txt").NewWriter(ctx), v1))
+}
+
+func TestOnRequest(t *testing.T) {
+	ctx := context.Background()
+	type request struct {
+		Method, Bucket, Object string
+		Status                 int
+	}
+	var mu sync.Mutex
+	var requests []request
+	svr, gcsClient := newTestServer(t, Options{
+		OnRequest: func(method, bucket, object string, status int, dur time.Duration) {
+			assert.Assert(t, dur >= 0)
+			mu.Lock()
+			defer mu.Unlock()
+			requests = append(requests, request{method, bucket, object, status})
+		},
+	})
+	assert.NilError(t, svr.Seed("request-bucket", "file.txt", []byte(v1), nil))
+	oh := gcsClient.Bucket("request-bucket").Object("file.txt")
+
+	r, err := oh.NewReader(ctx)
+	assert.NilError(t, err)
+	_, err = io.ReadAll(r)
+	assert.NilError(t, err)
+	assert.NilError(t, r.Close())
+	_, err = gcsClient.Bucket("request-bucket").Object("missing.txt").Attrs(ctx)
+	assert.Equal(t, storage.ErrObjectNotExist, err, "wrong error")
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.DeepEqual(t, []request{
+		{"GET", "request-bucket", "file.txt", http.StatusOK},
+		{"GET", "request-bucket", "missing.txt", http.StatusNotFound},
+	}, requests)
+}
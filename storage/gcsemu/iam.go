@@ -0,0 +1,122 @@
+package gcsemu
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"google.golang.org/api/storage/v1"
+)
+
+// iamPolicies holds per-bucket IAM policies in memory; policies are not persisted by the Store.
+type iamPolicies struct {
+	mu       sync.Mutex
+	policies map[string]*iamPolicy // keyed by bucket
+}
+
+type iamPolicy struct {
+	bindings []*storage.PolicyBindings
+	version  uint64 // bumped on every set, used to compute the etag
+}
+
+func newIamPolicies() *iamPolicies {
+	return &iamPolicies{policies: map[string]*iamPolicy{}}
+}
+
+// get returns the current policy for the given bucket.
+func (ip *iamPolicies) get(bucket string) *storage.Policy {
+	ip.mu.Lock()
+	defer ip.mu.Unlock()
+	return ip.toPolicy(bucket, ip.policies[bucket])
+}
+
+// set replaces the policy for the given bucket. If etag is non-empty, it must match the current policy's etag.
+func (ip *iamPolicies) set(bucket string, etag string, bindings []*storage.PolicyBindings) (*storage.Policy, error) {
+	ip.mu.Lock()
+	defer ip.mu.Unlock()
+	cur := ip.policies[bucket]
+	if etag != "" && etag != iamEtag(cur) {
+		return nil, fmtErrorfCode(http.StatusPreconditionFailed, "etag %s does not match current policy etag %s", etag, iamEtag(cur))
+	}
+	next := &iamPolicy{
+		bindings: bindings,
+		version:  1,
+	}
+	if cur != nil {
+		next.version = cur.version + 1
+	}
+	ip.policies[bucket] = next
+	return ip.toPolicy(bucket, next), nil
+}
+
+// delete removes any policy for the given bucket.
+func (ip *iamPolicies) delete(bucket string) {
+	ip.mu.Lock()
+	defer ip.mu.Unlock()
+	delete(ip.policies, bucket)
+}
+
+func (ip *iamPolicies) toPolicy(bucket string, p *iamPolicy) *storage.Policy {
+	ret := &storage.Policy{
+		Kind:       "storage#policy",
+		ResourceId: "projects/_/buckets/" + bucket,
+		Etag:       iamEtag(p),
+		Version:    1,
+	}
+	if p != nil {
+		ret.Bindings = p.bindings
+	}
+	return ret
+}
+
+// iamEtag returns an opaque (base64-encoded, as the client expects) etag for the policy.
+func iamEtag(p *iamPolicy) string {
+	var buf [8]byte
+	if p != nil {
+		binary.BigEndian.PutUint64(buf[:], p.version)
+	}
+	return base64.StdEncoding.EncodeToString(buf[:])
+}
+
+func (g *GcsEmu) handleGcsGetIamPolicy(baseUrl HttpBaseUrl, w http.ResponseWriter, bucket string) {
+	b, err := g.store.GetBucketMeta(baseUrl, bucket)
+	if err != nil {
+		g.gapiError(w, http.StatusInternalServerError, fmt.Sprintf("failed to get meta for %s: %s", bucket, err))
+		return
+	}
+	if b == nil {
+		g.gapiError(w, http.StatusNotFound, fmt.Sprintf("%s not found", bucket))
+		return
+	}
+	g.jsonRespond(w, g.iam.get(bucket))
+}
+
+func (g *GcsEmu) handleGcsSetIamPolicy(ctx context.Context, baseUrl HttpBaseUrl, w http.ResponseWriter, r *http.Request, bucket string) {
+	var req storage.Policy
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		g.gapiError(w, http.StatusBadRequest, "failed to parse body as json")
+		return
+	}
+
+	var policy *storage.Policy
+	err := g.locks.Run(ctx, lockName(bucket, ""), func(ctx context.Context) error {
+		b, err := g.store.GetBucketMeta(baseUrl, bucket)
+		if err != nil {
+			return fmt.Errorf("failed to get meta for %s: %w", bucket, err)
+		}
+		if b == nil {
+			return fmtErrorfCode(http.StatusNotFound, "%s not found", bucket)
+		}
+		policy, err = g.iam.set(bucket, req.Etag, req.Bindings)
+		return err
+	})
+	if err != nil {
+		g.gapiError(w, httpStatusCodeOf(err), err.Error())
+		return
+	}
+	g.jsonRespond(w, policy)
+}
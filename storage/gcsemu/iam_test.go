@@ -0,0 +1,43 @@
+package gcsemu
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"cloud.google.com/go/iam"
+	"cloud.google.com/go/storage"
+	"gotest.tools/v3/assert"
+)
+
+func TestBucketIamPolicy(t *testing.T) {
+	ctx := context.Background()
+	_, gcsClient := newTestServer(t, Options{})
+
+	bh := gcsClient.Bucket("iam-bucket")
+	assert.NilError(t, bh.Create(ctx, "dev", &storage.BucketAttrs{}))
+
+	policy, err := bh.IAM().Policy(ctx)
+	assert.NilError(t, err)
+	assert.Equal(t, 0, len(policy.Roles()))
+	initialEtag := string(policy.InternalProto.Etag)
+
+	// Set a binding, read it back.
+	policy.Add("allUsers", "roles/storage.objectViewer")
+	assert.NilError(t, bh.IAM().SetPolicy(ctx, policy))
+
+	updated, err := bh.IAM().Policy(ctx)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, []iam.RoleName{"roles/storage.objectViewer"}, updated.Roles())
+	assert.DeepEqual(t, []string{"allUsers"}, updated.Members("roles/storage.objectViewer"))
+	assert.Assert(t, initialEtag != string(updated.InternalProto.Etag), "expected etag to change")
+
+	// Setting with the stale etag should fail.
+	policy.Add("allAuthenticatedUsers", "roles/storage.objectViewer")
+	err = bh.IAM().SetPolicy(ctx, policy)
+	assert.Equal(t, http.StatusPreconditionFailed, httpStatusCodeOf(err), "wrong error %T: %s", err, err)
+
+	// Missing bucket.
+	_, err = gcsClient.Bucket(invalidBucketName).IAM().Policy(ctx)
+	assert.Equal(t, http.StatusNotFound, httpStatusCodeOf(err), "wrong error %T: %s", err, err)
+}
@@ -0,0 +1,193 @@
+package gcsemu
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"google.golang.org/api/storage/v1"
+)
+
+// RunLifecycle applies the bucket's lifecycle Delete rules as of now, deleting every object that matches one, and
+// returns the names of the deleted objects. Objects under a hold or retention policy are left alone, as in GCS.
+// Unless Options.LifecycleInterval is set, the emulator never runs lifecycle rules on its own; tests call this to
+// exercise lifecycle-driven cleanup deterministically.
+func (g *GcsEmu) RunLifecycle(ctx context.Context, bucket string, now time.Time) ([]string, error) {
+	meta, err := g.store.GetBucketMeta(dontNeedUrls, bucket)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get meta for %s: %w", bucket, err)
+	}
+	if meta == nil {
+		return nil, fmtErrorfCode(http.StatusNotFound, "The specified bucket does not exist: %s", bucket)
+	}
+
+	var conds []*storage.BucketLifecycleRuleCondition
+	if meta.Lifecycle != nil {
+		for _, rule := range meta.Lifecycle.Rule {
+			if rule.Action != nil && rule.Action.Type == "Delete" && rule.Condition != nil && !emptyCondition(rule.Condition) {
+				conds = append(conds, rule.Condition)
+			}
+		}
+	}
+	if len(conds) == 0 {
+		return nil, nil
+	}
+
+	// Collect candidates first, so the walk isn't disturbed by the deletes.
+	var filenames []string
+	if err := g.store.Walk(ctx, bucket, func(_ context.Context, filename string, fInfo os.FileInfo) error {
+		if fInfo == nil || !fInfo.IsDir() {
+			filenames = append(filenames, filename)
+		}
+		return nil
+	}); err != nil {
+		return nil, fmt.Errorf("failed to iterate %s: %w", bucket, err)
+	}
+
+	var deleted []string
+	for _, filename := range filenames {
+		err := g.locks.Run(ctx, lockName(bucket, filename), func(ctx context.Context) error {
+			obj, err := g.store.GetMeta(dontNeedUrls, bucket, filename)
+			if err != nil {
+				return fmt.Errorf("failed to check existence of %s/%s: %w", bucket, filename, err)
+			}
+			if obj == nil || checkRetention(obj, now) != nil {
+				return nil
+			}
+			for _, cond := range conds {
+				if lifecycleMatches(cond, obj, now) {
+					if err := g.store.Delete(bucket, filename); err != nil && !os.IsNotExist(err) {
+						return fmt.Errorf("failed to delete %s/%s: %w", bucket, filename, err)
+					}
+					deleted = append(deleted, filename)
+					return nil
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return deleted, err
+		}
+	}
+	return deleted, nil
+}
+
+// bucketLister is implemented by stores that can enumerate their buckets, for the background lifecycle sweep.
+type bucketLister interface {
+	bucketNames() ([]string, error)
+}
+
+// lifecycleLoop runs every bucket's lifecycle rules each interval until the emulator is closed.
+func (g *GcsEmu) lifecycleLoop(interval time.Duration) {
+	lister, ok := g.store.(bucketLister)
+	if !ok {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-g.done:
+			return
+		case <-ticker.C:
+		}
+
+		buckets, err := lister.bucketNames()
+		if err != nil {
+			g.log(err, "failed to list buckets for lifecycle")
+			continue
+		}
+		for _, bucket := range buckets {
+			if _, err := g.RunLifecycle(context.Background(), bucket, g.now()); err != nil {
+				g.log(err, "failed to run lifecycle for %s", bucket)
+			}
+		}
+	}
+}
+
+// emptyCondition reports whether a lifecycle condition has no fields set. GCS rejects such rules, so the emulator
+// treats them as matching nothing rather than every object.
+func emptyCondition(cond *storage.BucketLifecycleRuleCondition) bool {
+	return cond.Age == nil && cond.CreatedBefore == "" && cond.CustomTimeBefore == "" &&
+		cond.DaysSinceCustomTime == 0 && cond.DaysSinceNoncurrentTime == 0 && cond.IsLive == nil &&
+		cond.MatchesPattern == "" && len(cond.MatchesPrefix) == 0 && len(cond.MatchesStorageClass) == 0 &&
+		len(cond.MatchesSuffix) == 0 && cond.NoncurrentTimeBefore == "" && cond.NumNewerVersions == 0
+}
+
+// lifecycleMatches reports whether a live object satisfies every condition of a lifecycle rule. Conditions that only
+// apply to noncurrent versions never match, since the emulator keeps no older generations.
+func lifecycleMatches(cond *storage.BucketLifecycleRuleCondition, obj *storage.Object, now time.Time) bool {
+	if emptyCondition(cond) {
+		return false
+	}
+	if cond.IsLive != nil && !*cond.IsLive {
+		return false
+	}
+	if cond.NumNewerVersions != 0 || cond.DaysSinceNoncurrentTime != 0 || cond.NoncurrentTimeBefore != "" {
+		return false
+	}
+
+	if cond.Age != nil || cond.CreatedBefore != "" {
+		created, err := time.Parse(time.RFC3339Nano, obj.TimeCreated)
+		if err != nil {
+			return false
+		}
+		if cond.Age != nil && int64(now.Sub(created)/(24*time.Hour)) < *cond.Age {
+			return false
+		}
+		if cond.CreatedBefore != "" && !beforeDate(created, cond.CreatedBefore) {
+			return false
+		}
+	}
+
+	if cond.DaysSinceCustomTime != 0 || cond.CustomTimeBefore != "" {
+		customTime, err := time.Parse(time.RFC3339Nano, obj.CustomTime)
+		if err != nil {
+			return false
+		}
+		if int64(now.Sub(customTime)/(24*time.Hour)) < cond.DaysSinceCustomTime {
+			return false
+		}
+		if cond.CustomTimeBefore != "" && !beforeDate(customTime, cond.CustomTimeBefore) {
+			return false
+		}
+	}
+
+	if len(cond.MatchesPrefix) > 0 && !matchesAny(obj.Name, cond.MatchesPrefix, strings.HasPrefix) {
+		return false
+	}
+	if len(cond.MatchesSuffix) > 0 && !matchesAny(obj.Name, cond.MatchesSuffix, strings.HasSuffix) {
+		return false
+	}
+	if len(cond.MatchesStorageClass) > 0 && !matchesAny(obj.StorageClass, cond.MatchesStorageClass, func(s, class string) bool {
+		return s == class
+	}) {
+		return false
+	}
+	if cond.MatchesPattern != "" {
+		re, err := regexp.Compile(cond.MatchesPattern)
+		if err != nil || !re.MatchString(obj.Name) {
+			return false
+		}
+	}
+	return true
+}
+
+// beforeDate reports whether t is before midnight UTC of the given "2006-01-02" date.
+func beforeDate(t time.Time, date string) bool {
+	d, err := time.Parse("2006-01-02", date)
+	return err == nil && t.Before(d)
+}
+
+func matchesAny(s string, patterns []string, match func(s, pattern string) bool) bool {
+	for _, p := range patterns {
+		if match(s, p) {
+			return true
+		}
+	}
+	return false
+}
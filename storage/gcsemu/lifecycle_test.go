@@ -0,0 +1,134 @@
+package gcsemu
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/storage"
+	api "google.golang.org/api/storage/v1"
+	"gotest.tools/v3/assert"
+)
+
+func TestRunLifecycle(t *testing.T) {
+	ctx := context.Background()
+	svr, gcsClient := newTestServer(t, Options{})
+	assert.NilError(t, svr.InitBucket("lifecycle-bucket"))
+	bh := gcsClient.Bucket("lifecycle-bucket")
+
+	now := time.Now()
+	_, err := bh.Update(ctx, storage.BucketAttrsToUpdate{Lifecycle: &storage.Lifecycle{Rules: []storage.LifecycleRule{{
+		Action:    storage.LifecycleAction{Type: storage.DeleteAction},
+		Condition: storage.LifecycleCondition{AgeInDays: 1},
+	}}}})
+	assert.NilError(t, err)
+
+	seed := func(filename string, created time.Time, hold bool) {
+		t.Helper()
+		assert.NilError(t, svr.Seed("lifecycle-bucket", filename, []byte(v1), &api.Object{
+			TimeCreated:   created.UTC().Format(time.RFC3339Nano),
+			TemporaryHold: hold,
+		}))
+	}
+	seed("old.txt", now.Add(-48*time.Hour), false)
+	seed("held.txt", now.Add(-48*time.Hour), true)
+	seed("new.txt", now.Add(-time.Hour), false)
+
+	deleted, err := svr.RunLifecycle(ctx, "lifecycle-bucket", now)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, []string{"old.txt"}, deleted)
+
+	_, err = bh.Object("old.txt").Attrs(ctx)
+	assert.Equal(t, storage.ErrObjectNotExist, err)
+	for _, name := range []string{"held.txt", "new.txt"} {
+		_, err = bh.Object(name).Attrs(ctx)
+		assert.NilError(t, err, name)
+	}
+
+	// Once it's a day old, the new object goes too.
+	deleted, err = svr.RunLifecycle(ctx, "lifecycle-bucket", now.Add(24*time.Hour))
+	assert.NilError(t, err)
+	assert.DeepEqual(t, []string{"new.txt"}, deleted)
+}
+
+func TestLifecycleEmptyCondition(t *testing.T) {
+	ctx := context.Background()
+	svr, _ := newTestServer(t, Options{})
+	assert.NilError(t, svr.InitBucket("lifecycle-bucket"))
+	meta, err := svr.store.GetBucketMeta(dontNeedUrls, "lifecycle-bucket")
+	assert.NilError(t, err)
+	meta.Lifecycle = &api.BucketLifecycle{Rule: []*api.BucketLifecycleRule{{
+		Action:    &api.BucketLifecycleRuleAction{Type: "Delete"},
+		Condition: &api.BucketLifecycleRuleCondition{},
+	}}}
+	assert.NilError(t, svr.store.UpdateBucketMeta("lifecycle-bucket", meta))
+	assert.NilError(t, svr.Seed("lifecycle-bucket", "file.txt", []byte(v1), nil))
+
+	// A Delete rule with no conditions must not wipe the bucket.
+	deleted, err := svr.RunLifecycle(ctx, "lifecycle-bucket", time.Now())
+	assert.NilError(t, err)
+	assert.Equal(t, 0, len(deleted))
+}
+
+func TestLifecycleInterval(t *testing.T) {
+	ctx := context.Background()
+	svr, gcsClient := newTestServer(t, Options{LifecycleInterval: 10 * time.Millisecond})
+	assert.NilError(t, svr.InitBucket("lifecycle-bucket"))
+	bh := gcsClient.Bucket("lifecycle-bucket")
+	_, err := bh.Update(ctx, storage.BucketAttrsToUpdate{Lifecycle: &storage.Lifecycle{Rules: []storage.LifecycleRule{{
+		Action:    storage.LifecycleAction{Type: storage.DeleteAction},
+		Condition: storage.LifecycleCondition{AgeInDays: 1},
+	}}}})
+	assert.NilError(t, err)
+	assert.NilError(t, svr.Seed("lifecycle-bucket", "old.txt", []byte(v1), &api.Object{
+		TimeCreated: time.Now().Add(-48 * time.Hour).UTC().Format(time.RFC3339Nano),
+	}))
+
+	// The background sweep deletes the old object without an explicit RunLifecycle.
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		_, err = bh.Object("old.txt").Attrs(ctx)
+		if err == storage.ErrObjectNotExist {
+			break
+		}
+		assert.NilError(t, err)
+		if time.Now().After(deadline) {
+			t.Fatal("old.txt was not deleted by the background lifecycle sweep")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestLifecycleMatches(t *testing.T) {
+	now := time.Date(2024, 6, 10, 12, 0, 0, 0, time.UTC)
+	obj := &api.Object{
+		Name:         "logs/app.log",
+		StorageClass: "STANDARD",
+		TimeCreated:  now.Add(-72 * time.Hour).Format(time.RFC3339Nano),
+	}
+	age := func(days int64) *int64 { return &days }
+	notLive := false
+
+	for _, tc := range []struct {
+		name string
+		cond api.BucketLifecycleRuleCondition
+		want bool
+	}{
+		{"age met", api.BucketLifecycleRuleCondition{Age: age(3)}, true},
+		{"age not met", api.BucketLifecycleRuleCondition{Age: age(4)}, false},
+		{"created before", api.BucketLifecycleRuleCondition{CreatedBefore: "2024-06-08"}, true},
+		{"created after", api.BucketLifecycleRuleCondition{CreatedBefore: "2024-06-07"}, false},
+		{"prefix", api.BucketLifecycleRuleCondition{Age: age(0), MatchesPrefix: []string{"tmp/", "logs/"}}, true},
+		{"wrong prefix", api.BucketLifecycleRuleCondition{Age: age(0), MatchesPrefix: []string{"tmp/"}}, false},
+		{"suffix", api.BucketLifecycleRuleCondition{MatchesSuffix: []string{".log"}}, true},
+		{"storage class", api.BucketLifecycleRuleCondition{MatchesStorageClass: []string{"NEARLINE"}}, false},
+		{"noncurrent only", api.BucketLifecycleRuleCondition{NumNewerVersions: 1}, false},
+		{"not live", api.BucketLifecycleRuleCondition{IsLive: &notLive}, false},
+		{"no custom time", api.BucketLifecycleRuleCondition{DaysSinceCustomTime: 1}, false},
+		{"empty", api.BucketLifecycleRuleCondition{}, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.want, lifecycleMatches(&tc.cond, obj, now))
+		})
+	}
+}
@@ -2,6 +2,9 @@ package gcsemu
 
 import (
 	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"net/http"
 	"os"
 	"sync"
 	"time"
@@ -13,6 +16,11 @@ import (
 type memstore struct {
 	mu      sync.RWMutex
 	buckets map[string]*memBucket
+
+	// Total size of stored object contents, and the cap on it if >0; see Options.MaxStorageBytes.
+	usageMu  sync.Mutex
+	used     int64
+	maxBytes int64
 }
 
 var _ Store = (*memstore)(nil)
@@ -22,8 +30,26 @@ func NewMemStore() *memstore {
 	return &memstore{buckets: map[string]*memBucket{}}
 }
 
+func (ms *memstore) setMaxBytes(maxBytes int64) {
+	ms.usageMu.Lock()
+	defer ms.usageMu.Unlock()
+	ms.maxBytes = maxBytes
+}
+
+// reserve adjusts the tracked usage by delta bytes, failing without change if growth would exceed the cap.
+func (ms *memstore) reserve(delta int64) error {
+	ms.usageMu.Lock()
+	defer ms.usageMu.Unlock()
+	if delta > 0 && ms.maxBytes > 0 && ms.used+delta > ms.maxBytes {
+		return fmtErrorfCode(http.StatusInsufficientStorage, "storage limit of %d bytes exceeded: %d bytes in use, %d more requested", ms.maxBytes, ms.used, delta)
+	}
+	ms.used += delta
+	return nil
+}
+
 type memBucket struct {
 	created time.Time
+	meta    storage.Bucket
 
 	// mutex required (despite lock map in gcsemu), because btree mutations are not structurally safe
 	mu    sync.RWMutex
@@ -60,15 +86,43 @@ func (ms *memstore) CreateBucket(bucket string) error {
 	return nil
 }
 
+// bucketNames returns the names of all buckets; see bucketLister.
+func (ms *memstore) bucketNames() ([]string, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+	var names []string
+	for name := range ms.buckets {
+		names = append(names, name)
+	}
+	return names, nil
+}
+
 func (ms *memstore) GetBucketMeta(baseUrl HttpBaseUrl, bucket string) (*storage.Bucket, error) {
 	if b := ms.getBucket(bucket); b != nil {
-		obj := BucketMeta(baseUrl, bucket)
-		obj.Updated = b.created.UTC().Format(time.RFC3339Nano)
-		return obj, nil
+		b.mu.RLock()
+		obj := b.meta
+		b.mu.RUnlock()
+		InitBucketMetaWithUrls(baseUrl, &obj, bucket)
+		obj.TimeCreated = b.created.UTC().Format(time.RFC3339Nano)
+		obj.Updated = obj.TimeCreated
+		return &obj, nil
 	}
 	return nil, nil
 }
 
+func (ms *memstore) UpdateBucketMeta(bucket string, meta *storage.Bucket) error {
+	b := ms.getBucket(bucket)
+	if b == nil {
+		return os.ErrNotExist
+	}
+
+	ScrubBucketMeta(meta)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.meta = *meta
+	return nil
+}
+
 func (ms *memstore) Get(baseUrl HttpBaseUrl, bucket string, filename string) (*storage.Object, []byte, error) {
 	f := ms.find(bucket, filename)
 	if f != nil {
@@ -87,6 +141,14 @@ func (ms *memstore) GetMeta(baseUrl HttpBaseUrl, bucket string, filename string)
 	return nil, nil
 }
 
+func (ms *memstore) Stat(bucket string, filename string) (bool, int64, error) {
+	f := ms.find(bucket, filename)
+	if f == nil {
+		return false, 0, nil
+	}
+	return true, int64(len(f.data)), nil
+}
+
 func (ms *memstore) Add(bucket string, filename string, contents []byte, meta *storage.Object) error {
 	_ = ms.CreateBucket(bucket)
 
@@ -100,10 +162,20 @@ func (ms *memstore) Add(bucket string, filename string, contents []byte, meta *s
 	if meta.TimeCreated == "" {
 		meta.TimeCreated = meta.Updated
 	}
+	if meta.TimeStorageClassUpdated == "" {
+		meta.TimeStorageClassUpdated = meta.TimeCreated
+	}
 
 	b := ms.getBucket(bucket)
 	b.mu.Lock()
 	defer b.mu.Unlock()
+	delta := int64(len(contents))
+	if old := b.files.Get(ms.key(filename)); old != nil {
+		delta -= int64(len(old.(*memFile).data))
+	}
+	if err := ms.reserve(delta); err != nil {
+		return err
+	}
 	b.files.ReplaceOrInsert(&memFile{
 		meta: *meta,
 		data: contents,
@@ -111,6 +183,17 @@ func (ms *memstore) Add(bucket string, filename string, contents []byte, meta *s
 	return nil
 }
 
+// Seed inserts an object directly into the store, creating the bucket as needed. The given meta is optional.
+func (ms *memstore) Seed(bucket string, filename string, contents []byte, meta *storage.Object) error {
+	obj := storage.Object{}
+	if meta != nil {
+		obj = *meta
+	}
+	hash := md5.Sum(contents)
+	obj.Md5Hash = base64.StdEncoding.EncodeToString(hash[:])
+	return ms.Add(bucket, filename, contents, &obj)
+}
+
 func (ms *memstore) UpdateMeta(bucket string, filename string, meta *storage.Object, metagen int64) error {
 	f := ms.find(bucket, filename)
 	if f == nil {
@@ -139,6 +222,7 @@ func (ms *memstore) Copy(srcBucket string, srcFile string, dstBucket string, dst
 	// Copy with metadata
 	meta := src.meta
 	meta.TimeCreated = "" // reset creation time on the dest file
+	meta.TimeStorageClassUpdated = ""
 	err := ms.Add(dstBucket, dstFile, src.data, &meta)
 	if err != nil {
 		return false, err
@@ -147,24 +231,65 @@ func (ms *memstore) Copy(srcBucket string, srcFile string, dstBucket string, dst
 	return true, nil
 }
 
+func (ms *memstore) Move(bucket string, srcFile string, dstFile string) (bool, error) {
+	b := ms.getBucket(bucket)
+	if b == nil {
+		return false, nil
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	item := b.files.Delete(ms.key(srcFile))
+	if item == nil {
+		return false, nil
+	}
+	src := item.(*memFile)
+
+	// The moved object is a new generation under the new name.
+	meta := src.meta
+	now := time.Now().UTC()
+	meta.Name = dstFile
+	meta.Metageneration = 1
+	meta.Updated = now.Format(time.RFC3339Nano)
+	meta.Generation = now.UnixNano()
+	if replaced := b.files.ReplaceOrInsert(&memFile{
+		meta: meta,
+		data: src.data,
+	}); replaced != nil {
+		_ = ms.reserve(-int64(len(replaced.(*memFile).data)))
+	}
+	return true, nil
+}
+
 func (ms *memstore) Delete(bucket string, filename string) error {
 	if filename == "" {
 		// Remove the bucket
 		ms.mu.Lock()
 		defer ms.mu.Unlock()
-		if _, ok := ms.buckets[bucket]; !ok {
+		b, ok := ms.buckets[bucket]
+		if !ok {
 			return os.ErrNotExist
 		}
 
 		delete(ms.buckets, bucket)
+		var size int64
+		b.mu.RLock()
+		b.files.Ascend(func(i btree.Item) bool {
+			size += int64(len(i.(*memFile).data))
+			return true
+		})
+		b.mu.RUnlock()
+		_ = ms.reserve(-size)
 	} else if b := ms.getBucket(bucket); b != nil {
 		// Remove just the file
 		b.mu.Lock()
 		defer b.mu.Unlock()
-		if b.files.Delete(ms.key(filename)) == nil {
+		item := b.files.Delete(ms.key(filename))
+		if item == nil {
 			// case file does not exist
 			return os.ErrNotExist
 		}
+		_ = ms.reserve(-int64(len(item.(*memFile).data)))
 	} else {
 		return os.ErrNotExist
 	}
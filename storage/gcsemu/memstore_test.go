@@ -2,10 +2,15 @@ package gcsemu
 
 import (
 	"context"
+	"crypto/md5"
+	"io"
 	"net/http"
 	"net/http/httptest"
 	"testing"
 
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/iterator"
+	api "google.golang.org/api/storage/v1"
 	"gotest.tools/v3/assert"
 )
 
@@ -59,3 +64,79 @@ func TestMemStore(t *testing.T) {
 		testRawHttp(t, bh, http.DefaultClient, svr.URL)
 	})
 }
+
+func TestSeed(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemStore()
+	svr, gcsClient := newTestServer(t, Options{Store: store})
+
+	assert.NilError(t, store.Seed("seed-bucket", "store/a.txt", []byte(v1), nil))
+	assert.NilError(t, svr.Seed("seed-bucket", "emu/b.txt", []byte(v2), &api.Object{ContentType: "text/plain"}))
+	assert.NilError(t, svr.Seed("other-bucket", "c.csv", []byte(source1), &api.Object{Metadata: map[string]string{"k": "v"}}))
+
+	for _, tc := range []struct {
+		bucket, name, content, contentType string
+	}{
+		{"seed-bucket", "emu/b.txt", v2, "text/plain"},
+		{"seed-bucket", "store/a.txt", v1, ""},
+		{"other-bucket", "c.csv", source1, detectContentTypeByExtension("c.csv", nil)},
+	} {
+		oh := gcsClient.Bucket(tc.bucket).Object(tc.name)
+		attrs, err := oh.Attrs(ctx)
+		assert.NilError(t, err)
+		assert.Equal(t, tc.contentType, attrs.ContentType)
+		sum := md5.Sum([]byte(tc.content))
+		assert.DeepEqual(t, sum[:], attrs.MD5)
+
+		r, err := oh.NewReader(ctx)
+		assert.NilError(t, err)
+		data, err := io.ReadAll(r)
+		assert.NilError(t, err)
+		assert.NilError(t, r.Close())
+		assert.Equal(t, tc.content, string(data))
+	}
+
+	attrs, err := gcsClient.Bucket("other-bucket").Object("c.csv").Attrs(ctx)
+	assert.NilError(t, err)
+	assert.Equal(t, "v", attrs.Metadata["k"])
+
+	// Seeded objects are visible to listing.
+	it := gcsClient.Bucket("seed-bucket").Objects(ctx, nil)
+	var names []string
+	for {
+		attrs, err := it.Next()
+		if err == iterator.Done {
+			break
+		}
+		assert.NilError(t, err)
+		names = append(names, attrs.Name)
+	}
+	assert.DeepEqual(t, []string{"emu/b.txt", "store/a.txt"}, names)
+}
+
+func TestMemStoreMaxStorageBytes(t *testing.T) {
+	ctx := context.Background()
+	svr, gcsClient := newTestServer(t, Options{MaxStorageBytes: 2 * int64(len(v1))})
+	assert.NilError(t, svr.InitBucket("quota-bucket"))
+	bh := gcsClient.Bucket("quota-bucket")
+
+	// Fill up to the cap exactly; the next byte doesn't fit, however it's written.
+	assert.NilError(t, write(bh.Object("one.txt").NewWriter(ctx), v1))
+	assert.NilError(t, write(bh.Object("two.txt").NewWriter(ctx), v1))
+	err := write(bh.Object("three.txt").NewWriter(ctx), "x")
+	assert.Equal(t, http.StatusInsufficientStorage, httpStatusCodeOf(err), "wrong error %T: %s", err, err)
+	_, err = bh.Object("three.txt").Attrs(ctx)
+	assert.Equal(t, storage.ErrObjectNotExist, err, "wrong error")
+	_, err = bh.Object("three.txt").CopierFrom(bh.Object("one.txt")).Run(ctx)
+	assert.Equal(t, http.StatusInsufficientStorage, httpStatusCodeOf(err), "wrong error %T: %s", err, err)
+	_, err = bh.Object("three.txt").ComposerFrom(bh.Object("one.txt")).Run(ctx)
+	assert.Equal(t, http.StatusInsufficientStorage, httpStatusCodeOf(err), "wrong error %T: %s", err, err)
+
+	// Replacing an object only counts the difference, and deleting one frees its space.
+	err = write(bh.Object("two.txt").NewWriter(ctx), v2)
+	assert.Equal(t, http.StatusInsufficientStorage, httpStatusCodeOf(err), "wrong error %T: %s", err, err)
+	assert.NilError(t, write(bh.Object("two.txt").NewWriter(ctx), "short"))
+	assert.NilError(t, write(bh.Object("three.txt").NewWriter(ctx), "x"))
+	assert.NilError(t, bh.Object("one.txt").Delete(ctx))
+	assert.NilError(t, write(bh.Object("four.txt").NewWriter(ctx), v1))
+}
@@ -1,8 +1,11 @@
 package gcsemu
 
 import (
+	"encoding/json"
 	"fmt"
 	"mime"
+	"net/http"
+	"path"
 	"strings"
 
 	"google.golang.org/api/storage/v1"
@@ -10,12 +13,41 @@ import (
 
 // BucketMeta returns a default bucket metadata for the given name and base url.
 func BucketMeta(baseUrl HttpBaseUrl, bucket string) *storage.Bucket {
-	return &storage.Bucket{
-		Kind:         "storage#bucket",
-		Name:         bucket,
-		SelfLink:     BucketUrl(baseUrl, bucket),
-		StorageClass: "STANDARD",
+	meta := &storage.Bucket{}
+	InitBucketMetaWithUrls(baseUrl, meta, bucket)
+	return meta
+}
+
+// InitBucketMetaWithUrls "bakes" bucket metadata with intrinsic values, including computed links.
+func InitBucketMetaWithUrls(baseUrl HttpBaseUrl, meta *storage.Bucket, bucket string) {
+	meta.Kind = "storage#bucket"
+	meta.Name = bucket
+	meta.SelfLink = BucketUrl(baseUrl, bucket)
+	meta.Id = bucket
+	if meta.StorageClass == "" {
+		meta.StorageClass = "STANDARD"
 	}
+	if meta.Location == "" {
+		meta.Location = "US"
+		meta.LocationType = "multi-region"
+	}
+}
+
+// ScrubBucketMeta removes bucket fields that are intrinsic / computed for minimal storage.
+func ScrubBucketMeta(meta *storage.Bucket) {
+	meta.Kind = ""
+	meta.Name = ""
+	meta.SelfLink = ""
+	meta.Updated = ""
+}
+
+// detectContentTypeByExtension is the default content type detection: the registered mime type for the filename's
+// extension, if any.
+func detectContentTypeByExtension(filename string, _ []byte) string {
+	if ct := mime.TypeByExtension(path.Ext(filename)); ct != "" {
+		return ct
+	}
+	return "application/octet-stream"
 }
 
 // InitScrubbedMeta "bakes" metadata with intrinsic values and removes fields that are intrinsic / computed.
@@ -44,7 +76,13 @@ func InitMetaWithUrls(baseUrl HttpBaseUrl, meta *storage.Object, bucket string,
 	meta.Name = filename
 	meta.SelfLink = ObjectUrl(baseUrl, bucket, filename)
 	meta.Size = size
-	meta.StorageClass = "STANDARD"
+	if meta.StorageClass == "" {
+		meta.StorageClass = "STANDARD"
+	}
+	// Always report the size, even for empty objects; copy the slice so stored metadata is never aliased.
+	if !hasField(meta.ForceSendFields, "Size") {
+		meta.ForceSendFields = append(meta.ForceSendFields[:len(meta.ForceSendFields):len(meta.ForceSendFields)], "Size")
+	}
 }
 
 // ScrubMeta removes fields that are intrinsic / computed for minimal storage.
@@ -54,7 +92,72 @@ func ScrubMeta(meta *storage.Object) {
 	meta.MediaLink = ""
 	meta.SelfLink = ""
 	meta.Size = 0
-	meta.StorageClass = ""
+	if hasField(meta.ForceSendFields, "Size") {
+		var fields []string
+		for _, f := range meta.ForceSendFields {
+			if f != "Size" {
+				fields = append(fields, f)
+			}
+		}
+		meta.ForceSendFields = fields
+	}
+}
+
+// hasField reports whether fields contains the given field name.
+func hasField(fields []string, name string) bool {
+	for _, f := range fields {
+		if f == name {
+			return true
+		}
+	}
+	return false
+}
+
+// patchMetadata merges a patch request's raw "metadata" field into the existing custom metadata: keys with null values
+// are removed, and a null field clears everything. An object left with no custom metadata gets a nil map, so the field
+// is omitted from responses rather than serialized as {}.
+func patchMetadata(existing map[string]string, raw json.RawMessage) (map[string]string, error) {
+	if raw == nil {
+		return existing, nil
+	}
+	var patch map[string]*string
+	if err := json.Unmarshal(raw, &patch); err != nil {
+		return nil, err
+	}
+	if patch == nil {
+		return nil, nil
+	}
+
+	merged := make(map[string]string, len(existing)+len(patch))
+	for k, v := range existing {
+		merged[k] = v
+	}
+	for k, v := range patch {
+		if v == nil {
+			delete(merged, k)
+		} else {
+			merged[k] = *v
+		}
+	}
+	if len(merged) == 0 {
+		return nil, nil
+	}
+	return merged, nil
+}
+
+// gcsMaxMetadataSize is the documented limit on the total size of an object's custom metadata keys and values.
+const gcsMaxMetadataSize = 8 << 10
+
+// validateMetadataSize returns a 400 error if the custom metadata exceeds gcsMaxMetadataSize.
+func validateMetadataSize(metadata map[string]string) error {
+	size := 0
+	for k, v := range metadata {
+		size += len(k) + len(v)
+	}
+	if size > gcsMaxMetadataSize {
+		return fmtErrorfCode(http.StatusBadRequest, "custom metadata size %d exceeds maximum of %d bytes", size, gcsMaxMetadataSize)
+	}
+	return nil
 }
 
 // BucketUrl returns the URL for a bucket.
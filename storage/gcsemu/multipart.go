@@ -7,6 +7,7 @@ import (
 	"mime"
 	"mime/multipart"
 	"net/http"
+	"net/textproto"
 
 	"google.golang.org/api/storage/v1"
 )
@@ -27,25 +28,28 @@ func readMultipartInsert(r *http.Request) (*storage.Object, []byte, error) {
 
 	reader := multipart.NewReader(r.Body, boundary)
 
-	readPart := func() ([]byte, error) {
+	readPart := func() ([]byte, textproto.MIMEHeader, error) {
 		part, err := reader.NextPart()
 		if err != nil {
-			return nil, fmt.Errorf("failed to get multipart: %w", err)
+			return nil, nil, fmt.Errorf("failed to get multipart: %w", err)
 		}
 
 		b, err := io.ReadAll(part)
 		if err != nil {
-			return nil, fmt.Errorf("failed to get read multipart: %w", err)
+			return nil, nil, fmt.Errorf("failed to get read multipart: %w", err)
 		}
 
-		return b, nil
+		return b, part.Header, nil
 	}
 
 	// read the first part to get the storage.Object (in json)
-	b, err := readPart()
+	b, header, err := readPart()
 	if err != nil {
 		return nil, nil, fmt.Errorf("failed to read first part of body: %w", err)
 	}
+	if ct := header.Get("Content-Type"); !isJsonContentType(ct) {
+		return nil, nil, fmt.Errorf("first part of body must be application/json metadata, got Content-Type %q", ct)
+	}
 
 	var obj storage.Object
 	err = json.Unmarshal(b, &obj)
@@ -54,12 +58,26 @@ func readMultipartInsert(r *http.Request) (*storage.Object, []byte, error) {
 	}
 
 	// read the next part to get the file contents
-	contents, err := readPart()
+	contents, _, err := readPart()
 	if err != nil {
 		return nil, nil, fmt.Errorf("failed to read second part of body: %w", err)
 	}
 
+	// there must be nothing else
+	if _, err := reader.NextPart(); err != io.EOF {
+		if err == nil {
+			return nil, nil, fmt.Errorf("unexpected extra part in body; expected only metadata and contents")
+		}
+		return nil, nil, fmt.Errorf("failed to read end of body: %w", err)
+	}
+
 	obj.Size = uint64(len(contents))
 
 	return &obj, contents, nil
 }
+
+// isJsonContentType reports whether a Content-Type header names application/json, ignoring parameters like charset.
+func isJsonContentType(v string) bool {
+	d, _, err := mime.ParseMediaType(v)
+	return err == nil && d == "application/json"
+}
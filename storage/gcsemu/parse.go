@@ -14,6 +14,10 @@ const (
 	gcsBucketPathPattern = "/storage/v1/b(?:/([^\\/]+))?"
 	// example: "/my-bucket/2013-tax-returns.pdf" (for a file)
 	gcsStoragePathPattern = "/([^\\/]+)/(.+)"
+	// example: "/storage/v1/projects/my-project/serviceAccount"
+	gcsServiceAccountPathPattern = "^/storage/v1/projects/([^\\/]+)/serviceAccount$"
+	// example: "/storage/v1/b/my-bucket/o/2013-tax-returns.pdf/acl/allUsers" (matched against the escaped path)
+	gcsObjectAclPathPattern = "^(?:/storage/v1)?/b/([^\\/]+)/o/([^\\/]+)/acl(?:/([^\\/]+))?$"
 )
 
 var (
@@ -21,6 +25,9 @@ var (
 	gcsObjectPathRegex2 = regexp.MustCompile(gcsObjectPathPattern2)
 	gcsBucketPathRegex  = regexp.MustCompile(gcsBucketPathPattern)
 	gcsStoragePathRegex = regexp.MustCompile(gcsStoragePathPattern)
+
+	gcsServiceAccountPathRegex = regexp.MustCompile(gcsServiceAccountPathPattern)
+	gcsObjectAclPathRegex      = regexp.MustCompile(gcsObjectAclPathPattern)
 )
 
 // GcsParams represent a parsed GCS url.
@@ -48,6 +55,15 @@ func ParseGcsUrl(u *url.URL) (*GcsParams, bool) {
 	return nil, false
 }
 
+// ParseServiceAccountUrl parses a project service account url, returning the project.
+func ParseServiceAccountUrl(u *url.URL) (string, bool) {
+	submatches := gcsServiceAccountPathRegex.FindStringSubmatch(u.Path)
+	if submatches == nil {
+		return "", false
+	}
+	return submatches[1], true
+}
+
 func parseGcsUrl(re *regexp.Regexp, u *url.URL) (*GcsParams, bool) {
 	submatches := re.FindStringSubmatch(u.Path)
 	if submatches == nil {
@@ -63,3 +79,21 @@ func parseGcsUrl(re *regexp.Regexp, u *url.URL) (*GcsParams, bool) {
 	}
 	return g, true
 }
+
+// ParseObjectAclUrl parses an object ACL url, returning the bucket, the object, and the ACL entity, which is empty
+// when the url addresses the whole ACL.
+func ParseObjectAclUrl(u *url.URL) (bucket, object, entity string, ok bool) {
+	// Match on the escaped path, so that an object named ".../acl" isn't mistaken for an ACL request.
+	submatches := gcsObjectAclPathRegex.FindStringSubmatch(u.EscapedPath())
+	if submatches == nil {
+		return "", "", "", false
+	}
+	var err error
+	unescaped := make([]string, 3)
+	for i, s := range submatches[1:] {
+		if unescaped[i], err = url.PathUnescape(s); err != nil {
+			return "", "", "", false
+		}
+	}
+	return unescaped[0], unescaped[1], unescaped[2], true
+}
@@ -50,3 +50,57 @@ func parseByteRange(in string) *byteRange {
 
 	return &ret
 }
+
+// parseRangeHeader parses a single-range Range request header such as "bytes=0-99", "bytes=100-", or "bytes=-50",
+// resolving it against an object of the given size. Returns nil if the header is absent or cannot be parsed, in
+// which case the entire object should be served. The returned range is unsatisfiable if lo >= sz.
+func parseRangeHeader(in string, size int64) *byteRange {
+	if !strings.HasPrefix(in, "bytes=") {
+		return nil
+	}
+	in = strings.TrimPrefix(in, "bytes=")
+	if strings.Contains(in, ",") {
+		// multiple ranges are not supported; serve the entire object
+		return nil
+	}
+	parts := strings.Split(in, "-")
+	if len(parts) != 2 {
+		return nil
+	}
+
+	ret := byteRange{
+		lo: 0,
+		hi: size - 1,
+		sz: size,
+	}
+
+	if parts[0] == "" {
+		// suffix range: the last n bytes
+		n, err := strconv.ParseInt(parts[1], 10, 64)
+		if err != nil || n < 0 {
+			return nil
+		}
+		if n == 0 {
+			ret.lo = size // unsatisfiable
+		} else if n < size {
+			ret.lo = size - n
+		}
+		return &ret
+	}
+
+	lo, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil || lo < 0 {
+		return nil
+	}
+	ret.lo = lo
+	if parts[1] != "" {
+		hi, err := strconv.ParseInt(parts[1], 10, 64)
+		if err != nil || hi < lo {
+			return nil
+		}
+		if hi < ret.hi {
+			ret.hi = hi
+		}
+	}
+	return &ret
+}
@@ -1,8 +1,13 @@
 package gcsemu
 
 import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
 	"testing"
 
+	"cloud.google.com/go/storage"
 	"gotest.tools/v3/assert"
 )
 
@@ -21,3 +26,58 @@ func TestParseByteRange(t *testing.T) {
 		assert.Equal(t, tc.expect, *parseByteRange(tc.in))
 	}
 }
+
+func TestParseRangeHeader(t *testing.T) {
+	tcs := []struct {
+		in     string
+		expect *byteRange
+	}{
+		{in: "", expect: nil},
+		{in: "bytes=0-9", expect: &byteRange{lo: 0, hi: 9, sz: 100}},
+		{in: "bytes=10-", expect: &byteRange{lo: 10, hi: 99, sz: 100}},
+		{in: "bytes=90-200", expect: &byteRange{lo: 90, hi: 99, sz: 100}},
+		{in: "bytes=-10", expect: &byteRange{lo: 90, hi: 99, sz: 100}},
+		{in: "bytes=-200", expect: &byteRange{lo: 0, hi: 99, sz: 100}},
+		{in: "bytes=100-", expect: &byteRange{lo: 100, hi: 99, sz: 100}},
+		{in: "bytes=9-0", expect: nil},
+		{in: "bytes=0-1,5-6", expect: nil},
+	}
+
+	for _, tc := range tcs {
+		t.Logf("test case: %s", tc.in)
+		got := parseRangeHeader(tc.in, 100)
+		if tc.expect == nil {
+			assert.Assert(t, got == nil, "expected nil, got %v", got)
+		} else {
+			assert.Assert(t, got != nil, "expected %v, got nil", *tc.expect)
+			assert.Equal(t, *tc.expect, *got)
+		}
+	}
+}
+
+func TestRangeRequests(t *testing.T) {
+	ctx := context.Background()
+	svr, gcsClient := newTestServer(t, Options{})
+
+	bh := gcsClient.Bucket("range-bucket")
+	assert.NilError(t, bh.Create(ctx, "dev", &storage.BucketAttrs{}))
+	oh := bh.Object("range.txt")
+	assert.NilError(t, write(oh.NewWriter(ctx), v1))
+
+	r, err := oh.NewRangeReader(ctx, 5, 4)
+	assert.NilError(t, err)
+	data, err := io.ReadAll(r)
+	assert.NilError(t, err)
+	assert.NilError(t, r.Close())
+	assert.Equal(t, v1[5:9], string(data))
+
+	// A range beyond EOF is unsatisfiable.
+	req, err := http.NewRequest("GET", fmt.Sprintf("%s/storage/v1/b/range-bucket/o/range.txt?alt=media", svr.URL), nil)
+	assert.NilError(t, err)
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-", len(v1)+10))
+	rsp, err := http.DefaultClient.Do(req)
+	assert.NilError(t, err)
+	_ = rsp.Body.Close()
+	assert.Equal(t, http.StatusRequestedRangeNotSatisfiable, rsp.StatusCode)
+	assert.Equal(t, fmt.Sprintf("bytes */%d", len(v1)), rsp.Header.Get("Content-Range"))
+}
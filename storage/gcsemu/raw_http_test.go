@@ -6,6 +6,7 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"google.golang.org/api/googleapi"
 	api "google.golang.org/api/storage/v1"
 	"gotest.tools/v3/assert"
 	"io"
@@ -14,6 +15,7 @@ import (
 	"net/http"
 	"net/http/httputil"
 	"net/textproto"
+	"net/url"
 	"strings"
 	"testing"
 )
@@ -297,3 +299,483 @@ func testRawHttp(t *testing.T, bh BucketHandle, httpClient *http.Client, url str
 		}
 	})
 }
+
+func TestBatchGetMetadata(t *testing.T) {
+	svr, _ := newTestServer(t, Options{})
+	names := []string{"batch/a.txt", "batch/b.txt"}
+	for _, name := range names {
+		assert.NilError(t, svr.Seed("batch-bucket", name, []byte(v1), nil))
+	}
+
+	var buf bytes.Buffer
+	w := multipart.NewWriter(&buf)
+	for i, name := range names {
+		p, err := w.CreatePart(textproto.MIMEHeader{
+			"Content-Type": []string{"application/http"},
+			"Content-ID":   []string{fmt.Sprintf("get-%d", i)},
+		})
+		assert.NilError(t, err)
+		_, _ = fmt.Fprintf(p, "GET /storage/v1/b/batch-bucket/o/%s HTTP/1.1\r\n\r\n", url.PathEscape(name))
+	}
+	assert.NilError(t, w.Close())
+
+	req, err := http.NewRequest("POST", svr.URL+"/batch/storage/v1", &buf)
+	assert.NilError(t, err)
+	req.Header.Set("Content-Type", "multipart/mixed; boundary="+w.Boundary())
+	rsp, err := http.DefaultClient.Do(req)
+	assert.NilError(t, err)
+	defer rsp.Body.Close()
+	assert.Equal(t, http.StatusOK, rsp.StatusCode)
+
+	d, params, err := mime.ParseMediaType(rsp.Header.Get("Content-Type"))
+	assert.NilError(t, err)
+	assert.Equal(t, "multipart/mixed", d)
+
+	r := multipart.NewReader(rsp.Body, params["boundary"])
+	for i, name := range names {
+		part, err := r.NextPart()
+		assert.NilError(t, err)
+		assert.Equal(t, fmt.Sprintf("response-get-%d", i), part.Header.Get("Content-ID"))
+
+		subRsp, err := http.ReadResponse(bufio.NewReader(part), nil)
+		assert.NilError(t, err)
+		assert.Equal(t, http.StatusOK, subRsp.StatusCode)
+		var obj api.Object
+		assert.NilError(t, json.NewDecoder(subRsp.Body).Decode(&obj))
+		assert.Equal(t, "batch-bucket", obj.Bucket)
+		assert.Equal(t, name, obj.Name)
+		assert.Equal(t, uint64(len(v1)), obj.Size)
+	}
+	_, err = r.NextPart()
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestIdentityContentEncoding(t *testing.T) {
+	svr, _ := newTestServer(t, Options{})
+	assert.NilError(t, svr.Seed("identity-bucket", "identity.txt", []byte(v1), &api.Object{ContentEncoding: "identity"}))
+
+	req, err := http.NewRequest("GET", svr.URL+"/download/storage/v1/b/identity-bucket/o/identity.txt?alt=media", nil)
+	assert.NilError(t, err)
+	// Explicitly accepting gzip also stops the client from transparently decoding the response.
+	req.Header.Set("Accept-Encoding", "gzip")
+	rsp, err := http.DefaultClient.Do(req)
+	assert.NilError(t, err)
+	defer rsp.Body.Close()
+
+	body, err := io.ReadAll(rsp.Body)
+	assert.NilError(t, err)
+	assert.Equal(t, http.StatusOK, rsp.StatusCode)
+	assert.Equal(t, "", rsp.Header.Get("Content-Encoding"))
+	assert.Equal(t, v1, string(body))
+}
+
+func TestInvalidJsonBody(t *testing.T) {
+	svr, _ := newTestServer(t, Options{})
+	assert.NilError(t, svr.Seed("json-bucket", "obj.txt", []byte(v1), nil))
+	const badJson = `{"metadata": {"type": `
+
+	var multipartBody bytes.Buffer
+	mw := multipart.NewWriter(&multipartBody)
+	p, err := mw.CreatePart(textproto.MIMEHeader{"Content-Type": []string{"application/json"}})
+	assert.NilError(t, err)
+	_, _ = io.WriteString(p, badJson)
+	p, err = mw.CreatePart(textproto.MIMEHeader{"Content-Type": []string{"text/plain"}})
+	assert.NilError(t, err)
+	_, _ = io.WriteString(p, v1)
+	assert.NilError(t, mw.Close())
+
+	tcs := []struct {
+		name, method, path, contentType string
+		body                            io.Reader
+	}{
+		{"patch", "PATCH", "/storage/v1/b/json-bucket/o/obj.txt", "application/json", strings.NewReader(badJson)},
+		{"compose", "POST", "/storage/v1/b/json-bucket/o/composed.txt/compose", "application/json", strings.NewReader(badJson)},
+		{"resumable", "POST", "/upload/storage/v1/b/json-bucket/o?uploadType=resumable", "application/json", strings.NewReader(badJson)},
+		{"multipart", "POST", "/upload/storage/v1/b/json-bucket/o?uploadType=multipart", "multipart/related; boundary=" + mw.Boundary(), &multipartBody},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			req, err := http.NewRequest(tc.method, svr.URL+tc.path, tc.body)
+			assert.NilError(t, err)
+			req.Header.Set("Content-Type", tc.contentType)
+			rsp, err := http.DefaultClient.Do(req)
+			assert.NilError(t, err)
+			defer rsp.Body.Close()
+			body, err := io.ReadAll(rsp.Body)
+			assert.NilError(t, err)
+			assert.Equal(t, http.StatusBadRequest, rsp.StatusCode, string(body))
+			assert.Assert(t, strings.Contains(string(body), "failed to parse"), string(body))
+		})
+	}
+}
+
+func TestMultipartInsertParts(t *testing.T) {
+	svr, _ := newTestServer(t, Options{})
+	assert.NilError(t, svr.InitBucket("multipart-bucket"))
+	const meta = `{"name": "obj.txt"}`
+
+	type part struct {
+		contentType, body string
+	}
+	upload := func(parts ...part) (int, string) {
+		t.Helper()
+		var buf bytes.Buffer
+		mw := multipart.NewWriter(&buf)
+		for _, p := range parts {
+			w, err := mw.CreatePart(textproto.MIMEHeader{"Content-Type": []string{p.contentType}})
+			assert.NilError(t, err)
+			_, _ = io.WriteString(w, p.body)
+		}
+		assert.NilError(t, mw.Close())
+		req, err := http.NewRequest("POST", svr.URL+"/upload/storage/v1/b/multipart-bucket/o?uploadType=multipart", &buf)
+		assert.NilError(t, err)
+		req.Header.Set("Content-Type", "multipart/related; boundary="+mw.Boundary())
+		rsp, err := http.DefaultClient.Do(req)
+		assert.NilError(t, err)
+		defer rsp.Body.Close()
+		body, err := io.ReadAll(rsp.Body)
+		assert.NilError(t, err)
+		return rsp.StatusCode, string(body)
+	}
+
+	code, body := upload(part{"application/json; charset=UTF-8", meta}, part{"text/plain", v1})
+	assert.Equal(t, http.StatusOK, code, body)
+
+	code, body = upload(part{"text/plain", meta}, part{"text/plain", v1})
+	assert.Equal(t, http.StatusBadRequest, code, body)
+	assert.Assert(t, strings.Contains(body, "must be application/json"), body)
+
+	code, body = upload(part{"application/json", meta}, part{"text/plain", v1}, part{"text/plain", v2})
+	assert.Equal(t, http.StatusBadRequest, code, body)
+	assert.Assert(t, strings.Contains(body, "unexpected extra part"), body)
+
+	// The rejected uploads didn't replace the object.
+	rsp, err := http.Get(svr.URL + "/download/storage/v1/b/multipart-bucket/o/obj.txt?alt=media")
+	assert.NilError(t, err)
+	defer rsp.Body.Close()
+	contents, err := io.ReadAll(rsp.Body)
+	assert.NilError(t, err)
+	assert.Equal(t, v1, string(contents))
+}
+
+func TestListNextPageToken(t *testing.T) {
+	svr, _ := newTestServer(t, Options{})
+	for _, name := range []string{"page/a", "page/b", "page/c", "dir/x/1", "dir/x/2", "dir/y/1", "dir/z"} {
+		assert.NilError(t, svr.Seed("page-bucket", name, []byte(v1), nil))
+	}
+
+	list := func(params url.Values) *api.Objects {
+		rsp, err := http.Get(svr.URL + "/storage/v1/b/page-bucket/o?" + params.Encode())
+		assert.NilError(t, err)
+		defer rsp.Body.Close()
+		assert.Equal(t, http.StatusOK, rsp.StatusCode)
+		var objs api.Objects
+		assert.NilError(t, json.NewDecoder(rsp.Body).Decode(&objs))
+		return &objs
+	}
+	// listAll follows page tokens, returning the item names and prefixes across pages and the number of pages.
+	listAll := func(params url.Values) ([]string, []string, int) {
+		var names, prefixes []string
+		pages := 0
+		for {
+			objs := list(params)
+			pages++
+			for _, obj := range objs.Items {
+				names = append(names, obj.Name)
+			}
+			prefixes = append(prefixes, objs.Prefixes...)
+			if objs.NextPageToken == "" {
+				return names, prefixes, pages
+			}
+			assert.Assert(t, pages < 10, "too many pages")
+			params.Set("pageToken", objs.NextPageToken)
+		}
+	}
+
+	// Exactly one page worth of results has no token.
+	objs := list(url.Values{"prefix": {"page/"}, "maxResults": {"3"}})
+	assert.Equal(t, 3, len(objs.Items))
+	assert.Equal(t, "", objs.NextPageToken)
+
+	// Smaller pages carry a token until the final page.
+	names, _, pages := listAll(url.Values{"prefix": {"page/"}, "maxResults": {"2"}})
+	assert.DeepEqual(t, []string{"page/a", "page/b", "page/c"}, names)
+	assert.Equal(t, 2, pages)
+
+	// A page made up only of prefixes still continues to the rest of the listing.
+	names, prefixes, pages := listAll(url.Values{"prefix": {"dir/"}, "delimiter": {"/"}, "maxResults": {"2"}})
+	assert.DeepEqual(t, []string{"dir/z"}, names)
+	assert.DeepEqual(t, []string{"dir/x/", "dir/y/"}, prefixes)
+	assert.Equal(t, 2, pages)
+
+	// A page ending inside a prefix resumes after the whole prefix, so it isn't returned again.
+	for _, name := range []string{"delim/a/1", "delim/a/2", "delim/a/3", "delim/b"} {
+		assert.NilError(t, svr.Seed("page-bucket", name, []byte(v1), nil))
+	}
+	names, prefixes, pages = listAll(url.Values{"prefix": {"delim/"}, "delimiter": {"/"}, "maxResults": {"1"}})
+	assert.DeepEqual(t, []string{"delim/b"}, names)
+	assert.DeepEqual(t, []string{"delim/a/"}, prefixes)
+	assert.Equal(t, 2, pages)
+}
+
+func TestHeadObject(t *testing.T) {
+	svr, _ := newTestServer(t, Options{})
+	assert.NilError(t, svr.Seed("head-bucket", "head.txt", []byte(v1), &api.Object{ContentType: "text/plain"}))
+	u := svr.URL + "/download/storage/v1/b/head-bucket/o/head.txt?alt=media"
+
+	headRsp, err := http.DefaultClient.Head(u)
+	assert.NilError(t, err)
+	defer headRsp.Body.Close()
+	body, err := io.ReadAll(headRsp.Body)
+	assert.NilError(t, err)
+	assert.Equal(t, http.StatusOK, headRsp.StatusCode)
+	assert.Equal(t, "", string(body))
+
+	getRsp, err := http.DefaultClient.Get(u)
+	assert.NilError(t, err)
+	defer getRsp.Body.Close()
+	body, err = io.ReadAll(getRsp.Body)
+	assert.NilError(t, err)
+	assert.Equal(t, v1, string(body))
+
+	for _, h := range []string{"Content-Length", "Content-Type", "ETag", "Last-Modified"} {
+		assert.Assert(t, getRsp.Header.Get(h) != "", "GET missing %s", h)
+		assert.Equal(t, getRsp.Header.Get(h), headRsp.Header.Get(h), h)
+	}
+
+	missing, err := http.DefaultClient.Head(svr.URL + "/download/storage/v1/b/head-bucket/o/missing.txt?alt=media")
+	assert.NilError(t, err)
+	defer missing.Body.Close()
+	assert.Equal(t, http.StatusNotFound, missing.StatusCode)
+}
+
+func TestDownloadContentHeaders(t *testing.T) {
+	ctx := context.Background()
+	svr, gcsClient := newTestServer(t, Options{})
+	assert.NilError(t, svr.InitBucket("header-bucket"))
+	w := gcsClient.Bucket("header-bucket").Object("report.csv").NewWriter(ctx)
+	w.CacheControl = "public, max-age=3600"
+	w.ContentDisposition = `attachment; filename="report.csv"`
+	assert.NilError(t, write(w, v1))
+	assert.NilError(t, svr.Seed("header-bucket", "plain.txt", []byte(v1), nil))
+
+	get := func(name string) *http.Response {
+		t.Helper()
+		rsp, err := http.Get(svr.URL + "/download/storage/v1/b/header-bucket/o/" + name + "?alt=media")
+		assert.NilError(t, err)
+		_ = rsp.Body.Close()
+		assert.Equal(t, http.StatusOK, rsp.StatusCode)
+		return rsp
+	}
+
+	rsp := get("report.csv")
+	assert.Equal(t, "public, max-age=3600", rsp.Header.Get("Cache-Control"))
+	assert.Equal(t, `attachment; filename="report.csv"`, rsp.Header.Get("Content-Disposition"))
+
+	// Objects without them get neither header.
+	rsp = get("plain.txt")
+	_, ok := rsp.Header["Cache-Control"]
+	assert.Assert(t, !ok)
+	_, ok = rsp.Header["Content-Disposition"]
+	assert.Assert(t, !ok)
+}
+
+func TestConditionalMediaGet(t *testing.T) {
+	svr, _ := newTestServer(t, Options{})
+	assert.NilError(t, svr.Seed("cond-bucket", "cond.txt", []byte(v1), nil))
+	u := svr.URL + "/download/storage/v1/b/cond-bucket/o/cond.txt?alt=media"
+
+	get := func(header, value string) *http.Response {
+		t.Helper()
+		req, err := http.NewRequest("GET", u, nil)
+		assert.NilError(t, err)
+		if header != "" {
+			req.Header.Set(header, value)
+		}
+		rsp, err := http.DefaultClient.Do(req)
+		assert.NilError(t, err)
+		_ = rsp.Body.Close()
+		return rsp
+	}
+
+	rsp := get("", "")
+	assert.Equal(t, http.StatusOK, rsp.StatusCode)
+	etag, lastModified := rsp.Header.Get("ETag"), rsp.Header.Get("Last-Modified")
+	assert.Assert(t, etag != "")
+	assert.Assert(t, lastModified != "")
+
+	rsp = get("If-None-Match", etag)
+	assert.Equal(t, http.StatusNotModified, rsp.StatusCode)
+	assert.Equal(t, etag, rsp.Header.Get("ETag"))
+
+	rsp = get("If-None-Match", `"stale"`)
+	assert.Equal(t, http.StatusOK, rsp.StatusCode)
+
+	rsp = get("If-Modified-Since", lastModified)
+	assert.Equal(t, http.StatusNotModified, rsp.StatusCode)
+
+	// A new generation changes the ETag.
+	assert.NilError(t, svr.Seed("cond-bucket", "cond.txt", []byte(v2), nil))
+	rsp = get("If-None-Match", etag)
+	assert.Equal(t, http.StatusOK, rsp.StatusCode)
+	assert.Assert(t, rsp.Header.Get("ETag") != etag)
+}
+
+func TestReadPreconditions(t *testing.T) {
+	svr, _ := newTestServer(t, Options{})
+	assert.NilError(t, svr.Seed("cond-bucket", "cond.txt", []byte(v1), nil))
+
+	get := func(alt string, query string) *http.Response {
+		t.Helper()
+		rsp, err := http.Get(svr.URL + "/storage/v1/b/cond-bucket/o/cond.txt?alt=" + alt + "&" + query)
+		assert.NilError(t, err)
+		_ = rsp.Body.Close()
+		return rsp
+	}
+	rsp := get("media", "")
+	assert.Equal(t, http.StatusOK, rsp.StatusCode)
+	gen := rsp.Header.Get("X-Goog-Generation")
+	assert.Assert(t, gen != "")
+
+	// Replacing the object leaves the old generation stale.
+	assert.NilError(t, svr.Seed("cond-bucket", "cond.txt", []byte(v2), nil))
+	rsp = get("media", "")
+	assert.Equal(t, http.StatusOK, rsp.StatusCode)
+	liveGen := rsp.Header.Get("X-Goog-Generation")
+	assert.Assert(t, liveGen != gen)
+
+	for _, alt := range []string{"media", "json"} {
+		t.Run(alt, func(t *testing.T) {
+			for _, tc := range []struct {
+				query string
+				want  int
+			}{
+				{"ifGenerationMatch=" + gen, http.StatusPreconditionFailed},
+				{"ifGenerationMatch=" + liveGen, http.StatusOK},
+				{"ifGenerationMatch=0", http.StatusPreconditionFailed},
+				{"ifGenerationNotMatch=" + liveGen, http.StatusNotModified},
+				{"ifMetagenerationMatch=2", http.StatusPreconditionFailed},
+				{"ifMetagenerationMatch=1", http.StatusOK},
+			} {
+				rsp := get(alt, tc.query)
+				assert.Equal(t, tc.want, rsp.StatusCode, tc.query)
+			}
+		})
+	}
+
+	// A missing object is not found, whatever the preconditions.
+	rsp, err := http.Get(svr.URL + "/storage/v1/b/cond-bucket/o/missing.txt?alt=media&ifGenerationMatch=" + gen)
+	assert.NilError(t, err)
+	_ = rsp.Body.Close()
+	assert.Equal(t, http.StatusNotFound, rsp.StatusCode)
+}
+
+func TestNotFoundMessages(t *testing.T) {
+	svr, _ := newTestServer(t, Options{})
+	assert.NilError(t, svr.InitBucket("found-bucket"))
+
+	// notFound returns the GAPI error from a 404 response for the given object url.
+	notFound := func(u string) googleapi.ErrorItem {
+		t.Helper()
+		rsp, err := http.DefaultClient.Get(svr.URL + u)
+		assert.NilError(t, err)
+		defer rsp.Body.Close()
+		assert.Equal(t, http.StatusNotFound, rsp.StatusCode)
+		var body struct {
+			Error gapiErrorPartial `json:"error"`
+		}
+		assert.NilError(t, json.NewDecoder(rsp.Body).Decode(&body))
+		assert.Equal(t, 1, len(body.Error.Errors))
+		return body.Error.Errors[0]
+	}
+
+	for _, alt := range []string{"json", "media"} {
+		missingBucket := notFound("/storage/v1/b/missing-bucket/o/obj.txt?alt=" + alt)
+		missingObject := notFound("/storage/v1/b/found-bucket/o/obj.txt?alt=" + alt)
+		assert.Equal(t, "notFound", missingBucket.Reason)
+		assert.Equal(t, "notFound", missingObject.Reason)
+		assert.Assert(t, strings.Contains(missingBucket.Message, "The specified bucket does not exist: missing-bucket"), missingBucket.Message)
+		assert.Assert(t, strings.Contains(missingObject.Message, "No such object: found-bucket/obj.txt"), missingObject.Message)
+	}
+}
+
+func TestAltParam(t *testing.T) {
+	svr, _ := newTestServer(t, Options{})
+	assert.NilError(t, svr.Seed("alt-bucket", "alt.txt", []byte(v1), nil))
+
+	get := func(u string) (int, string) {
+		t.Helper()
+		rsp, err := http.DefaultClient.Get(svr.URL + u)
+		assert.NilError(t, err)
+		defer rsp.Body.Close()
+		body, err := io.ReadAll(rsp.Body)
+		assert.NilError(t, err)
+		return rsp.StatusCode, string(body)
+	}
+
+	for _, u := range []string{
+		"/storage/v1/b/alt-bucket/o/alt.txt",
+		"/storage/v1/b/alt-bucket/o/alt.txt?alt=json",
+	} {
+		code, body := get(u)
+		assert.Equal(t, http.StatusOK, code, u)
+		var obj api.Object
+		assert.NilError(t, json.Unmarshal([]byte(body), &obj), u)
+		assert.Equal(t, "alt.txt", obj.Name, u)
+	}
+
+	for _, u := range []string{
+		"/storage/v1/b/alt-bucket/o/alt.txt?alt=media",
+		"/download/storage/v1/b/alt-bucket/o/alt.txt",
+		"/download/storage/v1/b/alt-bucket/o/alt.txt?alt=media",
+		"/alt-bucket/alt.txt",
+	} {
+		code, body := get(u)
+		assert.Equal(t, http.StatusOK, code, u)
+		assert.Equal(t, v1, body, u)
+	}
+
+	for _, u := range []string{
+		"/storage/v1/b/alt-bucket/o/alt.txt?alt=json&alt=media",
+		"/storage/v1/b/alt-bucket/o/alt.txt?alt=proto",
+		"/download/storage/v1/b/alt-bucket/o/alt.txt?alt=json",
+	} {
+		code, _ := get(u)
+		assert.Equal(t, http.StatusBadRequest, code, u)
+	}
+}
+
+func TestSimpleMediaUpload(t *testing.T) {
+	ctx := context.Background()
+	svr, gcsClient := newTestServer(t, Options{})
+	assert.NilError(t, svr.InitBucket("simple-bucket"))
+
+	// The name goes in the query and the raw contents in the body, with no json metadata part.
+	u := svr.URL + "/upload/storage/v1/b/simple-bucket/o?uploadType=media&contentEncoding=identity&name=" + url.QueryEscape("dir/simple.txt")
+	rsp, err := http.Post(u, "text/plain", strings.NewReader(v1))
+	assert.NilError(t, err)
+	defer rsp.Body.Close()
+	assert.Equal(t, http.StatusOK, rsp.StatusCode)
+	var meta api.Object
+	assert.NilError(t, json.NewDecoder(rsp.Body).Decode(&meta))
+	assert.Equal(t, "dir/simple.txt", meta.Name)
+	assert.Equal(t, uint64(len(v1)), meta.Size)
+
+	oh := gcsClient.Bucket("simple-bucket").Object("dir/simple.txt")
+	attrs, err := oh.Attrs(ctx)
+	assert.NilError(t, err)
+	assert.Equal(t, "text/plain", attrs.ContentType)
+	assert.Equal(t, "identity", attrs.ContentEncoding)
+	r, err := oh.NewReader(ctx)
+	assert.NilError(t, err)
+	defer r.Close()
+	data, err := io.ReadAll(r)
+	assert.NilError(t, err)
+	assert.Equal(t, v1, string(data))
+
+	// A missing name is rejected.
+	rsp, err = http.Post(svr.URL+"/upload/storage/v1/b/simple-bucket/o?uploadType=media", "text/plain", strings.NewReader(v1))
+	assert.NilError(t, err)
+	defer rsp.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, rsp.StatusCode)
+}
@@ -0,0 +1,39 @@
+package gcsemu
+
+import (
+	"net/http"
+	"time"
+
+	"google.golang.org/api/storage/v1"
+)
+
+// checkRetention returns a 403 error if the given existing object cannot be deleted or overwritten, because it is
+// under a temporary or event-based hold, or within its bucket's retention period.
+func checkRetention(obj *storage.Object, now time.Time) error {
+	if obj == nil {
+		return nil
+	}
+	if obj.TemporaryHold {
+		return fmtErrorfCode(http.StatusForbidden, "object %s is under temporary hold and cannot be deleted or overwritten", obj.Name)
+	}
+	if obj.EventBasedHold {
+		return fmtErrorfCode(http.StatusForbidden, "object %s is under event-based hold and cannot be deleted or overwritten", obj.Name)
+	}
+	if obj.RetentionExpirationTime != "" {
+		expires, err := time.Parse(time.RFC3339Nano, obj.RetentionExpirationTime)
+		if err == nil && now.Before(expires) {
+			return fmtErrorfCode(http.StatusForbidden, "object %s is subject to bucket's retention policy and cannot be deleted or overwritten until %s", obj.Name, obj.RetentionExpirationTime)
+		}
+	}
+	return nil
+}
+
+// applyRetention sets a new object's retention expiration time from its bucket's retention policy, if any.
+func applyRetention(obj *storage.Object, bucket *storage.Bucket, now time.Time) {
+	obj.RetentionExpirationTime = ""
+	if bucket == nil || bucket.RetentionPolicy == nil || bucket.RetentionPolicy.RetentionPeriod <= 0 {
+		return
+	}
+	expires := now.Add(time.Duration(bucket.RetentionPolicy.RetentionPeriod) * time.Second)
+	obj.RetentionExpirationTime = expires.UTC().Format(time.RFC3339Nano)
+}
@@ -0,0 +1,79 @@
+package gcsemu
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"gotest.tools/v3/assert"
+)
+
+func TestObjectHolds(t *testing.T) {
+	ctx := context.Background()
+	_, gcsClient := newTestServer(t, Options{})
+
+	bh := gcsClient.Bucket("hold-bucket")
+	assert.NilError(t, bh.Create(ctx, "dev", &storage.BucketAttrs{}))
+
+	for _, tc := range []struct {
+		name    string
+		attrs   storage.ObjectAttrs
+		release storage.ObjectAttrsToUpdate
+	}{
+		{"temporary", storage.ObjectAttrs{TemporaryHold: true}, storage.ObjectAttrsToUpdate{TemporaryHold: false}},
+		{"event-based", storage.ObjectAttrs{EventBasedHold: true}, storage.ObjectAttrsToUpdate{EventBasedHold: false}},
+	} {
+		t.Logf("case %s", tc.name)
+		oh := bh.Object(tc.name + ".txt")
+		w := oh.NewWriter(ctx)
+		w.ObjectAttrs.TemporaryHold = tc.attrs.TemporaryHold
+		w.ObjectAttrs.EventBasedHold = tc.attrs.EventBasedHold
+		assert.NilError(t, write(w, v1))
+
+		// Cannot delete or overwrite while held.
+		err := oh.Delete(ctx)
+		assert.Equal(t, http.StatusForbidden, httpStatusCodeOf(err), "wrong error %T: %s", err, err)
+		err = write(oh.NewWriter(ctx), v2)
+		assert.Equal(t, http.StatusForbidden, httpStatusCodeOf(err), "wrong error %T: %s", err, err)
+
+		// Release the hold, then delete succeeds.
+		_, err = oh.Update(ctx, tc.release)
+		assert.NilError(t, err)
+		assert.NilError(t, oh.Delete(ctx))
+	}
+}
+
+func TestBucketRetentionPolicy(t *testing.T) {
+	ctx := context.Background()
+	svr, gcsClient := newTestServer(t, Options{})
+
+	bh := gcsClient.Bucket("retention-bucket")
+	assert.NilError(t, bh.Create(ctx, "dev", &storage.BucketAttrs{
+		RetentionPolicy: &storage.RetentionPolicy{RetentionPeriod: time.Hour},
+	}))
+	bAttrs, err := bh.Attrs(ctx)
+	assert.NilError(t, err)
+	assert.Equal(t, time.Hour, bAttrs.RetentionPolicy.RetentionPeriod)
+	assert.Assert(t, !bAttrs.RetentionPolicy.EffectiveTime.IsZero(), "expected effective time")
+
+	oh := bh.Object("retained.txt")
+	w := oh.NewWriter(ctx)
+	assert.NilError(t, write(w, v1))
+	attrs, err := oh.Attrs(ctx)
+	assert.NilError(t, err)
+	assert.Assert(t, attrs.RetentionExpirationTime.After(time.Now().Add(59*time.Minute)), "wrong retention expiration: %s", attrs.RetentionExpirationTime)
+
+	// Cannot delete or overwrite within the retention period.
+	err = oh.Delete(ctx)
+	assert.Equal(t, http.StatusForbidden, httpStatusCodeOf(err), "wrong error %T: %s", err, err)
+	err = write(oh.NewWriter(ctx), v2)
+	assert.Equal(t, http.StatusForbidden, httpStatusCodeOf(err), "wrong error %T: %s", err, err)
+
+	// Once the retention period passes, delete succeeds.
+	svr.now = func() time.Time {
+		return time.Now().Add(2 * time.Hour)
+	}
+	assert.NilError(t, oh.Delete(ctx))
+}
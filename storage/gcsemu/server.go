@@ -42,3 +42,9 @@ func NewServer(laddr string, opts Options) (*Server, error) {
 		GcsEmu: gcsEmu,
 	}, nil
 }
+
+// Close shuts down the HTTP server and stops the emulator's background work.
+func (s *Server) Close() {
+	s.Server.Close()
+	s.GcsEmu.Close()
+}
@@ -0,0 +1,59 @@
+package gcsemu
+
+import (
+	"encoding/hex"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	signedUrlDateFormat = "20060102T150405Z"
+	signedUrlMaxExpires = 7 * 24 * 60 * 60 // seconds
+)
+
+// validateSignedUrl checks the V4 signed URL query params for structural validity and expiry.
+// See https://cloud.google.com/storage/docs/authentication/signatures
+func validateSignedUrl(q url.Values, now time.Time) error {
+	for _, param := range []string{"X-Goog-Algorithm", "X-Goog-Credential", "X-Goog-Date", "X-Goog-Expires", "X-Goog-SignedHeaders", "X-Goog-Signature"} {
+		if q.Get(param) == "" {
+			return fmtErrorfCode(http.StatusForbidden, "signed url is missing required parameter %s", param)
+		}
+	}
+
+	switch alg := q.Get("X-Goog-Algorithm"); alg {
+	case "GOOG4-RSA-SHA256", "GOOG4-HMAC-SHA256":
+	default:
+		return fmtErrorfCode(http.StatusForbidden, "signed url has unsupported algorithm %q", alg)
+	}
+
+	date, err := time.Parse(signedUrlDateFormat, q.Get("X-Goog-Date"))
+	if err != nil {
+		return fmtErrorfCode(http.StatusForbidden, "signed url has malformed X-Goog-Date: %w", err)
+	}
+
+	// Credential scope is "<accessId>/<yyyymmdd>/<location>/storage/goog4_request"
+	credParts := strings.Split(q.Get("X-Goog-Credential"), "/")
+	if len(credParts) != 5 || credParts[0] == "" || credParts[3] != "storage" || credParts[4] != "goog4_request" {
+		return fmtErrorfCode(http.StatusForbidden, "signed url has malformed X-Goog-Credential")
+	}
+	if credParts[1] != date.Format("20060102") {
+		return fmtErrorfCode(http.StatusForbidden, "signed url credential scope date %s does not match X-Goog-Date", credParts[1])
+	}
+
+	expires, err := strconv.Atoi(q.Get("X-Goog-Expires"))
+	if err != nil || expires < 1 || expires > signedUrlMaxExpires {
+		return fmtErrorfCode(http.StatusForbidden, "signed url has invalid X-Goog-Expires: %s", q.Get("X-Goog-Expires"))
+	}
+
+	if _, err := hex.DecodeString(q.Get("X-Goog-Signature")); err != nil {
+		return fmtErrorfCode(http.StatusForbidden, "signed url has malformed X-Goog-Signature")
+	}
+
+	if now.After(date.Add(time.Duration(expires) * time.Second)) {
+		return fmtErrorfCode(http.StatusForbidden, "signed url has expired")
+	}
+	return nil
+}
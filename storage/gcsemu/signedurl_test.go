@@ -0,0 +1,71 @@
+package gcsemu
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"gotest.tools/v3/assert"
+)
+
+func TestSignedUrl(t *testing.T) {
+	ctx := context.Background()
+	svr, gcsClient := newTestServer(t, Options{VerifySignedUrls: true})
+
+	bh := gcsClient.Bucket("signed-bucket")
+	assert.NilError(t, bh.Create(ctx, "dev", &storage.BucketAttrs{}))
+	assert.NilError(t, write(bh.Object("signed.txt").NewWriter(ctx), v1))
+
+	signed, err := storage.SignedURL("signed-bucket", "signed.txt", &storage.SignedURLOptions{
+		GoogleAccessID: "emulator@example.iam.gserviceaccount.com",
+		SignBytes: func(b []byte) ([]byte, error) {
+			return []byte("not-a-real-signature"), nil
+		},
+		Method:  "GET",
+		Expires: time.Now().Add(time.Minute),
+		Scheme:  storage.SigningSchemeV4,
+	})
+	assert.NilError(t, err)
+
+	// Point the signed url at the emulator.
+	u, err := url.Parse(signed)
+	assert.NilError(t, err)
+	emuUrl, err := url.Parse(svr.URL)
+	assert.NilError(t, err)
+	u.Scheme, u.Host = emuUrl.Scheme, emuUrl.Host
+
+	get := func(u string) (int, string) {
+		rsp, err := http.Get(u)
+		assert.NilError(t, err)
+		defer func() {
+			_ = rsp.Body.Close()
+		}()
+		body, err := io.ReadAll(rsp.Body)
+		assert.NilError(t, err)
+		return rsp.StatusCode, string(body)
+	}
+
+	// Valid before expiry.
+	code, body := get(u.String())
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, v1, body)
+
+	// Malformed signature.
+	q := u.Query()
+	q.Set("X-Goog-Signature", "zzzz")
+	badUrl := *u
+	badUrl.RawQuery = q.Encode()
+	code, _ = get(badUrl.String())
+	assert.Equal(t, http.StatusForbidden, code)
+
+	// Expired.
+	svr.now = func() time.Time {
+		return time.Now().Add(2 * time.Minute)
+	}
+	code, _ = get(u.String())
+	assert.Equal(t, http.StatusForbidden, code)
+}
@@ -15,12 +15,18 @@ type Store interface {
 	// Get returns a bucket's metadata.
 	GetBucketMeta(baseUrl HttpBaseUrl, bucket string) (*storage.Bucket, error)
 
+	// UpdateBucketMeta replaces the given bucket's stored metadata.
+	UpdateBucketMeta(bucket string, meta *storage.Bucket) error
+
 	// Get returns a file's contents and metadata.
 	Get(url HttpBaseUrl, bucket string, filename string) (*storage.Object, []byte, error)
 
 	// GetMeta returns a file's metadata.
 	GetMeta(url HttpBaseUrl, bucket string, filename string) (*storage.Object, error)
 
+	// Stat reports whether a file exists and its size, without reading its contents or metadata.
+	Stat(bucket string, filename string) (bool, int64, error)
+
 	// Add creates the specified file.
 	Add(bucket string, filename string, contents []byte, meta *storage.Object) error
 
@@ -30,6 +36,10 @@ type Store interface {
 	// Copy copies the file
 	Copy(srcBucket string, srcFile string, dstBucket string, dstFile string) (bool, error)
 
+	// Move renames a file within a bucket, replacing any existing destination; returns false if the source is
+	// missing. Readers never see both files, or neither.
+	Move(bucket string, srcFile string, dstFile string) (bool, error)
+
 	// Delete deletes the file.
 	Delete(bucket string, filename string) error
 
@@ -0,0 +1,55 @@
+package gcsemu
+
+import (
+	"sync"
+	"time"
+
+	"google.golang.org/api/storage/v1"
+)
+
+// tombstones holds just-deleted objects that stay readable for Options.PostDeleteVisibility, to mimic eventually
+// consistent deletes. Like IAM policies, they are kept in memory and not persisted by the Store.
+type tombstones struct {
+	mu      sync.Mutex
+	deleted map[string]*tombstone // keyed by lockName(bucket, filename)
+}
+
+type tombstone struct {
+	meta     storage.Object
+	contents []byte
+	expires  time.Time
+}
+
+func newTombstones() *tombstones {
+	return &tombstones{deleted: map[string]*tombstone{}}
+}
+
+// add records a deleted object, visible until expires. Expired tombstones are dropped as a side effect.
+func (ts *tombstones) add(bucket string, filename string, meta *storage.Object, contents []byte, now time.Time, expires time.Time) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	for k, t := range ts.deleted {
+		if !now.Before(t.expires) {
+			delete(ts.deleted, k)
+		}
+	}
+	ts.deleted[lockName(bucket, filename)] = &tombstone{meta: *meta, contents: contents, expires: expires}
+}
+
+// get returns the still-visible deleted object with the given name, if any.
+func (ts *tombstones) get(baseUrl HttpBaseUrl, bucket string, filename string, now time.Time) (*storage.Object, []byte) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	key := lockName(bucket, filename)
+	t, ok := ts.deleted[key]
+	if !ok {
+		return nil, nil
+	}
+	if !now.Before(t.expires) {
+		delete(ts.deleted, key)
+		return nil, nil
+	}
+	meta := t.meta
+	InitMetaWithUrls(baseUrl, &meta, bucket, filename, uint64(len(t.contents)))
+	return &meta, t.contents
+}
@@ -15,6 +15,21 @@ func (g *GcsEmu) jsonRespond(w http.ResponseWriter, rsp interface{}) {
 	// do NOT write a http status since OK will be the default and this allows the caller to use their own if they want
 	w.Header().Set("Content-Type", "application/json; charset=utf-8")
 
+	if sw, ok := w.(*shapedResponseWriter); ok {
+		if sw.projection != "" {
+			rsp = g.projectAcls(rsp, sw.projection)
+		}
+		if sw.mask != nil {
+			projected, err := projectFields(rsp, sw.mask)
+			if err != nil {
+				g.log(err, "failed to apply fields mask")
+				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+				return
+			}
+			rsp = projected
+		}
+	}
+
 	encoder := json.NewEncoder(w)
 	if err := encoder.Encode(rsp); err != nil {
 		g.log(err, "failed to send response")
@@ -33,6 +48,17 @@ type gapiErrorPartial struct {
 	Errors []googleapi.ErrorItem `json:"errors,omitempty"`
 }
 
+// errorReasons maps status codes to the reason GCS reports for them in a GAPI error.
+var errorReasons = map[int]string{
+	http.StatusNotModified:                  "notModified",
+	http.StatusBadRequest:                   "invalid",
+	http.StatusForbidden:                    "forbidden",
+	http.StatusNotFound:                     "notFound",
+	http.StatusConflict:                     "conflict",
+	http.StatusPreconditionFailed:           "conditionNotMet",
+	http.StatusRequestedRangeNotSatisfiable: "requestedRangeNotSatisfiable",
+}
+
 // gapiError responds to the client with a GAPI error
 func (g *GcsEmu) gapiError(w http.ResponseWriter, code int, message string) {
 	if code == 0 {
@@ -54,6 +80,9 @@ func (g *GcsEmu) gapiError(w http.ResponseWriter, code int, message string) {
 			Message: message,
 		},
 	}
+	if reason := errorReasons[code]; reason != "" {
+		rsp.Error.Errors = []googleapi.ErrorItem{{Reason: reason, Message: message}}
+	}
 
 	w.Header().Set("Content-Type", "application/json; charset=utf-8")
 	w.WriteHeader(code)
@@ -139,3 +168,31 @@ func parseForwardedHeader(s string) forwarded {
 
 	return f
 }
+
+// statusResponseWriter records the status of the response written through it, for Options.OnRequest.
+type statusResponseWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusResponseWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusResponseWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Status returns the response status; a handler that writes nothing responds 200.
+func (w *statusResponseWriter) Status() int {
+	if w.status == 0 {
+		return http.StatusOK
+	}
+	return w.status
+}
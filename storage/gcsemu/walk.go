@@ -7,14 +7,16 @@ import (
 	"net/http"
 	"os"
 	"path/filepath"
+	"regexp"
 	"strings"
 
 	"github.com/fullstorydev/emulators/storage/gcsutil"
 	"google.golang.org/api/storage/v1"
 )
 
-// Iterate over the file system to serve a GCS list-bucket request.
-func (g *GcsEmu) makeBucketListResults(ctx context.Context, baseUrl HttpBaseUrl, w http.ResponseWriter, delimiter string, cursor string, prefix string, bucket string, maxResults int) {
+// Iterate over the file system to serve a GCS list-bucket request. If set, startOffset and endOffset restrict the
+// listing to names in [startOffset, endOffset), and glob to names it matches; a glob also suppresses prefixes.
+func (g *GcsEmu) makeBucketListResults(ctx context.Context, baseUrl HttpBaseUrl, w http.ResponseWriter, delimiter string, cursor string, prefix string, startOffset string, endOffset string, glob *regexp.Regexp, bucket string, maxResults int) {
 	var errAbort = errors.New("sentinel error to abort walk")
 
 	type item struct {
@@ -31,8 +33,17 @@ func (g *GcsEmu) makeBucketListResults(ctx context.Context, baseUrl HttpBaseUrl,
 		}
 	}
 
+	// A cursor within a delimited prefix means the prefix was already returned, so resume after all of it.
+	skipPrefix := ""
+	if delimiter != "" && glob == nil && strings.HasPrefix(cursor, prefix) {
+		if pos := strings.Index(cursor[len(prefix):], delimiter); pos >= 0 {
+			skipPrefix = cursor[:len(prefix)+pos+len(delimiter)]
+		}
+	}
+
 	moreResults := false
 	count := 0
+	lastCounted := "" // the last file counted toward maxResults, whether listed as an item or folded into a prefix
 	err := g.store.Walk(ctx, bucket, func(ctx context.Context, filename string, fInfo os.FileInfo) error {
 		dbgWalk("walk: %s", filename)
 
@@ -41,6 +52,11 @@ func (g *GcsEmu) makeBucketListResults(ctx context.Context, baseUrl HttpBaseUrl,
 			dbgWalk("%q > prefix=%q aborting", filename, prefix)
 			return errAbort
 		}
+		// Likewise if we've reached the end offset.
+		if endOffset != "" && filename >= endOffset {
+			dbgWalk("%q >= endOffset=%q aborting", filename, endOffset)
+			return errAbort
+		}
 
 		// In the filesystem implementation, skip any directories strictly less than the cursor or prefix.
 		if fInfo != nil && fInfo.IsDir() {
@@ -52,6 +68,10 @@ func (g *GcsEmu) makeBucketListResults(ctx context.Context, baseUrl HttpBaseUrl,
 				dbgWalk("%q < prefix=%q skip dir", filename, prefix)
 				return filepath.SkipDir
 			}
+			if lessThanPrefix(filename, startOffset) {
+				dbgWalk("%q < startOffset=%q skip dir", filename, startOffset)
+				return filepath.SkipDir
+			}
 			return nil // keep going
 		}
 
@@ -64,27 +84,44 @@ func (g *GcsEmu) makeBucketListResults(ctx context.Context, baseUrl HttpBaseUrl,
 			dbgWalk("%q < prefix=%q skipping", filename, prefix)
 			return nil
 		}
+		if filename < startOffset {
+			dbgWalk("%q < startOffset=%q skipping", filename, startOffset)
+			return nil
+		}
+		if glob != nil && !glob.MatchString(filename) {
+			dbgWalk("%q doesn't match glob, skipping", filename)
+			return nil
+		}
+		if skipPrefix != "" && strings.HasPrefix(filename, skipPrefix) {
+			dbgWalk("%q in returned prefix=%q skipping", filename, skipPrefix)
+			return nil
+		}
+
+		// See if the filename (beyond the prefix) contains delimiter, if it does, don't record the item,
+		// instead record the prefix (including the delimiter).
+		itemPrefix := ""
+		if delimiter != "" && glob == nil {
+			withoutPrefix := strings.TrimPrefix(filename, prefix)
+			if delimiterPos := strings.Index(withoutPrefix, delimiter); delimiterPos >= 0 {
+				// Got a hit, reconstruct the item's prefix, including the trailing delimiter
+				itemPrefix = filename[:len(prefix)+delimiterPos+len(delimiter)]
+				if seenPrefixes[itemPrefix] {
+					return nil // already counted
+				}
+			}
+		}
 
 		if count >= maxResults {
 			moreResults = true
 			return errAbort
 		}
 		count++
+		lastCounted = filename
 
-		if delimiter != "" {
-			// See if the filename (beyond the prefix) contains delimiter, if it does, don't record the item,
-			// instead record the prefix (including the delimiter).
-			withoutPrefix := strings.TrimPrefix(filename, prefix)
-			delimiterPos := strings.Index(withoutPrefix, delimiter)
-			if delimiterPos >= 0 {
-				// Got a hit, reconstruct the item's prefix, including the trailing delimiter
-				itemPrefix := filename[:len(prefix)+delimiterPos+len(delimiter)]
-				if !seenPrefixes[itemPrefix] {
-					seenPrefixes[itemPrefix] = true
-					prefixes = append(prefixes, itemPrefix)
-				}
-				return nil
-			}
+		if itemPrefix != "" {
+			seenPrefixes[itemPrefix] = true
+			prefixes = append(prefixes, itemPrefix)
+			return nil
 		}
 
 		found = append(found, item{
@@ -122,10 +159,20 @@ func (g *GcsEmu) makeBucketListResults(ctx context.Context, baseUrl HttpBaseUrl,
 		}
 	}
 
+	// Only hand out a token when there really is more, so clients know when to stop paging.
 	var nextPageToken = ""
-	if moreResults && len(items) > 0 {
-		lastItemName := items[len(items)-1].Name
-		nextPageToken = gcsutil.EncodePageToken(lastItemName)
+	if moreResults {
+		cursor := lastCounted
+		if len(items) < len(found) {
+			// Resolution stopped early; resume after the last item we could return.
+			cursor = ""
+			if len(items) > 0 {
+				cursor = items[len(items)-1].Name
+			}
+		}
+		if cursor != "" {
+			nextPageToken = gcsutil.EncodePageToken(cursor)
+		}
 	}
 
 	rsp := storage.Objects{
@@ -137,3 +184,67 @@ func (g *GcsEmu) makeBucketListResults(ctx context.Context, baseUrl HttpBaseUrl,
 
 	g.jsonRespond(w, &rsp)
 }
+
+// globRegexp compiles a matchGlob pattern. "*" and "?" don't match "/", "**" matches anything including "/", and
+// "**/" may also match no directories at all. "[...]" and "{a,b}" work as in most shells.
+func globRegexp(glob string) (*regexp.Regexp, error) {
+	var sb strings.Builder
+	sb.WriteString("^")
+	inBraces := false
+	for i := 0; i < len(glob); i++ {
+		switch c := glob[i]; c {
+		case '*':
+			if strings.HasPrefix(glob[i:], "**/") {
+				sb.WriteString("(?:.*/)?")
+				i += 2
+			} else if strings.HasPrefix(glob[i:], "**") {
+				sb.WriteString(".*")
+				i++
+			} else {
+				sb.WriteString("[^/]*")
+			}
+		case '?':
+			sb.WriteString("[^/]")
+		case '[':
+			end := strings.IndexByte(glob[i+1:], ']')
+			if end < 0 {
+				return nil, fmt.Errorf("invalid matchGlob %q: unterminated '['", glob)
+			}
+			class := glob[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			sb.WriteString("[" + class + "]")
+			i += end + 1
+		case '{':
+			if inBraces {
+				return nil, fmt.Errorf("invalid matchGlob %q: nested '{'", glob)
+			}
+			inBraces = true
+			sb.WriteString("(?:")
+		case '}':
+			if !inBraces {
+				return nil, fmt.Errorf("invalid matchGlob %q: unmatched '}'", glob)
+			}
+			inBraces = false
+			sb.WriteString(")")
+		case ',':
+			if inBraces {
+				sb.WriteString("|")
+			} else {
+				sb.WriteString(",")
+			}
+		default:
+			sb.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	if inBraces {
+		return nil, fmt.Errorf("invalid matchGlob %q: unterminated '{'", glob)
+	}
+	sb.WriteString("$")
+	re, err := regexp.Compile(sb.String())
+	if err != nil {
+		return nil, fmt.Errorf("invalid matchGlob %q: %w", glob, err)
+	}
+	return re, nil
+}
@@ -3,6 +3,7 @@ module github.com/fullstorydev/emulators/storage
 go 1.21
 
 require (
+	cloud.google.com/go/iam v1.2.2
 	cloud.google.com/go/storage v1.46.0
 	github.com/bluele/gcache v0.0.2
 	github.com/google/btree v1.1.3
@@ -18,7 +19,6 @@ require (
 	cloud.google.com/go/auth v0.10.2 // indirect
 	cloud.google.com/go/auth/oauth2adapt v0.2.5 // indirect
 	cloud.google.com/go/compute/metadata v0.5.2 // indirect
-	cloud.google.com/go/iam v1.2.2 // indirect
 	cloud.google.com/go/monitoring v1.21.2 // indirect
 	github.com/GoogleCloudPlatform/opentelemetry-operations-go/detectors/gcp v1.24.1 // indirect
 	github.com/GoogleCloudPlatform/opentelemetry-operations-go/exporter/metric v0.48.1 // indirect